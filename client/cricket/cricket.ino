@@ -1,3 +1,5 @@
+#include <map>
+#include <deque>
 #include <queue>
 
 #include <WiFi.h>
@@ -10,6 +12,21 @@
 
 #include "password.h"
 
+// The fleet this device belongs to, advertised as "ns" in its mDNS TXT
+// record. A server only claims devices in its own namespace, so two
+// installations (or a dev fleet and a production one) can share a
+// network. Define it in password.h, alongside the network it's for.
+#ifndef FLEET_NAMESPACE
+#define FLEET_NAMESPACE ""
+#endif
+
+// This firmware's version, advertised as "fw" in its mDNS TXT record.
+// The server doesn't send commands that a device's firmware is too old
+// for, so bump this when adding an endpoint, and say in the server which
+// version added it. Firmware from before versions were advertised had
+// none of the endpoints added since.
+#define FIRMWARE_VERSION "1.1"
+
 // Generate a uniformly distributed random number, given a mean and
 // a variance. The number will be in the range [mean - var, mean + var),
 // but will always be at least 0.
@@ -30,9 +47,10 @@ static int generate_random(int mean, int var) {
 
 class Net {
  public:
-  Net(const String& ssid, const String& pass, int port, bool debug_enabled) :
-    ssid_(ssid), pass_(pass), debug_enabled_(debug_enabled),
-    mdns_(udp_), server_(port) {}
+  Net(const String& ssid, const String& pass, const String& fleet_namespace,
+      int port, bool debug_enabled) :
+    ssid_(ssid), pass_(pass), fleet_namespace_(fleet_namespace),
+    debug_enabled_(debug_enabled), mdns_(udp_), server_(port) {}
 
   void setup() {
     debugln("\nConnecting to WiFi:");
@@ -54,7 +72,11 @@ class Net {
     mdns_.begin(WiFi.localIP(), hostname);
     char service[80];
     snprintf(service, sizeof (service), "Cricket %016llx._http", get_mac());
-    mdns_.addServiceRecord(service, 80, MDNSServiceTCP);
+    String txt = txt_string("fw=" FIRMWARE_VERSION);
+    if (fleet_namespace_.length() > 0) {
+      txt += txt_string("ns=" + fleet_namespace_.substring(0, 60));
+    }
+    mdns_.addServiceRecord(service, 80, MDNSServiceTCP, txt.c_str());
     debug("http://");
     debug(hostname);
     debugln(".local/");
@@ -63,6 +85,8 @@ class Net {
     // Without this, the server calls delay(1) every loop()!
     server_.enableDelay(false);
 
+    cmd_udp_.begin(kCommandPort);
+
     on("/wifi", [this]() {
       char buf[80];
       wifi_status(buf, sizeof (buf));
@@ -70,31 +94,37 @@ class Net {
     });
   }
 
-  void on(const Uri &uri, WebServer::THandlerFunction handler) {
+  // TXT records are a series of length-prefixed strings.
+  static String txt_string(const String& s) {
+    return String((char)s.length()) + s;
+  }
+
+  // Handlers are reachable both over HTTP and as UDP commands.
+  void on(const char *uri, WebServer::THandlerFunction handler) {
     server_.on(uri, HTTP_ANY, handler);
+    udp_handlers_[String(uri + 1)] = handler;
   }
 
   void sendSuccess(const String& msg = "") {
-    if (msg != "") {
-      server_.send(200, "text/plain", msg + "\n");
-    } else {
-      server_.send(200, "text/plain", "");
-    }
+    send(200, msg);
   }
 
   void sendFailure(const String& msg = "") {
-    if (msg != "") {
-      server_.send(401, "text/plain", msg + "\n");
-    } else {
-      server_.send(401, "text/plain", "");
-    }
+    send(401, msg);
   }
 
   bool hasArg(String name) {
+    if (in_udp_) {
+      return udp_args_.count(name) > 0;
+    }
     return server_.hasArg(name);
   }
 
   String arg(String name) {
+    if (in_udp_) {
+      auto a = udp_args_.find(name);
+      return a == udp_args_.end() ? String("") : a->second;
+    }
     return server_.arg(name);
   }
 
@@ -110,9 +140,66 @@ class Net {
   void loop() {
     mdns_.run();
     server_.handleClient();
+    handle_udp();
   }
 
  private:
+  // The port to listen for UDP commands on. The server assumes this
+  // unless the mDNS record has a "udp" key.
+  static const int kCommandPort = 4210;
+
+  void send(int code, const String& msg) {
+    if (in_udp_) {
+      // Acks look like "<seq> <code> <msg>".
+      cmd_udp_.beginPacket(cmd_udp_.remoteIP(), cmd_udp_.remotePort());
+      cmd_udp_.print(udp_seq_ + " " + String(code) + " " + msg);
+      cmd_udp_.endPacket();
+    } else if (msg != "") {
+      server_.send(code, "text/plain", msg + "\n");
+    } else {
+      server_.send(code, "text/plain", "");
+    }
+  }
+
+  // UDP commands look like "<seq> <command>?<args>", e.g.
+  // "17 blink?speed=1.000&reps=2".
+  void handle_udp() {
+    if (cmd_udp_.parsePacket() <= 0) return;
+    char buf[256];
+    int len = cmd_udp_.read(buf, sizeof (buf) - 1);
+    if (len <= 0) return;
+    buf[len] = '\0';
+
+    char *cmd = strchr(buf, ' ');
+    if (cmd == nullptr) return;
+    *cmd++ = '\0';
+    udp_seq_ = String(buf);
+
+    udp_args_.clear();
+    char *query = strchr(cmd, '?');
+    if (query != nullptr) {
+      *query++ = '\0';
+      for (char *kv = strtok(query, "&"); kv != nullptr; kv = strtok(nullptr, "&")) {
+        char *eq = strchr(kv, '=');
+        if (eq == nullptr) {
+          udp_args_[String(kv)] = "";
+        } else {
+          *eq = '\0';
+          udp_args_[String(kv)] = String(eq + 1);
+        }
+      }
+    }
+
+    in_udp_ = true;
+    auto h = udp_handlers_.find(String(cmd));
+    if (h == udp_handlers_.end()) {
+      send(404, "unknown command");
+    } else {
+      h->second();
+    }
+    in_udp_ = false;
+  }
+
   template <typename T> void debug(T t) {
     if (debug_enabled_) Serial.print(t);
   }
@@ -165,11 +252,18 @@ class Net {
 
   String ssid_;
   String pass_;
+  String fleet_namespace_;
   bool debug_enabled_;
 
   WiFiUDP udp_;
   MDNS mdns_;
   WebServer server_;
+
+  WiFiUDP cmd_udp_;
+  std::map<String, WebServer::THandlerFunction> udp_handlers_;
+  std::map<String, String> udp_args_;
+  String udp_seq_;
+  bool in_udp_ = false;
   EspClass esp_;
 };
 
@@ -257,6 +351,10 @@ class DFCmd {
   virtual String description() const = 0;
   virtual bool loop(DFPlayer&) = 0;
 
+  // The server's token for the command that queued this, if any.
+  void set_token(const String& token) { token_ = token; }
+  const String& token() const { return token_; }
+
  protected:
   template <typename S> void NewState(S s) {
     state_ = static_cast<int>(s);
@@ -287,6 +385,7 @@ class DFCmd {
   int state_;
   bool just_entered_state_;
   int deadline_;
+  String token_;
 };
 
 // Enqueue this after power is provided to DFPlayer.
@@ -507,7 +606,7 @@ class DFPlayerQueue {
     char message[80];
     snprintf(message, sizeof (message), "enqueue: %s", cmd->description());
     debugln(message);
-    work_queue_.push(std::move(cmd));
+    work_queue_.push_back(std::move(cmd));
   }
 
   // Take an action, if possible.
@@ -515,8 +614,19 @@ class DFPlayerQueue {
     if (work_queue_.empty()) return;
     DFCmd& cmd = *work_queue_.front();
     if (!cmd.loop(dfplayer)) {
-      work_queue_.pop();
+      work_queue_.pop_front();
+    }
+  }
+
+  // The tokens of the queued commands that have them, one per line.
+  String tokens() {
+    String result;
+    for (const auto& cmd : work_queue_) {
+      if (cmd->token() != "") {
+        result += cmd->token() + "\n";
+      }
     }
+    return result;
   }
 
   // Indicates how much work there is to be done.
@@ -524,10 +634,15 @@ class DFPlayerQueue {
     return work_queue_.size();
   }
 
+  // Drop any queued commands, including the one in progress.
+  void clear() {
+    work_queue_.clear();
+  }
+
   // Call this when power is about to be removed.
   // This executes synchronously.
   void fini() {
-    std::queue<std::unique_ptr<DFCmd>>().swap(work_queue_);
+    work_queue_.clear();
   }
 
  private:
@@ -535,7 +650,7 @@ class DFPlayerQueue {
     if (debug_enabled_) Serial.println(s);
   }
 
-  std::queue<std::unique_ptr<DFCmd>> work_queue_;
+  std::deque<std::unique_ptr<DFCmd>> work_queue_;
   bool debug_enabled_;
 };
 
@@ -589,7 +704,7 @@ class Firefly {
     pinMode(pin_, OUTPUT);
   }
 
-  void add_blink(float speed, int reps, int delay, int jitter) {
+  void add_blink(float speed, int reps, int delay, int jitter, const String& token) {
     if (speed < 0.01) {
       speed = 0.01;
     } else if (speed >= 255.0) {
@@ -602,7 +717,7 @@ class Firefly {
       jitter = 0;
     }
 
-    blinks_.push(BlinkSet {
+    blinks_.push_back(BlinkSet {
       .speed = speed,
       .reps = reps,
       .delay = delay,
@@ -611,6 +726,25 @@ class Firefly {
       .counter = 0.0,
       .delay_counter = 0,
       .pwm_value = 0,
+      .fade = false,
+      .token = token,
+    });
+  }
+
+  // Move the light's brightness smoothly from wherever it is to "level",
+  // over about "ms" milliseconds, and leave it there.
+  void add_fade(int level, int ms, const String& token) {
+    blinks_.push_back(BlinkSet {
+      .speed = 0.0,
+      .reps = 1,
+      .delay = 0,
+      .jitter = 0,
+      .sign = 0,
+      .counter = 0.0,
+      .delay_counter = ms,
+      .pwm_value = level,
+      .fade = true,
+      .token = token,
     });
   }
 
@@ -618,12 +752,42 @@ class Firefly {
     return blinks_.size() + (b_.reps > 0 ? 1 : 0);
   }
 
+  // The tokens of the current and queued blink sets that have them, one
+  // per line.
+  String tokens() {
+    String result;
+    if (b_.reps > 0 && b_.token != "") {
+      result += b_.token + "\n";
+    }
+    for (const auto& b : blinks_) {
+      if (b.token != "") {
+        result += b.token + "\n";
+      }
+    }
+    return result;
+  }
+
+  // Drop any queued blinks, and turn the light off.
+  void clear() {
+    blinks_.clear();
+    b_.reps = 0;
+    b_.pwm_value = 0;
+    level_ = 0;
+    analogWrite(pin_, 0);
+  }
+
   void loop() {
     // Current blink set is done.
     if (b_.reps <= 0) {
       if (blinks_.empty()) return;
       b_ = blinks_.front();
-      blinks_.pop();
+      blinks_.pop_front();
+      b_.counter = level_;
+    }
+
+    if (b_.fade) {
+      fade();
+      return;
     }
 
     // Delay between blinks in a set.
@@ -656,10 +820,28 @@ class Firefly {
     }
     b_.counter = new_counter;
     b_.pwm_value = static_cast<int>(new_counter);
+    level_ = b_.pwm_value;
     analogWrite(pin_, b_.pwm_value);
   }
 
  private:
+  // One step of a fade. For a fade, "pwm_value" is the target brightness
+  // and "delay_counter" is how many steps are left.
+  void fade() {
+    if (b_.delay_counter <= 0) {
+      b_.counter = b_.pwm_value;
+      b_.reps = 0;
+    } else {
+      b_.counter += (b_.pwm_value - b_.counter) / b_.delay_counter;
+      --b_.delay_counter;
+    }
+    int level = static_cast<int>(b_.counter + 0.5);
+    if (level != level_) {
+      level_ = level;
+      analogWrite(pin_, level_);
+    }
+  }
+
   struct BlinkSet {
     float speed; // how much to increment the PWM value each time
     int reps;    // how many times to blink it
@@ -670,11 +852,14 @@ class Firefly {
     float counter;
     int delay_counter;
     int pwm_value;
+    bool fade;     // a fade to pwm_value, rather than a blink
+    String token;  // the server's token for the command, if any
   };
 
   byte pin_;
-  std::queue<BlinkSet> blinks_;
+  std::deque<BlinkSet> blinks_;
   BlinkSet b_;
+  int level_ = 0;  // the light's current brightness
 };
 
 // ------------------------------------------------------------------
@@ -722,6 +907,7 @@ struct CricketConfig {
 
   String ssid;
   String pass;
+  String fleet_namespace;
 
   int port;
 
@@ -731,7 +917,8 @@ struct CricketConfig {
 class Cricket {
  public:
   Cricket(const CricketConfig& config) :
-      net_(config.ssid, config.pass, config.port, config.debug_enabled),
+      net_(config.ssid, config.pass, config.fleet_namespace, config.port,
+        config.debug_enabled),
       dfplayer_(config.dfplayer_tx_pin, config.dfplayer_rx_pin,
         config.dfplayer_busy_pin),
       dfqueue_(config.debug_enabled),
@@ -743,6 +930,7 @@ class Cricket {
       debug_enabled_(config.debug_enabled) {}
 
   void setup() {
+    sleep_exit();  // in case this is waking up from deep sleep
     net_.setup();
 
     net_.on("/ping", [this]() {
@@ -751,6 +939,7 @@ class Cricket {
     });
 
     net_.on("/play", [this]() {
+      if (repeated_command()) return;
       char msg[80];
       int folder = net_.arg("folder").toInt();
       int file = net_.arg("file").toInt();
@@ -773,7 +962,8 @@ class Cricket {
         if (volume > 0) {
           set_volume(volume, true);
         }
-        play(folder, file, reps, delay, jitter);
+        play(folder, file, reps, delay, jitter, net_.arg("token"));
+        remember_command();
         net_.sendSuccess();
       }
     });
@@ -792,6 +982,7 @@ class Cricket {
     });
 
     net_.on("/blink", [this]() {
+      if (repeated_command()) return;
       float speed = net_.arg("speed").toFloat();
       int delay = net_.arg("delay").toInt();
       int jitter = net_.arg("jitter").toInt();
@@ -801,7 +992,23 @@ class Cricket {
       } else if (reps <= 0) {
         net_.sendFailure("reps must be a positive number");
       } else {
-        add_blink(speed, reps, delay, jitter);
+        add_blink(speed, reps, delay, jitter, net_.arg("token"));
+        remember_command();
+        net_.sendSuccess();
+      }
+    });
+
+    net_.on("/fade", [this]() {
+      if (repeated_command()) return;
+      int level = net_.arg("level").toInt();
+      int ms = net_.arg("ms").toInt();
+      if (level < 0 || level > 255) {
+        net_.sendFailure("level must be between 0 and 255");
+      } else if (ms < 0) {
+        net_.sendFailure("ms must not be negative");
+      } else {
+        add_fade(level, ms, net_.arg("token"));
+        remember_command();
         net_.sendSuccess();
       }
     });
@@ -821,10 +1028,58 @@ class Cricket {
       net_.sendSuccess();
     });
 
+    net_.on("/clear", [this]() {
+      String queue = net_.arg("queue");
+      if (queue == "sound") {
+        clear_sound();
+        net_.sendSuccess();
+      } else if (queue == "light") {
+        clear_light();
+        net_.sendSuccess();
+      } else {
+        net_.sendFailure("queue must be either \"sound\" or \"light\"");
+      }
+    });
+
     net_.on("/battery", [this]() {
       net_.sendSuccess(String(read_battery_voltage()));
     });
 
+    net_.on("/rssi", [this]() {
+      net_.sendSuccess(String(WiFi.RSSI()));
+    });
+
+    net_.on("/temperature", [this]() {
+      net_.sendSuccess(String(temperatureRead()));
+    });
+
+    // Lists the tokens of the queued commands that had them, so the
+    // server can find commands that it didn't send, e.g. because it
+    // restarted since.
+    net_.on("/queue", [this]() {
+      String queue = net_.arg("queue");
+      if (queue == "sound") {
+        net_.sendSuccess(dfqueue_.tokens());
+      } else if (queue == "light") {
+        net_.sendSuccess(firefly_.tokens());
+      } else {
+        net_.sendFailure("queue must be either \"sound\" or \"light\"");
+      }
+    });
+
+    // Goes into deep sleep for "ms" milliseconds, to save the battery
+    // while the installation is closed. The device restarts when it
+    // wakes up, and the server finds it again over mDNS.
+    net_.on("/sleep", [this]() {
+      uint64_t ms = strtoull(net_.arg("ms").c_str(), nullptr, 10);
+      if (ms == 0) {
+        net_.sendFailure("ms must be a positive number");
+      } else {
+        net_.sendSuccess();
+        deep_sleep(ms);
+      }
+    });
+
     net_.on("/soundpending", [this]() {
       net_.sendSuccess(String(sound_pending()));
     });
@@ -852,12 +1107,42 @@ class Cricket {
     dfplayer_extend_lifetime();
   }
 
-  void play(int folder, int file, int reps, int delay, int jitter) {
+  void play(int folder, int file, int reps, int delay, int jitter, const String& token) {
     dfplayer_ensure_powered_on();
-    dfqueue_.add(std::make_unique<PlayCmd>(folder, file, reps, delay, jitter));
+    auto cmd = std::make_unique<PlayCmd>(folder, file, reps, delay, jitter);
+    cmd->set_token(token);
+    dfqueue_.add(std::move(cmd));
     dfplayer_extend_lifetime();
   }
 
+  // Commands that queue something may carry a "token" argument. If the
+  // server retries one after a failure that left it unsure whether the
+  // command arrived, the token is the same, and the repeat is answered
+  // without queueing anything again. Returns true if the command was a
+  // repeat, in which case it has been answered.
+  bool repeated_command() {
+    String token = net_.arg("token");
+    if (token == "") {
+      return false;
+    }
+    for (int i = 0; i < kTokens; i++) {
+      if (tokens_[i] == token) {
+        net_.sendSuccess();
+        return true;
+      }
+    }
+    return false;
+  }
+
+  // Record the token of a command that was queued.
+  void remember_command() {
+    String token = net_.arg("token");
+    if (token != "") {
+      tokens_[next_token_] = token;
+      next_token_ = (next_token_ + 1) % kTokens;
+    }
+  }
+
   void set_volume(int volume, bool persist) {
     dfplayer_ensure_powered_on();
     dfqueue_.add(std::make_unique<VolumeCmd>(volume));
@@ -865,9 +1150,14 @@ class Cricket {
     dfplayer_extend_lifetime();
   }
 
-  void add_blink(float speed, int reps, int delay, int jitter) {
+  void add_blink(float speed, int reps, int delay, int jitter, const String& token) {
     debugln("cricket: adding blink to queue");
-    firefly_.add_blink(speed, reps, delay, jitter);
+    firefly_.add_blink(speed, reps, delay, jitter, token);
+  }
+
+  void add_fade(int level, int ms, const String& token) {
+    debugln("cricket: adding fade to queue");
+    firefly_.add_fade(level, ms, token);
   }
 
   void pause() {
@@ -888,6 +1178,17 @@ class Cricket {
     dfplayer_extend_lifetime();
   }
 
+  void clear_sound() {
+    debugln("cricket: clearing sound queue");
+    dfqueue_.clear();
+    stop();
+  }
+
+  void clear_light() {
+    debugln("cricket: clearing light queue");
+    firefly_.clear();
+  }
+
   float read_battery_voltage() {
     return battery_.read_voltage();
   }
@@ -898,6 +1199,21 @@ class Cricket {
     return firefly_.work_pending();
   }
 
+  // Doesn't return: the device restarts when it wakes up.
+  void deep_sleep(uint64_t msec) {
+    debugln("cricket: deep sleep");
+    dfqueue_.clear();
+    firefly_.clear();
+    if (dfplayer_powered_on()) {
+      dfplayer_power_off();
+    }
+    delay(100);  // let the response get out first
+    sleep_enter();
+    gpio_deep_sleep_hold_en();
+    esp_sleep_enable_timer_wakeup(msec * 1000);
+    esp_deep_sleep_start();
+  }
+
   void sleep_enter() {
     mosfet_.sleep_enter();
   }
@@ -951,6 +1267,9 @@ class Cricket {
   Battery battery_;
 
   byte volume_;
+  static const int kTokens = 16;  // recent command tokens remembered
+  String tokens_[kTokens];
+  int next_token_ = 0;
   unsigned long shutdown_delay_msec_;
   unsigned long shutdown_deadline_;
   bool debug_enabled_;
@@ -972,6 +1291,7 @@ const CricketConfig cricket_config = {
 
   .ssid = SSID,
   .pass = PASSWORD,
+  .fleet_namespace = FLEET_NAMESPACE,
 
   .port = 80,
 
@@ -0,0 +1,57 @@
+// Fleetreport fetches the fleet status report from a running server's
+// admin API, e.g. from a nightly cron job. It writes the report to
+// standard output, or to the file given with -o.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var adminAddr = flag.String("admin", "localhost:8080", "address of the server's admin API")
+var format = flag.String("format", "csv", "report format: \"csv\" or \"json\"")
+var output = flag.String("o", "", "file to write the report to, instead of standard output")
+var timeout = flag.Duration("timeout", 30 * time.Second, "how long to wait for the server")
+
+func main() {
+	flag.Parse()
+
+	u := url.URL{
+		Scheme:		"http",
+		Host:		*adminAddr,
+		Path:		"/report",
+		RawQuery:	url.Values{"format": {*format}}.Encode(),
+	}
+	c := &http.Client{Timeout: *timeout}
+	resp, err := c.Get(u.String())
+	if err != nil {
+		log.Fatalf("failed to fetch report: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("server refused report: %s: %s", resp.Status, body)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("failed to create %q: %v", *output, err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Fatalf("failed to write %q: %v", *output, err)
+			}
+		}()
+		w = f
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Render runs an effect, or the configured players, against a virtual
+// fleet, and mixes what the fleet was asked to play into a stereo WAV
+// file, so a soundscape can be auditioned away from the installation.
+//
+// The crickets' files are MP3s, which this can't decode; the -audio
+// directory must hold 16-bit PCM WAV copies of them, laid out as on the
+// SD cards ("01/002.wav" for folder 1, file 2). Something like
+//
+//	ffmpeg -i 01/002.mp3 audio/01/002.wav
+//
+// makes them. The fleet is made of the configured clients, so that each
+// is panned according to its location, or of -clients virtual clients
+// if none are configured.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/config"
+	"github.com/blakej11/cricket/internal/render"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/virtual"
+)
+
+var configFile = flag.String("config", "", "path to config file")
+var venue = flag.String("venue", "", "name of the venue in the config file to use, if it has several")
+var effectName = flag.String("effect", "", "effect to run once; if empty, the players run for -duration")
+var duration = flag.Duration("duration", time.Minute, "how long to run the players, if no -effect is given")
+var numClients = flag.Int("clients", 20, "size of the virtual fleet, if the config has no clients")
+var audioDir = flag.String("audio", "", "directory of WAV copies of the clients' files")
+var outFile = flag.String("out", "render.wav", "path of the WAV file to write")
+var rate = flag.Int("rate", 44100, "sample rate of the WAV file to write")
+
+func main() {
+	flag.Parse()
+
+	if *configFile == "" {
+		log.Fatal("must specify configuration via \"-config=/path/to/config.json\"")
+	}
+	if *audioDir == "" {
+		log.Fatal("must specify the directory of WAV files via \"-audio=/path/to/dir\"")
+	}
+	jsonBlob, err := os.ReadFile(*configFile)
+	if err != nil {
+		log.Fatalf("could not open config file %q: %v", *configFile, err)
+	}
+	cfg, err := config.ParseJSONForVenue(jsonBlob, *venue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	durations := make(map[[2]int]time.Duration)
+	for _, f := range cfg.Files() {
+		durations[[2]int{f.Folder, f.File}] = time.Duration(f.Duration * float64(time.Second))
+	}
+	durationOf := func(folder, file int) time.Duration {
+		return durations[[2]int{folder, file}]
+	}
+	var fleet *virtual.Fleet
+	if ids := clientIDs(cfg); len(ids) > 0 {
+		fleet, err = virtual.NewWithIDs(ids, durationOf)
+	} else {
+		fleet, err = virtual.New(*numClients, durationOf)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fleet.Close()
+
+	// Configure first, so the devices pick up their locations when
+	// they're added.
+	if *effectName == "" {
+		cfg.RunWithoutDiscovery()
+	} else {
+		cfg.ConfigureClients()
+	}
+	ids := []types.ID{}
+	for _, d := range fleet.Devices() {
+		client.Add(d.ID(), []types.NetLocation{d.NetLocation()}, d.Capabilities())
+		ids = append(ids, d.ID())
+	}
+	fleet.RecordSounds()
+	start := time.Now()
+
+	end := start.Add(*duration)
+	if *effectName == "" {
+		time.Sleep(*duration)
+	} else {
+		e, err := cfg.NewEffect(*effectName, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := e.RunAndWait(); err != nil {
+			log.Fatal(err)
+		}
+		// Include everything the effect queued.
+		end = time.Now()
+		for _, d := range fleet.Devices() {
+			for _, s := range d.Sounds() {
+				if s.Cut.IsZero() && s.End().After(end) {
+					end = s.End()
+				}
+			}
+		}
+	}
+
+	sounds := make(map[types.ID][]virtual.Sound)
+	for _, d := range fleet.Devices() {
+		sounds[d.ID()] = d.Sounds()
+	}
+	f, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = render.Mix(f, render.Config{
+		AudioDir:	*audioDir,
+		Rate:		*rate,
+		Start:		start,
+		Length:		end.Sub(start),
+	}, sounds, client.Locations(ids))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("render: wrote %v of audio to %s", end.Sub(start).Round(time.Millisecond), *outFile)
+}
+
+// clientIDs returns the IDs of the configured clients, in order.
+func clientIDs(cfg *config.ConfigImpl) []types.ID {
+	ids := []types.ID{}
+	for id := range cfg.Clients() {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,238 @@
+// Soak runs the server against a virtual fleet for a long time, while
+// injecting faults (devices failing, going offline and rebooting, and
+// changing address), and checks that the server stays healthy:
+//
+// - the number of goroutines doesn't keep growing,
+// - the lease accounting stays consistent, and
+// - no healthy device goes unused for too long.
+//
+// The configuration should have players for the lease types of
+// interest, since otherwise nothing will run. Soak exits with a nonzero
+// status if any check failed.
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/config"
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/virtual"
+)
+
+var configFile = flag.String("config", "", "path to config file")
+var venue = flag.String("venue", "", "name of the venue in the config file to use, if it has several")
+var numClients = flag.Int("clients", 20, "size of the virtual fleet")
+var duration = flag.Duration("duration", 24 * time.Hour, "how long to run")
+var checkEvery = flag.Duration("check", time.Minute, "time between checks")
+var warmup = flag.Duration("warmup", 5 * time.Minute, "time before the goroutine baseline is taken")
+var faultEvery = flag.Duration("faults", 5 * time.Minute, "mean time between injected faults")
+var maxIdle = flag.Duration("idle", time.Hour, "longest a healthy device may go without playing or blinking")
+
+// How much the number of goroutines may grow beyond the baseline.
+const (
+	goroutineFactor	= 2
+	goroutineSlack	= 100
+)
+
+// device tracks a virtual device's faults and activity.
+type device struct {
+	*virtual.Device
+	healthySince	time.Time	// zero while a fault is in effect
+	lastActive	time.Time
+	activity	int		// plays + blinks so far
+}
+
+func main() {
+	flag.Parse()
+
+	if *configFile == "" {
+		log.Fatal("must specify configuration via \"-config=/path/to/config.json\"")
+	}
+	jsonBlob, err := os.ReadFile(*configFile)
+	if err != nil {
+		log.Fatalf("could not open config file %q: %v", *configFile, err)
+	}
+	cfg, err := config.ParseJSONForVenue(jsonBlob, *venue)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	durations := make(map[[2]int]time.Duration)
+	for _, f := range cfg.Files() {
+		durations[[2]int{f.Folder, f.File}] = time.Duration(f.Duration * float64(time.Second))
+	}
+	fleet, err := virtual.New(*numClients, func(folder, file int) time.Duration {
+		return durations[[2]int{folder, file}]
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fleet.Close()
+
+	// Run first, so the devices pick up any configured locations and
+	// zones when they're added.
+	cfg.RunWithoutDiscovery()
+	start := time.Now()
+	devices := []*device{}
+	for _, d := range fleet.Devices() {
+		devices = append(devices, &device{Device: d, healthySince: start, lastActive: start})
+		add(d)
+	}
+
+	s := &soak{start: start, devices: devices}
+	checks := time.Tick(*checkEvery)
+	fault := time.After(nextFault())
+	end := time.After(*duration)
+	for {
+		select {
+		case <-checks:
+			s.check()
+		case <-fault:
+			s.injectFault()
+			fault = time.After(nextFault())
+		case <-end:
+			s.check()
+			if s.failures > 0 {
+				log.Fatalf("soak: %d failed checks in %v", s.failures, time.Since(start))
+			}
+			log.Printf("soak: passed after %v", time.Since(start))
+			return
+		}
+	}
+}
+
+type soak struct {
+	start		time.Time
+
+	// Faults are cleared from timer goroutines.
+	mu		sync.Mutex
+	devices		[]*device
+	baseline	int	// goroutines after warmup
+	failures	int
+}
+
+func add(d *virtual.Device) {
+	client.Add(d.ID(), []types.NetLocation{d.NetLocation()}, d.Capabilities())
+}
+
+func nextFault() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(*faultEvery))
+}
+
+func (s *soak) fail(format string, args ...any) {
+	s.failures++
+	log.Printf("soak: CHECK FAILED: " + format, args...)
+}
+
+// check looks for problems.
+func (s *soak) check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+
+	g := runtime.NumGoroutine()
+	if s.baseline == 0 && now.Sub(s.start) >= *warmup {
+		s.baseline = g
+		log.Printf("soak: goroutine baseline is %d", g)
+	}
+	if s.baseline > 0 && g > s.baseline * goroutineFactor + goroutineSlack {
+		s.fail("%d goroutines, up from %d", g, s.baseline)
+	}
+
+	for _, ty := range lease.ValidTypes() {
+		if err := lease.Check(ty); err != nil {
+			s.fail("%v lease accounting: %v", ty, err)
+		}
+	}
+
+	idle := 0
+	for _, d := range s.devices {
+		c := d.Counts()
+		if n := c["play"] + c["blink"]; n != d.activity {
+			d.activity = n
+			d.lastActive = now
+		}
+		if d.healthySince.IsZero() {
+			continue
+		}
+		since := later(d.lastActive, d.healthySince)
+		if now.Sub(since) > *maxIdle {
+			s.fail("%s has been idle for %v", d.ID(), now.Sub(since).Round(time.Second))
+			// Only complain once per idle period.
+			d.lastActive = now
+		}
+		if now.Sub(since) > *checkEvery {
+			idle++
+		}
+	}
+	log.Printf("soak: %d goroutines, %d/%d devices idle, %d failed checks",
+	    g, idle, len(s.devices), s.failures)
+}
+
+// injectFault does something unpleasant to a random device.
+func (s *soak) injectFault() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.devices[rand.IntN(len(s.devices))]
+	if d.healthySince.IsZero() {
+		return	// already has a fault
+	}
+
+	switch rand.IntN(3) {
+	case 0:
+		// A brief network problem.
+		dur := time.Duration(10 + rand.IntN(290)) * time.Second
+		log.Printf("soak: %s failing for %v", d.ID(), dur)
+		d.healthySince = time.Time{}
+		d.SetFailing(true)
+		time.AfterFunc(dur, func() {
+			d.SetFailing(false)
+			s.recovered(d)
+		})
+	case 1:
+		// Offline for a while, then reboot and re-announce.
+		dur := time.Duration(5 + rand.IntN(55)) * time.Minute
+		log.Printf("soak: %s offline for %v", d.ID(), dur)
+		d.healthySince = time.Time{}
+		d.SetFailing(true)
+		time.AfterFunc(dur, func() {
+			d.Reboot()
+			d.SetFailing(false)
+			add(d.Device)
+			s.recovered(d)
+		})
+	case 2:
+		// A new address, announced a little later.
+		log.Printf("soak: %s changing address", d.ID())
+		if err := d.Move(); err != nil {
+			s.fail("%v", err)
+			return
+		}
+		d.healthySince = time.Time{}
+		time.AfterFunc(time.Duration(1 + rand.IntN(30)) * time.Second, func() {
+			add(d.Device)
+			s.recovered(d)
+		})
+	}
+}
+
+func (s *soak) recovered(d *device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.healthySince = time.Now()
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,749 @@
+// Package admin serves an HTTP API for inspecting and controlling the
+// server while it runs.
+package admin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blakej11/cricket/internal/beat"
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/config"
+	"github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/estop"
+	"github.com/blakej11/cricket/internal/intensity"
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/occupancy"
+	"github.com/blakej11/cricket/internal/player"
+	"github.com/blakej11/cricket/internal/session"
+	"github.com/blakej11/cricket/internal/startle"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/weather"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Start serves the admin API on the given address, e.g. ":8080".
+func Start(addr string, c *config.ConfigImpl) {
+	cfg = c
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", panel)
+	mux.HandleFunc("GET /algorithms", algorithms)
+	mux.HandleFunc("GET /schema", schema)
+	mux.HandleFunc("GET /fairness", fairness)
+	mux.HandleFunc("GET /map.svg", fleetMap)
+	mux.HandleFunc("GET /clients", clients)
+	mux.HandleFunc("GET /clients/{id}/history", clientHistory)
+	mux.HandleFunc("POST /clients/{id}/locate", locate)
+	mux.HandleFunc("POST /clients/{id}/replace", replaceClient)
+	mux.HandleFunc("PUT /clients/{id}/record", setRecord)
+	mux.HandleFunc("GET /inventory", inventory)
+	mux.HandleFunc("GET /aliases", aliases)
+	mux.HandleFunc("GET /fleet", fleet)
+	mux.HandleFunc("GET /report", report)
+	mux.HandleFunc("GET /probes", probes)
+	mux.HandleFunc("GET /telemetry", telemetry)
+	mux.HandleFunc("GET /energy", energy)
+	mux.HandleFunc("GET /sleep", asleep)
+	mux.HandleFunc("POST /sleep", sleep)
+	mux.HandleFunc("GET /backlog", backlog)
+	mux.HandleFunc("GET /leases", leases)
+	mux.HandleFunc("GET /lateness", lateness)
+	mux.HandleFunc("GET /claims", listClaims)
+	mux.HandleFunc("POST /claims", claimClients)
+	mux.HandleFunc("POST /claims/{handle}", resizeClaim)
+	mux.HandleFunc("DELETE /claims/{handle}", releaseClaim)
+	mux.HandleFunc("GET /events", events)
+	mux.HandleFunc("POST /startle", startleNow)
+	mux.HandleFunc("GET /estop", estopStatus)
+	mux.HandleFunc("POST /estop", estopNow)
+	mux.HandleFunc("POST /estop/release", estopRelease)
+	mux.HandleFunc("POST /pause", pause)
+	mux.HandleFunc("POST /unpause", unpause)
+	mux.HandleFunc("POST /volume", volume)
+	mux.HandleFunc("GET /intensity", getIntensity)
+	mux.HandleFunc("POST /intensity", setIntensity)
+	mux.HandleFunc("GET /beat", getBeat)
+	mux.HandleFunc("POST /beat", setBeat)
+	mux.HandleFunc("GET /weather", getWeather)
+	mux.HandleFunc("POST /weather", setWeather)
+	mux.HandleFunc("GET /occupancy", getOccupancy)
+	mux.HandleFunc("POST /occupancy", setOccupancy)
+	mux.HandleFunc("POST /calibrate/blink", calibrateBlink)
+	mux.HandleFunc("POST /finale", finale)
+	mux.HandleFunc("GET /scenes", scenes)
+	mux.HandleFunc("POST /scenes/{name}", captureScene)
+	mux.HandleFunc("POST /scenes/{name}/recall", recallScene)
+	mux.HandleFunc("DELETE /scenes/{name}", deleteScene)
+	mux.HandleFunc("POST /effects/{name}/preview", preview)
+	mux.HandleFunc("GET /feedback", listFeedback)
+	mux.HandleFunc("POST /feedback", vote)
+	mux.HandleFunc("GET /session", currentSession)
+	mux.HandleFunc("POST /session/start", startSession)
+	mux.HandleFunc("POST /session/stop", stopSession)
+
+	go func() {
+		log.Infof("admin API listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("admin API failed: %v", err)
+		}
+	}()
+}
+
+// The running configuration.
+var cfg *config.ConfigImpl
+
+// panelHTML is a phone-friendly page for operators walking around the
+// installation.
+//
+//go:embed panel.html
+var panelHTML []byte
+
+// ---------------------------------------------------------------------
+
+func panel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(panelHTML)
+}
+
+func algorithms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, effect.Algorithms())
+}
+
+func schema(w http.ResponseWriter, r *http.Request) {
+	s, err := config.Schema()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(s)
+}
+
+// holderFairness compares what a lease holder has received with what
+// its player weight entitles it to.
+type holderFairness struct {
+	lease.HolderStats
+	Weight		float64
+	Entitlement	float64	// weight-proportional share of all client-seconds
+	Ratio		float64	// ClientSeconds / Entitlement
+}
+
+func fairness(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string][]holderFairness)
+	for _, ty := range lease.ValidTypes() {
+		weights := map[string]float64{}
+		if p, ok := cfg.Players()[ty]; ok {
+			weights = p.Weights()
+		}
+		totalWeight := 0.0
+		for _, wt := range weights {
+			totalWeight += wt
+		}
+
+		stats := lease.Stats(ty)
+		totalSeconds := 0.0
+		for _, s := range stats {
+			totalSeconds += s.ClientSeconds
+		}
+
+		hfs := []holderFairness{}
+		for _, s := range stats {
+			hf := holderFairness{
+				HolderStats:	s,
+				Weight:		weights[s.Name],
+			}
+			if totalWeight > 0 {
+				hf.Entitlement = totalSeconds * hf.Weight / totalWeight
+			}
+			if hf.Entitlement > 0 {
+				hf.Ratio = s.ClientSeconds / hf.Entitlement
+			}
+			hfs = append(hfs, hf)
+		}
+		result[ty.String()] = hfs
+	}
+	writeJSON(w, result)
+}
+
+// clients lists the known clients, along with their inventory records,
+// optionally only those whose ID, name, zone, serial number, or notes
+// contain the "q" query parameter.
+func clients(w http.ResponseWriter, r *http.Request) {
+	type clientInfo struct {
+		client.Info
+		Record	*config.Record	`json:",omitempty"`
+	}
+	q := strings.ToLower(r.FormValue("q"))
+	records := cfg.Inventory()
+	result := []clientInfo{}
+	for _, c := range client.List() {
+		info := clientInfo{Info: c}
+		text := string(c.ID) + " " + c.Name + " " + c.Zone
+		if rec, ok := records[c.ID]; ok {
+			info.Record = &rec
+			text += " " + rec.Serial + " " + rec.Notes
+		}
+		if strings.Contains(strings.ToLower(text), q) {
+			result = append(result, info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	writeJSON(w, result)
+}
+
+// inventory returns operators' records of the devices' hardware.
+func inventory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, cfg.Inventory())
+}
+
+// setRecord replaces the record of the client with the ID in the path
+// with the config.Record in the request body. An empty record deletes
+// it.
+func setRecord(w http.ResponseWriter, r *http.Request) {
+	var rec config.Record
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		http.Error(w, "bad record: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := cfg.SetRecord(types.ID(r.PathValue("id")), rec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, rec)
+}
+
+// locate blinks a client, so an operator can find it.
+func locate(w http.ResponseWriter, r *http.Request) {
+	if !client.Locate(types.ID(r.PathValue("id"))) {
+		http.Error(w, "no such client", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// replaceClient makes the client with the ID in the path stand in for
+// the configured client in the "old" query parameter, e.g. after a
+// board is swapped; see client.Alias.
+func replaceClient(w http.ResponseWriter, r *http.Request) {
+	old := r.FormValue("old")
+	if old == "" {
+		http.Error(w, "must say which client is being replaced, with \"old\"", http.StatusBadRequest)
+		return
+	}
+	if err := client.Alias(types.ID(r.PathValue("id")), types.ID(old)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, client.Aliases())
+}
+
+// aliases returns the clients standing in for replaced ones, mapped to
+// the IDs of the clients they replaced.
+func aliases(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.Aliases())
+}
+
+// fleet exports the fleet as a bundle that another config can import;
+// see config.FleetBundle.
+func fleet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Disposition", `attachment; filename="fleet.json"`)
+	writeJSON(w, cfg.Fleet())
+}
+
+// clientHistory returns the recent leases of a client, by lease type.
+func clientHistory(w http.ResponseWriter, r *http.Request) {
+	id := types.ID(r.PathValue("id"))
+	result := make(map[string][]lease.Lease)
+	for _, ty := range lease.ValidTypes() {
+		result[ty.String()] = lease.History(ty)[id]
+	}
+	writeJSON(w, result)
+}
+
+// probes returns each client's round-trip statistics, to find jittery
+// clients.
+func probes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.Probes())
+}
+
+// telemetry returns each client's latest Wi-Fi signal strength and
+// temperature, to find clients that are likely to have trouble.
+func telemetry(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.Telemetry())
+}
+
+// energy returns each client's estimated battery use tonight, and how
+// much it's being held back to last until closing time.
+func energy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.Energy())
+}
+
+// asleep returns when each sleeping client is due to wake up.
+func asleep(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.Asleep())
+}
+
+// sleep puts the clients in the "zone" query parameter's zone (or all
+// clients) to sleep until the "until" query parameter's time of day,
+// e.g. "18:30", waking them up one by one over the "ramp" query
+// parameter's number of minutes before then. Sleeping clients can't be
+// woken up early.
+func sleep(w http.ResponseWriter, r *http.Request) {
+	t, err := time.Parse("15:04", r.FormValue("until"))
+	if err != nil {
+		http.Error(w, "bad until: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	mins := 0.0
+	if m := r.FormValue("ramp"); m != "" {
+		if mins, err = strconv.ParseFloat(m, 64); err != nil || mins < 0 {
+			http.Error(w, "ramp must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now()
+	until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !until.After(now) {
+		until = until.AddDate(0, 0, 1)
+	}
+	client.SleepUntil(client.ZoneIDs(r.FormValue("zone")), until, time.Duration(mins * float64(time.Minute)))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// backlog reports how far behind each lease thread is.
+func backlog(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]lease.Backlog)
+	for _, ty := range lease.ValidTypes() {
+		result[ty.String()] = lease.GetBacklog(ty)
+	}
+	writeJSON(w, result)
+}
+
+// leases reports who holds which clients, and what any lease thread is
+// waiting for; see lease.Snapshot.
+func leases(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, lease.Snapshot())
+}
+
+// lateness reports how far behind schedule requests have been running.
+func lateness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, client.GetLateness())
+}
+
+// startleNow startles the crickets, e.g. when a motion sensor fires.
+func startleNow(w http.ResponseWriter, r *http.Request) {
+	startle.Trigger()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// estopStatus reports whether the installation is stopped.
+func estopStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]bool{"Stopped": estop.Stopped()})
+}
+
+// estopNow silences everything, as the emergency stop button does.
+func estopNow(w http.ResponseWriter, r *http.Request) {
+	estop.Trigger("admin API")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// estopRelease lets the installation carry on after an emergency stop.
+func estopRelease(w http.ResponseWriter, r *http.Request) {
+	estop.Release()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// pause pauses sound on the clients in the "zone" query parameter's
+// zone, or on all clients if it's absent.
+func pause(w http.ResponseWriter, r *http.Request) {
+	client.PauseZone(r.FormValue("zone"))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func unpause(w http.ResponseWriter, r *http.Request) {
+	client.UnpauseZone(r.FormValue("zone"))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// volume turns the volume of the clients in the "zone" query parameter's
+// zone (or all clients) up or down by the "delta" query parameter. Or,
+// if the "level" query parameter is given, it ramps their volume to that
+// level over the "seconds" query parameter's number of seconds.
+func volume(w http.ResponseWriter, r *http.Request) {
+	if l := r.FormValue("level"); l != "" {
+		level, err := strconv.Atoi(l)
+		if err != nil || level < 0 || level > types.MaxVolume {
+			http.Error(w, fmt.Sprintf("level must be between 0 and %d", types.MaxVolume), http.StatusBadRequest)
+			return
+		}
+		secs := 0.0
+		if s := r.FormValue("seconds"); s != "" {
+			if secs, err = strconv.ParseFloat(s, 64); err != nil || secs < 0 {
+				http.Error(w, "seconds must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+		client.RampZoneVolume(r.FormValue("zone"), level, time.Duration(secs * float64(time.Second)))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	delta, err := strconv.Atoi(r.FormValue("delta"))
+	if err != nil {
+		http.Error(w, "bad delta: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	client.AdjustZoneVolume(r.FormValue("zone"), delta)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getIntensity reports the installation's intensity.
+func getIntensity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]float64{"Level": intensity.Level()})
+}
+
+// setIntensity sets the installation's intensity to the "level" query
+// parameter, from 0 to 1.
+func setIntensity(w http.ResponseWriter, r *http.Request) {
+	level, err := strconv.ParseFloat(r.FormValue("level"), 64)
+	if err == nil {
+		err = intensity.Set(level)
+	}
+	if err != nil {
+		http.Error(w, "bad level: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getBeat reports the beat grid, if there is one.
+func getBeat(w http.ResponseWriter, r *http.Request) {
+	g, ok := beat.Get()
+	if !ok {
+		http.Error(w, "no beat grid is set", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, g)
+}
+
+// setBeat sets the beat grid to the "bpm" query parameter's tempo, with
+// the beats shifted by the "phase" query parameter's number of seconds.
+func setBeat(w http.ResponseWriter, r *http.Request) {
+	var g beat.Grid
+	var err error
+	if g.BPM, err = strconv.ParseFloat(r.FormValue("bpm"), 64); err != nil {
+		http.Error(w, "bad bpm: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p := r.FormValue("phase"); p != "" {
+		if g.Phase, err = strconv.ParseFloat(p, 64); err != nil {
+			http.Error(w, "bad phase: " + err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := beat.Set(g); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getWeather reports the weather variables that have values.
+func getWeather(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, weather.Readings())
+}
+
+// setWeather sets the weather variable named by the "name" query
+// parameter to the "value" query parameter, e.g. from a sensor.
+func setWeather(w http.ResponseWriter, r *http.Request) {
+	value, err := strconv.ParseFloat(r.FormValue("value"), 64)
+	if err != nil {
+		http.Error(w, "bad value: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := weather.Set(r.FormValue("name"), value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// calibrateBlink times blinks on the client given by the "id" query
+// parameter, and reports the blink model that fits them, for a device
+// profile. The client should be idle, e.g. claimed; see claimClients.
+func calibrateBlink(w http.ResponseWriter, r *http.Request) {
+	m, err := client.CalibrateBlink(r.Context(), types.ID(r.FormValue("id")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, m)
+}
+
+// getOccupancy reports the latest visitor count.
+func getOccupancy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, occupancy.Get())
+}
+
+// setOccupancy is the webhook for a visitor counter. It takes either a
+// "count" of the visitors present, or the numbers who came "in" and went
+// "out" since the last report.
+func setOccupancy(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if c := r.FormValue("count"); c != "" {
+		var count int
+		if count, err = strconv.Atoi(c); err == nil {
+			err = occupancy.Set(count)
+		}
+	} else {
+		delta := 0
+		for sign, param := range map[int]string{1: "in", -1: "out"} {
+			if v := r.FormValue(param); v != "" && err == nil {
+				var n int
+				n, err = strconv.Atoi(v)
+				delta += sign * n
+			}
+		}
+		if err == nil {
+			err = occupancy.Adjust(delta)
+		}
+	}
+	if err != nil {
+		http.Error(w, "bad visitor count: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// finale runs the configured finale effect.
+func finale(w http.ResponseWriter, r *http.Request) {
+	if cfg.Finale() == "" {
+		http.Error(w, "no finale is configured", http.StatusNotFound)
+		return
+	}
+	e, err := cfg.NewEffect(cfg.Finale(), nil)
+	if err == nil {
+		err = e.Run()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// scenes lists the captured scenes; see config.Scene.
+func scenes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, cfg.Scenes())
+}
+
+// captureScene saves what the installation is doing now as the scene
+// named in the path.
+func captureScene(w http.ResponseWriter, r *http.Request) {
+	s, err := cfg.CaptureScene(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, s)
+}
+
+// recallScene puts the installation back the way it was in the scene
+// named in the path, moving there over the "seconds" query parameter's
+// number of seconds.
+func recallScene(w http.ResponseWriter, r *http.Request) {
+	secs := 0.0
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		if secs, err = strconv.ParseFloat(s, 64); err != nil || secs < 0 {
+			http.Error(w, "seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := cfg.RecallScene(r.PathValue("name"), time.Duration(secs * float64(time.Second))); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deleteScene forgets the scene named in the path.
+func deleteScene(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.DeleteScene(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// How long a preview runs if the request doesn't say.
+const defaultPreview = 10 * time.Second
+
+// preview runs the effect named in the path on the client(s) in the
+// "client" query parameter, for the "seconds" query parameter's number of
+// seconds, without leasing them; see effect.Effect.Preview. The request
+// body may hold a JSON object of parameter settings to try, which
+// replace the configured ones.
+func preview(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := cfg.EffectConfig(name); !ok {
+		http.Error(w, "no such effect", http.StatusNotFound)
+		return
+	}
+	ids := []types.ID{}
+	for _, id := range r.URL.Query()["client"] {
+		ids = append(ids, types.ID(id))
+	}
+	if len(ids) == 0 {
+		http.Error(w, "must name at least one client", http.StatusBadRequest)
+		return
+	}
+	known := make(map[types.ID]bool)
+	for _, id := range client.IDs() {
+		known[id] = true
+	}
+	for _, id := range ids {
+		if !known[id] {
+			http.Error(w, fmt.Sprintf("no such client %q", id), http.StatusNotFound)
+			return
+		}
+	}
+	dur := defaultPreview
+	if s := r.FormValue("seconds"); s != "" {
+		secs, err := strconv.ParseFloat(s, 64)
+		if err != nil || secs <= 0 {
+			http.Error(w, "seconds must be a positive number", http.StatusBadRequest)
+			return
+		}
+		dur = time.Duration(secs * float64(time.Second))
+	}
+	overrides := map[string]random.Config{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+			http.Error(w, "bad parameters: " + err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	e, err := cfg.NewEffect(name, overrides)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Infof("admin: previewing %q on %v for %v", name, ids, dur)
+	e.Preview(ids, dur)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// listFeedback returns operators' feedback on effects so far, by lease
+// type.
+func listFeedback(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]map[string]player.Feedback)
+	for ty, p := range cfg.Players() {
+		result[ty.String()] = p.Feedback()
+	}
+	writeJSON(w, result)
+}
+
+// vote records an operator's "vote" ("more", "less", "never", or
+// "reset") on the effect named by the "effect" query parameter, or, if
+// that's absent, on the effect most recently started by the player of
+// the "type" query parameter's lease type.
+func vote(w http.ResponseWriter, r *http.Request) {
+	v, err := player.ParseVote(r.FormValue("vote"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("effect")
+	var p *player.Player
+	if name != "" {
+		c, ok := cfg.EffectConfig(name)
+		if !ok {
+			http.Error(w, "no such effect", http.StatusNotFound)
+			return
+		}
+		p = cfg.Players()[c.Lease.Type]
+	} else {
+		var ty lease.Type
+		ty.UnmarshalText([]byte(r.FormValue("type")))
+		p = cfg.Players()[ty]
+	}
+	if p == nil {
+		http.Error(w, "no player for that effect or type", http.StatusNotFound)
+		return
+	}
+
+	name, fb, err := p.Vote(name, v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Infof("admin: %q vote on effect %q: now %+v", r.FormValue("vote"), name, fb)
+	if err := cfg.SaveFeedback(); err != nil {
+		log.Warningf("admin: %v", err)
+	}
+	writeJSON(w, map[string]any{"Effect": name, "Feedback": fb})
+}
+
+// currentSession returns the running session's statistics so far.
+func currentSession(w http.ResponseWriter, r *http.Request) {
+	s, ok := session.Current()
+	if !ok {
+		http.Error(w, "no session is running", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s)
+}
+
+// startSession starts a session, named by the "name" query parameter or
+// else by the date. Operators' feedback from any earlier session is
+// forgotten.
+func startSession(w http.ResponseWriter, r *http.Request) {
+	if err := session.Start(r.FormValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	for _, p := range cfg.Players() {
+		p.SetFeedback(nil)
+	}
+	if err := cfg.SaveFeedback(); err != nil {
+		log.Warningf("admin: %v", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// stopSession ends the running session, and returns its summary.
+func stopSession(w http.ResponseWriter, r *http.Request) {
+	s, err := session.Stop()
+	if err != nil && s.Name == "" {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Warningf("admin: %v", err)
+	}
+	writeJSON(w, s)
+}
+
+// ---------------------------------------------------------------------
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
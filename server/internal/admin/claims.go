@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Operators can claim idle clients, to try something out on them
+// without defining an effect. A claim holds its clients, so effects
+// can't use them, until it's released.
+var claims struct {
+	mu	sync.Mutex
+	next	int
+	held	map[string]*claim
+}
+
+func init() {
+	claims.held = make(map[string]*claim)
+}
+
+type claim struct {
+	Handle	string
+	Type	lease.Type
+	Clients	[]types.ID
+	holding	*effect.Holding
+}
+
+// claimClients claims up to the "count" query parameter's number of idle
+// clients of the "type" query parameter's lease type, and returns a
+// handle for releasing them. It takes whatever is idle right away, and
+// fails only if nothing is.
+func claimClients(w http.ResponseWriter, r *http.Request) {
+	var ty lease.Type
+	ty.UnmarshalText([]byte(r.FormValue("type")))
+	if ty == lease.UnknownType {
+		http.Error(w, "type must be \"sound\" or \"light\"", http.StatusBadRequest)
+		return
+	}
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || count <= 0 {
+		http.Error(w, "count must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	claims.mu.Lock()
+	claims.next++
+	handle := fmt.Sprintf("claim%d", claims.next)
+	claims.mu.Unlock()
+
+	// The claim starts with one client and grows from there, so that
+	// it has no maximum, and can be resized later.
+	h, err := effect.Hold("admin " + handle, lease.Config{
+		Type:		ty,
+		MinClients:	1,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	h.Grow(count - 1)
+	c := &claim{Handle: handle, Type: ty, Clients: h.Clients(), holding: h}
+	log.Infof("admin: %s claimed %d %v clients: %v", handle, len(c.Clients), ty, c.Clients)
+	resp := *c
+	claims.mu.Lock()
+	claims.held[handle] = c
+	claims.mu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+// listClaims returns the claims that haven't been released.
+func listClaims(w http.ResponseWriter, r *http.Request) {
+	// Copy the claims, since resizeClaim may change them while they're
+	// being encoded.
+	claims.mu.Lock()
+	result := []claim{}
+	for _, c := range claims.held {
+		result = append(result, *c)
+	}
+	claims.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Handle < result[j].Handle
+	})
+	writeJSON(w, result)
+}
+
+// resizeClaim changes how many clients a claim holds, to the "count"
+// query parameter. Growing takes whatever is idle right away; shrinking
+// gives back the most recently claimed clients, in the background, as
+// releaseClaim does.
+func resizeClaim(w http.ResponseWriter, r *http.Request) {
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || count <= 0 {
+		http.Error(w, "count must be a positive number", http.StatusBadRequest)
+		return
+	}
+	claims.mu.Lock()
+	c, ok := claims.held[r.PathValue("handle")]
+	claims.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such claim", http.StatusNotFound)
+		return
+	}
+
+	held := c.holding.Clients()
+	switch {
+	case count > len(held):
+		more := c.holding.Grow(count - len(held))
+		log.Infof("admin: %s claimed %d more clients: %v", c.Handle, len(more), more)
+		held = append(held, more...)
+	case count < len(held):
+		// The clients given back stay held until the shrink
+		// finishes, but they aren't the claim's any more.
+		giving := held[count:]
+		held = held[:count:count]
+		log.Infof("admin: %s releasing %d clients: %v", c.Handle, len(giving), giving)
+		go c.holding.Shrink(giving, r.FormValue("stop") == "true")
+	}
+	claims.mu.Lock()
+	c.Clients = held
+	resp := *c
+	claims.mu.Unlock()
+	writeJSON(w, resp)
+}
+
+// releaseClaim releases a claim's clients. If the "stop" query parameter
+// is "true", their queues are cleared, rather than played out. The
+// clients are returned in the background, once their queues are empty.
+func releaseClaim(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+	claims.mu.Lock()
+	c, ok := claims.held[handle]
+	delete(claims.held, handle)
+	claims.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such claim", http.StatusNotFound)
+		return
+	}
+	log.Infof("admin: releasing %s", handle)
+	go c.holding.Release(r.FormValue("stop") == "true")
+	w.WriteHeader(http.StatusAccepted)
+}
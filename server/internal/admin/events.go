@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/blakej11/cricket/internal/bus"
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// How often an idle event stream sends a comment, so that proxies and
+// clients don't give up on it.
+const eventKeepalive = 30 * time.Second
+
+// events streams the bus messages with the "key" query parameters' keys
+// (e.g. "intensity", or whatever effects announce), as server-sent
+// events, until the client hangs up. Each event is named by its key,
+// and its data is the bus.Message, as JSON. Like any bus subscriber, a
+// client that doesn't keep up misses messages.
+func events(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		http.Error(w, "must name at least one key", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	msgs := make(chan bus.Message)
+	for _, key := range keys {
+		ch := bus.Shared.Subscribe(ctx, key)
+		go func() {
+			for {
+				select {
+				case m := <-ch:
+					select {
+					case msgs <- m:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	log.Infof("admin: %s subscribed to events %v", r.RemoteAddr, keys)
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case m := <-msgs:
+			b, err := json.Marshal(m)
+			if err != nil {
+				log.Warningf("admin: can't encode %q event: %v", m.Key, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Key, b)
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+		case <-ctx.Done():
+			log.Infof("admin: %s unsubscribed from events", r.RemoteAddr)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// stateColors gives the color of each client state on the fleet map.
+var stateColors = []struct {
+	state	client.State
+	color	string
+}{
+	{client.Playing,	"#2d5"},
+	{client.Blinking,	"#fd3"},
+	{client.Idle,		"#789"},
+	{client.LowBattery,	"#e94"},
+	{client.Offline,	"#d23"},
+	{client.Sleeping,	"#46a"},
+}
+
+const (
+	mapWidth	= 800.0	// pixels, not counting the margin and legend
+	mapMargin	= 20.0
+	legendHeight	= 30.0
+	legendWidth	= 110.0	// per state
+	clientRadius	= 6.0
+)
+
+// fleetMap draws the clients at their physical locations, colored by
+// state. It's a standalone SVG, so it can be embedded elsewhere.
+func fleetMap(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeFleetMap(w, client.List())
+}
+
+func writeFleetMap(w io.Writer, clients []client.Info) {
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+
+	locs := []types.PhysLocation{}
+	for _, c := range clients {
+		locs = append(locs, c.Location)
+	}
+	lo, hi, ok := space.Bounds(locs)
+	if !ok {
+		hi = types.PhysLocation{X: 1, Y: 1}
+	}
+	minX, minY, maxX, maxY := lo.X, lo.Y, hi.X, hi.Y
+	scale := mapWidth / max(maxX - minX, maxY - minY, 1)
+	width := max((maxX - minX) * scale, legendWidth * float64(len(stateColors))) + 2 * mapMargin
+	height := (maxY - minY) * scale + 2 * mapMargin + legendHeight
+
+	colors := make(map[client.State]string)
+	for _, sc := range stateColors {
+		colors[sc.state] = sc.color
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %.0f %.0f" width="%.0f" height="%.0f">`+"\n",
+	    width, height, width, height)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="#111"/>`+"\n")
+	for _, c := range clients {
+		// SVG's y axis points down; the installation's points up.
+		x := (c.Location.X - minX) * scale + mapMargin
+		y := (maxY - c.Location.Y) * scale + mapMargin + legendHeight
+		label := string(c.ID)
+		if c.Name != "" {
+			label = c.Name + " (" + label + ")"
+		}
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="%.0f" fill="%s"><title>%s: %s</title></circle>`+"\n",
+		    x, y, clientRadius, colors[c.State], html.EscapeString(label), c.State)
+	}
+	for i, sc := range stateColors {
+		x := mapMargin + float64(i) * legendWidth
+		fmt.Fprintf(w, `<circle cx="%.0f" cy="%.0f" r="%.0f" fill="%s"/>`+
+		    `<text x="%.0f" y="%.0f" fill="#eee" font-family="sans-serif" font-size="13">%s</text>`+"\n",
+		    x, mapMargin, clientRadius, sc.color, x + 10, mapMargin + 4, sc.state)
+	}
+	fmt.Fprintln(w, `</svg>`)
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// reportRow is one client's line in the fleet report.
+type reportRow struct {
+	ID		types.ID
+	Name		string
+	Zone		string
+	Address		string
+	Firmware	string
+	State		client.State
+	Voltage		float32		// zero if not known yet
+	LastSeen	time.Time	// zero if it's never answered
+	Requests	int
+	Failures	int
+	ErrorRate	float64		// failures per request
+}
+
+// reportColumns are the CSV report's columns, in the order of
+// reportRow's fields.
+var reportColumns = []string{
+	"id", "name", "zone", "address", "firmware", "state", "voltage",
+	"last_seen", "requests", "failures", "error_rate",
+}
+
+// report exports the status of every client, for spreadsheets and
+// nightly reports. The "format" query parameter may be "json" (the
+// default) or "csv".
+func report(w http.ResponseWriter, r *http.Request) {
+	rows := reportRows()
+	filename := "fleet-" + time.Now().Format("2006-01-02")
+	switch format := r.FormValue("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="` + filename + `.json"`)
+		writeJSON(w, rows)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="` + filename + `.csv"`)
+		writeReportCSV(w, rows)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q; must be \"json\" or \"csv\"", format), http.StatusBadRequest)
+	}
+}
+
+func reportRows() []reportRow {
+	rows := []reportRow{}
+	for _, c := range client.List() {
+		row := reportRow{
+			ID:		c.ID,
+			Name:		c.Name,
+			Zone:		c.Zone,
+			Address:	c.Address,
+			Firmware:	c.Firmware,
+			State:		c.State,
+			Voltage:	c.Voltage,
+			LastSeen:	c.LastSeen,
+			Requests:	c.Requests,
+			Failures:	c.Failures,
+		}
+		if c.Requests > 0 {
+			row.ErrorRate = float64(c.Failures) / float64(c.Requests)
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].ID < rows[j].ID
+	})
+	return rows
+}
+
+func writeReportCSV(w http.ResponseWriter, rows []reportRow) {
+	cw := csv.NewWriter(w)
+	cw.Write(reportColumns)
+	for _, row := range rows {
+		lastSeen := ""
+		if !row.LastSeen.IsZero() {
+			lastSeen = row.LastSeen.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			string(row.ID),
+			row.Name,
+			row.Zone,
+			row.Address,
+			row.Firmware,
+			string(row.State),
+			strconv.FormatFloat(float64(row.Voltage), 'f', 2, 32),
+			lastSeen,
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.Failures),
+			strconv.FormatFloat(row.ErrorRate, 'f', 4, 64),
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,83 @@
+// Package beat holds the installation's beat grid: a tempo, and where
+// the beats fall. Effects that quantize their requests to the grid (see
+// effect.Config.QuantizeBeats) stay in time with each other, even when
+// they use different algorithms. Without a grid, nothing is quantized.
+//
+// The beats are counted from the Unix epoch, so the grid is the same
+// for every effect, and across restarts.
+package beat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Grid describes a beat grid.
+type Grid struct {
+	BPM	float64	// beats per minute
+	Phase	float64	// how far the beats are shifted, in seconds
+}
+
+var grid struct {
+	mu	sync.Mutex
+	grid	*Grid
+}
+
+// Check returns an error if the grid is invalid.
+func (g Grid) Check() error {
+	if g.BPM <= 0 || g.BPM > 1000 {
+		return fmt.Errorf("beat grid's BPM %v must be between 0 and 1000", g.BPM)
+	}
+	return nil
+}
+
+// period returns the time between beats.
+func (g Grid) period() time.Duration {
+	return time.Duration(float64(time.Minute) / g.BPM)
+}
+
+// Set replaces the beat grid.
+func Set(g Grid) error {
+	if err := g.Check(); err != nil {
+		return err
+	}
+	grid.mu.Lock()
+	defer grid.mu.Unlock()
+	log.Infof("beat grid set to %v BPM, phase %vs", g.BPM, g.Phase)
+	grid.grid = &g
+	return nil
+}
+
+// Get returns the beat grid, if there is one.
+func Get() (Grid, bool) {
+	grid.mu.Lock()
+	defer grid.mu.Unlock()
+	if grid.grid == nil {
+		return Grid{}, false
+	}
+	return *grid.grid, true
+}
+
+// Next returns the first step of the grid at or after t, where a step
+// is the given number of beats (e.g. 0.5 for eighth notes if a beat is
+// a quarter note). Without a grid, or if beats isn't positive, it
+// returns t.
+func Next(t time.Time, beats float64) time.Time {
+	g, ok := Get()
+	if !ok || beats <= 0 {
+		return t
+	}
+	step := time.Duration(beats * float64(g.period()))
+	if step <= 0 {
+		return t
+	}
+	origin := time.Unix(0, 0).Add(time.Duration(g.Phase * float64(time.Second)))
+	next := origin.Add(t.Sub(origin) / step * step)
+	if next.Before(t) {
+		next = next.Add(step)
+	}
+	return next
+}
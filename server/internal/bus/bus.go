@@ -0,0 +1,86 @@
+// Package bus lets running effects signal each other, so that reactive
+// effects can be built out of simpler ones. For example, a thunder
+// effect can announce each thunderclap, and a lightning effect can flash
+// in response.
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Message is a signal sent on the bus.
+type Message struct {
+	Key	string		// what happened, e.g. "thunderclap"
+	Sender	string		// the name of the sending effect
+	Time	time.Time	// when it was sent
+	Value	float64		// optional, e.g. an intensity
+}
+
+// Bus delivers messages to the subscribers of each key.
+type Bus struct {
+	mu	sync.Mutex
+	subs	map[string]map[chan Message]struct{}
+}
+
+// How many undelivered messages a subscriber can have before further
+// messages to it are dropped.
+const subscriberBuffer = 16
+
+// Shared is the bus used by all effects.
+var Shared = New()
+
+// IntensityKey is the key of messages that set the shared intensity of
+// the scene, e.g. how hard a storm is raging. The Value runs from 0
+// (calm) to 1 (as intense as configured); effects that follow it use
+// the most recent value, and 1 until they've heard one.
+const IntensityKey = "intensity"
+
+func New() *Bus {
+	return &Bus{
+		subs:	make(map[string]map[chan Message]struct{}),
+	}
+}
+
+// Publish sends a message to everything subscribed to its key. It
+// doesn't block; a subscriber that isn't keeping up misses messages.
+func (b *Bus) Publish(m Message) {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[m.Key] {
+		select {
+		case ch <- m:
+		default:
+			log.Warningf("bus: dropping %q message from %q for a slow subscriber", m.Key, m.Sender)
+		}
+	}
+}
+
+// Subscribe returns a channel that receives messages with the given
+// key, until the context is done.
+func (b *Bus) Subscribe(ctx context.Context, key string) <-chan Message {
+	ch := make(chan Message, subscriberBuffer)
+	b.mu.Lock()
+	if _, ok := b.subs[key]; !ok {
+		b.subs[key] = make(map[chan Message]struct{})
+	}
+	b.subs[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[key], ch)
+		if len(b.subs[key]) == 0 {
+			delete(b.subs, key)
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Alias says that the client with ID newID is new hardware standing in
+// for the configured client oldID, e.g. because oldID's board died. The
+// new client takes on oldID's name, zone, and location, both now (if
+// it's already been discovered) and whenever it's added later, so that
+// swapping a board doesn't need a config edit and a restart.
+//
+// Aliases only last until the server exits; to keep one, add it to the
+// config's Aliases.
+func Alias(newID, oldID types.ID) error {
+	ch := make(chan error)
+	enqueueAdminMessage(&aliasMessage{newID: newID, oldID: oldID, response: ch})
+	return <-ch
+}
+
+// Aliases returns the current aliases, from each new client's ID to the
+// ID of the configured client it stands in for.
+func Aliases() map[types.ID]types.ID {
+	ch := make(chan map[types.ID]types.ID)
+	enqueueAdminMessage(&aliasesMessage{response: ch})
+	return <-ch
+}
+
+// configFor returns the configuration for a client, following any alias.
+// This is called by the admin thread.
+func configFor(id types.ID) (types.Client, bool) {
+	if old, ok := data.aliases[id]; ok {
+		id = old
+	}
+	conf, ok := data.config[id]
+	return conf, ok
+}
+
+type aliasMessage struct {
+	newID		types.ID
+	oldID		types.ID
+	response	chan error
+}
+
+func (r *aliasMessage) handle() {
+	oldID := r.oldID
+	// Replacing a replacement means standing in for the original.
+	if orig, ok := data.aliases[oldID]; ok {
+		oldID = orig
+	}
+	if oldID == r.newID {
+		r.response <- fmt.Errorf("client %q can't stand in for itself", r.newID)
+		return
+	}
+	conf, ok := data.config[oldID]
+	if !ok {
+		r.response <- fmt.Errorf("client %q isn't configured, so there's nothing to inherit", oldID)
+		return
+	}
+	data.aliases[r.newID] = oldID
+	log.Infof("client %q now stands in for %q (%q)", r.newID, oldID, conf.Name)
+
+	if c, ok := data.clients[r.newID]; ok {
+		// The lanes' workers may be using the client.
+		c.mu.Lock()
+		c.name = conf.Name
+		moved := c.physLocation != conf.PhysLocation || c.zone != conf.Zone
+		c.physLocation = conf.PhysLocation
+		c.zone = conf.Zone
+		log.Infof("%v took over configuration of %q", *c, oldID)
+		c.mu.Unlock()
+		if moved {
+			lease.SetLocation(c.id, conf.PhysLocation, conf.Zone)
+			invalidateNeighbors()
+		}
+	}
+	r.response <- nil
+}
+
+type aliasesMessage struct {
+	response	chan map[types.ID]types.ID
+}
+
+func (r *aliasesMessage) handle() {
+	aliases := make(map[types.ID]types.ID)
+	for n, o := range data.aliases {
+		aliases[n] = o
+	}
+	r.response <- aliases
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Budget limits how many clients in a zone may play loudly at once, to
+// keep the overall sound level sane when several sound effects overlap
+// in the same area.
+type Budget struct {
+	MaxPlaying	int	// clients that may play above Volume at once
+	Volume		int	// plays at or below this volume don't count
+}
+
+var budgets struct {
+	mu	sync.Mutex
+	zones	map[string]Budget
+
+	// when each client's current loud play is expected to end, by zone
+	loud	map[string]map[types.ID]time.Time
+}
+
+func init() {
+	budgets.zones = make(map[string]Budget)
+	budgets.loud = make(map[string]map[types.ID]time.Time)
+}
+
+// SetBudget sets the loudness budget for a zone.
+func SetBudget(zone string, b Budget) error {
+	if b.MaxPlaying < 0 {
+		return fmt.Errorf("zone %q: MaxPlaying %d must not be negative", zone, b.MaxPlaying)
+	}
+	if b.Volume < 0 || b.Volume > types.MaxVolume {
+		return fmt.Errorf("zone %q: volume %d must be between 0 and %d inclusive",
+		    zone, b.Volume, types.MaxVolume)
+	}
+	budgets.mu.Lock()
+	defer budgets.mu.Unlock()
+	budgets.zones[zone] = b
+	return nil
+}
+
+// budgetVolume returns the volume that a client may play at for the
+// given duration, given its zone's budget. If the zone's budget is used
+// up, the volume is lowered to the budget's threshold.
+func (c *client) budgetVolume(volume int, dur time.Duration) int {
+	budgets.mu.Lock()
+	defer budgets.mu.Unlock()
+
+	b, ok := budgets.zones[c.zone]
+	if !ok || volume <= b.Volume {
+		return volume
+	}
+	loud, ok := budgets.loud[c.zone]
+	if !ok {
+		loud = make(map[types.ID]time.Time)
+		budgets.loud[c.zone] = loud
+	}
+	now := time.Now()
+	playing := 0
+	for id, end := range loud {
+		if !end.After(now) {
+			delete(loud, id)
+		} else if id != c.id {
+			playing++
+		}
+	}
+	if playing >= b.MaxPlaying {
+		log.Infof("%v zone %q has %d loud clients; playing at volume %d rather than %d",
+		    *c, c.zone, playing, b.Volume, volume)
+		return b.Volume
+	}
+	loud[c.id] = later(loud[c.id], now).Add(dur)
+	return volume
+}
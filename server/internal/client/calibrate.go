@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// A BlinkModel says how long a device takes to carry out a Blink. Each
+// rep ramps the light up and back down, which takes Ramp / Speed
+// milliseconds, then waits for the blink's Delay, plus Overhead
+// milliseconds. Getting this wrong doesn't fail anything; it just lets
+// the server's idea of the device's queue drift from the truth, which
+// desynchronizes light effects, so it's worth measuring for new
+// hardware with CalibrateBlink.
+type BlinkModel struct {
+	Ramp		float64
+	Overhead	float64
+}
+
+// The original hardware ramps through 256 levels each way, at one level
+// per millisecond at speed 1.
+var defaultBlinkModel = BlinkModel{Ramp: 256 * 2}
+
+// Check returns an error if the model is invalid.
+func (m BlinkModel) Check() error {
+	if m.Ramp <= 0 {
+		return fmt.Errorf("ramp %v must be positive", m.Ramp)
+	}
+	if m.Overhead < 0 {
+		return fmt.Errorf("overhead %v must not be negative", m.Overhead)
+	}
+	return nil
+}
+
+func (m BlinkModel) duration(r *Blink) time.Duration {
+	pause := m.Ramp / r.Speed + m.Overhead + float64(r.Delay.Milliseconds())
+	pause *= float64(r.Reps)
+	return time.Duration(pause * float64(time.Millisecond))
+}
+
+// ---------------------------------------------------------------------
+
+// The blinks that CalibrateBlink times. Both speed and reps vary, so that
+// time per rep can be told apart from the fixed cost of measuring.
+var calibrationBlinks = []Blink{
+	{Speed: 1, Reps: 2},
+	{Speed: 1, Reps: 4},
+	{Speed: 2, Reps: 2},
+	{Speed: 2, Reps: 6},
+	{Speed: 4, Reps: 4},
+	{Speed: 4, Reps: 8},
+	{Speed: 8, Reps: 8},
+}
+
+// How often CalibrateBlink asks whether a blink has finished.
+const calibrationPoll = 10 * time.Millisecond
+
+// CalibrateBlink measures how long a reference device actually takes to
+// carry out a series of blinks, and fits a BlinkModel to the timings,
+// for the profile of devices like it. The device should be otherwise
+// idle (e.g. claimed through the admin API), since anything else it does
+// would throw the timings off. It takes about ten seconds.
+func CalibrateBlink(ctx context.Context, id types.ID) (BlinkModel, error) {
+	if n, err := queueDepth(ctx, id, lease.Light); err != nil {
+		return BlinkModel{}, err
+	} else if n > 0 {
+		return BlinkModel{}, fmt.Errorf("client %q has %d blinks queued; it must be idle", id, n)
+	}
+
+	// Each timing is modeled as
+	//     ms = Ramp * reps / speed + Overhead * reps + bias
+	// where the bias is the time it takes to send the blink and to see
+	// that it's done.
+	var xs [][3]float64
+	var ys []float64
+	for _, b := range calibrationBlinks {
+		d, err := timeBlink(ctx, id, &b)
+		if err != nil {
+			return BlinkModel{}, err
+		}
+		reps := float64(b.Reps)
+		xs = append(xs, [3]float64{reps / b.Speed, reps, 1})
+		ys = append(ys, float64(d) / float64(time.Millisecond))
+	}
+	coef, err := leastSquares(xs, ys)
+	if err != nil {
+		return BlinkModel{}, err
+	}
+	m := BlinkModel{Ramp: coef[0], Overhead: max(coef[1], 0)}
+	log.Infof("calibrated blinks on %q: ramp %.1fms, overhead %.1fms per rep, bias %.1fms",
+	    id, coef[0], coef[1], coef[2])
+	if err := m.Check(); err != nil {
+		return BlinkModel{}, fmt.Errorf("timings from %q don't fit a blink model: %w", id, err)
+	}
+	return m, nil
+}
+
+// timeBlink sends a blink to a device, and waits until it's done.
+func timeBlink(ctx context.Context, id types.ID, b *Blink) (time.Duration, error) {
+	results := make(chan Result, 1)
+	start := time.Now()
+	ActionWithResults([]types.ID{id}, ctx, b, time.Time{}, results)
+	if r := <-results; r.Err != nil {
+		return 0, r.Err
+	}
+	for {
+		n, err := queueDepth(ctx, id, lease.Light)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return time.Since(start), nil
+		}
+		sleepCtx(ctx, calibrationPoll)
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// leastSquares fits y = c[0] * x[0] + c[1] * x[1] + c[2] * x[2], by
+// solving the normal equations.
+func leastSquares(xs [][3]float64, ys []float64) ([3]float64, error) {
+	var a [3][4]float64
+	for k, x := range xs {
+		for i := range 3 {
+			for j := range 3 {
+				a[i][j] += x[i] * x[j]
+			}
+			a[i][3] += x[i] * ys[k]
+		}
+	}
+	// Gaussian elimination, with partial pivoting.
+	for col := range 3 {
+		pivot := col
+		for row := col + 1; row < 3; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return [3]float64{}, fmt.Errorf("timings can't be fitted")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := range 3 {
+			if row == col {
+				continue
+			}
+			f := a[row][col] / a[col][col]
+			for j := col; j < 4; j++ {
+				a[row][j] -= f * a[col][j]
+			}
+		}
+	}
+	var c [3]float64
+	for i := range 3 {
+		c[i] = a[i][3] / a[i][i]
+	}
+	return c, nil
+}
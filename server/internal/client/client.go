@@ -1,13 +1,12 @@
 package client
 
 import (
-	"container/heap"
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blakej11/cricket/internal/fileset"
@@ -17,15 +16,56 @@ import (
 )
 
 // Add allows the mDNS thread to add information about a newly discovered
-// client.
-func Add(id types.ID, loc types.NetLocation) {
-	enqueueAdminMessage(&addClientMessage{id: id, location: loc})
+// client. A client may have several addresses, in order of preference;
+// if one stops working, the next one is tried.
+func Add(id types.ID, locs []types.NetLocation, caps types.Capabilities) {
+	enqueueAdminMessage(&addClientMessage{id: id, locations: locs, capabilities: caps})
+}
+
+func addWithTransport(id types.ID, loc types.NetLocation, caps types.Capabilities, t transport) {
+	enqueueAdminMessage(&addClientMessage{id: id, locations: []types.NetLocation{loc}, capabilities: caps, transport: t})
 }
 
 // Request that some clients perform an action.
+// If the context carries a RequestBudget, this waits for room in it,
+// and drops the request if the context ends first. If it carries a
+// Quantizer or StartOffsets, they move timed requests' start times.
 func Action(ids []types.ID, ctx context.Context, req clientRequest, earliest time.Time) {
+	ActionWithResults(ids, ctx, req, earliest, nil)
+}
+
+// Result says how a request turned out on one client.
+type Result struct {
+	ID	types.ID
+	Body	string	// the device's last response while handling the request
+	Err	error
+}
+
+// ActionWithResults is like Action, but also sends each client's Result
+// on the results channel, which should have room for one per client.
+// A request that's dropped because its context is done, or skipped
+// because the client can't handle it, still gets a Result.
+func ActionWithResults(ids []types.ID, ctx context.Context, req clientRequest, earliest time.Time, results chan<- Result) {
 	for _, id := range ids {
-		action(id, ctx, req, earliest)
+		c, ok := data.clients[id]
+		if !ok {
+			log.Fatalf("can't execute request on nonexistent client %q", id)
+		}
+		msg := clientMessage{
+			ctx:		ctx,
+			clientRequest:	req,
+			earliest:	dueTime(ctx, id, req, earliest),
+			results:	results,
+		}
+		var acquired bool
+		if msg.budget, acquired = acquire(ctx); !acquired {
+			msg.report(c, ctx.Err())
+			continue
+		}
+		msg.external = true
+		if !c.enqueue(ctx, msg) {
+			msg.report(c, ctx.Err())
+		}
 	}
 }
 
@@ -37,13 +77,26 @@ func action(id types.ID, ctx context.Context, req clientRequest, earliest time.T
 	if !ok {
 		log.Fatalf("can't execute request on nonexistent client %q", id)
 	}
-	c.heapChannel <- clientMessage{
+	c.lanes[laneOf(req)].heapChannel <- clientMessage{
 		ctx:		ctx,
 		clientRequest:	req,
 		earliest:	earliest,
 	}
 }
 
+// IDs returns the IDs of all known clients.
+func IDs() []types.ID {
+	return ZoneIDs("")
+}
+
+// ZoneIDs returns the IDs of the known clients in a zone, or of all
+// known clients if zone is empty.
+func ZoneIDs(zone string) []types.ID {
+	ch := make(chan []types.ID)
+	enqueueAdminMessage(&idsMessage{zone: zone, response: ch})
+	return <-ch
+}
+
 // ---------------------------------------------------------------------
 
 func Configure(defaultVolume int, clients map[types.ID]types.Client) { 
@@ -66,15 +119,22 @@ const (
 	// Time between voltage updates.
 	voltageUpdateDelay = 60 * time.Second
 
-	// Time between getURL() calls to a given client, to avoid "connection reset by peer".
-	postGetURLDelay = 30 * time.Millisecond
+	// Anything above this from a device's battery is a garbled reading.
+	maxVoltage = 10.0
+
+	// How far a device's queue may drift from our estimate of it
+	// before we complain and correct the estimate.
+	queueDriftThreshold = 2 * time.Second
 )
 
 func init() {
 	data.clients = make(map[types.ID]*client)
 	data.ch = make(chan adminMessage)
 	data.config = make(map[types.ID]types.Client)
+	data.aliases = make(map[types.ID]types.ID)
+	data.commandTransports = make(map[string]transport)
 	data.defaultVolume = 24 // midway between min (0) and max (48)
+	data.maxQueue = defaultMaxQueue
 
 	go func() {	// The admin thread.
 		for msg := range data.ch {
@@ -90,55 +150,142 @@ var data struct {
 	// Client information from startup configuration.
 	defaultVolume	int
 	config		map[types.ID]types.Client
+
+	// Replacement hardware, from new ID to configured ID; see Alias.
+	aliases		map[types.ID]types.ID
+
+	// Transports chosen for particular commands; see SetTransport.
+	commandTransports	map[string]transport
+
+	// Device profiles, by name; see SetProfiles.
+	profiles	map[string]Profile
+
+	// Limits on clients' heaps; see SetQueueLimit.
+	maxQueue	int
+	overflow	OverflowPolicy
+
+	// Whether clients are muted; see MuteAll.
+	muted		bool
 }
 
 // ---------------------------------------------------------------------
 // Admin message handling - performed by the admin thread.
 
+type idsMessage struct {
+	zone		string
+	response	chan []types.ID
+}
+
+func (r *idsMessage) handle() {
+	ids := []types.ID{}
+	for id, c := range data.clients {
+		if r.zone == "" || r.zone == c.zone {
+			ids = append(ids, id)
+		}
+	}
+	r.response <- ids
+}
+
 type addClientMessage struct {
 	id		types.ID
-	location	types.NetLocation
+	locations	[]types.NetLocation
+	capabilities	types.Capabilities
+	transport	transport	// nil means HTTP
 }
 
 func (r *addClientMessage) handle() {
-	if _, ok := data.clients[r.id]; ok {
-		c := data.clients[r.id]
-		log.Infof("%v got new add from existing client", *c)
-		if !c.netLocation.Address.Equal(r.location.Address) ||
-		   c.netLocation.Port != r.location.Port {
-			log.Infof("%v updating net to %v", *c, r.location)
-			c.netLocation = r.location
-		}
+	if c, ok := data.clients[r.id]; ok {
+		c.rediscover(r)
 		return
 	}
 
+	t := r.transport
+	if t == nil {
+		t = &httpTransport{}
+	}
+
 	physLocation := types.PhysLocation{}
 	name := ""
-	if conf, ok := data.config[r.id]; ok {
+	zone := ""
+	if conf, ok := configFor(r.id); ok {
 		physLocation = conf.PhysLocation
+		zone = conf.Zone
 		name = conf.Name
 	}
 
 	c := &client{
 		id:		r.id,
-		netLocation:	r.location,
+		netLocation:	r.locations[0],
+		netLocations:	r.locations,
 		physLocation:	physLocation,
+		zone:		zone,
 		name:		name,
-
-		heapChannel:	make(chan clientMessage),
-		deviceChannel:	make(chan clientMessage),
-		heap:		&clientMessageHeap{},
+		capabilities:	r.capabilities,
+		profile:	profileFor(r.capabilities),
+		transport:	t,
 
 		creation:	time.Now(),
 
 		targetVolume:	data.defaultVolume,
+		muted:		data.muted,
+
+		gateWarned:	make(map[string]bool),
+		queueEnd:	make(map[lease.Type]time.Time),
+	}
+	c.mu = &sync.Mutex{}
+	c.gate = newGate()
+	for l := range numLanes {
+		c.lanes[l] = newLaneQueue(l)
 	}
 	data.clients[r.id] = c
 	log.Infof("%v adding new client", *c)
+	if c.profile != nil {
+		log.Infof("%v using device profile %q", *c, c.profile.name)
+	}
+	invalidateNeighbors()
 
+	c.updateStatus()
 	c.start()
 
-	lease.Add(r.id, physLocation)
+	lease.Add(r.id, physLocation, zone, r.capabilities)
+}
+
+// rediscover updates an existing client from a new add. The lanes'
+// workers may be using the client, so this takes its lock.
+func (c *client) rediscover(r *addClientMessage) {
+	c.mu.Lock()
+	log.Infof("%v got new add from existing client", *c)
+	if !slices.EqualFunc(c.netLocations, r.locations, types.NetLocation.Equal) {
+		log.Infof("%v updating net to %v", *c, r.locations)
+		c.netLocations = r.locations
+		if !slices.ContainsFunc(c.netLocations, c.netLocation.Equal) {
+			c.netLocation = r.locations[0]
+		}
+	}
+	if c.capabilities.Firmware != r.capabilities.Firmware {
+		log.Infof("%v firmware changed from %q to %q", *c,
+		    c.capabilities.Firmware, r.capabilities.Firmware)
+	}
+	startLevel := !c.capabilities.Has([]string{levelFeature}) &&
+	    r.capabilities.Has([]string{levelFeature})
+	c.capabilities = r.capabilities
+	p := profileFor(c.capabilities)
+	if p != nil && (c.profile == nil || p.name != c.profile.name) {
+		log.Infof("%v using device profile %q", *c, p.name)
+	}
+	c.profile = p
+	if r.transport != nil && r.transport != c.transport {
+		log.Infof("%v switching to %T", *c, r.transport)
+		c.transport = r.transport
+	}
+	c.mu.Unlock()
+
+	if startLevel {
+		action(c.id, context.Background(), &KeepLevelUpdated{}, time.Now())
+	}
+	if isAsleep(c.id) {
+		action(c.id, context.Background(), &wake{}, time.Now())
+	}
 }
 
 // ---------------------------------------------------------------------
@@ -147,26 +294,57 @@ func (r *addClientMessage) handle() {
 type client struct {
 	id		types.ID
         name		string
-        netLocation	types.NetLocation
+        netLocation	types.NetLocation	// the address currently in use
+	netLocations	[]types.NetLocation	// all known addresses
 	physLocation	types.PhysLocation
+	zone		string
+	capabilities	types.Capabilities
+	profile		*profile	// nil if it has none
+	transport	transport
 
-	heap		*clientMessageHeap
-
-	// messages from API clients to the heap manager
-	heapChannel	chan clientMessage
+	lanes		[numLanes]*laneQueue
+	gate		*gate	// every lane's requests to the device go through it
 
-	// messages from the heap manager to the device thread
-	deviceChannel	chan clientMessage
+	// held by a lane's worker while it handles a request, except while
+	// the request waits for the device; everything below is only used
+	// with it held
+	mu		*sync.Mutex
 
         creation        time.Time
         lastPing        time.Time
-	nextGetURL	time.Time
-        lastSuccessCmd  time.Time
-        lastFailureCmd  time.Time
+	tokenSeq	uint64	// of the last queueing command; see queueURL
+	failures	int	// failed requests, ever
+	requests	int	// requests sent, ever
+
+	// cached by baseURL
+	base		string
+	baseLocation	types.NetLocation
         lastVoltageUpdate	time.Time
         voltage		float32
 
         targetVolume    int
+	rampSeq		uint64	// of the latest RampVolume
+
+	// from KeepLevelUpdated
+	playedVolume	int	// of the last play
+	peakLevel	float32	// since the previous query, from 0 to 1
+	clips		int	// times the output clipped, ever
+	volumeCap	int	// if nonzero, the loudest the device may play
+
+	// kinds of request we've warned about being unsupported
+	gateWarned	map[string]bool
+
+	// when each of the device's queues is expected to drain
+	queueEnd	map[lease.Type]time.Time
+
+	// when the device was paused, or zero if it isn't paused
+	pausedAt	time.Time
+
+	// whether the device is muted; see MuteAll
+	muted		bool
+
+	// when the device wakes up, if it's asleep; see Sleep
+	asleepUntil	time.Time
 }
 
 func (c client) String() string {
@@ -177,6 +355,22 @@ type clientMessage struct {
 	ctx		context.Context
 	clientRequest
 	earliest	time.Time
+	results		chan<- Result	// may be nil
+	budget		*RequestBudget	// may be nil
+	body		string	// the device's last response, once it's handled
+
+	seq		uint64	// order of arrival in the heap
+	external	bool	// sent by Action, not by the client itself
+	counted		bool	// holds a place in the client's room
+}
+
+// report sends the message's result, if anyone wants it, and releases
+// its place in the budget.
+func (m clientMessage) report(c *client, err error) {
+	m.budget.release()
+	if m.results != nil {
+		m.results <- Result{ID: c.id, Body: m.body, Err: err}
+	}
 }
 
 type clientMessageHeap []clientMessage
@@ -207,6 +401,10 @@ func (h *clientMessageHeap) Pop() any {
 }
 
 // not part of the containers/heap interface
+func (h *clientMessageHeap) peek() clientMessage {
+	return (*h)[0]
+}
+
 func (h *clientMessageHeap) nextDeadline() time.Time {
 	if len(*h) == 0 {
 		// an arbitrary timeout; if nothing happens between now and
@@ -217,68 +415,190 @@ func (h *clientMessageHeap) nextDeadline() time.Time {
 }
 
 func (c *client) start() {
-	go c.heapThread()
-	go c.deviceThread()
+	for _, q := range c.lanes {
+		go c.heapThread(q)
+		go c.deviceThread(q)
+	}
+
+	for _, ty := range lease.ValidTypes() {
+		action(c.id, context.Background(), &ReconcileQueue{Type: ty}, time.Now())
+	}
 
 	s := &Stop{}
 	action(c.id, context.Background(), s, time.Now())
 
 	v := &SetVolume{Volume: c.targetVolume}
 	action(c.id, context.Background(), v, time.Now())
+	if c.muted {
+		m := &Mute{}
+		action(c.id, context.Background(), m, time.Now())
+	}
 
 	k := &KeepVoltageUpdated{}
 	action(c.id, context.Background(), k, time.Now().Add(voltageUpdateDelay))
+
+	p := &Probe{}
+	action(c.id, context.Background(), p, time.Now().Add(probeDelay))
+
+	t := &KeepTelemetryUpdated{}
+	action(c.id, context.Background(), t, time.Now().Add(telemetryDelay))
+
+	if c.capabilities.Has([]string{levelFeature}) {
+		l := &KeepLevelUpdated{}
+		action(c.id, context.Background(), l, time.Now().Add(levelDelay))
+	}
 }
 
-func (c *client) heapThread() {
+func (c *client) heapThread(q *laneQueue) {
+	// Reuse one timer, rather than allocating one per message.
+	timer := time.NewTimer(0)
 	for {
+		timer.Reset(time.Until(q.heap.nextDeadline()))
 		select {
-		case msg := <-c.heapChannel:
-			heap.Push(c.heap, msg)
+		case msg := <-q.heapChannel:
+			c.push(q, msg)
+			continue
+		case done := <-q.flushChannel:
+			c.dropSilenced(q)
+			close(done)
 			continue
-		case <-time.After(time.Until(c.heap.nextDeadline())):
+		case <-timer.C:
 			// there's at least one message ready to dequeue
 		}
 
-		poppedMsg := heap.Pop(c.heap).(clientMessage)
+		poppedMsg := q.heap.peek()
 		if poppedMsg.ctx.Err() != nil {
+			c.pop(q)
 			log.Infof("%v: discarding expired message: %v", *c, poppedMsg.ctx.Err())
+			poppedMsg.report(c, poppedMsg.ctx.Err())
 			continue
 		}
 
 		select {
-		case msg := <-c.heapChannel:
+		case msg := <-q.heapChannel:
 			// We got another incoming message before we were
 			// able to push this one to the device channel.
 			// Try again.
-			heap.Push(c.heap, msg)
-			heap.Push(c.heap, poppedMsg)
-		case c.deviceChannel <- poppedMsg:
+			c.push(q, msg)
+		case done := <-q.flushChannel:
+			c.dropSilenced(q)
+			close(done)
+		case q.deviceChannel <- poppedMsg:
+			c.pop(q)
 			// Successfully sent the popped message.
 		}
 	}
 }
 
-func (c *client) deviceThread() {
+// deviceThread is a lane's worker.
+func (c *client) deviceThread(q *laneQueue) {
 	for {
 		select {
-		case msg := <-c.deviceChannel:
-			err := msg.clientRequest.handle(msg.ctx, c)
-			if err != nil {
-				log.Errorf("%v request failed: %v", *c, err)
-			}
+		case msg := <-q.deviceChannel:
+			c.run(q, msg)
+		}
+	}
+}
+
+// run handles one of a lane's requests, once it's due.
+func (c *client) run(q *laneQueue, msg clientMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !msg.earliest.IsZero() {
+		recordLateness(c, max(time.Since(msg.earliest), 0))
+	}
+	req := c.supportedRequest(msg.clientRequest)
+	if req == nil {
+		msg.report(c, fmt.Errorf("%T not supported", msg.clientRequest))
+		return
+	}
+	f := &inFlight{lane: q.lane, fence: c.gate.fence.Load()}
+	err := req.handle(withInFlight(msg.ctx, f), c)
+	msg.body = f.body
+	msg.report(c, err)
+	if err != nil && !errors.Is(err, errAsleep) && !errors.Is(err, errMuted) {
+		log.Errorf("%v request failed: %v", *c, err)
+	} else if _, ok := req.(timedRequest); ok {
+		recordLatency(c.id, time.Since(msg.earliest))
+	}
+	c.updateStatus()
+}
+
+// meets reports whether this client's firmware meets a requirement.
+func (c *client) meets(r requirement) bool {
+	return c.capabilities.Has(r.Features) && c.capabilities.FirmwareAtLeast(r.Firmware)
+}
+
+// supportedRequest returns a version of the request that this client's
+// firmware can handle, or nil if it can't handle the request at all.
+// This warns the first time each kind of request is changed or skipped.
+func (c *client) supportedRequest(req clientRequest) clientRequest {
+	g, ok := req.(gatedRequest)
+	if !ok {
+		return req
+	}
+	r := g.requires()
+	if c.meets(r) {
+		return req
+	}
+
+	kind := fmt.Sprintf("%T", req)
+	var replacement clientRequest
+	if d, ok := req.(downgradableRequest); ok {
+		replacement = d.downgrade()
+	}
+	if !c.gateWarned[kind] {
+		c.gateWarned[kind] = true
+		what := "skipping"
+		if replacement != nil {
+			what = fmt.Sprintf("sending %T instead", replacement)
 		}
+		log.Warningf("%v firmware %q (features %v) doesn't support %s (needs %+v); %s",
+		    *c, c.capabilities.Firmware, c.capabilities.Features, kind, r, what)
 	}
+	return replacement
 }
 
 // ------------------------------------------------------------------
-// The following code is only run from the deviceThread.
+// The following code is only run from a deviceThread, with the client's
+// lock held.
 
 // The commands that a client can handle implement this interface.
 type clientRequest interface {
 	handle(ctx context.Context, c *client) error
 }
 
+// timedRequest is implemented by requests that start something on the
+// device that lasts a while, such as playing a sound. Their latency is
+// tracked so that effects can send them early; see Pacer.
+type timedRequest interface {
+	clientRequest
+	Duration() time.Duration
+}
+
+// requirement describes what a client needs in order to handle a request.
+type requirement struct {
+	Firmware	string		// minimum firmware version
+	Features	[]string	// advertised features
+}
+
+// The first firmware version to advertise itself, in the "fw" key of its
+// mDNS record. Older firmware lacks the clear, fade, queue, rssi, sleep,
+// and temperature endpoints.
+const versionedFirmware = "1.1"
+
+// Requests that not every client's firmware can handle implement this.
+type gatedRequest interface {
+	requires() requirement
+}
+
+// Gated requests that have a fallback for older firmware implement this.
+type downgradableRequest interface {
+	// downgrade returns a request that older firmware can handle,
+	// or nil if the request should just be skipped.
+	downgrade() clientRequest
+}
+
 type Ping struct {}
 
 func (r *Ping) handle(ctx context.Context, c *client) error {
@@ -314,21 +634,27 @@ func (r *Play) handle(ctx context.Context, c *client) error {
             *c, r.File.Folder, r.File.File, r.Reps, r.Delay.Milliseconds(), r.Jitter.Milliseconds(),
             r.Duration().Seconds())
 
-	if r.Reps == 0 {
+	if r.Reps == 0 || c.muted {
 		return nil
 	}
 	volume := r.Volume
 	if volume == 0 {
 		volume = c.targetVolume
 	}
+	volume = c.capped(c.budgetVolume(volume, r.Duration()))
+	c.playedVolume = volume
 
-	_, err := c.getURL(ctx, "play",
+	_, err := c.queueURL(ctx, "play",
 		fmt.Sprintf("folder=%d", r.File.Folder),
 		fmt.Sprintf("file=%d", r.File.File),
 		fmt.Sprintf("volume=%d", volume),
 		fmt.Sprintf("reps=%d", r.Reps),
 		fmt.Sprintf("delay=%d", r.Delay.Milliseconds()),
 		fmt.Sprintf("jitter=%d", r.Jitter.Milliseconds()))
+	if err == nil {
+		c.extendQueue(lease.Sound, r.Duration())
+		c.spendEnergy(lease.Sound, volume, r.Duration())
+	}
 	return err
 }
 
@@ -337,15 +663,75 @@ type SetVolume struct {
 }
 
 func (r *SetVolume) handle(ctx context.Context, c *client) error {
-	arg1 := fmt.Sprintf("volume=%d", r.Volume)
+	volume := c.capped(r.Volume)
+	if c.muted {
+		// Unmute will send it.
+		c.targetVolume = volume
+		return nil
+	}
+	arg1 := fmt.Sprintf("volume=%d", volume)
 	_, err := c.getURL(ctx, "setvolume", arg1, "persist=true")
 
 	// set this regardless of whether the set-volume action succeeded
-	c.targetVolume = r.Volume
+	c.targetVolume = volume
 
 	return err
 }
 
+// AdjustVolume turns the client's volume up or down.
+type AdjustVolume struct {
+	Delta int
+}
+
+func (r *AdjustVolume) handle(ctx context.Context, c *client) error {
+	s := &SetVolume{Volume: min(max(c.targetVolume + r.Delta, 0), types.MaxVolume)}
+	return s.handle(ctx, c)
+}
+
+// RampVolume moves the client's volume to Volume in single steps, spread
+// evenly over Over. A later ramp takes over from one that's unfinished.
+type RampVolume struct {
+	Volume	int
+	Over	time.Duration
+}
+
+func (r *RampVolume) handle(ctx context.Context, c *client) error {
+	c.rampSeq++
+	target := min(max(r.Volume, 0), types.MaxVolume)
+	steps := target - c.targetVolume
+	if steps < 0 {
+		steps = -steps
+	}
+	if steps == 0 {
+		return nil
+	}
+	dir := 1
+	if target < c.targetVolume {
+		dir = -1
+	}
+	now := time.Now()
+	for i := 1; i <= steps; i++ {
+		s := &rampStep{volume: c.targetVolume + dir * i, seq: c.rampSeq}
+		action(c.id, ctx, s, now.Add(r.Over * time.Duration(i) / time.Duration(steps)))
+	}
+	return nil
+}
+
+// rampStep is one step of a RampVolume; it's skipped if a later ramp has
+// started since.
+type rampStep struct {
+	volume	int
+	seq	uint64
+}
+
+func (r *rampStep) handle(ctx context.Context, c *client) error {
+	if r.seq != c.rampSeq {
+		return nil
+	}
+	s := &SetVolume{Volume: r.volume}
+	return s.handle(ctx, c)
+}
+
 type Blink struct {
 	Speed  float64
 	Delay  time.Duration
@@ -353,20 +739,72 @@ type Blink struct {
 	Reps   int
 }
 
-// The expected duration of this command.
+// The expected duration of this command, on a device without a blink
+// model in its profile; see BlinkModel.
 // This is an unfortunate hack given the synchronous web server on the client.
 func (r *Blink) Duration() time.Duration {
-	pause := ((256.0 / r.Speed) * 2.0) + float64(r.Delay.Milliseconds())
-	pause *= float64(r.Reps)
-	return time.Duration(pause * float64(time.Millisecond))
+	return defaultBlinkModel.duration(r)
 }
 
 func (r *Blink) handle(ctx context.Context, c *client) error {
-	_, err := c.getURL(ctx, "blink",
+	if c.muted {
+		return nil
+	}
+	_, err := c.queueURL(ctx, "blink",
 		fmt.Sprintf("speed=%.3f", r.Speed),
 		fmt.Sprintf("delay=%d", r.Delay.Milliseconds()),
 		fmt.Sprintf("jitter=%d", r.Jitter.Milliseconds()),
 		fmt.Sprintf("reps=%d", r.Reps))
+	if err == nil {
+		d := c.blinkModel().duration(r)
+		c.extendQueue(lease.Light, d)
+		c.spendEnergy(lease.Light, 0, d)
+	}
+	return err
+}
+
+// Fade moves the brightness of the device's light smoothly to Level,
+// from 0 to types.MaxBrightness, over Over. It's queued along with blinks,
+// and the light stays at that level until the next request.
+type Fade struct {
+	Level	int
+	Over	time.Duration
+}
+
+func (r *Fade) Duration() time.Duration {
+	return r.Over
+}
+
+// The slowest the firmware blinks, in brightness steps per millisecond.
+const minBlinkSpeed = 0.01
+
+func (r *Fade) requires() requirement {
+	return requirement{Firmware: versionedFirmware}
+}
+
+// downgrade turns a fade up into a single blink that takes as long as
+// the fade would have, so the light still comes on in time, if at full
+// brightness and only briefly. A fade down is skipped, since the blink
+// already ends dark.
+func (r *Fade) downgrade() clientRequest {
+	if r.Level <= 0 {
+		return nil
+	}
+	ms := max(float64(r.Over.Milliseconds()), 1)
+	return &Blink{Speed: max(defaultBlinkModel.Ramp / ms, minBlinkSpeed), Reps: 1}
+}
+
+func (r *Fade) handle(ctx context.Context, c *client) error {
+	if c.muted {
+		return nil
+	}
+	_, err := c.queueURL(ctx, "fade",
+		fmt.Sprintf("level=%d", min(max(r.Level, 0), c.maxBrightness())),
+		fmt.Sprintf("ms=%d", r.Over.Milliseconds()))
+	if err == nil {
+		c.extendQueue(lease.Light, r.Duration())
+		c.spendEnergy(lease.Light, 0, r.Duration())
+	}
 	return err
 }
 
@@ -374,6 +812,9 @@ type Pause struct {}
 
 func (r *Pause) handle(ctx context.Context, c *client) error {
 	_, err := c.getURL(ctx, "pause")
+	if err == nil && c.pausedAt.IsZero() {
+		c.pausedAt = time.Now()
+	}
 	return err
 }
 
@@ -381,6 +822,13 @@ type Unpause struct {}
 
 func (r *Unpause) handle(ctx context.Context, c *client) error {
 	_, err := c.getURL(ctx, "unpause")
+	if err == nil && !c.pausedAt.IsZero() {
+		// The sound queue was frozen while paused, so it has as
+		// much left to do as it did when it was paused.
+		remaining := max(c.queueEnd[lease.Sound].Sub(c.pausedAt), 0)
+		c.queueEnd[lease.Sound] = time.Now().Add(remaining)
+		c.pausedAt = time.Time{}
+	}
 	return err
 }
 
@@ -391,6 +839,24 @@ func (r *Stop) handle(ctx context.Context, c *client) error {
 	return err
 }
 
+// Clear empties one of the device's queues, stopping whatever it's
+// currently doing from that queue.
+type Clear struct {
+	Type	lease.Type
+}
+
+func (r *Clear) requires() requirement {
+	return requirement{Firmware: versionedFirmware}
+}
+
+func (r *Clear) handle(ctx context.Context, c *client) error {
+	_, err := c.getURL(ctx, "clear", "queue=" + r.Type.String())
+	if err == nil {
+		c.queueEnd[r.Type] = time.Now()
+	}
+	return err
+}
+
 type KeepVoltageUpdated struct {}
 
 func (r *KeepVoltageUpdated) handle(ctx context.Context, c *client) error {
@@ -400,15 +866,15 @@ func (r *KeepVoltageUpdated) handle(ctx context.Context, c *client) error {
 		action(c.id, ctx, r, retryTime)
 		return err
 	}
-	p, err := strconv.ParseFloat(strings.TrimSpace(body), 32)
+	v, err := parseVoltage(body)
 	if err != nil {
 		action(c.id, ctx, r, retryTime)
 		return err
 	}
 
-	c.voltage = float32(p)
+	c.voltage = v
 	c.lastVoltageUpdate = time.Now()
-	log.Infof("%v voltage is %.2f", c, p)
+	log.Infof("%v voltage is %.2f", c, v)
 
 	action(c.id, ctx, r, retryTime)
 	return nil
@@ -430,73 +896,150 @@ func (r *DrainQueue) handle(ctx context.Context, c *client) error {
 
 	retryTime := time.Now().Add(transientDelay)
 	body, err := c.getURL(ctx, url)
-	if err != nil {
-		action(c.id, ctx, r, retryTime)
-		return err
+	var p int
+	if err == nil {
+		p, err = parseCount(body)
 	}
-	p, err := strconv.ParseInt(strings.TrimSpace(body), 10, 32)
 	if err != nil {
+		// Asking again won't help if the device can't answer this
+		// at all, so give up rather than holding up the effect.
+		if !Classify(err).Transient() {
+			r.Ack <- c.id
+			return err
+		}
 		action(c.id, ctx, r, retryTime)
 		return err
 	}
-	if int(p) == 0 {
+	c.reconcileQueue(r.Type, p > 0)
+	if p == 0 {
 		r.Ack <- c.id
 		return nil
 	}
 
+	// Don't bother asking again before the queue is expected to drain.
+	if end := c.queueEnd[r.Type]; end.After(retryTime) {
+		retryTime = end
+	}
 	action(c.id, ctx, r, retryTime)
 	return nil
 }
 
-func (c *client) getURL(ctx context.Context, command string, args ...string) (string, error) {
-	url := fmt.Sprintf("http://%s:%d/%s", c.netLocation.Address, c.netLocation.Port, command)
-	urlArgs := strings.Join(args, "&")
-	if urlArgs != "" {
-		url = url + "?" + urlArgs
-	}
+// extendQueue records that a command of the given duration has been
+// added to one of the device's queues.
+func (c *client) extendQueue(ty lease.Type, d time.Duration) {
+	c.queueEnd[ty] = later(c.queueEnd[ty], c.queueNow(ty)).Add(d)
+}
 
-	desc := fmt.Sprintf("%q", command)
-	descArgs := strings.Join(args, ",")
-	if descArgs != "" {
-		desc = desc + " (" + descArgs + ")"
+// queueNow returns the current time as far as one of the device's
+// queues is concerned. Time stands still for the sound queue while the
+// device is paused.
+func (c *client) queueNow(ty lease.Type) time.Time {
+	if ty == lease.Sound && !c.pausedAt.IsZero() {
+		return c.pausedAt
 	}
+	return time.Now()
+}
 
+// reconcileQueue compares what the device says about one of its queues
+// with when we expected that queue to drain, and corrects our estimate
+// if they've drifted apart by more than queueDriftThreshold. That can
+// happen if the device rebooted, a file is missing, or a file's
+// configured duration is wrong.
+func (c *client) reconcileQueue(ty lease.Type, pending bool) {
+	if ty == lease.Sound && !c.pausedAt.IsZero() {
+		return
+	}
 	now := time.Now()
-	if now.Before(c.nextGetURL) {
-		dur := c.nextGetURL.Sub(now)
-		<-time.After(dur)
+	end := later(c.queueEnd[ty], now)
+	drift := end.Sub(now)
+	switch {
+	case !pending && drift > queueDriftThreshold:
+		log.Warningf("%v queue drift: %v queue drained %.1f sec earlier than expected",
+		    *c, ty, drift.Seconds())
+	case pending && end.Equal(now) && now.Sub(c.queueEnd[ty]) > queueDriftThreshold:
+		log.Warningf("%v queue drift: %v queue still busy %.1f sec after it was expected to drain",
+		    *c, ty, now.Sub(c.queueEnd[ty]).Seconds())
+	default:
+		return
 	}
+	c.queueEnd[ty] = now
+}
 
-	getURLFailure := func(err error, message string) (string, error) {
-		t := time.Now()
-		times := fmt.Sprintf("[last success %v, last fail %v, now %v]", c.lastSuccessCmd, c.lastFailureCmd, t)
-		if ctx.Err() == nil {
-			c.lastFailureCmd = t
-			c.nextGetURL = c.lastSuccessCmd.Add(postGetURLDelay)
-		}
-		return "", fmt.Errorf("%s %s: err = %v", times, message, err)
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
 	}
+	return b
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return getURLFailure(err, fmt.Sprintf("NewRequest(%s) returned error", desc))
+func (c *client) getURL(ctx context.Context, command string, args ...string) (string, error) {
+	if time.Now().Before(c.asleepUntil) {
+		return "", errAsleep
 	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return getURLFailure(err, fmt.Sprintf("Do(%s) returned error", desc))
+	f := inFlightFrom(ctx)
+	q := c.lanes[f.lane]
+	var minInterval time.Duration
+	if c.profile != nil {
+		minInterval = c.profile.minInterval
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return getURLFailure(err, fmt.Sprintf("error while reading body from %s", desc))
+	c.requests++
+	t, endpoint := c.transportFor(command), c.endpoint(command)
+	var body string
+	var err error
+	var grew bool
+	var delay, rtt time.Duration
+	c.unlocked(func() {
+		c.gate.enter()
+		defer c.gate.leave()
+		if f.lane != adminLane && c.gate.fence.Load() != f.fence {
+			err = errMuted
+			return
+		}
+		sent := time.Now()
+		body, err = t.call(ctx, c, endpoint, args)
+		rtt = time.Since(sent)
+		if ctx.Err() == nil {
+			grew = c.gate.pacing.pace(sent, err, minInterval)
+			delay = c.gate.pacing.delay
+		}
+	})
+	q.roundTrip = rtt
+	if grew {
+		log.Infof("%v reset the connection; spacing requests %v apart", *c, delay)
 	}
-	if resp.StatusCode > 299 {
-		return getURLFailure(err, fmt.Sprintf("got failure status code (%d) from %s: %q", resp.StatusCode, desc, body))
+	if errors.Is(err, errMuted) {
+		return "", err
 	}
+	if err != nil {
+		// Only describe the request when there's something to report,
+		// since this is on every request's path.
+		desc := fmt.Sprintf("%q", command)
+		if descArgs := strings.Join(args, ","); descArgs != "" {
+			desc = desc + " (" + descArgs + ")"
+		}
+		t := time.Now()
+		times := fmt.Sprintf("[last success %v, last fail %v, now %v]", q.lastSuccess, q.lastFailure, t)
+		if ctx.Err() == nil {
+			q.lastFailure = t
+			c.failures++
+			// A device that rejects a request is still healthy.
+			if Classify(err).Transient() {
+				lease.RecordFailure(c.id, t)
+			}
+		}
+		return "", fmt.Errorf("%s %s: err = %w", times, desc, err)
+	}
+
+	q.lastSuccess = time.Now()
+	f.body = body
+	return body, nil
+}
 
-	c.lastSuccessCmd = time.Now()
-	c.nextGetURL = c.lastSuccessCmd.Add(postGetURLDelay)
-	return string(body), nil
+// unlocked runs f with the client's lock released, e.g. while waiting
+// for the device, so that other lanes' workers can use the client.
+func (c *client) unlocked(f func()) {
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	f()
 }
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// EnergyModel describes how fast the devices use their batteries, and
+// how much they have to last the night. From it, the server estimates
+// each device's spending so far, and if the device is on course to run
+// out before closing time, has it sit out some of the effects that would
+// use it (see lease.SetThrottle), so that it takes part less often.
+type EnergyModel struct {
+	BudgetWh	float64	// what each device may use in a night
+	IdleWatts	float64	// used all the time
+	PlayWatts	float64	// used as well while playing at full volume
+	LightWatts	float64	// used as well while the light is busy
+	Close		string	// closing time, e.g. "23:30"
+}
+
+// How often devices' spending is checked.
+const energyCheckInterval = time.Minute
+
+// The most of its effects a device may be kept out of.
+const maxThrottle = 0.9
+
+// How long to watch devices' spending before judging their rates.
+const energyWarmup = 10 * time.Minute
+
+// EnergyStats describes a device's estimated spending tonight.
+type EnergyStats struct {
+	UsedWh		float64	// so far
+	ProjectedWh	float64	// by closing time, at the rate so far
+	Throttle	float64	// the fraction of effects it's sitting out
+}
+
+var energy struct {
+	mu	sync.Mutex
+	model	*EnergyModel
+	close	time.Duration	// since midnight
+	night	string		// see random.DateSeed
+	start	time.Time	// of tonight's accounting
+	active	map[types.ID]float64	// Wh used tonight beyond idling
+	stats	map[types.ID]EnergyStats
+}
+
+// Check returns an error if the model is invalid.
+func (m EnergyModel) Check() error {
+	if m.BudgetWh <= 0 {
+		return fmt.Errorf("energy budget %v Wh must be positive", m.BudgetWh)
+	}
+	if m.IdleWatts < 0 || m.PlayWatts < 0 || m.LightWatts < 0 {
+		return fmt.Errorf("energy model's power draws must not be negative")
+	}
+	if _, err := time.Parse("15:04", m.Close); err != nil {
+		return fmt.Errorf("energy model's closing time %q: %w", m.Close, err)
+	}
+	return nil
+}
+
+// StartEnergyBudget starts keeping track of devices' spending. The model
+// must have been checked with Check.
+func StartEnergyBudget(m EnergyModel) {
+	t, _ := time.Parse("15:04", m.Close)
+	energy.mu.Lock()
+	energy.model = &m
+	energy.close = time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+	energy.night = random.DateSeed(time.Now())
+	energy.start = time.Now()
+	energy.active = make(map[types.ID]float64)
+	energy.stats = make(map[types.ID]EnergyStats)
+	energy.mu.Unlock()
+
+	go func() {
+		for ; ; time.Sleep(energyCheckInterval) {
+			checkEnergy(IDs(), time.Now())
+		}
+	}()
+}
+
+// Energy returns each device's estimated spending tonight, if an energy
+// budget is being kept.
+func Energy() map[types.ID]EnergyStats {
+	energy.mu.Lock()
+	defer energy.mu.Unlock()
+	stats := make(map[types.ID]EnergyStats)
+	for id, s := range energy.stats {
+		stats[id] = s
+	}
+	return stats
+}
+
+// spendEnergy records that a device has been asked to play or blink for
+// the given time.
+func (c *client) spendEnergy(ty lease.Type, volume int, d time.Duration) {
+	energy.mu.Lock()
+	defer energy.mu.Unlock()
+	m := energy.model
+	if m == nil {
+		return
+	}
+	watts := m.LightWatts
+	if ty == lease.Sound {
+		watts = m.PlayWatts * float64(volume) / types.MaxVolume
+	}
+	energy.active[c.id] += watts * d.Hours()
+}
+
+// checkEnergy works out whether each device is on course to last until
+// closing time, and throttles those that aren't.
+func checkEnergy(ids []types.ID, now time.Time) {
+	energy.mu.Lock()
+	defer energy.mu.Unlock()
+	m := energy.model
+
+	if night := random.DateSeed(now); night != energy.night {
+		log.Infof("starting tonight's energy accounting")
+		energy.night = night
+		energy.start = now
+		energy.active = make(map[types.ID]float64)
+	}
+
+	// Closing time is on the night's date, or after midnight if it's
+	// earlier in the day than the night starts.
+	night, _ := time.ParseInLocation("2006-01-02", energy.night, now.Location())
+	closing := night.Add(energy.close)
+	if closing.Before(energy.start) {
+		closing = closing.AddDate(0, 0, 1)
+	}
+	elapsed := now.Sub(energy.start).Hours()
+	left := max(closing.Sub(now).Hours(), 0)
+
+	for _, id := range ids {
+		active := energy.active[id]
+		used := active + m.IdleWatts * elapsed
+		rate := 0.0
+		if elapsed > 0 {
+			rate = active / elapsed
+		}
+		projected := used + (m.IdleWatts + rate) * left
+
+		// What's left for playing and blinking, and what's needed
+		// to keep going at the same rate.
+		spare := m.BudgetWh - used - m.IdleWatts * left
+		needed := rate * left
+		throttle := 0.0
+		if now.Sub(energy.start) >= energyWarmup && needed > 0 && spare < needed {
+			throttle = min(1 - max(spare, 0) / needed, maxThrottle)
+		}
+
+		prev := energy.stats[id].Throttle
+		if (throttle == 0) != (prev == 0) {
+			if throttle > 0 {
+				log.Warningf("[%s] projected to use %.1f of %.1f Wh by closing; sitting out %.0f%% of effects",
+				    id, projected, m.BudgetWh, throttle * 100)
+			} else {
+				log.Infof("[%s] back within its energy budget", id)
+			}
+		}
+		if throttle != prev {
+			lease.SetThrottle(id, throttle)
+		}
+		energy.stats[id] = EnergyStats{
+			UsedWh:		used,
+			ProjectedWh:	projected,
+			Throttle:	throttle,
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrorKind classifies why a request to a device failed, so that callers
+// can tell a device that's busy or briefly unreachable (worth retrying)
+// from one that will never accept the request (not worth retrying).
+type ErrorKind int
+const (
+	UnknownError	ErrorKind = iota
+	Timeout		// no answer in time
+	Unreachable	// connection refused, no route, etc.
+	Busy		// the device answered with a 5xx status
+	Rejected	// the device answered with a 4xx status
+	BadResponse	// the device's answer didn't make sense
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	default:
+		return "unknown error"
+	case Timeout:
+		return "timeout"
+	case Unreachable:
+		return "unreachable"
+	case Busy:
+		return "busy"
+	case Rejected:
+		return "rejected"
+	case BadResponse:
+		return "bad response"
+	}
+}
+
+// Transient reports whether a request that failed this way might
+// succeed if it's tried again later.
+func (k ErrorKind) Transient() bool {
+	switch k {
+	case Rejected, BadResponse:
+		return false
+	}
+	return true
+}
+
+// DeviceError is the error returned when a request to a device fails.
+type DeviceError struct {
+	Kind	ErrorKind
+	Status	int	// HTTP-style status code, if the device answered
+	Err	error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the kind of a request's error.
+func Classify(err error) ErrorKind {
+	var de *DeviceError
+	if errors.As(err, &de) {
+		return de.Kind
+	}
+	return UnknownError
+}
+
+// statusError returns the error for a device's failure status code.
+func statusError(status int, body string) error {
+	kind := Busy
+	if status >= 400 && status < 500 {
+		kind = Rejected
+	}
+	return &DeviceError{
+		Kind:	kind,
+		Status:	status,
+		Err:	fmt.Errorf("got failure status code (%d): %q", status, body),
+	}
+}
+
+// callError returns the error for a request that got no answer.
+func callError(err error) error {
+	kind := Unreachable
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		kind = Timeout
+	}
+	return &DeviceError{Kind: kind, Err: err}
+}
+
+// responseError returns the error for an answer that couldn't be parsed.
+func responseError(err error) error {
+	return &DeviceError{Kind: BadResponse, Err: err}
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+)
+
+// Each client's requests are split into lanes by what they're for, and
+// each lane has its own heap and its own worker, so a flood of sound
+// requests can't hold up the device's light or housekeeping requests.
+// A worker holds the client's lock while it handles a request, but not
+// while the request waits for the device (see getURL), so one lane can
+// get on with its work while another's request is out. The requests
+// themselves still reach the device one at a time, through the client's
+// gate (see pacing.go).
+type lane int
+
+const (
+	soundLane lane = iota
+	lightLane
+	adminLane
+	numLanes
+)
+
+func (l lane) String() string {
+	switch l {
+	case soundLane:
+		return "sound"
+	case lightLane:
+		return "light"
+	case adminLane:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// laneFor returns the lane for requests that act on a type of queue.
+func laneFor(ty lease.Type) lane {
+	if ty == lease.Light {
+		return lightLane
+	}
+	return soundLane
+}
+
+// Requests that don't belong in the admin lane implement this.
+type lanedRequest interface {
+	lane() lane
+}
+
+// laneOf returns the lane a request is handled in.
+func laneOf(req clientRequest) lane {
+	if l, ok := req.(lanedRequest); ok {
+		return l.lane()
+	}
+	return adminLane
+}
+
+func (r *Play) lane() lane		{ return soundLane }
+func (r *SetVolume) lane() lane		{ return soundLane }
+func (r *AdjustVolume) lane() lane	{ return soundLane }
+func (r *RampVolume) lane() lane	{ return soundLane }
+func (r *rampStep) lane() lane		{ return soundLane }
+func (r *Pause) lane() lane		{ return soundLane }
+func (r *Unpause) lane() lane		{ return soundLane }
+func (r *Blink) lane() lane		{ return lightLane }
+func (r *Fade) lane() lane		{ return lightLane }
+func (r *Clear) lane() lane		{ return laneFor(r.Type) }
+func (r *DrainQueue) lane() lane	{ return laneFor(r.Type) }
+func (r *ReconcileQueue) lane() lane	{ return laneFor(r.Type) }
+
+type inFlightKey struct{}
+
+// inFlight is what's kept about a request while a lane's worker handles
+// it.
+type inFlight struct {
+	lane	lane
+	fence	uint64	// the client's fence when the request started
+	body	string	// from the request's last successful getURL
+}
+
+// requestContext is a request's context while it's being handled.
+type requestContext struct {
+	context.Context
+	f	*inFlight
+}
+
+func (c requestContext) Value(key any) any {
+	if key == (inFlightKey{}) {
+		return c.f
+	}
+	return c.Context.Value(key)
+}
+
+// withInFlight returns a context for a request being handled. Requests
+// that reschedule themselves pass their context on, so a context that
+// already has an inFlight is unwrapped first, rather than growing each
+// time round.
+func withInFlight(ctx context.Context, f *inFlight) context.Context {
+	if r, ok := ctx.(requestContext); ok {
+		ctx = r.Context
+	}
+	return requestContext{Context: ctx, f: f}
+}
+
+// inFlightFrom returns what's kept about the request a context is being
+// handled for.
+func inFlightFrom(ctx context.Context) *inFlight {
+	if f, ok := ctx.Value(inFlightKey{}).(*inFlight); ok {
+		return f
+	}
+	return &inFlight{lane: adminLane}
+}
+
+// laneQueue holds one lane's requests until they're due.
+type laneQueue struct {
+	lane		lane
+	heap		*clientMessageHeap
+	seq		uint64		// of the last message pushed
+	overflowWarned	bool
+
+	// one entry for each counted message in the heap; see enqueue
+	room		chan struct{}
+
+	// messages from API clients to the heap manager
+	heapChannel	chan clientMessage
+
+	// messages from the heap manager to the lane's worker
+	deviceChannel	chan clientMessage
+
+	// requests to the heap manager to drop what Mute would; see fence
+	flushChannel	chan chan struct{}
+
+	// used with the client's lock held
+	lastSuccess	time.Time	// of the lane's requests to the device
+	lastFailure	time.Time
+	roundTrip	time.Duration	// of the last request, not counting the gate
+}
+
+func newLaneQueue(l lane) *laneQueue {
+	return &laneQueue{
+		lane:		l,
+		heap:		&clientMessageHeap{},
+		room:		make(chan struct{}, data.maxQueue),
+		heapChannel:	make(chan clientMessage),
+		deviceChannel:	make(chan clientMessage),
+		flushChannel:	make(chan chan struct{}),
+	}
+}
+
+// lastSuccess returns when any of a client's requests last succeeded.
+func (c *client) lastSuccess() time.Time {
+	var t time.Time
+	for _, q := range c.lanes {
+		t = later(t, q.lastSuccess)
+	}
+	return t
+}
+
+// lastFailure returns when any of a client's requests last failed.
+func (c *client) lastFailure() time.Time {
+	var t time.Time
+	for _, q := range c.lanes {
+		t = later(t, q.lastFailure)
+	}
+	return t
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Lateness describes how late requests have started running, relative
+// to when they were scheduled, over recent requests. A request can't run
+// early, but it runs late if the device is still busy with the requests
+// ahead of it, or if the server is too busy to get to it.
+type Lateness struct {
+	Samples	int
+	P50	time.Duration
+	P90	time.Duration
+	P99	time.Duration
+	Max	time.Duration
+	Behind	bool	// see behindThreshold
+}
+
+// LatenessReport describes lateness across the fleet, and per client.
+// When the whole fleet is behind, the server is probably overloaded;
+// when only a few clients are, they're probably rate-limiting everything
+// queued for them.
+type LatenessReport struct {
+	Fleet	Lateness
+	Clients	map[types.ID]Lateness
+}
+
+const (
+	// How many recent requests to keep, for each client and for the
+	// fleet as a whole.
+	clientLatenessWindow = 100
+	fleetLatenessWindow = 1000
+
+	// A client is behind if the median lateness of its recent requests
+	// is over this, as checked every latenessCheckEvery requests. The
+	// fleet is behind if most clients are.
+	behindThreshold = 250 * time.Millisecond
+	latenessCheckEvery = 20
+)
+
+// lateness is written by device threads, and read by the admin thread.
+var lateness struct {
+	mu	sync.Mutex
+	fleet	latenessWindow
+	clients	map[types.ID]*latenessWindow
+	behind	int	// how many clients are behind
+}
+
+func init() {
+	lateness.fleet = latenessWindow{size: fleetLatenessWindow}
+	lateness.clients = make(map[types.ID]*latenessWindow)
+}
+
+// latenessWindow holds the most recent lateness samples, as a ring.
+type latenessWindow struct {
+	size	int
+	samples	[]time.Duration
+	next	int
+	count	int	// samples ever added
+	behind	bool
+}
+
+func (w *latenessWindow) add(d time.Duration) {
+	if len(w.samples) < w.size {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % w.size
+	}
+	w.count++
+}
+
+func (w *latenessWindow) stats() Lateness {
+	if len(w.samples) == 0 {
+		return Lateness{}
+	}
+	s := slices.Clone(w.samples)
+	slices.Sort(s)
+	at := func(p float64) time.Duration {
+		return s[int(p * float64(len(s) - 1))]
+	}
+	return Lateness{
+		Samples:	len(s),
+		P50:		at(0.5),
+		P90:		at(0.9),
+		P99:		at(0.99),
+		Max:		s[len(s) - 1],
+		Behind:		w.behind,
+	}
+}
+
+// check re-evaluates whether a client's window is behind, every so
+// often, and returns the median and whether that changed.
+func (w *latenessWindow) check() (time.Duration, bool) {
+	if w.count % latenessCheckEvery != 0 {
+		return 0, false
+	}
+	p50 := w.stats().P50
+	behind := p50 > behindThreshold
+	changed := behind != w.behind
+	w.behind = behind
+	return p50, changed
+}
+
+// recordLateness records how late a request started running, and warns
+// when the client, or the whole fleet, falls behind or catches up.
+func recordLateness(c *client, late time.Duration) {
+	lateness.mu.Lock()
+	defer lateness.mu.Unlock()
+
+	w, ok := lateness.clients[c.id]
+	if !ok {
+		w = &latenessWindow{size: clientLatenessWindow}
+		lateness.clients[c.id] = w
+	}
+	w.add(late)
+	f := &lateness.fleet
+	f.add(late)
+
+	p50, changed := w.check()
+	if !changed {
+		return
+	}
+	if w.behind {
+		lateness.behind++
+		log.Warningf("%v is falling behind schedule (median %v late); it may be rate-limiting its requests",
+		    *c, p50.Round(time.Millisecond))
+	} else {
+		lateness.behind--
+		log.Infof("%v has caught up with its schedule", *c)
+	}
+	behind := lateness.behind * 2 > len(lateness.clients)
+	if behind && !f.behind {
+		log.Warningf("%d of %d clients are behind schedule; the server may be overloaded",
+		    lateness.behind, len(lateness.clients))
+	} else if !behind && f.behind {
+		log.Infof("most clients have caught up with their schedule")
+	}
+	f.behind = behind
+}
+
+// GetLateness returns how late recent requests have run.
+func GetLateness() LatenessReport {
+	lateness.mu.Lock()
+	defer lateness.mu.Unlock()
+	r := LatenessReport{
+		Fleet:		lateness.fleet.stats(),
+		Clients:	make(map[types.ID]Lateness),
+	}
+	for id, w := range lateness.clients {
+		r.Clients[id] = w.stats()
+	}
+	return r
+}
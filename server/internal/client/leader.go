@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// A Pacer's timeline is the server's idea of when things happen on the
+// devices. Over a long sequence of queued requests it can drift from
+// what the devices actually do, e.g. because files' configured
+// durations are a little off. A Pacer that follows a leader watches one
+// of its clients, and when the leader starts a request later or earlier
+// than the timeline planned, shifts the rest of the timeline to match,
+// so the other clients (the followers) stay in step with the leader.
+//
+// The leader is watched by polling how many requests it has queued: a
+// request has started once everything before it is done. That can only
+// be seen for a request that was queued behind another; one that starts
+// as soon as it arrives tells us nothing the timeline doesn't already
+// know.
+//
+// Each poll is a request to the leader, so watching isn't free: while
+// a request is due, the leader gets one extra request every leaderPoll.
+// Polling only starts leaderLead before the request's planned start, so
+// the cost is a few dozen requests per followed request, not one every
+// leaderPoll for as long as the leader's queue is. The polls don't count
+// against the effect's request budget (see WithRequestBudget), since
+// they'd otherwise crowd out the requests it's meant to pace.
+
+const (
+	// How often the leader is polled while waiting for it to start a
+	// request.
+	leaderPoll = 50 * time.Millisecond
+
+	// How long before a request's planned start polling begins. A
+	// leader that starts the request earlier than that is treated as
+	// on time.
+	leaderLead = time.Second
+)
+
+// ElectLeader picks the client that's best placed to lead the others:
+// the one with the lowest latency, then the fewest failed requests.
+func ElectLeader(ids []types.ID) types.ID {
+	if len(ids) == 0 {
+		return ""
+	}
+	sorted := slices.Clone(ids)
+	slices.SortFunc(sorted, func(a, b types.ID) int {
+		if la, lb := Latency([]types.ID{a}), Latency([]types.ID{b}); la != lb {
+			return int(la - lb)
+		}
+		if fa, fb := getFailures(a), getFailures(b); fa != fb {
+			return fa - fb
+		}
+		if a < b {
+			return -1
+		}
+		return 1
+	})
+	return sorted[0]
+}
+
+// FollowLeader makes the pacer follow the given client's timing for
+// requests of the given type. The leader should be one of the pacer's
+// clients. An empty ID stops following.
+func (p *Pacer) FollowLeader(id types.ID, ty lease.Type) {
+	p.leader = id
+	p.leaderType = ty
+	p.leaderPending = false
+}
+
+// sent notes that a request planned to start at the given point in the
+// timeline was just sent, so that its start on the leader can be
+// watched for.
+func (p *Pacer) sent(planned time.Time) {
+	if p.leader == "" || !slices.Contains(p.clients, p.leader) {
+		return
+	}
+	p.planned = planned
+	p.leaderPending = true
+}
+
+// followLeader waits for the leader to start the request that was last
+// sent, and shifts the timeline by however far off its plan that was.
+// It gives up if the leader can't be asked, or if it's clear that the
+// start can't be seen.
+func (p *Pacer) followLeader(ctx context.Context) {
+	if !p.leaderPending {
+		return
+	}
+	p.leaderPending = false
+
+	sleepCtx(ctx, time.Until(p.planned.Add(-leaderLead)))
+	waited := false
+	for ctx.Err() == nil {
+		n, err := queueDepth(ctx, p.leader, p.leaderType)
+		if err != nil {
+			log.Warningf("can't follow leader %s: %v", p.leader, err)
+			p.leader = ""
+			return
+		}
+		if n > 1 {
+			waited = true
+			sleepCtx(ctx, leaderPoll)
+			continue
+		}
+		if waited && n == 1 {
+			// The request started since the last poll.
+			p.next = later(p.next.Add(time.Since(p.planned)), time.Now())
+		}
+		return
+	}
+}
+
+// queueDepth asks a client how many requests of the given type it has
+// queued, including the one in progress.
+func queueDepth(ctx context.Context, id types.ID, ty lease.Type) (int, error) {
+	results := make(chan Result, 1)
+	// The zero time puts this ahead of anything else waiting for the
+	// client.
+	ctx = withoutRequestBudget(ctx)
+	ActionWithResults([]types.ID{id}, ctx, &pending{Type: ty}, time.Time{}, results)
+	r := <-results
+	if r.Err != nil {
+		return 0, r.Err
+	}
+	return parseCount(r.Body)
+}
+
+// pending asks a device how many requests it has queued.
+type pending struct {
+	Type	lease.Type
+}
+
+func (r *pending) handle(ctx context.Context, c *client) error {
+	url := "soundpending"
+	if r.Type == lease.Light {
+		url = "lightpending"
+	}
+	body, err := c.getURL(ctx, url)
+	if err != nil {
+		return err
+	}
+	if n, err := parseCount(body); err == nil {
+		c.reconcileQueue(r.Type, n > 0)
+	}
+	return nil
+}
+
+// sleepCtx sleeps for d, or until the context is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Some hardware can measure its own output, and its firmware advertises
+// the "level" feature. Such devices are polled for their peak output
+// level and for whether they clipped. A device that clips has its
+// volume capped a little below the volume it clipped at, for the rest of
+// the run, so that each device ends up calibrated to what its speaker
+// can handle.
+
+const (
+	// The feature that firmware advertises if it can report levels.
+	levelFeature = "level"
+
+	// Time between level queries.
+	levelDelay = 20 * time.Second
+
+	// How far below the volume it clipped at a device's volume is
+	// capped.
+	clipBackoff = 2
+)
+
+// KeepLevelUpdated periodically asks the device how loud it's been.
+type KeepLevelUpdated struct {}
+
+func (r *KeepLevelUpdated) requires() requirement {
+	return requirement{Features: []string{levelFeature}}
+}
+
+func (r *KeepLevelUpdated) handle(ctx context.Context, c *client) error {
+	action(c.id, ctx, r, time.Now().Add(levelDelay))
+
+	body, err := c.getURL(ctx, "level")
+	if err != nil {
+		return err
+	}
+	peak, clips, err := parseLevel(body)
+	if err != nil {
+		return err
+	}
+	c.peakLevel = peak
+	if clips > 0 {
+		c.clips += clips
+		c.capVolume()
+	}
+	return nil
+}
+
+// capVolume lowers the device's volume cap below the volume it last
+// played at, since that clipped.
+func (c *client) capVolume() {
+	limit := max(c.playedVolume - clipBackoff, 1)
+	if c.volumeCap != 0 && c.volumeCap <= limit {
+		return
+	}
+	log.Warningf("%v clipped at volume %d; capping its volume at %d", *c, c.playedVolume, limit)
+	c.volumeCap = limit
+	if c.targetVolume > limit {
+		s := &SetVolume{Volume: limit}
+		action(c.id, context.Background(), s, time.Now())
+	}
+}
+
+// capped returns the given volume, limited by the device's volume cap
+// and its profile's maximum.
+func (c *client) capped(volume int) int {
+	if c.volumeCap != 0 {
+		volume = min(volume, c.volumeCap)
+	}
+	if c.profile != nil && c.profile.maxVolume != 0 {
+		volume = min(volume, c.profile.maxVolume)
+	}
+	return volume
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// MuteAll silences every client as quickly as it can, e.g. in an
+// emergency. Each client's queues are emptied, whatever it's doing is
+// stopped, and its volume is turned all the way down. Until UnmuteAll,
+// plays, blinks, and fades sent to it are dropped, and volume changes
+// are remembered but not sent. Clients added in the meantime start out
+// muted.
+func MuteAll() {
+	enqueueAdminMessage(&muteMessage{muted: true})
+}
+
+// UnmuteAll undoes MuteAll. Each client's volume goes back to what it
+// was, or to what it was since set to.
+func UnmuteAll() {
+	enqueueAdminMessage(&muteMessage{muted: false})
+}
+
+type muteMessage struct {
+	muted	bool
+}
+
+func (r *muteMessage) handle() {
+	data.muted = r.muted
+	var req clientRequest = &Unmute{}
+	if r.muted {
+		req = &Mute{}
+	}
+	log.Infof("sending %T to %d clients", req, len(data.clients))
+	for id := range data.clients {
+		// The zero time puts this ahead of anything else
+		// waiting for the client.
+		action(id, context.Background(), req, time.Time{})
+	}
+}
+
+// errMuted is returned for requests that were dropped because their
+// client was muted.
+var errMuted = errors.New("client was muted")
+
+// Mute silences a client; see MuteAll.
+type Mute struct {}
+
+func (r *Mute) handle(ctx context.Context, c *client) error {
+	c.muted = true
+	c.rampSeq++	// abandon any ramp in progress
+	c.fence()
+
+	var errs []error
+	for _, ty := range lease.ValidTypes() {
+		cl := &Clear{Type: ty}
+		errs = append(errs, cl.handle(ctx, c))
+	}
+	s := &Stop{}
+	errs = append(errs, s.handle(ctx, c))
+	_, err := c.getURL(ctx, "setvolume", "volume=0", "persist=true")
+	errs = append(errs, err)
+	return errors.Join(errs...)
+}
+
+// Unmute undoes Mute.
+type Unmute struct {}
+
+func (r *Unmute) handle(ctx context.Context, c *client) error {
+	if !c.muted {
+		return nil
+	}
+	c.muted = false
+	s := &SetVolume{Volume: c.targetVolume}
+	return s.handle(ctx, c)
+}
+
+// fence keeps the sound and light lanes' requests from reaching the
+// device after a mute. Those waiting in the lanes' heaps that would do
+// nothing once the client is muted are dropped, and those already being
+// handled are turned away at the gate (see getURL); the mute's own
+// requests wait at the gate for any that are on their way to the device.
+// The client must already be muted, so that requests handled after this
+// don't make any noise.
+func (c *client) fence() {
+	c.gate.fence.Add(1)
+	for _, q := range c.lanes {
+		if q.lane == adminLane {
+			continue
+		}
+		done := make(chan struct{})
+		q.flushChannel <- done
+		<-done
+	}
+}
+
+// dropSilenced drops the requests in a lane's heap that would do nothing
+// on a muted client. It's called by the lane's heap thread.
+func (c *client) dropSilenced(q *laneQueue) {
+	kept := clientMessageHeap{}
+	for _, msg := range *q.heap {
+		switch msg.clientRequest.(type) {
+		case *Play, *Blink, *Fade:
+			c.drop(q, msg, errMuted)
+		default:
+			kept = append(kept, msg)
+		}
+	}
+	*q.heap = kept
+	heap.Init(q.heap)
+	c.recordDepth(q)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// NeighborCount is how many neighbors each client has in the neighbor
+// graph.
+const NeighborCount = 6
+
+// neighbors caches the fleet's neighbor graph, since building it takes
+// time quadratic in the size of the fleet. The admin thread marks it
+// stale when a client is added, and it's rebuilt the next time it's
+// wanted.
+var neighbors struct {
+	mu	sync.Mutex
+	graph	space.Graph
+	stale	bool
+}
+
+func init() {
+	neighbors.stale = true
+}
+
+// Neighbors returns the graph connecting each known client to the
+// NeighborCount clients physically nearest to it. It's shared, so it
+// mustn't be modified; use Restrict to narrow it to an effect's clients.
+func Neighbors() space.Graph {
+	neighbors.mu.Lock()
+	defer neighbors.mu.Unlock()
+	if neighbors.stale {
+		locs := make(map[types.ID]types.PhysLocation)
+		for _, info := range List() {
+			locs[info.ID] = info.Location
+		}
+		neighbors.graph = space.NeighborGraph(locs, NeighborCount)
+		neighbors.stale = false
+	}
+	return neighbors.graph
+}
+
+// invalidateNeighbors marks the neighbor graph as needing to be rebuilt.
+func invalidateNeighbors() {
+	neighbors.mu.Lock()
+	defer neighbors.mu.Unlock()
+	neighbors.stale = true
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// A RequestBudget limits how many requests an effect may have queued or
+// in flight at once, across all of its clients. An algorithm that sends
+// requests faster than the devices can handle them (usually a bug) is
+// slowed down to match, rather than filling the clients' queues without
+// bound.
+type RequestBudget struct {
+	name	string
+	slots	chan struct{}
+	warned	chan struct{}
+}
+
+type requestBudgetKey struct{}
+
+// NewRequestBudget returns a budget of max outstanding requests, for
+// the named effect.
+func NewRequestBudget(name string, max int) *RequestBudget {
+	return &RequestBudget{
+		name:	name,
+		slots:	make(chan struct{}, max),
+		warned:	make(chan struct{}, 1),
+	}
+}
+
+// WithRequestBudget returns a context that makes Action and
+// ActionWithResults wait for room in the budget.
+func WithRequestBudget(ctx context.Context, b *RequestBudget) context.Context {
+	return context.WithValue(ctx, requestBudgetKey{}, b)
+}
+
+// withoutRequestBudget returns a context whose requests don't count
+// against any budget, for the server's own bookkeeping requests.
+func withoutRequestBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestBudgetKey{}, (*RequestBudget)(nil))
+}
+
+// acquire waits until there's room in the context's budget, if it has
+// one, and returns the budget to release when the request is done. It
+// returns false if the context ends first.
+func acquire(ctx context.Context) (*RequestBudget, bool) {
+	b, ok := ctx.Value(requestBudgetKey{}).(*RequestBudget)
+	if !ok || b == nil {
+		return nil, true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return b, true
+	default:
+	}
+
+	select {
+	case b.warned <- struct{}{}:
+		log.Warningf("effect %q has %d requests outstanding; waiting for its clients to catch up",
+		    b.name, cap(b.slots))
+	default:
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return b, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (b *RequestBudget) release() {
+	if b != nil {
+		<-b.slots
+	}
+}
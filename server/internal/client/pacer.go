@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// latency tracks, per client, how long requests take between being due
+// and being acknowledged by the device. It's written by device threads
+// and read by effects.
+var latency struct {
+	mu	sync.Mutex
+	ewma	map[types.ID]time.Duration
+}
+
+// The weight given to each new latency sample.
+const latencyAlpha = 0.1
+
+func init() {
+	latency.ewma = make(map[types.ID]time.Duration)
+}
+
+func recordLatency(id types.ID, d time.Duration) {
+	latency.mu.Lock()
+	defer latency.mu.Unlock()
+	old, ok := latency.ewma[id]
+	if !ok {
+		latency.ewma[id] = d
+		return
+	}
+	latency.ewma[id] = old + time.Duration(latencyAlpha * float64(d - old))
+}
+
+// Latency returns the largest smoothed request latency among a set of
+// clients.
+func Latency(ids []types.ID) time.Duration {
+	latency.mu.Lock()
+	defer latency.mu.Unlock()
+	l := time.Duration(0)
+	for _, id := range ids {
+		l = max(l, latency.ewma[id])
+	}
+	return l
+}
+
+// ---------------------------------------------------------------------
+
+// A Pacer sends a series of requests to a set of clients, one after
+// another. It keeps an absolute timeline rather than sleeping for each
+// request's estimated duration, so time spent sending requests doesn't
+// accumulate into drift, and it sends each request early by the
+// clients' measured latency, so the request takes effect on time.
+type Pacer struct {
+	clients	[]types.ID
+	next	time.Time
+	early	time.Duration	// extra lead for the next Action; see AdvanceQueued
+
+	// See FollowLeader.
+	leader		types.ID
+	leaderType	lease.Type
+	planned		time.Time	// when the last request was to start
+	leaderPending	bool		// whether to watch for it to start
+}
+
+// How far ahead of time AdvanceQueued lets the next request be sent.
+const Lookahead = 500 * time.Millisecond
+
+func NewPacer(clients []types.ID) *Pacer {
+	return &Pacer{
+		clients:	clients,
+		next:		time.Now(),
+	}
+}
+
+// Action requests that the clients perform an action at the current
+// point in the timeline.
+func (p *Pacer) Action(ctx context.Context, req clientRequest) {
+	Action(p.clients, ctx, req, p.next.Add(-Latency(p.clients) - p.early))
+	p.early = 0
+	p.sent(p.next)
+}
+
+// ActionWithResults is like Action, but returns a channel that will
+// receive each client's Result.
+func (p *Pacer) ActionWithResults(ctx context.Context, req clientRequest) <-chan Result {
+	results := make(chan Result, len(p.clients))
+	ActionWithResults(p.clients, ctx, req, p.next.Add(-Latency(p.clients) - p.early), results)
+	p.early = 0
+	p.sent(p.next)
+	return results
+}
+
+// SetClients changes the set of clients that later requests go to.
+func (p *Pacer) SetClients(clients []types.ID) {
+	p.clients = clients
+}
+
+// Advance moves the timeline forward, and waits until it's time to send
+// the next request. It returns early if the context is done.
+func (p *Pacer) Advance(ctx context.Context, d time.Duration) {
+	now := time.Now()
+	p.next = p.next.Add(d)
+	if p.next.Before(now) {
+		// We've fallen behind (e.g. the pacer was idle); don't
+		// try to catch up by sending a burst of requests.
+		p.next = now
+	}
+	p.wait(ctx)
+}
+
+// AdvanceQueued is like Advance(ctx, d + gap), for a request such as
+// Play or Blink that the devices queue, and that lasts for d. If gap is
+// zero, it returns Lookahead early, so the next request can be queued
+// on the devices behind this one and start as soon as it ends, rather
+// than risking a gap if the devices' latency varies.
+//
+// The timeline is also kept from running ahead of the devices' queues,
+// in case they're running behind what the durations would suggest.
+func (p *Pacer) AdvanceQueued(ctx context.Context, ty lease.Type, d, gap time.Duration) {
+	if gap > 0 {
+		p.Advance(ctx, d + gap)
+		return
+	}
+	p.next = later(p.next.Add(d), QueueEnd(p.clients, ty))
+	p.early = Lookahead
+	p.wait(ctx)
+}
+
+// wait waits until it's time to send the next request, or until the
+// context is done.
+func (p *Pacer) wait(ctx context.Context) {
+	p.followLeader(ctx)
+	t := time.NewTimer(time.Until(p.next.Add(-Latency(p.clients) - p.early)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
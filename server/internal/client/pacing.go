@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The firmware's web server handles one connection at a time, and
+// resets connections that arrive too soon after the previous one. So
+// however many of a client's lanes have requests ready, they go through
+// the client's gate, which lets one request at a time through to the
+// device and spaces them out. How far apart depends on the device, so
+// the gap adapts: it doubles when a request gets "connection reset by
+// peer", and shrinks slowly again once requests have been succeeding
+// for a while. It's never shorter than the device profile's minimum
+// interval, if it has one.
+
+const (
+	// The smallest and largest gaps between requests to a device.
+	minGetURLDelay = 30 * time.Millisecond
+	maxGetURLDelay = 500 * time.Millisecond
+
+	// After this many requests in a row without a reset, the gap
+	// shrinks by pacingDecrease.
+	pacingStreak	= 20
+	pacingDecrease	= 5 * time.Millisecond
+)
+
+// gate serializes a client's requests to its device.
+type gate struct {
+	mu	sync.Mutex	// held while a request is sent and answered
+	pacing	pacing		// used with mu held
+	fence	atomic.Uint64	// bumped by each Mute; see fence
+}
+
+// pacing is how requests to a device are spaced out.
+type pacing struct {
+	next		time.Time	// when the next request may be sent
+	delay		time.Duration
+	streak		int
+	lastSuccess	time.Time
+}
+
+func newGate() *gate {
+	return &gate{pacing: pacing{delay: minGetURLDelay}}
+}
+
+// enter waits for the device to be free, and for the pacing to allow
+// another request.
+func (g *gate) enter() {
+	g.mu.Lock()
+	if dur := time.Until(g.pacing.next); dur > 0 {
+		time.Sleep(dur)
+	}
+}
+
+func (g *gate) leave() {
+	g.mu.Unlock()
+}
+
+// pace adjusts the gap between requests to the device, given how a
+// request sent at the given time turned out, and reports whether the
+// gap grew. The caller must be inside the gate.
+func (p *pacing) pace(sent time.Time, err error, minInterval time.Duration) bool {
+	grew := false
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		p.streak = 0
+		if p.delay < maxGetURLDelay {
+			p.delay = min(p.delay * 2, maxGetURLDelay)
+			grew = true
+		}
+	case err != nil:
+	default:
+		p.lastSuccess = time.Now()
+		p.streak++
+		if p.streak >= pacingStreak && p.delay > minGetURLDelay {
+			p.streak = 0
+			p.delay = max(p.delay - pacingDecrease, minGetURLDelay)
+		}
+	}
+	p.next = later(p.lastSuccess.Add(p.delay), sent.Add(minInterval))
+	return grew
+}
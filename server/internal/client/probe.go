@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// ProbeStats summarizes the round-trip times of a client's probes.
+type ProbeStats struct {
+	Samples	int
+	Mean	time.Duration
+	StdDev	time.Duration	// the client's jitter
+	Outlier	bool		// much more jittery than most clients
+}
+
+const (
+	// Time between probes of each client.
+	probeDelay = 30 * time.Second
+
+	// The weight given to each new probe.
+	probeAlpha = 0.1
+
+	// A client is an outlier if its jitter is more than this many
+	// times the fleet's median jitter ...
+	outlierFactor = 3.0
+
+	// ... and more than this.
+	outlierMinJitter = 10 * time.Millisecond
+)
+
+var probes struct {
+	mu	sync.Mutex
+	stats	map[types.ID]*probeData
+}
+
+type probeData struct {
+	samples		int
+	mean		float64	// seconds
+	variance	float64	// seconds^2
+	outlier		bool
+}
+
+func init() {
+	probes.stats = make(map[types.ID]*probeData)
+}
+
+// Probe periodically measures the round-trip time of a cheap request to
+// the device, to estimate its processing delay and jitter.
+type Probe struct {}
+
+func (r *Probe) handle(ctx context.Context, c *client) error {
+	action(c.id, ctx, r, time.Now().Add(probeDelay))
+
+	// Unlike "ping", this doesn't power up the sound hardware.
+	if _, err := c.getURL(ctx, "lightpending"); err != nil {
+		return err
+	}
+	jitter := recordProbe(*c, c.lanes[inFlightFrom(ctx).lane].roundTrip)
+	lease.SetJitter(c.id, jitter)
+	return nil
+}
+
+// recordProbe adds a round-trip time to a client's statistics, and
+// returns the client's updated jitter.
+func recordProbe(c client, rtt time.Duration) time.Duration {
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+
+	p, ok := probes.stats[c.id]
+	if !ok {
+		p = &probeData{mean: rtt.Seconds()}
+		probes.stats[c.id] = p
+	}
+	p.samples++
+	diff := rtt.Seconds() - p.mean
+	p.mean += probeAlpha * diff
+	p.variance = (1 - probeAlpha) * (p.variance + probeAlpha * diff * diff)
+
+	outlier := isOutlier(p)
+	if outlier && !p.outlier {
+		log.Warningf("%v is jittery: round trip %.1f +/- %.1f msec",
+		    c, p.mean * 1000, math.Sqrt(p.variance) * 1000)
+	}
+	p.outlier = outlier
+	return seconds(math.Sqrt(p.variance))
+}
+
+// isOutlier reports whether a client's jitter is much larger than that
+// of most clients. The caller must hold probes.mu.
+func isOutlier(p *probeData) bool {
+	jitters := []float64{}
+	for _, q := range probes.stats {
+		jitters = append(jitters, q.variance)
+	}
+	slices.Sort(jitters)
+	median := math.Sqrt(jitters[len(jitters) / 2])
+	jitter := math.Sqrt(p.variance)
+	return jitter > outlierFactor * median && seconds(jitter) > outlierMinJitter
+}
+
+// Probes returns the probe statistics of each client.
+func Probes() map[types.ID]ProbeStats {
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+	stats := make(map[types.ID]ProbeStats)
+	for id, p := range probes.stats {
+		stats[id] = ProbeStats{
+			Samples:	p.samples,
+			Mean:		seconds(p.mean),
+			StdDev:		seconds(math.Sqrt(p.variance)),
+			Outlier:	p.outlier,
+		}
+	}
+	return stats
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
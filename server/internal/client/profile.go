@@ -0,0 +1,138 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// A Profile describes a model of device, for fleets that mix hardware
+// revisions or firmware that differ in what they can be asked to do. A
+// device gets the first profile (by name) whose Match it satisfies, or
+// none; without a profile, a device is driven as the original hardware
+// is.
+type Profile struct {
+	// Keys and values that the device's mDNS TXT record must all
+	// have, e.g. {"hw": "2"}; see types.Capabilities.
+	Match		map[string]string
+
+	// Commands that the device knows by other names, e.g.
+	// {"blink": "flash"}.
+	Endpoints	map[string]string
+
+	// The loudest the device may play, and the brightest its light
+	// may be set to; zero means the usual maximum.
+	MaxVolume	int
+	MaxBrightness	int
+
+	// The least time between requests to the device, in seconds, for
+	// devices that can't keep up with the usual pace.
+	MinInterval	float64
+
+	// How long the device takes to blink, if it's not the usual; see
+	// CalibrateBlink.
+	Blink		*BlinkModel
+}
+
+// Check returns an error if the profile is invalid.
+func (p Profile) Check() error {
+	if len(p.Match) == 0 {
+		return fmt.Errorf("profile must match something")
+	}
+	if p.MaxVolume < 0 || p.MaxVolume > types.MaxVolume {
+		return fmt.Errorf("profile's max volume %d must be between 0 and %d", p.MaxVolume, types.MaxVolume)
+	}
+	if p.MaxBrightness < 0 || p.MaxBrightness > types.MaxBrightness {
+		return fmt.Errorf("profile's max brightness %d must be between 0 and %d", p.MaxBrightness, types.MaxBrightness)
+	}
+	if p.MinInterval < 0 {
+		return fmt.Errorf("profile's min interval %v must not be negative", p.MinInterval)
+	}
+	if p.Blink != nil {
+		if err := p.Blink.Check(); err != nil {
+			return fmt.Errorf("profile's blink model: %w", err)
+		}
+	}
+	return nil
+}
+
+// profile is a Profile, instantiated.
+type profile struct {
+	name		string
+	endpoints	map[string]string
+	maxVolume	int
+	maxBrightness	int
+	minInterval	time.Duration
+	blink		*BlinkModel
+}
+
+// SetProfiles sets the device profiles, by name. The profiles must have
+// been checked with Check. It must be called before any clients are
+// added.
+func SetProfiles(profiles map[string]Profile) {
+	data.profiles = profiles
+}
+
+// profileFor returns the profile for a device with the given
+// capabilities, or nil if none match.
+func profileFor(caps types.Capabilities) *profile {
+	for _, name := range slices.Sorted(maps.Keys(data.profiles)) {
+		p := data.profiles[name]
+		matched := true
+		for k, v := range p.Match {
+			if have, ok := caps.Info[k]; !ok || have != v {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		return &profile{
+			name:		name,
+			endpoints:	p.Endpoints,
+			maxVolume:	p.MaxVolume,
+			maxBrightness:	p.MaxBrightness,
+			minInterval:	time.Duration(p.MinInterval * float64(time.Second)),
+			blink:		p.Blink,
+		}
+	}
+	return nil
+}
+
+// endpoint returns what the client's device calls a command.
+func (c *client) endpoint(command string) string {
+	if c.profile != nil {
+		if e, ok := c.profile.endpoints[command]; ok {
+			return e
+		}
+	}
+	return command
+}
+
+// profileName returns the name of the client's profile, if it has one.
+func (c *client) profileName() string {
+	if c.profile == nil {
+		return ""
+	}
+	return c.profile.name
+}
+
+// blinkModel returns how long the client's device takes to blink.
+func (c *client) blinkModel() BlinkModel {
+	if c.profile != nil && c.profile.blink != nil {
+		return *c.profile.blink
+	}
+	return defaultBlinkModel
+}
+
+// maxBrightness returns the brightest the client's light may be set to.
+func (c *client) maxBrightness() int {
+	if c.profile != nil && c.profile.maxBrightness != 0 {
+		return c.profile.maxBrightness
+	}
+	return types.MaxBrightness
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// QueueLimit bounds the number of requests waiting in each of a client's
+// heaps (one per lane), so a runaway producer can't use up memory.
+type QueueLimit struct {
+	MaxSize		int	// zero means defaultMaxQueue
+	Overflow	string	// an OverflowPolicy name; default "block"
+}
+
+// OverflowPolicy says what to do with a request for a client whose
+// heap is full.
+type OverflowPolicy int
+const (
+	Block		OverflowPolicy = iota	// wait for room
+	DropNewest				// drop the new request
+	DropOldest				// drop the longest-waiting request
+)
+
+var overflowPolicies = map[string]OverflowPolicy{
+	"block":	Block,
+	"drop-newest":	DropNewest,
+	"drop-oldest":	DropOldest,
+}
+
+// The largest each of a client's heaps gets, by default. Normally it holds only
+// a few requests, since effects pace themselves.
+const defaultMaxQueue = 256
+
+// SetQueueLimit sets the limit for every client's heaps.
+// It must be called before any clients are added.
+func SetQueueLimit(l QueueLimit) error {
+	if l.MaxSize < 0 {
+		return fmt.Errorf("queue size %d is negative", l.MaxSize)
+	}
+	if l.MaxSize > 0 {
+		data.maxQueue = l.MaxSize
+	}
+	if l.Overflow != "" {
+		p, ok := overflowPolicies[l.Overflow]
+		if !ok {
+			return fmt.Errorf("unknown queue overflow policy %q", l.Overflow)
+		}
+		data.overflow = p
+	}
+	return nil
+}
+
+// depths holds the number of requests in each of each client's heaps.
+// It's written by heap threads and read by anyone.
+var depths struct {
+	mu	sync.Mutex
+	depth	map[types.ID][numLanes]int
+}
+
+func init() {
+	depths.depth = make(map[types.ID][numLanes]int)
+}
+
+// QueueDepth returns the number of requests waiting to be sent to a
+// client.
+func QueueDepth(id types.ID) int {
+	depths.mu.Lock()
+	defer depths.mu.Unlock()
+	n := 0
+	for _, d := range depths.depth[id] {
+		n += d
+	}
+	return n
+}
+
+func (c *client) recordDepth(q *laneQueue) {
+	depths.mu.Lock()
+	defer depths.mu.Unlock()
+	d := depths.depth[c.id]
+	d[q.lane] = q.heap.Len()
+	depths.depth[c.id] = d
+}
+
+// enqueue sends a request from outside the client to its lane's heap
+// thread. Under the Block policy it waits for room in the heap, and
+// returns false if the context ends first.
+func (c *client) enqueue(ctx context.Context, msg clientMessage) bool {
+	q := c.lanes[laneOf(msg.clientRequest)]
+	if data.overflow == Block {
+		select {
+		case q.room <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		msg.counted = true
+	}
+	q.heapChannel <- msg
+	return true
+}
+
+// push adds a message to the heap, making room for it first if
+// necessary. Messages sent by the client to itself, such as retries,
+// are always accepted, since the device thread mustn't block on its
+// own heap.
+func (c *client) push(q *laneQueue, msg clientMessage) {
+	q.seq++
+	msg.seq = q.seq
+	if data.overflow != Block && msg.external && q.heap.Len() >= data.maxQueue {
+		victim := msg
+		if data.overflow == DropOldest {
+			i := q.heap.oldest()
+			victim = (*q.heap)[i]
+			heap.Remove(q.heap, i)
+			heap.Push(q.heap, msg)
+		}
+		if !q.overflowWarned {
+			q.overflowWarned = true
+			log.Warningf("%v has %d %v requests queued; dropping some", *c, q.heap.Len(), q.lane)
+		}
+		c.drop(q, victim, fmt.Errorf("client queue full"))
+	} else {
+		heap.Push(q.heap, msg)
+	}
+	c.recordDepth(q)
+}
+
+// pop removes the next message from a lane's heap.
+func (c *client) pop(q *laneQueue) clientMessage {
+	msg := heap.Pop(q.heap).(clientMessage)
+	if msg.counted {
+		<-q.room
+	}
+	c.recordDepth(q)
+	return msg
+}
+
+// drop discards a message that's been pushed, reporting err as its
+// result.
+func (c *client) drop(q *laneQueue, msg clientMessage, err error) {
+	if msg.counted {
+		<-q.room
+	}
+	msg.report(c, err)
+}
+
+// oldest returns the index of the message that's been in the heap the
+// longest.
+func (h clientMessageHeap) oldest() int {
+	o := 0
+	for i := range h {
+		if h[i].seq < h[o].seq {
+			o = i
+		}
+	}
+	return o
+}
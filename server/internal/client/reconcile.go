@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// ReconcileQueue asks the device what's in one of its queues, and clears
+// the queue if everything in it was queued by someone else, e.g. by this
+// server before it restarted. Left alone, such leftovers would play out
+// on top of whatever the next effect does. Each queued command is known
+// by its token; see queueURL.
+type ReconcileQueue struct {
+	Type	lease.Type
+}
+
+func (r *ReconcileQueue) handle(ctx context.Context, c *client) error {
+	body, err := c.getURL(ctx, "queue", "queue=" + r.Type.String())
+	if err != nil {
+		if !Classify(err).Transient() {
+			log.Infof("%v can't list its %v queue; not reconciling it", *c, r.Type)
+			return nil
+		}
+		return err
+	}
+
+	ours, orphans := 0, 0
+	for _, token := range strings.Fields(body) {
+		if strings.HasPrefix(token, tokenPrefix + "-") {
+			ours++
+		} else {
+			orphans++
+		}
+	}
+	switch {
+	case orphans == 0:
+		if ours == 0 {
+			c.queueEnd[r.Type] = time.Now()
+		}
+		return nil
+	case ours > 0:
+		log.Warningf("%v %v queue has %d commands we didn't send, mixed in with %d of ours; leaving it",
+		    *c, r.Type, orphans, ours)
+		return nil
+	}
+	log.Infof("%v clearing %d leftover commands from its %v queue", *c, orphans, r.Type)
+	clear := &Clear{Type: r.Type}
+	return clear.handle(ctx, c)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Devices are supposed to answer numeric queries with a bare number, but
+// some firmware adds decoration, e.g. "4.20 V" or "OK: 3", sometimes over
+// several lines. These parsers take the first number in the response and
+// check that it makes sense for the endpoint. Their errors are
+// BadResponse DeviceErrors.
+
+var numberRE = regexp.MustCompile(`[-+]?([0-9]+(\.[0-9]+)?|\.[0-9]+)([eE][-+]?[0-9]+)?`)
+
+// firstNumber returns the first number in body.
+func firstNumber(body string) (string, error) {
+	n := numberRE.FindString(body)
+	if n == "" {
+		return "", responseError(fmt.Errorf("no number in response %q", body))
+	}
+	return n, nil
+}
+
+// parseVoltage parses the response to "battery".
+func parseVoltage(body string) (float32, error) {
+	n, err := firstNumber(body)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(n, 32)
+	if err != nil {
+		return 0, responseError(err)
+	}
+	if math.IsNaN(v) || v < 0 || v > maxVoltage {
+		return 0, responseError(fmt.Errorf("implausible voltage in response %q", body))
+	}
+	return float32(v), nil
+}
+
+// parseCount parses the response to "soundpending" or "lightpending".
+func parseCount(body string) (int, error) {
+	n, err := firstNumber(body)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(n, 10, 32)
+	if err != nil {
+		return 0, responseError(fmt.Errorf("bad count in response %q: %w", body, err))
+	}
+	if v < 0 {
+		return 0, responseError(fmt.Errorf("negative count in response %q", body))
+	}
+	return int(v), nil
+}
+
+// parseLevel parses the response to "level": the peak output level since
+// the last query, from 0 to 1, then how many times the output clipped.
+func parseLevel(body string) (float32, int, error) {
+	ns := numberRE.FindAllString(body, 2)
+	if len(ns) < 2 {
+		return 0, 0, responseError(fmt.Errorf("need a level and a clip count in response %q", body))
+	}
+	peak, err := strconv.ParseFloat(ns[0], 32)
+	if err != nil || math.IsNaN(peak) || peak < 0 || peak > 1 {
+		return 0, 0, responseError(fmt.Errorf("implausible level in response %q", body))
+	}
+	clips, err := strconv.Atoi(ns[1])
+	if err != nil || clips < 0 {
+		return 0, 0, responseError(fmt.Errorf("bad clip count in response %q", body))
+	}
+	return float32(peak), clips, nil
+}
+
+// parseRSSI parses the response to "rssi", in dBm.
+func parseRSSI(body string) (int, error) {
+	n, err := firstNumber(body)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v < -120 || v > 0 {
+		return 0, responseError(fmt.Errorf("implausible RSSI in response %q", body))
+	}
+	return int(math.Round(v)), nil
+}
+
+// parseTemperature parses the response to "temperature", in degrees C.
+func parseTemperature(body string) (float32, error) {
+	n, err := firstNumber(body)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(n, 32)
+	if err != nil || v < -40 || v > 150 {
+		return 0, responseError(fmt.Errorf("implausible temperature in response %q", body))
+	}
+	return float32(v), nil
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// checkResponseErr reports whether err is what a test case wants: nil,
+// or a BadResponse DeviceError.
+func checkResponseErr(t *testing.T, call string, err error, wantErr bool) bool {
+	t.Helper()
+	if !wantErr {
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", call, err)
+			return false
+		}
+		return true
+	}
+	var de *DeviceError
+	if !errors.As(err, &de) || de.Kind != BadResponse {
+		t.Errorf("%s: error = %v, want a BadResponse", call, err)
+	}
+	return false
+}
+
+func TestFirstNumber(t *testing.T) {
+	tests := []struct {
+		body	string
+		want	string
+		wantErr	bool
+	}{
+		{"42", "42", false},
+		{"4.20 V", "4.20", false},
+		{"OK: 3", "3", false},
+		{"-67 dBm", "-67", false},
+		{"level:\n0.5\n", "0.5", false},
+		{".5 V", ".5", false},
+		{"1e9", "1e9", false},
+		{"OK: 3.", "3", false},
+		{"NaN", "", true},
+		{"", "", true},
+		{"ok", "", true},
+	}
+	for _, tt := range tests {
+		got, err := firstNumber(tt.body)
+		call := "firstNumber(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && got != tt.want {
+			t.Errorf("%s = %q, want %q", call, got, tt.want)
+		}
+	}
+}
+
+func TestParseVoltage(t *testing.T) {
+	tests := []struct {
+		body	string
+		want	float32
+		wantErr	bool
+	}{
+		{"4.20", 4.2, false},
+		{"4.20 V", 4.2, false},
+		{"battery: 3.7\r\n", 3.7, false},
+		{"0", 0, false},
+		{"NaN", 0, true},
+		{"-1", 0, true},
+		{"-0.5 V", 0, true},
+		{"11", 0, true},
+		{"1e9", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseVoltage(tt.body)
+		call := "parseVoltage(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && got != tt.want {
+			t.Errorf("%s = %v, want %v", call, got, tt.want)
+		}
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		body	string
+		want	int
+		wantErr	bool
+	}{
+		{"3", 3, false},
+		{"OK: 3", 3, false},
+		{"3\n4\n", 3, false},
+		{"pending 0", 0, false},
+		{"-2", 0, true},
+		{"1.5", 0, true},
+		{"1e3", 0, true},
+		{"99999999999", 0, true},
+		{"NaN", 0, true},
+		{"none", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCount(tt.body)
+		call := "parseCount(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && got != tt.want {
+			t.Errorf("%s = %v, want %v", call, got, tt.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		body		string
+		wantPeak	float32
+		wantClips	int
+		wantErr		bool
+	}{
+		{"0.5 3", 0.5, 3, false},
+		{"peak: 0.25\nclips: 0\n", 0.25, 0, false},
+		{"1 0", 1, 0, false},
+		{"0.5", 0, 0, true},
+		{"1.5 0", 0, 0, true},
+		{"-0.1 0", 0, 0, true},
+		{"0.5 -1", 0, 0, true},
+		{"0.5 2.5", 0, 0, true},
+		{"NaN 0", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		peak, clips, err := parseLevel(tt.body)
+		call := "parseLevel(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && (peak != tt.wantPeak || clips != tt.wantClips) {
+			t.Errorf("%s = %v, %v, want %v, %v", call, peak, clips, tt.wantPeak, tt.wantClips)
+		}
+	}
+}
+
+func TestParseRSSI(t *testing.T) {
+	tests := []struct {
+		body	string
+		want	int
+		wantErr	bool
+	}{
+		{"-67", -67, false},
+		{"-67 dBm", -67, false},
+		{"rssi: -70.6", -71, false},
+		{"0", 0, false},
+		{"-120", -120, false},
+		{"5", 0, true},
+		{"-121", 0, true},
+		{"NaN", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRSSI(tt.body)
+		call := "parseRSSI(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && got != tt.want {
+			t.Errorf("%s = %v, want %v", call, got, tt.want)
+		}
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	tests := []struct {
+		body	string
+		want	float32
+		wantErr	bool
+	}{
+		{"21.5", 21.5, false},
+		{"21.5 C", 21.5, false},
+		{"temp: -10\n", -10, false},
+		{"-40", -40, false},
+		{"150", 150, false},
+		{"-41", 0, true},
+		{"151", 0, true},
+		{"NaN", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTemperature(tt.body)
+		call := "parseTemperature(" + strconv.Quote(tt.body) + ")"
+		if checkResponseErr(t, call, err, tt.wantErr) && got != tt.want {
+			t.Errorf("%s = %v, want %v", call, got, tt.want)
+		}
+	}
+}
+
+func FuzzParseVoltage(f *testing.F) {
+	for _, s := range []string{"4.20", "4.20 V", "battery: 3.7\n", "", "NaN", "-1", "1e9", "99999999999999999999"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		v, err := parseVoltage(body)
+		if err != nil {
+			return
+		}
+		if v < 0 || v > maxVoltage {
+			t.Errorf("parseVoltage(%q) = %v, out of range", body, v)
+		}
+	})
+}
+
+func FuzzParseCount(f *testing.F) {
+	for _, s := range []string{"3", "OK: 3", "3\n4\n", "", "-2", "1.5", "99999999999999999999"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		n, err := parseCount(body)
+		if err != nil {
+			return
+		}
+		if n < 0 {
+			t.Errorf("parseCount(%q) = %d, negative", body, n)
+		}
+	})
+}
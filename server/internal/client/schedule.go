@@ -0,0 +1,47 @@
+package client
+
+import (
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Slot is when a timed request is expected to run on a client.
+type Slot struct {
+	Start	time.Time
+	End	time.Time
+}
+
+// Expect returns when a timed request of duration d, sent to a client
+// now, would run: after whatever is already in the client's queue of
+// the given type. The queue's end is estimated from the requests the
+// client has carried out, and corrected when the device reports that
+// its queue drained early or late.
+func Expect(id types.ID, ty lease.Type, d time.Duration) Slot {
+	start := later(queueEnd(id, ty), time.Now())
+	return Slot{Start: start, End: start.Add(d)}
+}
+
+// QueueEnd returns when the queues of the given type on all of the
+// given clients are expected to have drained.
+func QueueEnd(ids []types.ID, ty lease.Type) time.Time {
+	end := time.Now()
+	for _, id := range ids {
+		end = later(end, queueEnd(id, ty))
+	}
+	return end
+}
+
+func queueEnd(id types.ID, ty lease.Type) time.Time {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	s := statuses.status[id]
+	switch ty {
+	case lease.Sound:
+		return s.soundEnd
+	case lease.Light:
+		return s.lightEnd
+	}
+	return time.Time{}
+}
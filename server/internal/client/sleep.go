@@ -0,0 +1,267 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Devices can go into a deep sleep, which uses far less of their battery
+// than sitting idle, for a set time. Nothing can wake one early: it
+// doesn't listen to the network while it sleeps. When it wakes up, it
+// restarts, and the server finds it again over mDNS; until then, the
+// server doesn't send it anything, and the lease threads don't hand it
+// out.
+//
+// A SleepSchedule puts the devices to sleep every night, and wakes them
+// up one by one over a ramp before showtime, so that they don't all
+// join the network at once.
+
+// SleepSchedule describes when the installation is closed.
+type SleepSchedule struct {
+	Sleep		string	// when to put the devices to sleep, e.g. "01:00"
+	Showtime	string	// when they should all be awake, e.g. "18:30"
+	RampMinutes	float64	// how long before showtime they start waking up
+}
+
+const (
+	// How often the sleep schedule is checked.
+	sleepCheckInterval = time.Minute
+
+	// How long after its wake time a device should be back.
+	wakeGrace = time.Minute
+)
+
+// errAsleep is returned for requests to a sleeping device.
+var errAsleep = errors.New("device is asleep")
+
+var sleeping struct {
+	mu	sync.Mutex
+	until	map[types.ID]time.Time	// when each sleeping device wakes up
+}
+
+func init() {
+	sleeping.until = make(map[types.ID]time.Time)
+}
+
+// Check returns an error if the schedule is invalid.
+func (s SleepSchedule) Check() error {
+	sleep, err := time.Parse("15:04", s.Sleep)
+	if err != nil {
+		return fmt.Errorf("sleep time %q: %w", s.Sleep, err)
+	}
+	show, err := time.Parse("15:04", s.Showtime)
+	if err != nil {
+		return fmt.Errorf("showtime %q: %w", s.Showtime, err)
+	}
+	if s.RampMinutes < 0 {
+		return fmt.Errorf("wake ramp %v minutes must not be negative", s.RampMinutes)
+	}
+	closed := show.Sub(sleep)
+	if closed <= 0 {
+		closed += 24 * time.Hour
+	}
+	if s.ramp() >= closed {
+		return fmt.Errorf("wake ramp of %v minutes doesn't fit between %s and %s",
+		    s.RampMinutes, s.Sleep, s.Showtime)
+	}
+	return nil
+}
+
+func (s SleepSchedule) ramp() time.Duration {
+	return time.Duration(s.RampMinutes * float64(time.Minute))
+}
+
+// closed returns whether the devices should be asleep at the given time,
+// and if so, when the next show starts.
+func (s SleepSchedule) closed(now time.Time) (bool, time.Time) {
+	sleep, _ := time.Parse("15:04", s.Sleep)
+	show, _ := time.Parse("15:04", s.Showtime)
+	y, m, d := now.Date()
+	showtime := time.Date(y, m, d, show.Hour(), show.Minute(), 0, 0, now.Location())
+	if !showtime.After(now) {
+		showtime = showtime.AddDate(0, 0, 1)
+	}
+	bedtime := time.Date(showtime.Year(), showtime.Month(), showtime.Day(),
+	    sleep.Hour(), sleep.Minute(), 0, 0, now.Location())
+	if !bedtime.Before(showtime) {
+		bedtime = bedtime.AddDate(0, 0, -1)
+	}
+	return !now.Before(bedtime) && now.Before(showtime.Add(-s.ramp())), showtime
+}
+
+// StartSleepSchedule puts the devices to sleep while the installation is
+// closed, including any that turn up then. The schedule must have been
+// checked with Check.
+//
+// Devices whose firmware can't sleep are left awake, rather than being
+// sent a Sleep they'd skip every time round.
+func StartSleepSchedule(s SleepSchedule) {
+	go func() {
+		sleepless := make(map[types.ID]bool)	// that we've warned about
+		for ; ; time.Sleep(sleepCheckInterval) {
+			closed, showtime := s.closed(time.Now())
+			if !closed {
+				continue
+			}
+			awake := []types.ID{}
+			for id, ok := range canSleep() {
+				switch {
+				case !ok:
+					if !sleepless[id] {
+						sleepless[id] = true
+						log.Warningf("client %q firmware can't sleep; leaving it awake", id)
+					}
+				case !isAsleep(id):
+					awake = append(awake, id)
+				}
+			}
+			if len(awake) > 0 {
+				SleepUntil(awake, showtime, s.ramp())
+			}
+		}
+	}()
+}
+
+// canSleep returns whether each known client's firmware can sleep.
+func canSleep() map[types.ID]bool {
+	ch := make(chan map[types.ID]bool)
+	enqueueAdminMessage(&canSleepMessage{response: ch})
+	return <-ch
+}
+
+type canSleepMessage struct {
+	response	chan map[types.ID]bool
+}
+
+func (r *canSleepMessage) handle() {
+	req := (&Sleep{}).requires()
+	can := make(map[types.ID]bool)
+	for id, c := range data.clients {
+		can[id] = c.meets(req)
+	}
+	r.response <- can
+}
+
+// SleepUntil puts the given devices to sleep, to wake up at times spread
+// evenly over the ramp before the given time, in a random order.
+func SleepUntil(ids []types.ID, wake time.Time, ramp time.Duration) {
+	ids = slices.Clone(ids)
+	random.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	log.Infof("putting %d clients to sleep, to wake up between %v and %v",
+	    len(ids), wake.Add(-ramp).Format(time.Kitchen), wake.Format(time.Kitchen))
+	for i, id := range ids {
+		until := wake.Add(-ramp)
+		if len(ids) > 1 {
+			until = until.Add(ramp * time.Duration(i) / time.Duration(len(ids) - 1))
+		}
+		action(id, context.Background(), &Sleep{Until: until}, time.Now())
+	}
+}
+
+// Asleep returns when each sleeping device is due to wake up.
+func Asleep() map[types.ID]time.Time {
+	sleeping.mu.Lock()
+	defer sleeping.mu.Unlock()
+	asleep := make(map[types.ID]time.Time)
+	for id, until := range sleeping.until {
+		asleep[id] = until
+	}
+	return asleep
+}
+
+func isAsleep(id types.ID) bool {
+	sleeping.mu.Lock()
+	defer sleeping.mu.Unlock()
+	_, ok := sleeping.until[id]
+	return ok
+}
+
+func setAsleep(id types.ID, until time.Time) {
+	sleeping.mu.Lock()
+	if until.IsZero() {
+		delete(sleeping.until, id)
+	} else {
+		sleeping.until[id] = until
+	}
+	sleeping.mu.Unlock()
+	lease.SetAsleep(id, !until.IsZero())
+}
+
+// Sleep puts a device to sleep until the given time.
+type Sleep struct {
+	Until	time.Time
+}
+
+func (r *Sleep) requires() requirement {
+	return requirement{Firmware: versionedFirmware}
+}
+
+func (r *Sleep) handle(ctx context.Context, c *client) error {
+	ms := time.Until(r.Until).Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+	if _, err := c.getURL(ctx, "sleep", fmt.Sprintf("ms=%d", ms)); err != nil {
+		return err
+	}
+	log.Infof("%v asleep until %v", *c, r.Until.Format(time.Kitchen))
+	c.asleepUntil = r.Until
+	setAsleep(c.id, r.Until)
+
+	// Whatever the device had queued is gone.
+	for ty := range c.queueEnd {
+		c.queueEnd[ty] = time.Time{}
+	}
+
+	// In case the device doesn't turn up over mDNS when it wakes up.
+	action(c.id, context.Background(), &wake{retry: true}, r.Until.Add(wakeGrace))
+	return nil
+}
+
+// wake checks whether a sleeping device has woken up, and if so, sets it
+// up again, since it restarted. It's sent when the device turns up over
+// mDNS, and after the device's wake time, in case it doesn't; the latter
+// keeps trying until the device answers.
+type wake struct {
+	retry	bool
+}
+
+func (r *wake) handle(ctx context.Context, c *client) error {
+	if c.asleepUntil.IsZero() {
+		return nil
+	}
+	until := c.asleepUntil
+	c.asleepUntil = time.Time{}
+	p := &Ping{}
+	if err := p.handle(ctx, c); err != nil {
+		c.asleepUntil = until
+		if r.retry {
+			action(c.id, context.Background(), r, time.Now().Add(wakeGrace))
+		}
+		return nil
+	}
+	log.Infof("%v woke up", *c)
+	setAsleep(c.id, time.Time{})
+
+	var req clientRequest = &SetVolume{Volume: c.targetVolume}
+	if c.muted {
+		req = &Mute{}
+	}
+	action(c.id, context.Background(), req, time.Now())
+	if !c.pausedAt.IsZero() {
+		c.pausedAt = time.Time{}
+		action(c.id, context.Background(), &Pause{}, time.Now())
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// State summarizes what a client is doing, for operators.
+type State string
+const (
+	Offline		State = "offline"	// its last request failed
+	Sleeping	State = "asleep"	// see Sleep
+	LowBattery	State = "low battery"
+	Playing		State = "playing"
+	Blinking	State = "blinking"
+	Idle		State = "idle"
+)
+
+// Below this voltage, a client's battery is considered low.
+const lowVoltage = 3.5
+
+// statuses holds a snapshot of each client's state, which its device
+// thread updates after each request, so that other threads can look at
+// it safely.
+var statuses struct {
+	mu	sync.Mutex
+	status	map[types.ID]status
+}
+
+type status struct {
+	soundEnd	time.Time
+	lightEnd	time.Time
+	lastSuccess	time.Time
+	lastFailure	time.Time
+	failures	int
+	requests	int
+	voltage		float32
+	peakLevel	float32
+	clips		int
+	volumeCap	int
+	volume		int
+	paused		bool
+}
+
+func init() {
+	statuses.status = make(map[types.ID]status)
+}
+
+func (c *client) updateStatus() {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	statuses.status[c.id] = status{
+		soundEnd:	c.queueEnd[lease.Sound],
+		lightEnd:	c.queueEnd[lease.Light],
+		lastSuccess:	c.lastSuccess(),
+		lastFailure:	c.lastFailure(),
+		failures:	c.failures,
+		requests:	c.requests,
+		voltage:	c.voltage,
+		peakLevel:	c.peakLevel,
+		clips:		c.clips,
+		volumeCap:	c.volumeCap,
+		volume:		c.targetVolume,
+		paused:		!c.pausedAt.IsZero(),
+	}
+}
+
+// getFailures returns how many of a client's requests have failed.
+func getFailures(id types.ID) int {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	return statuses.status[id].failures
+}
+
+// getActivity returns how many requests have been sent to a client, and
+// when one last succeeded.
+func getActivity(id types.ID) (requests int, lastSuccess time.Time) {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	s := statuses.status[id]
+	return s.requests, s.lastSuccess
+}
+
+// getLevel returns a client's output level statistics; see
+// KeepLevelUpdated.
+func getLevel(id types.ID) (peak float32, clips, volumeCap int) {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	s := statuses.status[id]
+	return s.peakLevel, s.clips, s.volumeCap
+}
+
+// getMix returns a client's volume setting, and whether it's paused.
+func getMix(id types.ID) (volume int, paused bool) {
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	s := statuses.status[id]
+	return s.volume, s.paused
+}
+
+// getStatus returns a client's state and battery voltage.
+func getStatus(id types.ID) (State, float32) {
+	statuses.mu.Lock()
+	s := statuses.status[id]
+	statuses.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case isAsleep(id):
+		return Sleeping, s.voltage
+	case s.lastFailure.After(s.lastSuccess):
+		return Offline, s.voltage
+	case s.voltage > 0 && s.voltage < lowVoltage:
+		return LowBattery, s.voltage
+	case s.soundEnd.After(now):
+		return Playing, s.voltage
+	case s.lightEnd.After(now):
+		return Blinking, s.voltage
+	}
+	return Idle, s.voltage
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// TelemetryStats holds the latest health readings from a client's
+// hardware. A weak Wi-Fi signal is the best warning that a client is
+// about to start dropping requests.
+type TelemetryStats struct {
+	RSSI		int	`json:",omitempty"`	// Wi-Fi signal strength, in dBm
+	Temperature	float32	`json:",omitempty"`	// of the chip, in degrees C
+	Updated		time.Time
+
+	WeakSignal	bool	// the RSSI is poor, or much worse than most clients'
+	Hot		bool	// it's hot, or much hotter than most clients
+}
+
+const (
+	// Time between telemetry updates.
+	telemetryDelay = 60 * time.Second
+
+	// A client's signal is weak if its RSSI is below this ...
+	weakRSSI = -80
+
+	// ... or this far below the fleet's median.
+	rssiMargin = 15
+
+	// A client is hot if its temperature is above this ...
+	hotTemperature = 70.0
+
+	// ... or this far above the fleet's median.
+	temperatureMargin = 15.0
+)
+
+var telemetry struct {
+	mu	sync.Mutex
+	stats	map[types.ID]*TelemetryStats
+}
+
+func init() {
+	telemetry.stats = make(map[types.ID]*TelemetryStats)
+}
+
+// KeepTelemetryUpdated periodically reads the device's Wi-Fi signal
+// strength and temperature. Firmware that lacks either endpoint stops
+// being asked for it.
+type KeepTelemetryUpdated struct {
+	noRSSI		bool
+	noTemperature	bool
+}
+
+func (r *KeepTelemetryUpdated) handle(ctx context.Context, c *client) error {
+	var rssi *int
+	var temperature *float32
+	var err error
+	if !r.noRSSI {
+		var body string
+		if body, err = c.getURL(ctx, "rssi"); err == nil {
+			var v int
+			if v, err = parseRSSI(body); err == nil {
+				rssi = &v
+			}
+		} else if !Classify(err).Transient() {
+			log.Infof("%v can't report its RSSI; not asking again", *c)
+			r.noRSSI = true
+		}
+	}
+	if !r.noTemperature {
+		body, terr := c.getURL(ctx, "temperature")
+		if terr == nil {
+			var v float32
+			if v, terr = parseTemperature(body); terr == nil {
+				temperature = &v
+			}
+		} else if !Classify(terr).Transient() {
+			log.Infof("%v can't report its temperature; not asking again", *c)
+			r.noTemperature = true
+		}
+		if err == nil {
+			err = terr
+		}
+	}
+	if rssi != nil || temperature != nil {
+		recordTelemetry(*c, rssi, temperature)
+	}
+	if !r.noRSSI || !r.noTemperature {
+		action(c.id, ctx, r, time.Now().Add(telemetryDelay))
+	}
+	return err
+}
+
+// recordTelemetry stores a client's latest readings, and warns when it
+// becomes an outlier.
+func recordTelemetry(c client, rssi *int, temperature *float32) {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+
+	t, ok := telemetry.stats[c.id]
+	if !ok {
+		t = &TelemetryStats{}
+		telemetry.stats[c.id] = t
+	}
+	t.Updated = time.Now()
+	if rssi != nil {
+		t.RSSI = *rssi
+		median := medianTelemetry(func(s *TelemetryStats) float64 {
+			return float64(s.RSSI)
+		})
+		weak := t.RSSI < weakRSSI || float64(t.RSSI) < median - rssiMargin
+		if weak && !t.WeakSignal {
+			log.Warningf("%v has a weak Wi-Fi signal (%d dBm); expect dropped requests", c, t.RSSI)
+		}
+		t.WeakSignal = weak
+	}
+	if temperature != nil {
+		t.Temperature = *temperature
+		median := medianTelemetry(func(s *TelemetryStats) float64 {
+			return float64(s.Temperature)
+		})
+		hot := t.Temperature > hotTemperature || float64(t.Temperature) > median + temperatureMargin
+		if hot && !t.Hot {
+			log.Warningf("%v is running hot (%.1f C)", c, t.Temperature)
+		}
+		t.Hot = hot
+	}
+}
+
+// medianTelemetry returns the median over the fleet of one reading,
+// leaving out clients that haven't reported it. The caller must hold
+// telemetry.mu.
+func medianTelemetry(reading func(*TelemetryStats) float64) float64 {
+	vs := []float64{}
+	for _, s := range telemetry.stats {
+		if v := reading(s); v != 0 {
+			vs = append(vs, v)
+		}
+	}
+	if len(vs) == 0 {
+		return 0
+	}
+	slices.Sort(vs)
+	return vs[len(vs) / 2]
+}
+
+// Telemetry returns the latest telemetry from each client that has
+// reported any.
+func Telemetry() map[types.ID]TelemetryStats {
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	stats := make(map[types.ID]TelemetryStats)
+	for id, t := range telemetry.stats {
+		stats[id] = *t
+	}
+	return stats
+}
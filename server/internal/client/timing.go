@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// An effect can shape when the timed requests (plays, blinks, and fades)
+// it sends start, through its requests' context:
+//
+// - A Quantizer moves each request's start to a later time, e.g. the
+//   next beat (see the beat package). The start is when the request is
+//   expected to reach the device, i.e. its due time plus the client's
+//   latency, so the device gets it on the beat.
+//
+// - StartOffsets delay each client's requests by a fixed amount per
+//   client, so that requests sent to several clients for the same time
+//   start a little raggedly, as real crickets would. Since each
+//   client's offset is the same for all of its requests, the requests
+//   still run in order.
+
+// A Quantizer returns the time a request that would start at t should
+// start instead, which must not be before t.
+type Quantizer func(t time.Time) time.Time
+
+// StartOffsets are per-client start delays.
+type StartOffsets map[types.ID]time.Duration
+
+type quantizerKey struct{}
+type startOffsetsKey struct{}
+
+// WithQuantizer returns a context that makes Action and
+// ActionWithResults quantize timed requests' start times.
+func WithQuantizer(ctx context.Context, q Quantizer) context.Context {
+	return context.WithValue(ctx, quantizerKey{}, q)
+}
+
+// WithStartOffsets returns a context that makes Action and
+// ActionWithResults apply the offsets.
+func WithStartOffsets(ctx context.Context, o StartOffsets) context.Context {
+	return context.WithValue(ctx, startOffsetsKey{}, o)
+}
+
+// dueTime returns when the request, asked to be sent at earliest, should
+// be sent to the client.
+func dueTime(ctx context.Context, id types.ID, req clientRequest, earliest time.Time) time.Time {
+	if _, ok := req.(timedRequest); !ok || earliest.IsZero() {
+		return earliest
+	}
+	if q, ok := ctx.Value(quantizerKey{}).(Quantizer); ok {
+		l := Latency([]types.ID{id})
+		earliest = q(earliest.Add(l)).Add(-l)
+	}
+	if o, ok := ctx.Value(startOffsetsKey{}).(StartOffsets); ok {
+		earliest = earliest.Add(o[id])
+	}
+	return earliest
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Commands that add to one of a device's queues carry a token, which is
+// different for each command. If such a command times out, it may or may
+// not have reached the device; firmware that advertises the "tokens"
+// feature remembers the tokens of recent commands, so the command can be
+// sent again without being queued twice. Other firmware ignores tokens,
+// and commands to it aren't retried.
+
+// The feature that firmware advertises if it understands tokens.
+const tokenFeature = "tokens"
+
+// tokenPrefix keeps this run's tokens from matching the last run's, which
+// devices may still remember.
+var tokenPrefix = fmt.Sprintf("%08x", rand.Uint32())
+
+// queueURL is like getURL, for commands that queue something on the
+// device.
+func (c *client) queueURL(ctx context.Context, command string, args ...string) (string, error) {
+	c.tokenSeq++
+	args = append(args, fmt.Sprintf("token=%s-%d", tokenPrefix, c.tokenSeq))
+	body, err := c.getURL(ctx, command, args...)
+	if err != nil && Classify(err) == Timeout && ctx.Err() == nil &&
+	    c.capabilities.Has([]string{tokenFeature}) {
+		log.Infof("%v retrying %q after a timeout", *c, command)
+		body, err = c.getURL(ctx, command, args...)
+	}
+	return body, err
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// A transport carries a command to a device, and returns the body of
+// the device's response. It's called without the client's lock held.
+type transport interface {
+	call(ctx context.Context, c *client, command string, args []string) (string, error)
+}
+
+// ---------------------------------------------------------------------
+
+// httpTransport sends each command as an HTTP GET to the device's
+// web server. This is how devices found via mDNS are reached.
+//
+// If the device can't be reached at its current address, its other
+// addresses are tried in turn, and the first one that works becomes
+// its current address.
+type httpTransport struct {}
+
+func (t *httpTransport) call(ctx context.Context, c *client, command string, args []string) (string, error) {
+	// The client's lock isn't held while waiting for the device (see
+	// getURL), so it's only taken here to use the client's addresses.
+	c.mu.Lock()
+	locs := []types.NetLocation{c.netLocation}
+	for _, l := range c.netLocations {
+		if !l.Equal(c.netLocation) {
+			locs = append(locs, l)
+		}
+	}
+	c.mu.Unlock()
+
+	var body string
+	var err error
+	for _, loc := range locs {
+		var reached bool
+		c.mu.Lock()
+		base := c.baseURL(loc)
+		c.mu.Unlock()
+		body, reached, err = t.get(ctx, base, command, args)
+		if !reached {
+			if ctx.Err() == nil {
+				continue
+			}
+			break
+		}
+		c.mu.Lock()
+		if !loc.Equal(c.netLocation) {
+			log.Infof("%v failing over from %v to %v", *c, c.netLocation, loc)
+			c.netLocation = loc
+		}
+		c.mu.Unlock()
+		break
+	}
+	return body, err
+}
+
+// baseURL returns "http://host:port/" for one of the client's locations.
+// The last one is kept, since it's almost always the one asked for.
+func (c *client) baseURL(loc types.NetLocation) string {
+	if c.base == "" || !loc.Equal(c.baseLocation) {
+		c.base = "http://" + loc.HostPort() + "/"
+		c.baseLocation = loc
+	}
+	return c.base
+}
+
+// get performs a single request, and reports whether the device was
+// reached at all.
+func (t *httpTransport) get(ctx context.Context, base, command string, args []string) (string, bool, error) {
+	n := len(base) + len(command) + len(args)
+	for _, a := range args {
+		n += len(a)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	b.WriteString(base)
+	b.WriteString(command)
+	for i, a := range args {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(a)
+	}
+	url := b.String()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("NewRequest returned error: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", false, callError(fmt.Errorf("Do(%v) returned error: %w", base, err))
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, callError(fmt.Errorf("error while reading body: %w", err))
+	}
+	if resp.StatusCode > 299 {
+		return "", true, statusError(resp.StatusCode, string(body))
+	}
+	return string(body), true, nil
+}
+
+// ---------------------------------------------------------------------
+
+// pollTransport is used by devices that can't be reached directly, e.g.
+// because they're behind NAT or their multicast traffic gets lost. Such
+// a device repeatedly polls the server for its next command, and posts
+// the result back.
+//
+// The device's side of the protocol is:
+//
+// - GET /poll?id=ID[&txt=KEY=VALUE...] waits for up to pollWait, and
+//   returns either 204 (no command) or a JSON pollCommand. The txt
+//   arguments are parsed like an mDNS TXT record.
+//
+// - POST /result?id=ID with a JSON pollResult body reports the result
+//   of the command with the matching Seq.
+type pollTransport struct {
+	commands	chan pollCommand
+
+	mu		sync.Mutex
+	seq		uint64
+	waiting		map[uint64]chan pollResult
+}
+
+type pollCommand struct {
+	Seq	uint64
+	Command	string
+	Args	string	// URL-encoded, as for HTTP
+}
+
+type pollResult struct {
+	Seq	uint64
+	Status	int	// HTTP-style status code
+	Body	string
+}
+
+const (
+	// How long a device's poll waits for a command.
+	pollWait = 25 * time.Second
+
+	// How long to wait for a device to pick up and answer a command.
+	pollCallTimeout = 2 * pollWait
+)
+
+var polled struct {
+	mu		sync.Mutex
+	transports	map[types.ID]*pollTransport
+	namespace	string
+	foreign		map[types.ID]bool	// warned about; see ServePolling
+}
+
+// ServePolling accepts polling connections from devices on the given
+// address, e.g. ":8081". Devices that poll are added as clients, unless
+// they're in a fleet namespace other than the given one, as with mDNS
+// discovery.
+func ServePolling(addr, namespace string) {
+	polled.transports = make(map[types.ID]*pollTransport)
+	polled.namespace = namespace
+	polled.foreign = make(map[types.ID]bool)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /poll", handlePoll)
+	mux.HandleFunc("POST /result", handleResult)
+	go func() {
+		log.Infof("accepting device polls on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("device polling failed: %v", err)
+		}
+	}()
+}
+
+func (t *pollTransport) call(ctx context.Context, c *client, command string, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pollCallTimeout)
+	defer cancel()
+
+	ch := make(chan pollResult, 1)
+	t.mu.Lock()
+	t.seq++
+	cmd := pollCommand{Seq: t.seq, Command: command, Args: strings.Join(args, "&")}
+	t.waiting[cmd.Seq] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.waiting, cmd.Seq)
+		t.mu.Unlock()
+	}()
+
+	select {
+	case t.commands <- cmd:
+	case <-ctx.Done():
+		return "", callError(fmt.Errorf("device didn't poll: %w", ctx.Err()))
+	}
+	select {
+	case res := <-ch:
+		if res.Status > 299 {
+			return "", statusError(res.Status, res.Body)
+		}
+		return res.Body, nil
+	case <-ctx.Done():
+		return "", callError(fmt.Errorf("device didn't answer: %w", ctx.Err()))
+	}
+}
+
+func handlePoll(w http.ResponseWriter, r *http.Request) {
+	id := types.ID(r.FormValue("id"))
+	if !id.Valid() {
+		http.Error(w, "missing or invalid id", http.StatusBadRequest)
+		return
+	}
+
+	caps := types.ParseCapabilities(r.Form["txt"])
+	polled.mu.Lock()
+	if caps.Namespace != polled.namespace {
+		if !polled.foreign[id] {
+			log.Infof("ignoring polling client %q in fleet namespace %q", id, caps.Namespace)
+			polled.foreign[id] = true
+		}
+		polled.mu.Unlock()
+		http.Error(w, "wrong fleet namespace", http.StatusForbidden)
+		return
+	}
+	t, ok := polled.transports[id]
+	if !ok {
+		t = &pollTransport{
+			commands:	make(chan pollCommand),
+			waiting:	make(map[uint64]chan pollResult),
+		}
+		polled.transports[id] = t
+	}
+	polled.mu.Unlock()
+	if !ok {
+		loc := types.NetLocation{}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			host, zone, _ := strings.Cut(host, "%")
+			loc.Address = net.ParseIP(host)
+			loc.Zone = zone
+		}
+		addWithTransport(id, loc, caps, t)
+	}
+
+	select {
+	case cmd := <-t.commands:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cmd)
+	case <-time.After(pollWait):
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+func handleResult(w http.ResponseWriter, r *http.Request) {
+	polled.mu.Lock()
+	t, ok := polled.transports[types.ID(r.FormValue("id"))]
+	polled.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown id", http.StatusNotFound)
+		return
+	}
+
+	var res pollResult
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only the first result for a command is delivered; a device that
+	// retries its POST gets told the command is gone.
+	t.mu.Lock()
+	ch, ok := t.waiting[res.Seq]
+	delete(t.waiting, res.Seq)
+	t.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown or expired seq", http.StatusGone)
+		return
+	}
+	select {
+	case ch <- res:
+	default:
+	}
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// udpTransport sends commands as UDP datagrams, for requests (such as
+// blinks) where tens of milliseconds matter for synchronization.
+//
+// A command is sent as "<seq> <command>?<args>", and the device answers
+// with "<seq> <status> <body>", where status is an HTTP-style code.
+// If wait is false, the ack isn't waited for, but it's still used to
+// tell whether the device is listening. If the device hasn't acked
+// anything within udpAckWindow, commands go over its usual transport
+// instead, along with a UDP ping to see whether it has started acking.
+type udpTransport struct {
+	wait	bool
+}
+
+const (
+	// The port devices listen for UDP commands on, unless their mDNS
+	// record says otherwise with a "udp" key.
+	udpCommandPort = 4210
+
+	// How recently a device must have acked a UDP command for UDP to
+	// be used.
+	udpAckWindow = 30 * time.Second
+
+	// How long to wait for an ack, when waiting.
+	udpAckTimeout = 100 * time.Millisecond
+
+	// How long to wait after a failed read before trying again, at
+	// first and at most.
+	udpReadBackoff = 100 * time.Millisecond
+	maxUDPReadBackoff = 30 * time.Second
+)
+
+// udpTransports maps the names accepted by SetTransport to transports.
+var udpTransports = map[string]*udpTransport{
+	"udp":		{wait: false},
+	"udp-ack":	{wait: true},
+}
+
+type udpAck struct {
+	status	int
+	body	string
+}
+
+type udpPending struct {
+	id	types.ID
+	sent	time.Time
+	ch	chan udpAck
+}
+
+var udp struct {
+	once	sync.Once
+	conn	*net.UDPConn
+	err	error
+
+	mu	sync.Mutex
+	seq	uint64
+	pending	map[uint64]udpPending
+	lastAck	map[types.ID]time.Time
+}
+
+// SetTransport chooses how a command (e.g. "blink") is sent to devices
+// reached over HTTP: "http", "udp" (fire and forget), or "udp-ack"
+// (wait briefly for an ack, falling back to HTTP without one).
+// It must be called before any clients are added.
+func SetTransport(command, name string) error {
+	switch name {
+	case "http":
+		delete(data.commandTransports, command)
+		return nil
+	}
+	t, ok := udpTransports[name]
+	if !ok {
+		return fmt.Errorf("unknown transport %q for command %q", name, command)
+	}
+	data.commandTransports[command] = t
+	return nil
+}
+
+// transportFor returns the transport to use for a command.
+// UDP is only used for devices that are reachable directly.
+func (c *client) transportFor(command string) transport {
+	if _, ok := c.transport.(*httpTransport); !ok {
+		return c.transport
+	}
+	if t, ok := data.commandTransports[command]; ok {
+		return t
+	}
+	return c.transport
+}
+
+func (t *udpTransport) call(ctx context.Context, c *client, command string, args []string) (string, error) {
+	udp.mu.Lock()
+	lastAck := udp.lastAck[c.id]
+	udp.mu.Unlock()
+	if time.Since(lastAck) > udpAckWindow {
+		udpSend(c, "ping", nil)
+		return c.transport.call(ctx, c, command, args)
+	}
+
+	ch, err := udpSend(c, command, args)
+	if err != nil {
+		c.mu.Lock()
+		log.Warningf("%v UDP %q failed, using %T: %v", *c, command, c.transport, err)
+		c.mu.Unlock()
+		return c.transport.call(ctx, c, command, args)
+	}
+	if !t.wait {
+		return "", nil
+	}
+
+	select {
+	case ack := <-ch:
+		if ack.status > 299 {
+			return "", statusError(ack.status, ack.body)
+		}
+		return ack.body, nil
+	case <-time.After(udpAckTimeout):
+		// The command may have arrived even though the ack didn't,
+		// so this can occasionally run it twice.
+		return c.transport.call(ctx, c, command, args)
+	case <-ctx.Done():
+		return "", callError(ctx.Err())
+	}
+}
+
+// udpSend sends a command to a device, and returns a channel that will
+// receive its ack. The client's lock must not be held.
+func udpSend(c *client, command string, args []string) (<-chan udpAck, error) {
+	udp.once.Do(func() {
+		udp.pending = make(map[uint64]udpPending)
+		udp.lastAck = make(map[types.ID]time.Time)
+		udp.conn, udp.err = net.ListenUDP("udp", nil)
+		if udp.err == nil {
+			go udpReader()
+		}
+	})
+	if udp.err != nil {
+		return nil, udp.err
+	}
+
+	c.mu.Lock()
+	port := udpCommandPort
+	if p, err := strconv.Atoi(c.capabilities.Info["udp"]); err == nil {
+		port = p
+	}
+	addr := &net.UDPAddr{IP: c.netLocation.Address, Port: port, Zone: c.netLocation.Zone}
+	c.mu.Unlock()
+
+	now := time.Now()
+	ch := make(chan udpAck, 1)
+	udp.mu.Lock()
+	udp.seq++
+	seq := udp.seq
+	udp.pending[seq] = udpPending{id: c.id, sent: now, ch: ch}
+	for s, p := range udp.pending {
+		if now.Sub(p.sent) > udpAckWindow {
+			delete(udp.pending, s)
+		}
+	}
+	udp.mu.Unlock()
+
+	msg := fmt.Sprintf("%d %s", seq, command)
+	if len(args) > 0 {
+		msg += "?" + strings.Join(args, "&")
+	}
+	if _, err := udp.conn.WriteToUDP([]byte(msg), addr); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// udpReader receives acks from devices. It gives up if the socket is
+// closed, and backs off if reads keep failing, so a broken socket
+// doesn't flood the log. Commands fall back to their usual transport
+// while acks aren't arriving.
+func udpReader() {
+	buf := make([]byte, 1500)
+	backoff := time.Duration(0)
+	for {
+		n, _, err := udp.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			log.Errorf("UDP socket closed; no longer reading acks")
+			return
+		}
+		if err != nil {
+			backoff = min(max(backoff * 2, udpReadBackoff), maxUDPReadBackoff)
+			log.Errorf("UDP read failed: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		fields := strings.SplitN(string(buf[:n]), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		seq, err1 := strconv.ParseUint(fields[0], 10, 64)
+		status, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ack := udpAck{status: status}
+		if len(fields) == 3 {
+			ack.body = fields[2]
+		}
+
+		udp.mu.Lock()
+		p, ok := udp.pending[seq]
+		delete(udp.pending, seq)
+		if ok {
+			udp.lastAck[p.id] = time.Now()
+		}
+		udp.mu.Unlock()
+		if ok {
+			p.ch <- ack
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// PauseZone pauses sound on every client in a zone, or on every client
+// if zone is empty, e.g. for an announcement. Queued sounds are kept,
+// and the server's idea of when each client's queue will drain is
+// frozen until the client is unpaused.
+func PauseZone(zone string) {
+	ids := ZoneIDs(zone)
+	log.Infof("pausing %d clients (zone %q)", len(ids), zone)
+	Action(ids, context.Background(), &Pause{}, time.Now())
+}
+
+// UnpauseZone undoes PauseZone.
+func UnpauseZone(zone string) {
+	ids := ZoneIDs(zone)
+	log.Infof("unpausing %d clients (zone %q)", len(ids), zone)
+	Action(ids, context.Background(), &Unpause{}, time.Now())
+}
+
+// AdjustZoneVolume turns the volume of every client in a zone (or of
+// every client, if zone is empty) up or down by delta.
+func AdjustZoneVolume(zone string, delta int) {
+	ids := ZoneIDs(zone)
+	log.Infof("adjusting volume of %d clients (zone %q) by %+d", len(ids), zone, delta)
+	Action(ids, context.Background(), &AdjustVolume{Delta: delta}, time.Now())
+}
+
+// RampZoneVolume moves the volume of every client in a zone (or of every
+// client, if zone is empty) to volume, in single steps spread evenly over
+// the given time, so that the change isn't an audible jump.
+func RampZoneVolume(zone string, volume int, over time.Duration) {
+	ids := ZoneIDs(zone)
+	log.Infof("ramping volume of %d clients (zone %q) to %d over %v", len(ids), zone, volume, over)
+	Action(ids, context.Background(), &RampVolume{Volume: volume, Over: over}, time.Now())
+}
+
+// Info describes a client, for operators.
+type Info struct {
+	ID		types.ID
+	Name		string
+	Zone		string
+	Address		string
+	Location	types.PhysLocation
+	State		State
+	Voltage		float32	// zero if not known yet
+	Queued		int	// requests waiting to be sent
+	Firmware	string
+	Profile		string	`json:",omitempty"`	// see SetProfiles
+	LastSeen	time.Time	// when a request to it last succeeded
+	Requests	int	// requests sent since the server started
+	Failures	int	// failed requests since the server started
+	Volume		int	// its volume setting
+	Paused		bool	// see PauseZone
+
+	// For devices that can measure their output: the peak level
+	// recently, from 0 to 1; how often the output has clipped; and the
+	// volume cap that clipping led to, if any.
+	PeakLevel	float32	`json:",omitempty"`
+	Clips		int	`json:",omitempty"`
+	VolumeCap	int	`json:",omitempty"`
+}
+
+// List returns information about every known client.
+func List() []Info {
+	ch := make(chan []Info)
+	enqueueAdminMessage(&listMessage{response: ch})
+	return <-ch
+}
+
+type listMessage struct {
+	response	chan []Info
+}
+
+func (r *listMessage) handle() {
+	infos := []Info{}
+	for id, c := range data.clients {
+		state, voltage := getStatus(id)
+		peak, clips, volumeCap := getLevel(id)
+		volume, paused := getMix(id)
+		requests, lastSeen := getActivity(id)
+		// A lane's worker may be failing the client over.
+		c.mu.Lock()
+		infos = append(infos, Info{
+			ID:		id,
+			Name:		c.name,
+			Zone:		c.zone,
+			Address:	c.netLocation.String(),
+			Location:	c.physLocation,
+			State:		state,
+			Voltage:	voltage,
+			Queued:		QueueDepth(id),
+			Firmware:	c.capabilities.Firmware,
+			Profile:	c.profileName(),
+			LastSeen:	lastSeen,
+			Requests:	requests,
+			Failures:	getFailures(id),
+			Volume:		volume,
+			Paused:		paused,
+			PeakLevel:	peak,
+			Clips:		clips,
+			VolumeCap:	volumeCap,
+		})
+		c.mu.Unlock()
+	}
+	r.response <- infos
+}
+
+// Locations returns the physical locations of the given clients. Clients
+// that aren't known are left out.
+func Locations(ids []types.ID) map[types.ID]types.PhysLocation {
+	wanted := make(map[types.ID]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	locs := make(map[types.ID]types.PhysLocation)
+	for _, info := range List() {
+		if wanted[info.ID] {
+			locs[info.ID] = info.Location
+		}
+	}
+	return locs
+}
+
+// Locate blinks a client's light a few times, so an operator can find
+// it. It returns false if there's no such client.
+func Locate(id types.ID) bool {
+	for _, info := range List() {
+		if info.ID == id {
+			b := &Blink{Speed: 4, Delay: 200 * time.Millisecond, Reps: 5}
+			action(id, context.Background(), b, time.Now())
+			return true
+		}
+	}
+	return false
+}
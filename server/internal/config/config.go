@@ -3,26 +3,173 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
+	"github.com/blakej11/cricket/internal/beat"
         "github.com/blakej11/cricket/internal/client"
         "github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/estop"
         "github.com/blakej11/cricket/internal/fileset"
+	"github.com/blakej11/cricket/internal/intensity"
         "github.com/blakej11/cricket/internal/lease"
 	_ "github.com/blakej11/cricket/internal/light"
+        "github.com/blakej11/cricket/internal/log"
 	"github.com/blakej11/cricket/internal/mdns"
+	"github.com/blakej11/cricket/internal/occupancy"
+	"github.com/blakej11/cricket/internal/relay"
         "github.com/blakej11/cricket/internal/player"
+	"github.com/blakej11/cricket/internal/session"
 	_ "github.com/blakej11/cricket/internal/sound"
+	"github.com/blakej11/cricket/internal/startle"
         "github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/weather"
+        "github.com/blakej11/cricket/pkg/random"
 )
 
 // Config holds the configuration for the server.
 type Config struct {
 	DefaultVolume	int
 	Clients		map[types.ID]types.Client
+	LocationsFile	string	// CSV or JSON file of client locations
+
+	// The fleet namespace whose clients this server claims, so that
+	// installations sharing a network don't claim each other's; see
+	// types.Capabilities. Empty means clients that advertise none.
+	Namespace	string
+
+	// A fleet bundle (see FleetBundle) to take clients, aliases, and
+	// zone budgets from. Anything set here as well wins.
+	FleetFile	string
+
 	Files		map[string]fileset.File
 	FileSets	map[string]fileset.Config
 	Effects		map[string]effect.Config
 	Players		map[lease.Type]player.Config
+
+	// How to choose clients for each lease type; see lease.SetStrategy.
+	Allocation	map[lease.Type]string
+
+	// Loudness budgets for zones of clients; see types.Client.Zone.
+	ZoneBudgets	map[string]client.Budget
+
+	// The effect run by the operator panel's finale button; optional.
+	Finale		string
+
+	// How the crickets react to being startled; optional.
+	Startle		*startle.Config
+
+	// Levels of the installation's intensity through the day; see the
+	// intensity package. Optional; operators can also set it.
+	IntensitySchedule	[]intensity.Point
+
+	// A beat grid for effects to quantize to; optional. See the beat
+	// package.
+	BeatGrid	*beat.Grid
+
+	// A button or key on the server's host that silences everything;
+	// optional. See the estop package.
+	EmergencyStop	*estop.Config
+
+	// GPIO output lines on the server's host, e.g. for relays driving
+	// a fog machine; optional. See the relay package.
+	Outputs		[]relay.Config
+
+	// Where to get real weather conditions for effects to follow;
+	// optional. See the weather package.
+	Weather		*weather.Config
+
+	// How to read a visitor count, e.g. from a door counter's
+	// webhook; optional. See the occupancy package.
+	Occupancy	*occupancy.Config
+
+	// How to send particular commands (e.g. "blink") to devices;
+	// see client.SetTransport.
+	Transports	map[string]string
+
+	// Profiles of the models of device in a mixed fleet, by name;
+	// optional. See client.Profile.
+	Profiles	map[string]client.Profile
+
+	// How the devices use their batteries, to keep them going until
+	// closing time; optional. See client.EnergyModel.
+	Energy		*client.EnergyModel
+
+	// When to put the devices to sleep overnight, and wake them up;
+	// optional. See client.SleepSchedule.
+	Sleep		*client.SleepSchedule
+
+	// Limits on the requests waiting for each client; optional.
+	ClientQueue	client.QueueLimit
+
+	// Where to save operators' feedback on effects (see
+	// player.Player.Vote), so it lasts through a restart during the
+	// session. Optional.
+	FeedbackFile	string
+
+	// Where to write a summary of each session (see the session
+	// package); if empty, summaries are only logged.
+	SessionDir	string
+
+	// Seeds the show's randomness; see random.SetSeed. If empty, the
+	// date is used, so each night differs, even if the server is left
+	// running from one night to the next. Each night's seed is logged,
+	// and recorded in its session summary, so that a memorable night can
+	// be roughly recreated by setting this to its seed.
+	ShowSeed	string
+
+	// Where to keep operators' scenes (see Scene), so they last through
+	// restarts. If empty, scenes last only until the server exits.
+	ScenesFile	string
+
+	// Where to keep operators' records of the devices' hardware (see
+	// Record), e.g. serial numbers and when batteries were changed.
+	// If empty, records last only until the server exits.
+	InventoryFile	string
+
+	// Replacement hardware: each key is the ID of a client standing in
+	// for the configured client whose ID is its value, and which it
+	// takes the name, zone, and location of. See client.Alias.
+	Aliases		map[types.ID]types.ID
+
+	// The places the installation travels to, by name. When one is
+	// selected, its settings replace the ones above; see Venue.
+	Venues		map[string]Venue
+}
+
+// Venue holds the settings that differ between the places the
+// installation is set up: which clients there are and where, and which
+// files are on them. Empty settings are left as they are in Config.
+type Venue struct {
+	// If non-nil, these replace Config.Clients.
+	Clients		map[types.ID]types.Client
+
+	// If set, these replace Config.LocationsFile and Config.FleetFile.
+	LocationsFile	string
+	FleetFile	string
+
+	// These are added to Config.Files, replacing any of the same name.
+	Files		map[string]fileset.File
+}
+
+// apply replaces the config's settings with the venue's.
+func (v Venue) apply(c *Config) {
+	if v.Clients != nil {
+		c.Clients = v.Clients
+	}
+	if v.LocationsFile != "" {
+		c.LocationsFile = v.LocationsFile
+	}
+	if v.FleetFile != "" {
+		c.FleetFile = v.FleetFile
+	}
+	if len(v.Files) > 0 && c.Files == nil {
+		c.Files = make(map[string]fileset.File)
+	}
+	for name, f := range v.Files {
+		c.Files[name] = f
+	}
 }
 
 // ---------------------------------------------------------------------
@@ -30,8 +177,29 @@ type Config struct {
 // ConfigImpl is the runtime version of Config.
 type ConfigImpl struct {
 	defaultVolume	int
+	namespace	string
 	clients		map[types.ID]types.Client
+	aliases		map[types.ID]types.ID
+	zoneBudgets	map[string]client.Budget
+	files		map[string]fileset.File
+	fileSets	map[string]*fileset.Set
+	effects		map[string]effect.Config
 	players		map[lease.Type]*player.Player
+	startle		*startle.Config
+	emergencyStop	*estop.Config
+	outputs		[]relay.Config
+	weather		*weather.Config
+	occupancy	*occupancy.Config
+	intensitySchedule	[]intensity.Point
+	beatGrid	*beat.Grid
+	energy		*client.EnergyModel
+	sleep		*client.SleepSchedule
+	finale		string
+	feedbackFile	string
+	sessionDir	string
+	scenes		*sceneBook
+	inventory	*inventory
+	showSeed	string
 }
 
 // If a parse error is encountered, show this many characters
@@ -39,6 +207,12 @@ type ConfigImpl struct {
 const jsonErrorDelta = 20
 
 func ParseJSON(jsonBlob []byte) (*ConfigImpl, error) {
+	return ParseJSONForVenue(jsonBlob, "")
+}
+
+// ParseJSONForVenue is like ParseJSON, but uses the settings of the
+// named venue. If venue is empty, the top-level settings are used.
+func ParseJSONForVenue(jsonBlob []byte, venue string) (*ConfigImpl, error) {
 	var config Config
 	if err := json.Unmarshal(jsonBlob, &config); err != nil {
 		if jsonErr, ok := err.(*json.SyntaxError); ok {
@@ -53,13 +227,140 @@ func ParseJSON(jsonBlob []byte) (*ConfigImpl, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	fileSets := make(map[string]*fileset.Set)
-	for name, fs := range config.FileSets {
-		set, err := fileset.New(name, fs, config.Files)
+	if venue != "" {
+		v, ok := config.Venues[venue]
+		if !ok {
+			return nil, fmt.Errorf("failed to find venue %q (have [ %s ])",
+			    venue, strings.Join(slices.Sorted(maps.Keys(config.Venues)), ","))
+		}
+		v.apply(&config)
+		log.Infof("using venue %q", venue)
+	} else if len(config.Venues) > 0 {
+		log.Infof("no venue selected; using the top-level clients and files")
+	}
+
+	if strings.ContainsAny(config.Namespace, " =") {
+		return nil, fmt.Errorf("namespace %q can't contain spaces or '='", config.Namespace)
+	}
+
+	if config.DefaultVolume < 0 || config.DefaultVolume > types.MaxVolume {
+		return nil, fmt.Errorf("default volume %d must be between 0 and %d inclusive",
+		    config.DefaultVolume, types.MaxVolume)
+	}
+
+	if config.FleetFile != "" {
+		fleet, err := loadFleet(config.FleetFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse fileset %q: %w", name, err)
+			return nil, fmt.Errorf("failed to load fleet: %w", err)
+		}
+		mergeFleet(&config, fleet)
+	}
+
+	if config.LocationsFile != "" {
+		fromFile, err := loadLocations(config.LocationsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load locations: %w", err)
+		}
+		config.Clients = mergeLocations(config.Clients, fromFile)
+	}
+
+	for newID, oldID := range config.Aliases {
+		if _, ok := config.Clients[oldID]; !ok {
+			return nil, fmt.Errorf("client %q is an alias for %q, which isn't configured", newID, oldID)
+		}
+	}
+
+	if _, ok := config.Effects[config.Finale]; config.Finale != "" && !ok {
+		return nil, fmt.Errorf("failed to find finale effect %q", config.Finale)
+	}
+
+	if config.Startle != nil {
+		if err := config.Startle.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := intensity.CheckSchedule(config.IntensitySchedule); err != nil {
+		return nil, err
+	}
+
+	if config.BeatGrid != nil {
+		if err := config.BeatGrid.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range config.Outputs {
+		if err := o.Check(); err != nil {
+			return nil, err
+		}
+		if _, ok := config.Effects[o.Effect]; o.Effect != "" && !ok {
+			return nil, fmt.Errorf("output %q follows unknown effect %q", o.GPIO, o.Effect)
+		}
+	}
+
+	if config.Weather != nil {
+		if err := config.Weather.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Occupancy != nil {
+		if err := config.Occupancy.Check(); err != nil {
+			return nil, err
 		}
-		fileSets[name] = set
+	}
+
+	if config.EmergencyStop != nil {
+		if err := config.EmergencyStop.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	for zone, b := range config.ZoneBudgets {
+		if err := client.SetBudget(zone, b); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Energy != nil {
+		if err := config.Energy.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Sleep != nil {
+		if err := config.Sleep.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.SetQueueLimit(config.ClientQueue); err != nil {
+		return nil, err
+	}
+
+	for command, name := range config.Transports {
+		if err := client.SetTransport(command, name); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, p := range config.Profiles {
+		if err := p.Check(); err != nil {
+			return nil, fmt.Errorf("device profile %q: %w", name, err)
+		}
+	}
+	client.SetProfiles(config.Profiles)
+
+	for ty, name := range config.Allocation {
+		if err := lease.SetStrategy(ty, name); err != nil {
+			return nil, err
+		}
+	}
+
+	fileSets, err := fileset.NewAll(config.FileSets, config.Files)
+	if err != nil {
+		return nil, err
 	}
 	effects := make(map[lease.Type]map[string]*effect.Effect)
 	for _, t := range lease.ValidTypes() {
@@ -72,6 +373,8 @@ func ParseJSON(jsonBlob []byte) (*ConfigImpl, error) {
 		}
 		effects[e.Lease.Type][name] = effect
 	}
+	reportUnused(config, fileSets)
+
 	players := make(map[lease.Type]*player.Player)
 	for _, t := range lease.ValidTypes() {
 		player, err := player.New(t, config.Players[t], effects[t])
@@ -83,15 +386,198 @@ func ParseJSON(jsonBlob []byte) (*ConfigImpl, error) {
 
 	return &ConfigImpl{
 		defaultVolume:	config.DefaultVolume,
+		namespace:	config.Namespace,
 		clients:	config.Clients,
+		aliases:	config.Aliases,
+		zoneBudgets:	config.ZoneBudgets,
+		files:		config.Files,
+		fileSets:	fileSets,
+		effects:	config.Effects,
 		players:	players,
+		startle:	config.Startle,
+		emergencyStop:	config.EmergencyStop,
+		outputs:	config.Outputs,
+		weather:	config.Weather,
+		occupancy:	config.Occupancy,
+		intensitySchedule:	config.IntensitySchedule,
+		beatGrid:	config.BeatGrid,
+		energy:		config.Energy,
+		sleep:		config.Sleep,
+		finale:		config.Finale,
+		feedbackFile:	config.FeedbackFile,
+		sessionDir:	config.SessionDir,
+		showSeed:	config.ShowSeed,
+		scenes:		&sceneBook{
+			file:	config.ScenesFile,
+			scenes:	make(map[string]Scene),
+		},
+		inventory:	&inventory{
+			file:		config.InventoryFile,
+			records:	make(map[types.ID]Record),
+		},
 	}, nil
 }
 
+// reportUnused warns about filesets that no effect uses, and about files
+// that aren't in any fileset.
+func reportUnused(config Config, fileSets map[string]*fileset.Set) {
+	usedSets := make(map[string]bool)
+	for _, e := range config.Effects {
+		for _, n := range e.FileSets {
+			usedSets[n] = true
+		}
+	}
+	// Sets used by weighted sets are used too.
+	for changed := true; changed; {
+		changed = false
+		for name, fs := range config.FileSets {
+			if !usedSets[name] {
+				continue
+			}
+			for n := range fs.Weights {
+				if !usedSets[n] {
+					usedSets[n] = true
+					changed = true
+				}
+			}
+		}
+	}
+	usedFiles := make(map[string]bool)
+	for name, set := range fileSets {
+		if !usedSets[name] {
+			log.Warningf("fileset %q is not used by any effect", name)
+			continue
+		}
+		for _, n := range set.Names() {
+			usedFiles[n] = true
+		}
+	}
+	for name := range config.Files {
+		if !usedFiles[name] {
+			log.Warningf("file %q is not in any fileset used by an effect", name)
+		}
+	}
+}
+
+// Clients returns the configured clients.
+func (c *ConfigImpl) Clients() map[types.ID]types.Client {
+	return c.clients
+}
+
+// Players returns the player for each lease type.
+func (c *ConfigImpl) Players() map[lease.Type]*player.Player {
+	return c.players
+}
+
+// Files returns the files described by the configuration.
+func (c *ConfigImpl) Files() map[string]fileset.File {
+	return c.files
+}
+
+// Namespace returns the fleet namespace whose clients this server claims.
+func (c *ConfigImpl) Namespace() string {
+	return c.namespace
+}
+
+// Finale returns the name of the finale effect, if there is one.
+func (c *ConfigImpl) Finale() string {
+	return c.finale
+}
+
+// EffectConfig returns the configuration of the named effect.
+func (c *ConfigImpl) EffectConfig(name string) (effect.Config, bool) {
+	e, ok := c.effects[name]
+	return e, ok
+}
+
+// NewEffect creates a fresh instance of the named effect, with any
+// parameters in overrides replacing the configured ones.
+func (c *ConfigImpl) NewEffect(name string, overrides map[string]random.Config) (*effect.Effect, error) {
+	e, ok := c.effects[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to find effect %q", name)
+	}
+	params := make(map[string]random.Config)
+	for n, p := range e.Parameters {
+		params[n] = p
+	}
+	for n, p := range overrides {
+		params[n] = p
+	}
+	e.Parameters = params
+	return effect.New(name, e, c.fileSets)
+}
+
 func (c *ConfigImpl) Run() { 
+	c.ConfigureClients()
+
+	mdns.Start(c.namespace)
+	c.start()
+}
+
+// RunWithoutDiscovery is like Run, but doesn't look for clients via mDNS;
+// they must be added with client.Add, after this so that their
+// configuration applies. This is for virtual fleets.
+func (c *ConfigImpl) RunWithoutDiscovery() {
+	c.ConfigureClients()
+	c.start()
+}
+
+// ConfigureClients sets up the configured clients' names, locations, and
+// so on, without starting anything. Run does this; tools that run
+// effects by hand call it before adding clients.
+func (c *ConfigImpl) ConfigureClients() {
 	client.Configure(c.defaultVolume, c.clients)
+	for newID, oldID := range c.aliases {
+		if err := client.Alias(newID, oldID); err != nil {
+			log.Errorf("failed to set up alias: %v", err)
+		}
+	}
+}
+
+// SetShowSeed replaces the configured show seed; see Config.ShowSeed.
+func (c *ConfigImpl) SetShowSeed(seed string) {
+	c.showSeed = seed
+}
 
-	mdns.Start()
+func (c *ConfigImpl) start() {
+	if c.showSeed == "" {
+		random.SeedByDate()
+	} else {
+		random.SetSeed(c.showSeed)
+	}
+	log.Infof("show seed is %q", random.Seed())
+
+	session.SetDir(c.sessionDir)
+	c.loadFeedback()
+	c.loadScenes()
+	c.loadInventory()
+	if c.startle != nil {
+		startle.Start(*c.startle)
+	}
+	intensity.StartSchedule(c.intensitySchedule)
+	if c.beatGrid != nil {
+		beat.Set(*c.beatGrid)
+	}
+	relay.Start(c.outputs)
+	if c.weather != nil {
+		weather.Start(*c.weather)
+	}
+	if c.occupancy != nil {
+		occupancy.Configure(*c.occupancy)
+	}
+	if c.energy != nil {
+		client.StartEnergyBudget(*c.energy)
+	}
+	if c.sleep != nil {
+		client.StartSleepSchedule(*c.sleep)
+	}
+	// Even without a button or key, the admin API can stop everything.
+	stop := estop.Config{}
+	if c.emergencyStop != nil {
+		stop = *c.emergencyStop
+	}
+	estop.Start(stop, slices.Collect(maps.Values(c.players)))
 	for _, p := range c.players {
 		p.Start()
 	}
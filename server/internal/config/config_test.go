@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+func FuzzParseJSON(f *testing.F) {
+	f.Add([]byte(`{}`))
+	f.Add([]byte(``))
+	f.Add([]byte(`{"Namespace": "a b"}`))
+	f.Add([]byte(`{"DefaultVolume": 99}`))
+	f.Add([]byte(`{"Venues": {"hall": {}}}`))
+	f.Add([]byte(`{
+		"Files": {"chirp1": {"Folder": 1, "File": 1, "Duration": 0.5}},
+		"FileSets": {"chirps": {"Regex": "chirp"}},
+		"Effects": {
+			"long": {
+				"Algorithm": "loop",
+				"FileSets": {"main": "chirps"},
+				"Parameters": {"fileReps": {"Mean": 1}, "fileDelay": {"Mean": 0.1}, "groupDelay": {"Mean": 0.2}},
+				"Duration": {"Mean": 60},
+				"Lease": {"Type": "sound", "MinClients": 1, "FleetFraction": {"Mean": 1}}
+			}
+		}
+	}`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		c, err := ParseJSON(b)
+		if err == nil && c == nil {
+			t.Errorf("ParseJSON(%q) returned neither a config nor an error", b)
+		}
+	})
+}
+
+func TestKindProperties(t *testing.T) {
+	tests := []struct {
+		kind		random.Kind
+		min, max	any
+	}{
+		{random.AnyKind, nil, nil},
+		{random.DurationKind, 0.0, nil},
+		{random.CountKind, 0.0, nil},
+		{random.VolumeKind, 0.0, float64(random.MaxVolume)},
+		{random.FractionKind, 0.0, 1.0},
+	}
+	for _, tt := range tests {
+		mean := kindProperties(map[string]any{}, tt.kind)["Mean"].(map[string]any)
+		if mean["minimum"] != tt.min || mean["maximum"] != tt.max {
+			t.Errorf("%v: minimum %v, maximum %v, want %v, %v",
+			    tt.kind, mean["minimum"], mean["maximum"], tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/player"
+)
+
+// Saved feedback older than this is from an earlier session, e.g. the
+// previous evening, and is ignored.
+const feedbackSessionGap = 12 * time.Hour
+
+// savedFeedback is the contents of the feedback file.
+type savedFeedback struct {
+	Saved	time.Time
+	Players	map[lease.Type]map[string]player.Feedback
+}
+
+// loadFeedback restores the players' feedback from the feedback file, if
+// it was saved during this session.
+func (c *ConfigImpl) loadFeedback() {
+	if c.feedbackFile == "" {
+		return
+	}
+	b, err := os.ReadFile(c.feedbackFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var saved savedFeedback
+	if err == nil {
+		err = json.Unmarshal(b, &saved)
+	}
+	if err != nil {
+		log.Warningf("ignoring feedback file %q: %v", c.feedbackFile, err)
+		return
+	}
+	if time.Since(saved.Saved) > feedbackSessionGap {
+		log.Infof("ignoring feedback from an earlier session, saved %v", saved.Saved)
+		return
+	}
+	for ty, p := range c.players {
+		p.SetFeedback(saved.Players[ty])
+	}
+	log.Infof("restored operator feedback saved %v", saved.Saved)
+}
+
+// SaveFeedback writes the players' feedback to the feedback file, so it
+// survives a restart later in the session.
+func (c *ConfigImpl) SaveFeedback() error {
+	if c.feedbackFile == "" {
+		return nil
+	}
+	saved := savedFeedback{
+		Saved:		time.Now(),
+		Players:	make(map[lease.Type]map[string]player.Feedback),
+	}
+	for ty, p := range c.players {
+		saved.Players[ty] = p.Feedback()
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomically(c.feedbackFile, b); err != nil {
+		return fmt.Errorf("failed to save feedback: %w", err)
+	}
+	return nil
+}
+
+// writeAtomically writes a file and renames it into place, so a crash
+// can't leave half a file.
+func writeAtomically(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// The version of the fleet bundle format written by Fleet. Bundles with
+// a newer version can't be read.
+const fleetBundleVersion = 1
+
+// FleetBundle describes the fleet independently of the effects it runs:
+// which clients there are, where they are, what zones they're in, and
+// which clients replaced which. It can be exported from one config and
+// imported into another with Config.FleetFile, to share or back up a
+// venue's setup.
+type FleetBundle struct {
+	Version		int
+	Exported	time.Time
+	Clients		map[types.ID]types.Client
+	Aliases		map[types.ID]types.ID		`json:",omitempty"`
+	ZoneBudgets	map[string]client.Budget	`json:",omitempty"`
+}
+
+// Fleet returns the fleet described by the config, including any aliases
+// that operators have added since the server started.
+func (c *ConfigImpl) Fleet() FleetBundle {
+	aliases := maps.Clone(c.aliases)
+	if aliases == nil {
+		aliases = make(map[types.ID]types.ID)
+	}
+	maps.Copy(aliases, client.Aliases())
+	return FleetBundle{
+		Version:	fleetBundleVersion,
+		Exported:	time.Now(),
+		Clients:	c.clients,
+		Aliases:	aliases,
+		ZoneBudgets:	c.zoneBudgets,
+	}
+}
+
+// loadFleet reads a fleet bundle written by Fleet.
+func loadFleet(path string) (FleetBundle, error) {
+	var b FleetBundle
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(blob, &b); err != nil {
+		return b, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	if b.Version < 1 || b.Version > fleetBundleVersion {
+		return b, fmt.Errorf("%q is fleet bundle version %d, but only versions up to %d are supported",
+		    path, b.Version, fleetBundleVersion)
+	}
+	return b, nil
+}
+
+// mergeFleet adds a fleet bundle's contents to the config. As with a
+// locations file, anything set explicitly in the config wins.
+func mergeFleet(config *Config, b FleetBundle) {
+	config.Clients = mergeLocations(config.Clients, b.Clients)
+	config.Aliases = mergeMap(b.Aliases, config.Aliases)
+	config.ZoneBudgets = mergeMap(b.ZoneBudgets, config.ZoneBudgets)
+}
+
+// mergeMap returns a map with the contents of both maps, preferring
+// over's where they have the same key.
+func mergeMap[K comparable, V any](under, over map[K]V) map[K]V {
+	if len(under) == 0 {
+		return over
+	}
+	merged := maps.Clone(under)
+	maps.Copy(merged, over)
+	return merged
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Record is what operators know about a device's hardware, beyond what
+// the device itself reports: e.g. "speaker buzzes at volume over 40".
+// Records are keyed by device ID, so a board's record stays with the
+// board when it stands in for another one (see client.Alias).
+type Record struct {
+	Serial			string	`json:",omitempty"`
+	BatteryInstalled	string	`json:",omitempty"`	// e.g. "2024-06-01"
+	Notes			string	`json:",omitempty"`
+	Updated			time.Time
+}
+
+// inventory holds the devices' records.
+type inventory struct {
+	mu	sync.Mutex
+	file	string
+	records	map[types.ID]Record
+}
+
+// Check returns an error if the record is invalid.
+func (r Record) Check() error {
+	if r.BatteryInstalled != "" {
+		if _, err := time.Parse(time.DateOnly, r.BatteryInstalled); err != nil {
+			return fmt.Errorf("battery install date %q: %w", r.BatteryInstalled, err)
+		}
+	}
+	return nil
+}
+
+// empty returns whether the record says nothing.
+func (r Record) empty() bool {
+	return r.Serial == "" && r.BatteryInstalled == "" && r.Notes == ""
+}
+
+// loadInventory reads the records saved in the inventory file, if any.
+func (c *ConfigImpl) loadInventory() {
+	inv := c.inventory
+	if inv.file == "" {
+		return
+	}
+	blob, err := os.ReadFile(inv.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var records map[types.ID]Record
+	if err == nil {
+		err = json.Unmarshal(blob, &records)
+	}
+	if err != nil {
+		log.Warningf("ignoring inventory file %q: %v", inv.file, err)
+		return
+	}
+	if records == nil {
+		records = make(map[types.ID]Record)
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.records = records
+	log.Infof("loaded inventory records for %d devices", len(records))
+}
+
+// Inventory returns each device's record.
+func (c *ConfigImpl) Inventory() map[types.ID]Record {
+	inv := c.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return maps.Clone(inv.records)
+}
+
+// SetRecord replaces a device's record, or forgets it if the new one is
+// empty, and saves the records to the inventory file, if there is one.
+func (c *ConfigImpl) SetRecord(id types.ID, r Record) (Record, error) {
+	if err := r.Check(); err != nil {
+		return Record{}, err
+	}
+	r.Updated = time.Now()
+
+	inv := c.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if r.empty() {
+		delete(inv.records, id)
+	} else {
+		inv.records[id] = r
+	}
+	if inv.file == "" {
+		return r, nil
+	}
+	blob, err := json.MarshalIndent(inv.records, "", "  ")
+	if err == nil {
+		err = writeAtomically(inv.file, blob)
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to save inventory: %w", err)
+	}
+	return r, nil
+}
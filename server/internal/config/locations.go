@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// floorplan is the JSON form of a locations file.
+type floorplan struct {
+	Clients []struct {
+		ID		types.ID
+		Name		string
+		X, Y		float64
+		SpeakerRadius	float64
+		Zone		string
+	}
+}
+
+// loadLocations reads client locations from a file, which is either JSON
+// (if its name ends in ".json") or CSV. A CSV file has a header row naming
+// its columns; "id", "x", and "y" are required, and "name", "radius",
+// and "zone" are optional.
+func loadLocations(path string) (map[types.ID]types.Client, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var fp floorplan
+		if err := json.NewDecoder(f).Decode(&fp); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+		}
+		clients := make(map[types.ID]types.Client)
+		for _, c := range fp.Clients {
+			clients[c.ID] = types.Client{
+				Name:		c.Name,
+				PhysLocation:	types.PhysLocation{X: c.X, Y: c.Y},
+				SpeakerRadius:	c.SpeakerRadius,
+				Zone:		c.Zone,
+			}
+		}
+		return clients, nil
+	}
+	return parseLocationsCSV(path, f)
+}
+
+func parseLocationsCSV(path string, r io.Reader) (map[types.ID]types.Client, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%q is empty", path)
+	}
+	cols := make(map[string]int)
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"id", "x", "y"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("%q has no %q column", path, name)
+		}
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	number := func(rec []string, line int, name string) (float64, error) {
+		s := field(rec, name)
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q line %d: bad %s %q: %w", path, line, name, s, err)
+		}
+		return v, nil
+	}
+
+	clients := make(map[types.ID]types.Client)
+	for i, rec := range records[1:] {
+		line := i + 2
+		id := types.ID(field(rec, "id"))
+		if id == "" {
+			return nil, fmt.Errorf("%q line %d: missing id", path, line)
+		}
+		c := types.Client{Name: field(rec, "name"), Zone: field(rec, "zone")}
+		if c.X, err = number(rec, line, "x"); err != nil {
+			return nil, err
+		}
+		if c.Y, err = number(rec, line, "y"); err != nil {
+			return nil, err
+		}
+		if c.SpeakerRadius, err = number(rec, line, "radius"); err != nil {
+			return nil, err
+		}
+		clients[id] = c
+	}
+	return clients, nil
+}
+
+// mergeLocations adds the information from a locations file to the
+// configured clients. Anything set explicitly in the config wins.
+func mergeLocations(clients, fromFile map[types.ID]types.Client) map[types.ID]types.Client {
+	merged := make(map[types.ID]types.Client)
+	for id, c := range fromFile {
+		merged[id] = c
+	}
+	for id, c := range clients {
+		f, ok := merged[id]
+		if !ok {
+			merged[id] = c
+			continue
+		}
+		if c.Name != "" {
+			f.Name = c.Name
+		}
+		if c.PhysLocation != (types.PhysLocation{}) {
+			if f.PhysLocation != c.PhysLocation {
+				log.Infof("client %q: config location %v overrides %v from locations file",
+				    id, c.PhysLocation, f.PhysLocation)
+			}
+			f.PhysLocation = c.PhysLocation
+		}
+		if c.SpeakerRadius != 0 {
+			f.SpeakerRadius = c.SpeakerRadius
+		}
+		if c.Zone != "" {
+			f.Zone = c.Zone
+		}
+		merged[id] = f
+	}
+	return merged
+}
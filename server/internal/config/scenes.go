@@ -0,0 +1,253 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Scene is a snapshot of what the installation is doing, which operators
+// can capture and later recall, as on a lighting desk.
+type Scene struct {
+	Name		string
+	Captured	time.Time
+
+	// Each player's effect weights; see player.Player.SetWeights.
+	Weights		map[lease.Type]map[string]float64
+
+	// Each client's volume, and which clients are paused.
+	Volumes		map[types.ID]int
+	Paused		[]types.ID
+
+	// The effects that were running. Recalling the scene starts any
+	// of them that aren't running then.
+	Effects		[]string
+}
+
+// How often weights are moved during a transition between scenes.
+const sceneStep = time.Second
+
+// sceneBook holds the captured scenes.
+type sceneBook struct {
+	mu	sync.Mutex
+	file	string
+	scenes	map[string]Scene
+	seq	uint64	// of the latest recall, to end older transitions
+}
+
+// loadScenes reads the scenes saved in the scenes file, if any.
+func (c *ConfigImpl) loadScenes() {
+	b := c.scenes
+	if b.file == "" {
+		return
+	}
+	blob, err := os.ReadFile(b.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var scenes map[string]Scene
+	if err == nil {
+		err = json.Unmarshal(blob, &scenes)
+	}
+	if err != nil {
+		log.Warningf("ignoring scenes file %q: %v", b.file, err)
+		return
+	}
+	if scenes == nil {
+		scenes = make(map[string]Scene)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scenes = scenes
+	log.Infof("loaded %d scenes", len(scenes))
+}
+
+// saveLocked writes the scenes to the scenes file, if there is one.
+// The caller must hold b.mu.
+func (b *sceneBook) saveLocked() error {
+	if b.file == "" {
+		return nil
+	}
+	blob, err := json.MarshalIndent(b.scenes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomically(b.file, blob); err != nil {
+		return fmt.Errorf("failed to save scenes: %w", err)
+	}
+	return nil
+}
+
+// Scenes returns the captured scenes, by name.
+func (c *ConfigImpl) Scenes() []Scene {
+	b := c.scenes
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	scenes := []Scene{}
+	for _, name := range slices.Sorted(maps.Keys(b.scenes)) {
+		scenes = append(scenes, b.scenes[name])
+	}
+	return scenes
+}
+
+// CaptureScene saves what the installation is doing now as a scene with
+// the given name, replacing any scene of that name.
+func (c *ConfigImpl) CaptureScene(name string) (Scene, error) {
+	if name == "" {
+		return Scene{}, fmt.Errorf("a scene needs a name")
+	}
+	s := Scene{
+		Name:		name,
+		Captured:	time.Now(),
+		Weights:	make(map[lease.Type]map[string]float64),
+		Volumes:	make(map[types.ID]int),
+		Paused:		[]types.ID{},
+		Effects:	[]string{},
+	}
+	for ty, p := range c.players {
+		s.Weights[ty] = p.Weights()
+	}
+	for _, info := range client.List() {
+		s.Volumes[info.ID] = info.Volume
+		if info.Paused {
+			s.Paused = append(s.Paused, info.ID)
+		}
+	}
+	slices.Sort(s.Paused)
+	s.Effects = c.runningEffects()
+
+	b := c.scenes
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scenes[name] = s
+	return s, b.saveLocked()
+}
+
+// DeleteScene forgets the named scene.
+func (c *ConfigImpl) DeleteScene(name string) error {
+	b := c.scenes
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.scenes[name]; !ok {
+		return fmt.Errorf("no scene named %q", name)
+	}
+	delete(b.scenes, name)
+	return b.saveLocked()
+}
+
+// RecallScene puts the installation back the way it was when the named
+// scene was captured. Volumes ramp and weights move to the scene's over
+// the given time; pausing and unpausing happen at once, as does starting
+// the scene's effects. Clients that weren't around for the capture are
+// left as they are. A recall that's still moving takes over from an
+// earlier one.
+func (c *ConfigImpl) RecallScene(name string, over time.Duration) error {
+	b := c.scenes
+	b.mu.Lock()
+	s, ok := b.scenes[name]
+	b.seq++
+	seq := b.seq
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no scene named %q", name)
+	}
+	log.Infof("recalling scene %q over %v", name, over)
+
+	paused := make(map[types.ID]bool)
+	for _, id := range s.Paused {
+		paused[id] = true
+	}
+	now := time.Now()
+	for _, info := range client.List() {
+		volume, ok := s.Volumes[info.ID]
+		if !ok {
+			continue
+		}
+		ids := []types.ID{info.ID}
+		client.Action(ids, context.Background(), &client.RampVolume{Volume: volume, Over: over}, now)
+		switch {
+		case paused[info.ID] && !info.Paused:
+			client.Action(ids, context.Background(), &client.Pause{}, now)
+		case !paused[info.ID] && info.Paused:
+			client.Action(ids, context.Background(), &client.Unpause{}, now)
+		}
+	}
+
+	running := make(map[string]bool)
+	for _, name := range c.runningEffects() {
+		running[name] = true
+	}
+	for _, name := range s.Effects {
+		if running[name] {
+			continue
+		}
+		e, err := c.NewEffect(name, nil)
+		if err != nil {
+			log.Warningf("scene %q: %v", s.Name, err)
+			continue
+		}
+		go func() {
+			if err := e.Run(); err != nil {
+				log.Infof("scene %q couldn't start effect %q: %v", s.Name, name, err)
+			}
+		}()
+	}
+
+	go c.moveWeights(seq, s.Weights, over)
+	return nil
+}
+
+// moveWeights moves the players' weights to the given ones in even steps
+// over the given time, unless a later recall starts.
+func (c *ConfigImpl) moveWeights(seq uint64, to map[lease.Type]map[string]float64, over time.Duration) {
+	from := make(map[lease.Type]map[string]float64)
+	for ty, p := range c.players {
+		from[ty] = p.Weights()
+	}
+	steps := max(int(over / sceneStep), 1)
+	for i := 1; i <= steps; i++ {
+		time.Sleep(over / time.Duration(steps))
+		c.scenes.mu.Lock()
+		current := c.scenes.seq == seq
+		c.scenes.mu.Unlock()
+		if !current {
+			return
+		}
+		frac := float64(i) / float64(steps)
+		for ty, p := range c.players {
+			weights := make(map[string]float64)
+			for name, w := range to[ty] {
+				f := from[ty][name]
+				weights[name] = f + (w - f) * frac
+			}
+			p.SetWeights(weights)
+		}
+	}
+}
+
+// runningEffects returns the names of the configured effects that hold
+// clients now.
+func (c *ConfigImpl) runningEffects() []string {
+	names := []string{}
+	for _, ty := range lease.ValidTypes() {
+		for _, hs := range lease.Stats(ty) {
+			if _, ok := c.effects[hs.Name]; ok && hs.Holding > 0 {
+				names = append(names, hs.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/lease"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Schema returns a JSON Schema describing the configuration file.
+// The per-effect parts of the schema are generated from the registered
+// algorithms, so each algorithm's parameters and filesets are spelled out.
+func Schema() ([]byte, error) {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "cricket server configuration"
+
+	props := s["properties"].(map[string]any)
+	props["DefaultVolume"] = map[string]any{
+		"type":		"integer",
+		"minimum":	0,
+		"maximum":	types.MaxVolume,
+	}
+
+	effects := props["Effects"].(map[string]any)
+	effects["additionalProperties"] = map[string]any{
+		"allOf": []any{
+			effects["additionalProperties"],
+			map[string]any{"oneOf": algorithmSchemas()},
+		},
+	}
+
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// algorithmSchemas returns one schema per registered algorithm, describing
+// the Algorithm, Lease.Type, Parameters, and FileSets an effect using that
+// algorithm must have.
+func algorithmSchemas() []any {
+	variable := typeSchema(reflect.TypeOf(random.Config{}))
+
+	schemas := []any{}
+	for _, a := range effect.Algorithms() {
+		reqs := a.Requirements
+		params := make(map[string]any)
+		optional := make(map[string]bool)
+		for _, p := range reqs.Parameters {
+			ps := copySchema(variable)
+			info := reqs.ParamInfo[p]
+			if d := info.Description; d != "" {
+				if info.Unit != "" {
+					d += " (" + info.Unit + ")"
+				}
+				ps["description"] = d
+			}
+			if info.Kind != random.AnyKind {
+				ps["properties"] = kindProperties(ps["properties"].(map[string]any), info.Kind)
+			}
+			if info.Default != nil {
+				ps["default"] = info.Default
+				optional[p] = true
+			}
+			params[p] = ps
+		}
+		fileSets := make(map[string]any)
+		for _, fs := range reqs.FileSets {
+			fss := map[string]any{"type": "string"}
+			if d := reqs.FileSetInfo[fs]; d != "" {
+				fss["description"] = d
+			}
+			fileSets[fs] = fss
+		}
+		paramSchema := objectSchema(params)
+		required := []string{}
+		for _, p := range paramSchema["required"].([]string) {
+			if !optional[p] {
+				required = append(required, p)
+			}
+		}
+		paramSchema["required"] = required
+
+		schemas = append(schemas, map[string]any{
+			"description": reqs.Description,
+			"required": []string{"Algorithm", "Lease"},
+			"properties": map[string]any{
+				"Algorithm": map[string]any{"const": a.Name},
+				"Lease": map[string]any{
+					"required": []string{"Type"},
+					"properties": map[string]any{
+						"Type": map[string]any{"const": a.Type.String()},
+					},
+				},
+				"Parameters": paramSchema,
+				"FileSets": objectSchema(fileSets),
+			},
+		})
+	}
+	return schemas
+}
+
+// kindProperties adds bounds on the mean and choices of a random value of
+// the given kind, the same ones that Kind.Check enforces.
+func kindProperties(props map[string]any, k random.Kind) map[string]any {
+	props = copySchema(props)
+	mean := map[string]any{"type": "number"}
+	lo, hi := k.Bounds()
+	if !math.IsInf(lo, 0) {
+		mean["minimum"] = lo
+	}
+	if !math.IsInf(hi, 0) {
+		mean["maximum"] = hi
+	}
+	props["Mean"] = mean
+	props["Variance"] = map[string]any{"type": "number", "minimum": 0}
+	props["Step"] = map[string]any{"type": "number", "minimum": 0}
+	props["Choices"] = map[string]any{"type": "array", "items": mean}
+	return props
+}
+
+func copySchema(s map[string]any) map[string]any {
+	c := make(map[string]any)
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// objectSchema describes an object that must have exactly these properties.
+func objectSchema(props map[string]any) map[string]any {
+	required := []string{}
+	for n := range props {
+		required = append(required, n)
+	}
+	sort.Strings(required)
+	return map[string]any{
+		"type":			"object",
+		"properties":		props,
+		"required":		required,
+		"additionalProperties":	false,
+	}
+}
+
+// enumTypes are types that are unmarshaled from a fixed set of strings.
+var enumTypes = map[reflect.Type][]string{
+	reflect.TypeOf(lease.Type(0)):		typeNames(),
+	reflect.TypeOf(random.Distribution(0)):	{"unknown", "normal", "uniform", "exponential"},
+}
+
+func typeNames() []string {
+	names := []string{}
+	for _, ty := range lease.ValidTypes() {
+		names = append(names, ty.String())
+	}
+	return names
+}
+
+// typeSchema derives a schema from a Go type, following the rules that
+// encoding/json uses to unmarshal it.
+func typeSchema(t reflect.Type) map[string]any {
+	if enum, ok := enumTypes[t]; ok {
+		return map[string]any{"type": "string", "enum": enum}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+	    reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		s := map[string]any{
+			"type":			"object",
+			"additionalProperties":	typeSchema(t.Elem()),
+		}
+		if enum, ok := enumTypes[t.Key()]; ok {
+			s["propertyNames"] = map[string]any{"enum": enum}
+		}
+		return s
+	case reflect.Struct:
+		props := make(map[string]any)
+		addFields(t, props)
+		return map[string]any{"type": "object", "properties": props}
+	}
+	return map[string]any{}
+}
+
+// addFields adds the exported fields of a struct to props. As with
+// encoding/json, fields of embedded structs are promoted.
+func addFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addFields(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		props[f.Name] = typeSchema(f.Type)
+	}
+}
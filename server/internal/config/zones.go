@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// WithZones returns a copy of a JSON configuration with each client's
+// Zone set as given. Clients that are only in the locations file get an
+// entry holding just their zone. If venue is set and that venue has its
+// own clients, the zones go there. Other settings are kept, though their
+// formatting and key order are not.
+func WithZones(jsonBlob []byte, venue string, zones map[types.ID]string) ([]byte, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(jsonBlob, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	parent := raw
+	if venues, ok := raw["Venues"].(map[string]any); ok && venue != "" {
+		if v, ok := venues[venue].(map[string]any); ok && v["Clients"] != nil {
+			parent = v
+		}
+	}
+	clients, ok := parent["Clients"].(map[string]any)
+	if !ok {
+		clients = make(map[string]any)
+		parent["Clients"] = clients
+	}
+	for id, zone := range zones {
+		c, ok := clients[string(id)].(map[string]any)
+		if !ok {
+			c = make(map[string]any)
+			clients[string(id)] = c
+		}
+		c["Zone"] = zone
+	}
+	return json.MarshalIndent(raw, "", "  ")
+}
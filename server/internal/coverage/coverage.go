@@ -0,0 +1,157 @@
+// Package coverage estimates how well a set of clients covers the space
+// they're installed in, to help with planning an installation.
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Config describes how to evaluate coverage.
+type Config struct {
+	Radius		float64	// speaker radius for clients that don't specify one
+	Resolution	float64	// grid spacing, in meters
+	Fraction	float64	// fraction of the fleet an effect would use
+}
+
+// Report describes the coverage of the space by a fleet.
+type Report struct {
+	Cells		int		// grid cells in the space
+	Covered		int		// cells in range of at least one client
+	Gaps		[]Gap
+
+	Suggested	[]types.ID	// clients to use for Fraction
+	SuggestedCovered int		// cells in range of the suggested clients
+}
+
+// Gap is a horizontal run of uncovered grid cells.
+type Gap struct {
+	Y		float64
+	MinX, MaxX	float64
+}
+
+// A cell is a grid cell, identified by its corner.
+type cell = types.PhysLocation
+
+// Analyze works out which parts of the space are out of range of every
+// client, and which clients an effect using the configured fraction of
+// the fleet should use to cover the most space. The space is taken to be
+// the bounding box of the clients' locations.
+func Analyze(clients map[types.ID]types.Client, c Config) (Report, error) {
+	if len(clients) == 0 {
+		return Report{}, fmt.Errorf("no clients to analyze")
+	}
+	if c.Resolution <= 0 {
+		return Report{}, fmt.Errorf("resolution must be positive")
+	}
+
+	ids := []types.ID{}
+	for id := range clients {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	locs := []types.PhysLocation{}
+	for _, id := range ids {
+		locs = append(locs, clients[id].PhysLocation)
+	}
+	lo, hi, _ := space.Bounds(locs)
+
+	// Which cells each client can be heard in.
+	var rows [][]cell
+	for y := lo.Y; y <= hi.Y; y += c.Resolution {
+		row := []cell{}
+		for x := lo.X; x <= hi.X; x += c.Resolution {
+			row = append(row, cell{X: x, Y: y})
+		}
+		rows = append(rows, row)
+	}
+	reach := make(map[types.ID]map[cell]bool)
+	for _, id := range ids {
+		cl := clients[id]
+		r := cl.SpeakerRadius
+		if r <= 0 {
+			r = c.Radius
+		}
+		reach[id] = make(map[cell]bool)
+		for _, row := range rows {
+			for _, ce := range row {
+				if space.Distance(ce, cl.PhysLocation) <= r {
+					reach[id][ce] = true
+				}
+			}
+		}
+	}
+
+	report := Report{}
+	covered := make(map[cell]bool)
+	for _, id := range ids {
+		for ce := range reach[id] {
+			covered[ce] = true
+		}
+	}
+	for _, row := range rows {
+		report.Cells += len(row)
+		var gap *Gap
+		for _, ce := range row {
+			if covered[ce] {
+				report.Covered++
+				gap = nil
+				continue
+			}
+			if gap == nil {
+				report.Gaps = append(report.Gaps, Gap{Y: ce.Y, MinX: ce.X})
+				gap = &report.Gaps[len(report.Gaps) - 1]
+			}
+			gap.MaxX = ce.X
+		}
+	}
+
+	// Greedily choose the clients that cover the most new cells.
+	want := int(math.Round(c.Fraction * float64(len(ids))))
+	chosen := make(map[cell]bool)
+	used := make(map[types.ID]bool)
+	for len(report.Suggested) < want {
+		best, bestGain := types.ID(""), -1
+		for _, id := range ids {
+			if used[id] {
+				continue
+			}
+			gain := 0
+			for ce := range reach[id] {
+				if !chosen[ce] {
+					gain++
+				}
+			}
+			if gain > bestGain {
+				best, bestGain = id, gain
+			}
+		}
+		used[best] = true
+		report.Suggested = append(report.Suggested, best)
+		for ce := range reach[best] {
+			chosen[ce] = true
+		}
+	}
+	report.SuggestedCovered = len(chosen)
+
+	return report, nil
+}
+
+// Write prints a human-readable version of the report.
+func (r Report) Write(w io.Writer) {
+	pct := func(n int) float64 {
+		return 100.0 * float64(n) / float64(max(r.Cells, 1))
+	}
+	fmt.Fprintf(w, "coverage: %d of %d cells (%.1f%%)\n", r.Covered, r.Cells, pct(r.Covered))
+	for _, g := range r.Gaps {
+		fmt.Fprintf(w, "  gap at y=%.1f: x from %.1f to %.1f\n", g.Y, g.MinX, g.MaxX)
+	}
+	fmt.Fprintf(w, "suggested %d clients, covering %d cells (%.1f%%): %v\n",
+	    len(r.Suggested), r.SuggestedCovered, pct(r.SuggestedCovered), r.Suggested)
+}
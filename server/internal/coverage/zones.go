@@ -0,0 +1,53 @@
+package coverage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// SuggestZones groups clients into k zones of nearby clients, using
+// k-means clustering on their locations. Zones are named "zone1",
+// "zone2", and so on, ordered by the X and then Y coordinate of their
+// centers. The result is deterministic for a given set of clients.
+func SuggestZones(clients map[types.ID]types.Client, k int) (map[types.ID]string, error) {
+	ids := []types.ID{}
+	for id := range clients {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	points := []types.PhysLocation{}
+	for _, id := range ids {
+		points = append(points, clients[id].PhysLocation)
+	}
+
+	assignment, centers, err := space.Cluster(points, k)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name the zones in a stable order.
+	order := make([]int, k)
+	for j := range order {
+		order[j] = j
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ca, cb := centers[order[a]], centers[order[b]]
+		if ca.X != cb.X {
+			return ca.X < cb.X
+		}
+		return ca.Y < cb.Y
+	})
+	names := make([]string, k)
+	for n, j := range order {
+		names[j] = fmt.Sprintf("zone%d", n + 1)
+	}
+
+	zones := make(map[types.ID]string)
+	for i, id := range ids {
+		zones[id] = names[assignment[i]]
+	}
+	return zones, nil
+}
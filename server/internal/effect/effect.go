@@ -2,18 +2,24 @@ package effect
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
-	"hash/maphash"
+	"sort"
 	"strings"
 	"time"
 
+        "github.com/blakej11/cricket/internal/beat"
+        "github.com/blakej11/cricket/internal/bus"
         "github.com/blakej11/cricket/internal/client"
         "github.com/blakej11/cricket/internal/fileset"
+        "github.com/blakej11/cricket/internal/intensity"
         "github.com/blakej11/cricket/internal/lease"
         "github.com/blakej11/cricket/internal/log"
-        "github.com/blakej11/cricket/internal/random"
+        "github.com/blakej11/cricket/internal/occupancy"
+        "github.com/blakej11/cricket/internal/session"
+        "github.com/blakej11/cricket/internal/space"
         "github.com/blakej11/cricket/internal/types"
+        "github.com/blakej11/cricket/internal/weather"
+        "github.com/blakej11/cricket/pkg/random"
 )
 
 // Config describes the configuration of a single sound or light effect.
@@ -23,8 +29,66 @@ type Config struct {
 	Parameters	map[string]random.Config// how to define parameters
 	Duration	random.Config
 	Lease		lease.Config
+
+	// If set, clients' queues are cleared when the effect ends, rather
+	// than letting queued sounds or blinks play out. This suits effects
+	// that are meant to end abruptly.
+	StopOnReturn	bool
+
+	// Labels such as "ambient" or "kidsafe", which players can use to
+	// select effects; see TagExpr.
+	Tags		[]string
+
+	// How much each parameter follows the installation's intensity;
+	// see the intensity package. Parameters not named here don't.
+	Sensitivity	map[string]float64
+
+	// How parameters follow real conditions outside, e.g. a chirp
+	// interval that lengthens as it gets colder; see the weather
+	// package. These multiply with any Sensitivity.
+	Weather		map[string]weather.Binding
+
+	// How much each parameter follows the number of visitors, e.g. a
+	// density that fills out when there's a crowd; see the occupancy
+	// package. These also multiply with any Sensitivity.
+	CrowdSensitivity map[string]float64
+
+	// The effect doesn't run while any of these weather variables is
+	// above its threshold, e.g. {"rain": 0.1} for an artificial storm
+	// that shouldn't compete with a real one.
+	SuppressWhen	weather.Thresholds
+
+	// How raggedly the effect's clients start what they're sent for
+	// the same time, in seconds: each run, every client gets a start
+	// offset between zero and a value drawn from this, which delays
+	// all of its plays, blinks, and fades. Zero (the default) keeps
+	// them in tight sync; half a second sounds natural. See
+	// client.StartOffsets.
+	StartJitter	random.Config
+
+	// If set, the effect's plays, blinks, and fades start on the beat
+	// grid (see the beat package), at steps of this many beats, e.g. 1
+	// for every beat or 0.5 for every half beat. Requests that devices
+	// queue behind others still start when those end.
+	QuantizeBeats	float64
+
+	// The most requests the effect may have queued or in flight at
+	// once; the algorithm waits when it reaches this. If zero, it's
+	// defaultOutstandingPerClient for each leased client.
+	MaxOutstanding	int
 }
 
+// Keys of the messages sent on the bus when an effect starts and
+// finishes; the Sender is the effect's name.
+const (
+	StartedKey	= "effect-started"
+	FinishedKey	= "effect-finished"
+)
+
+// More requests than this per client almost certainly means that an
+// algorithm is sending them faster than they can be carried out.
+const defaultOutstandingPerClient = 20
+
 // ---------------------------------------------------------------------
 
 // Effect is the instantiation of a Config.
@@ -35,6 +99,12 @@ type Effect struct {
 	fileSets	map[string]*fileset.Set
 	parameters	map[string]*random.Variable
 	duration	*random.Variable
+	suppressWhen	weather.Thresholds
+	startJitter	*random.Variable
+	quantizeBeats	float64
+	stopOnReturn	bool
+	tags		[]string
+	maxOutstanding	int
 }
 
 func New(name string, c Config, fileSets map[string]*fileset.Set) (*Effect, error) {
@@ -43,6 +113,53 @@ func New(name string, c Config, fileSets map[string]*fileset.Set) (*Effect, erro
 		return nil, err
 	}
 	reqs := alg.GetRequirements()
+	if err := c.Lease.Check(); err != nil {
+		return nil, fmt.Errorf("effect %q's lease: %w", name, err)
+	}
+	if err := random.DurationKind.Check(c.Duration); err != nil {
+		return nil, fmt.Errorf("effect %q's duration: %w", name, err)
+	}
+	if err := random.DurationKind.Check(c.StartJitter); err != nil {
+		return nil, fmt.Errorf("effect %q's start jitter: %w", name, err)
+	}
+	if c.QuantizeBeats < 0 {
+		return nil, fmt.Errorf("effect %q's QuantizeBeats %v is negative", name, c.QuantizeBeats)
+	}
+	if c.MaxOutstanding < 0 {
+		return nil, fmt.Errorf("effect %q's MaxOutstanding %d is negative", name, c.MaxOutstanding)
+	}
+
+	for fsName := range c.FileSets {
+		if err := checkDeclared("fileset", fsName, reqs.FileSets); err != nil {
+			return nil, fmt.Errorf("effect %q: %w", name, err)
+		}
+	}
+	for paramName := range c.Parameters {
+		if err := checkDeclared("parameter", paramName, reqs.Parameters); err != nil {
+			return nil, fmt.Errorf("effect %q: %w", name, err)
+		}
+	}
+	for paramName := range c.Sensitivity {
+		if err := checkDeclared("parameter", paramName, reqs.Parameters); err != nil {
+			return nil, fmt.Errorf("effect %q's sensitivity: %w", name, err)
+		}
+	}
+	for paramName, b := range c.Weather {
+		if err := checkDeclared("parameter", paramName, reqs.Parameters); err != nil {
+			return nil, fmt.Errorf("effect %q's weather: %w", name, err)
+		}
+		if err := b.Check(); err != nil {
+			return nil, fmt.Errorf("effect %q's %q parameter: %w", name, paramName, err)
+		}
+	}
+	for paramName := range c.CrowdSensitivity {
+		if err := checkDeclared("parameter", paramName, reqs.Parameters); err != nil {
+			return nil, fmt.Errorf("effect %q's crowd sensitivity: %w", name, err)
+		}
+	}
+	if err := c.SuppressWhen.Check(); err != nil {
+		return nil, fmt.Errorf("effect %q's SuppressWhen: %w", name, err)
+	}
 
 	fss := make(map[string]*fileset.Set)
 	for _, fsName := range reqs.FileSets {
@@ -58,107 +175,213 @@ func New(name string, c Config, fileSets map[string]*fileset.Set) (*Effect, erro
 
 	parameters := make(map[string]*random.Variable)
 	for _, paramName := range reqs.Parameters {
-		if _, ok := c.Parameters[paramName]; !ok {
-			return nil, fmt.Errorf("failed to find effect %q's %q parameter", name, paramName)
+		pc, ok := c.Parameters[paramName]
+		if !ok {
+			def := reqs.ParamInfo[paramName].Default
+			if def == nil {
+				return nil, fmt.Errorf("failed to find effect %q's %q parameter", name, paramName)
+			}
+			pc = *def
+		}
+		if err := reqs.ParamInfo[paramName].Kind.Check(pc); err != nil {
+			return nil, fmt.Errorf("effect %q's %q parameter: %w", name, paramName, err)
+		}
+		parameters[paramName] = random.New(pc)
+		if scale := c.scale(paramName); scale != nil {
+			parameters[paramName].ScaleBy(scale)
 		}
-		parameters[paramName] = random.New(c.Parameters[paramName])
 	}
 
 	return &Effect{
 		name:		name,
-		lease:		lease.New(c.Lease),
+		lease:		lease.New(name, c.Lease),
 		alg:		alg,
 		fileSets:	fss,
 		parameters:	parameters,
 		duration:	random.New(c.Duration),
+		suppressWhen:	c.SuppressWhen,
+		startJitter:	random.New(c.StartJitter),
+		quantizeBeats:	c.QuantizeBeats,
+		stopOnReturn:	c.StopOnReturn,
+		tags:		c.Tags,
+		maxOutstanding:	c.MaxOutstanding,
 	}, nil
 }
 
+// scale returns a function giving what to multiply the named parameter
+// by, from everything it follows, or nil if it doesn't follow anything.
+func (c Config) scale(paramName string) func() float64 {
+	factors := []func() float64{}
+	if sens, ok := c.Sensitivity[paramName]; ok {
+		factors = append(factors, intensity.Scale(sens))
+	}
+	if b, ok := c.Weather[paramName]; ok {
+		factors = append(factors, b.Factor)
+	}
+	if sens, ok := c.CrowdSensitivity[paramName]; ok {
+		factors = append(factors, func() float64 {
+			return occupancy.Factor(sens)
+		})
+	}
+	switch len(factors) {
+	case 0:
+		return nil
+	case 1:
+		return factors[0]
+	}
+	return func() float64 {
+		f := 1.0
+		for _, factor := range factors {
+			f *= factor()
+		}
+		return f
+	}
+}
+
+// Tags returns the effect's tags.
+func (e *Effect) Tags() []string {
+	return e.tags
+}
+
+// checkDeclared returns an error if the algorithm doesn't declare name.
+// A near miss, e.g. one that differs only by case, is mentioned in the error.
+func checkDeclared(kind, name string, declared []string) error {
+	for _, d := range declared {
+		if d == name {
+			return nil
+		}
+	}
+	for _, d := range declared {
+		if strings.EqualFold(d, name) {
+			return fmt.Errorf("algorithm has no %s %q (did you mean %q?)", kind, name, d)
+		}
+	}
+	return fmt.Errorf("algorithm has no %s %q (has [ %s ])", kind, name, strings.Join(declared, ","))
+}
+
 // Run leases some clients and instantiates an effect on them.
 // It spawns a thread to run the algorithm, and that thread hangs around
 // until all of the client leases are returned.
 // It returns an error if the lease could not be satisfied.
 func (e *Effect) Run() error {
-	clients, err := lease.Request(e.lease)
+	_, err := e.start()
+	return err
+}
+
+// RunAndWait is like Run, but it doesn't return until the effect has
+// finished and all of its client leases have been returned.
+func (e *Effect) RunAndWait() error {
+	done, err := e.start()
 	if err != nil {
 		return err
 	}
+	<-done
+	return nil
+}
+
+// Suppressed returns an error saying why the effect can't run right now
+// because of the weather, or nil if it can.
+func (e *Effect) Suppressed() error {
+	if err := e.suppressWhen.Exceeded(); err != nil {
+		return fmt.Errorf("effect %q is suppressed: %w", e.name, err)
+	}
+	return nil
+}
+
+func (e *Effect) start() (<-chan struct{}, error) {
+	if err := e.Suppressed(); err != nil {
+		return nil, err
+	}
+	h, err := hold(e.name, e.lease)
+	session.EffectRan(e.name, err)
+	if err != nil {
+		return nil, err
+	}
+	done := e.runOn(h.Clients(), e.duration.Duration(), h, func() {
+		h.Release(e.stopOnReturn)
+	})
+	return done, nil
+}
+
+// Preview runs the effect on the given clients for the given duration,
+// so an operator can audition it without waiting for a player to pick
+// it. The clients aren't leased, so anything else running on them will
+// be mixed in; nor does the run count in session statistics. The
+// returned channel is closed when the algorithm finishes.
+func (e *Effect) Preview(clients []types.ID, dur time.Duration) <-chan struct{} {
+	return e.runOn(clients, dur, nil, func() {
+		if e.stopOnReturn {
+			clear := &client.Clear{Type: e.lease.Type}
+			client.Action(clients, context.Background(), clear, time.Now())
+		}
+	})
+}
 
-        dur := e.duration.Duration()
+// runOn runs the algorithm on the given clients in a new thread, and
+// calls release when it's done. If the clients are held by h (rather
+// than being previewed on), the algorithm is stopped early if the
+// holding is preempted.
+func (e *Effect) runOn(clients []types.ID, dur time.Duration, h *Holding, release func()) <-chan struct{} {
         ctx, cancel := context.WithTimeout(context.Background(), dur)
+	var preempted <-chan struct{}
+	if h != nil {
+		preempted = h.Preempted()
+	}
+	// ctx is added to below, so don't let the goroutine read it.
+	timedOut := ctx.Done()
+	go func() {
+		select {
+		case <-preempted:
+			log.Infof("effect %q preempted; finishing early", e.name)
+			cancel()
+		case <-timedOut:
+		}
+	}()
+	budget := e.maxOutstanding
+	if budget == 0 {
+		budget = defaultOutstandingPerClient * len(clients)
+	}
+	ctx = client.WithRequestBudget(ctx, client.NewRequestBudget(e.name, budget))
+	if e.quantizeBeats > 0 {
+		ctx = client.WithQuantizer(ctx, func(t time.Time) time.Time {
+			return beat.Next(t, e.quantizeBeats)
+		})
+	}
+	if spread := e.startJitter.Duration(); spread > 0 {
+		offsets := make(client.StartOffsets)
+		for _, id := range clients {
+			offsets[id] = time.Duration(random.Float64() * float64(spread))
+		}
+		ctx = client.WithStartOffsets(ctx, offsets)
+	}
 
 	algParams := AlgParams {
 		FileSets:	e.fileSets,
 		Parameters:	e.parameters,
 		Clients:	clients,
+		Holding:	h,
+		Bus:		bus.Shared,
+		Name:		e.name,
 	}
 	for _, p := range algParams.Parameters {
 		p.Reset()
 	}
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		defer cancel()
 
 		log.Infof("Start  effect %q: duration %v, params %s", e.name, dur, algParams)
+		algParams.Publish(StartedKey, 0)
 		e.alg.Run(ctx, algParams)
 		log.Infof("Finish effect %q: params %s", e.name, algParams)
+		algParams.Publish(FinishedKey, 0)
 
-		e.drainQueue(clients)
+		release()
 	}()
 
-	return nil
-}
-
-// Drain the queue on each client.
-// We will hang around as long as necessary to do so.
-func (e *Effect) drainQueue(clients []types.ID) {
-	var b []byte
-	drained := make(map[types.ID]bool)
-	for _, id := range clients {
-		drained[id] = false
-		b, _ = binary.Append(b, binary.NativeEndian, ([]byte)(id))
-	}
-	clientHash := maphash.Bytes(maphash.MakeSeed(), b)
-	acks := make(chan types.ID)
-	drain := client.DrainQueue {
-		Ack:	acks,
-		Type:	e.lease.Type,
-	}
-	client.Action(clients, context.Background(), &drain, time.Now())
-
-	start := time.Now()
-	now := start
-	ticker := time.Tick(time.Second)
-	draining := []types.ID{}
-	toDrain := len(clients)
-	for toDrain > 0 {
-		select {
-		case id := <-acks:
-			draining = append(draining, id)
-			continue
-		case now = <-ticker:
-		}
-
-		lease.Return(draining, e.lease.Type)
-		for _, id := range draining {
-			drained[id] = true
-		}
-		toDrain -= len(draining)
-		draining = nil
-
-		if now.Sub(start) <= 10 * time.Second {
-			continue
-		}
-		stillDraining := []types.ID{}
-		for id, done := range drained {
-			if done {
-				continue
-			}
-			stillDraining = append(stillDraining, id)
-		}
-		log.Infof("[drain %016x] %d clients still draining after %.1f seconds: %v",
-		    clientHash, toDrain, now.Sub(start).Seconds(), stillDraining)
-	}
+	return done
 }
 
 // ---------------------------------------------------------------------
@@ -166,12 +389,71 @@ func (e *Effect) drainQueue(clients []types.ID) {
 type AlgRequirements struct {
 	FileSets	[]string
 	Parameters	[]string
+
+	// The rest is optional documentation for people writing configs.
+	Description	string
+	FileSetInfo	map[string]string	// description of each fileset
+	ParamInfo	map[string]ParamInfo
+}
+
+// ParamInfo describes one of an algorithm's parameters.
+type ParamInfo struct {
+	Description	string
+	Unit		string
+
+	// What the parameter is used for; configured values are checked
+	// against the range allowed for this kind.
+	Kind		random.Kind
+
+	// If non-nil, this is used when an effect doesn't specify the parameter.
+	Default		*random.Config
 }
 
 type AlgParams struct {
 	FileSets	map[string]*fileset.Set
 	Parameters	map[string]*random.Variable
 	Clients		[]types.ID
+
+	// The lease on Clients, for algorithms that change how many
+	// clients they use as they run, with Holding.Grow and
+	// Holding.Shrink. Clients isn't updated; such an algorithm keeps
+	// track itself. Nil for a preview, whose clients aren't leased.
+	//
+	// Clients are only ever added to a running effect by its own
+	// call to Grow, so an algorithm that can't cope with new clients
+	// mid-run (e.g. one playing a synchronized playlist) declares that
+	// simply by not calling it.
+	Holding		*Holding
+
+	// For signaling other running effects.
+	Bus		*bus.Bus
+	Name		string	// the effect's name, for use as a Sender
+}
+
+// Publish sends a message with the given key on the bus, from this effect.
+func (a AlgParams) Publish(key string, value float64) {
+	a.Bus.Publish(bus.Message{Key: key, Sender: a.Name, Value: value})
+}
+
+// Duration returns the named parameter as a duration.
+func (a AlgParams) Duration(name string) random.DurationVar {
+	return random.DurationVar{Variable: a.Parameters[name]}
+}
+
+// Volume returns the named parameter as a volume.
+func (a AlgParams) Volume(name string) random.VolumeVar {
+	return random.VolumeVar{Variable: a.Parameters[name]}
+}
+
+// Count returns the named parameter as a count.
+func (a AlgParams) Count(name string) random.CountVar {
+	return random.CountVar{Variable: a.Parameters[name]}
+}
+
+// Neighbors returns the neighbor graph of the effect's clients; see
+// client.Neighbors.
+func (a AlgParams) Neighbors() space.Graph {
+	return client.Neighbors().Restrict(a.Clients)
 }
 
 func (a AlgParams) String() string {
@@ -211,6 +493,33 @@ func RegisterAlgorithm(ty lease.Type, name string, alg Algorithm) {
 	algs[ty][name] = alg
 }
 
+// AlgorithmInfo describes a registered algorithm.
+type AlgorithmInfo struct {
+	Type		lease.Type
+	Name		string
+	Requirements	AlgRequirements
+}
+
+// Algorithms returns all registered algorithms, sorted by type and name.
+func Algorithms() []AlgorithmInfo {
+	infos := []AlgorithmInfo{}
+	for _, ty := range lease.ValidTypes() {
+		names := []string{}
+		for name := range algs[ty] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			infos = append(infos, AlgorithmInfo{
+				Type:		ty,
+				Name:		name,
+				Requirements:	algs[ty][name].GetRequirements(),
+			})
+		}
+	}
+	return infos
+}
+
 func lookupAlgorithm(ty lease.Type, name string) (Algorithm, error) {
 	if _, ok := algs[ty]; !ok {
 		return nil, fmt.Errorf("failed to find any %v-type algorithms", ty)
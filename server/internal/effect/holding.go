@@ -0,0 +1,169 @@
+package effect
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+
+        "github.com/blakej11/cricket/internal/client"
+        "github.com/blakej11/cricket/internal/lease"
+        "github.com/blakej11/cricket/internal/log"
+        "github.com/blakej11/cricket/internal/types"
+)
+
+// A Holding is a set of leased clients. Effects hold their clients this
+// way, but so can anything else that needs clients to itself for a
+// while, e.g. a maintenance task that checks batteries, or an operator
+// trying something out. Like an effect, a holder shows up by name in
+// lease statistics and history, and competes with effects for clients.
+//
+// While it holds them, the holder can send the clients requests with
+// client.Action, ask for more with Grow, and give some back early with
+// Shrink. It must call Release when it's done.
+type Holding struct {
+	name	string
+	ty	lease.Type
+	grant	*lease.Grant
+
+	mu	sync.Mutex
+	clients	[]types.ID
+}
+
+// Hold leases clients as described by c, for a holder with the given
+// name. Like an effect, it waits up to c.MaxWait for enough clients,
+// and returns an error if there aren't enough.
+func Hold(name string, c lease.Config) (*Holding, error) {
+	if err := c.Check(); err != nil {
+		return nil, fmt.Errorf("%q's lease: %w", name, err)
+	}
+	return hold(name, lease.New(name, c))
+}
+
+func hold(name string, p lease.Params) (*Holding, error) {
+	clients, grant, err := lease.Request(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Holding{name: name, ty: p.Type, grant: grant, clients: clients}, nil
+}
+
+// Clients returns the held clients.
+func (h *Holding) Clients() []types.ID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.clients)
+}
+
+// Preempted returns a channel that's closed if a more important holder
+// needs the clients; see lease.Config.Priority. The holder should then
+// Release them as soon as it can.
+func (h *Holding) Preempted() <-chan struct{} {
+	return h.grant.Preempted()
+}
+
+// Grow asks for up to n more clients like the ones held, waiting up to
+// the lease's MaxWait for them, and returns the ones it got, which may
+// be none. See lease.Grow.
+func (h *Holding) Grow(n int) []types.ID {
+	more := lease.Grow(h.grant, n)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients = append(h.clients, more...)
+	return more
+}
+
+// Shrink gives back some of the held clients before the holder is done,
+// as Release does for all of them. Clients that aren't held are ignored.
+func (h *Holding) Shrink(ids []types.ID, stop bool) {
+	h.mu.Lock()
+	giving := []types.ID{}
+	h.clients = slices.DeleteFunc(h.clients, func(id types.ID) bool {
+		if slices.Contains(ids, id) {
+			giving = append(giving, id)
+			return true
+		}
+		return false
+	})
+	h.mu.Unlock()
+	h.release(giving, stop)
+}
+
+// Release gives the clients back. If stop is set, their queues are
+// cleared first; otherwise whatever they have queued plays out. Either
+// way, each client is returned only once its queue is empty, so the
+// next holder starts with an idle client. Release doesn't return until
+// all of the clients have been returned.
+func (h *Holding) Release(stop bool) {
+	h.mu.Lock()
+	clients := h.clients
+	h.clients = nil
+	h.mu.Unlock()
+	h.release(clients, stop)
+}
+
+func (h *Holding) release(clients []types.ID, stop bool) {
+	if len(clients) == 0 {
+		return
+	}
+	if stop {
+		clear := &client.Clear{Type: h.ty}
+		client.Action(clients, context.Background(), clear, time.Now())
+	}
+	h.drainQueue(clients)
+}
+
+// Drain the queue on each client.
+// We will hang around as long as necessary to do so.
+func (h *Holding) drainQueue(clients []types.ID) {
+	var b []byte
+	drained := make(map[types.ID]bool)
+	for _, id := range clients {
+		drained[id] = false
+		b, _ = binary.Append(b, binary.NativeEndian, ([]byte)(id))
+	}
+	clientHash := maphash.Bytes(maphash.MakeSeed(), b)
+	acks := make(chan types.ID)
+	drain := client.DrainQueue {
+		Ack:	acks,
+		Type:	h.ty,
+	}
+	client.Action(clients, context.Background(), &drain, time.Now())
+
+	start := time.Now()
+	now := start
+	ticker := time.Tick(time.Second)
+	draining := []types.ID{}
+	toDrain := len(clients)
+	for toDrain > 0 {
+		select {
+		case id := <-acks:
+			draining = append(draining, id)
+			continue
+		case now = <-ticker:
+		}
+
+		lease.Return(draining, h.ty)
+		for _, id := range draining {
+			drained[id] = true
+		}
+		toDrain -= len(draining)
+		draining = nil
+
+		if now.Sub(start) <= 10 * time.Second {
+			continue
+		}
+		stillDraining := []types.ID{}
+		for id, done := range drained {
+			if done {
+				continue
+			}
+			stillDraining = append(stillDraining, id)
+		}
+		log.Infof("[drain %016x] %d clients still draining after %.1f seconds: %v",
+		    clientHash, toDrain, now.Sub(start).Seconds(), stillDraining)
+	}
+}
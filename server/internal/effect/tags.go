@@ -0,0 +1,70 @@
+package effect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagExpr is a compiled tag expression, which selects effects by their
+// tags. An expression is a list of alternatives separated by "|", each
+// of which is a list of terms separated by "&"; a term is a tag, or a
+// tag preceded by "!" to mean that the tag must be absent. For example,
+// "ambient & !spooky | featured" selects effects that are either
+// ambient and not spooky, or featured.
+type TagExpr struct {
+	text		string
+	alternatives	[][]tagTerm
+}
+
+type tagTerm struct {
+	tag	string
+	negated	bool
+}
+
+// ParseTagExpr compiles a tag expression.
+func ParseTagExpr(s string) (*TagExpr, error) {
+	e := &TagExpr{text: s}
+	for _, alt := range strings.Split(s, "|") {
+		terms := []tagTerm{}
+		for _, t := range strings.Split(alt, "&") {
+			t = strings.TrimSpace(t)
+			term := tagTerm{}
+			if strings.HasPrefix(t, "!") {
+				term.negated = true
+				t = strings.TrimSpace(t[1:])
+			}
+			if t == "" || strings.ContainsAny(t, "!() \t") {
+				return nil, fmt.Errorf("bad tag expression %q: bad term %q", s, t)
+			}
+			term.tag = t
+			terms = append(terms, term)
+		}
+		e.alternatives = append(e.alternatives, terms)
+	}
+	return e, nil
+}
+
+// Match reports whether an effect with the given tags is selected.
+func (e *TagExpr) Match(tags []string) bool {
+	has := make(map[string]bool)
+	for _, t := range tags {
+		has[t] = true
+	}
+	for _, alt := range e.alternatives {
+		matched := true
+		for _, term := range alt {
+			if has[term.tag] == term.negated {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *TagExpr) String() string {
+	return e.text
+}
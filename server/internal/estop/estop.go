@@ -0,0 +1,186 @@
+// Package estop silences the whole installation at once, e.g. for an
+// announcement or when something has gone wrong. A stop mutes every
+// client (see client.MuteAll) and holds the players, so that no new
+// effects start; it lasts until Release.
+//
+// Stops come from a button wired to a GPIO line on the server's host, or
+// a key on a keyboard plugged into it, so that they work even when the
+// admin API doesn't, or nobody can get to it in time. Trigger can also
+// be called from elsewhere.
+package estop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/player"
+)
+
+// Config describes where stops come from. Either or both may be set.
+type Config struct {
+	// The sysfs value file of a GPIO line, e.g.
+	// "/sys/class/gpio/gpio17/value", which must already be exported
+	// as an input. It reads "1" while the button is pressed, or "0"
+	// if ActiveLow is set.
+	GPIO		string
+	ActiveLow	bool
+
+	// A Linux input device, e.g. "/dev/input/by-id/...-event-kbd", and
+	// the code of the key on it that stops the installation (see
+	// linux/input-event-codes.h; e.g. 119 is Pause). The server must
+	// be allowed to read the device, and reads it whether or not
+	// anything has the keyboard's focus.
+	Keyboard	string
+	Key		int
+}
+
+const (
+	// How often to look at the GPIO line.
+	gpioPoll = 20 * time.Millisecond
+
+	// How long to wait before reopening an input that failed, e.g. a
+	// keyboard that was unplugged.
+	retryDelay = 5 * time.Second
+
+	// From linux/input-event-codes.h.
+	evKey		= 1
+	keyPressed	= 1
+)
+
+var estop struct {
+	mu		sync.Mutex
+	players		[]*player.Player
+	stopped		bool
+}
+
+// Check returns an error if the configuration is invalid.
+func (c Config) Check() error {
+	if c.Keyboard != "" && c.Key <= 0 {
+		return fmt.Errorf("emergency stop keyboard %q needs a key code", c.Keyboard)
+	}
+	return nil
+}
+
+// Start begins watching for stops, which hold the given players.
+func Start(c Config, players []*player.Player) {
+	estop.mu.Lock()
+	estop.players = players
+	estop.mu.Unlock()
+
+	if c.GPIO != "" {
+		go watchGPIO(c.GPIO, c.ActiveLow)
+	}
+	if c.Keyboard != "" {
+		go watchKeyboard(c.Keyboard, c.Key)
+	}
+}
+
+// Trigger stops the installation. Triggering it again while it's stopped
+// mutes the clients again, in case any were missed.
+func Trigger(source string) {
+	estop.mu.Lock()
+	defer estop.mu.Unlock()
+	log.Warningf("emergency stop from %s", source)
+	estop.stopped = true
+	for _, p := range estop.players {
+		p.Hold()
+	}
+	client.MuteAll()
+}
+
+// Release lets the installation carry on after a stop.
+func Release() {
+	estop.mu.Lock()
+	defer estop.mu.Unlock()
+	if !estop.stopped {
+		return
+	}
+	log.Infof("releasing emergency stop")
+	estop.stopped = false
+	client.UnmuteAll()
+	for _, p := range estop.players {
+		p.Release()
+	}
+}
+
+// Stopped returns whether the installation is stopped.
+func Stopped() bool {
+	estop.mu.Lock()
+	defer estop.mu.Unlock()
+	return estop.stopped
+}
+
+// watchGPIO triggers a stop each time the GPIO line goes from released
+// to pressed, including if it's pressed when the server starts.
+func watchGPIO(path string, activeLow bool) {
+	pressedValue := []byte("1")
+	if activeLow {
+		pressedValue = []byte("0")
+	}
+	wasPressed := false
+	failing := false
+	for ; ; time.Sleep(gpioPoll) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if !failing {
+				log.Errorf("can't read emergency stop GPIO: %v", err)
+				failing = true
+			}
+			time.Sleep(retryDelay)
+			continue
+		}
+		failing = false
+		pressed := bytes.Equal(bytes.TrimSpace(b), pressedValue)
+		if pressed && !wasPressed {
+			Trigger("GPIO " + path)
+		}
+		wasPressed = pressed
+	}
+}
+
+// inputEvent is struct input_event from linux/input.h.
+type inputEvent struct {
+	Time	syscall.Timeval
+	Type	uint16
+	Code	uint16
+	Value	int32
+}
+
+// watchKeyboard triggers a stop each time the key is pressed.
+func watchKeyboard(path string, key int) {
+	failing := false
+	for ; ; time.Sleep(retryDelay) {
+		f, err := os.Open(path)
+		if err != nil {
+			if !failing {
+				log.Errorf("can't open emergency stop keyboard: %v", err)
+				failing = true
+			}
+			continue
+		}
+		failing = false
+		err = readKeys(f, key)
+		f.Close()
+		log.Errorf("lost emergency stop keyboard: %v", err)
+	}
+}
+
+func readKeys(r io.Reader, key int) error {
+	for {
+		var ev inputEvent
+		if err := binary.Read(r, binary.NativeEndian, &ev); err != nil {
+			return err
+		}
+		if ev.Type == evKey && int(ev.Code) == key && ev.Value == keyPressed {
+			Trigger(fmt.Sprintf("key %d", key))
+		}
+	}
+}
@@ -2,14 +2,20 @@ package fileset
 
 import (
 	"fmt"
-	"math/rand/v2"
 	"regexp"
+	"sort"
 	"time"
+
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 // Config describes a set of files that are operated on together.
+// A set is either the files whose names match Regex, or a weighted mix
+// of other sets: each Pick chooses one of the sets in Weights (with
+// probability proportional to its weight) and picks a file from it.
 type Config struct {
-	Regex		string	// matches key in file map
+	Regex		string			// matches key in file map
+	Weights		map[string]float64	// names of other filesets
 }
 
 // File holds the information needed to access one MP3 file on a client.
@@ -31,6 +37,67 @@ func (f *File) SleepForDuration() {
 // Set is the runtime instantiation of a file set.
 type Set struct {
 	files	[]File
+	names	[]string
+
+	// only used for weighted sets
+	children	[]*Set
+	weights		[]float64
+	totalWeight	float64
+}
+
+// NewAll instantiates all of the configured sets, including weighted sets
+// that refer to other sets.
+func NewAll(configs map[string]Config, files map[string]File) (map[string]*Set, error) {
+	sets := make(map[string]*Set)
+	building := make(map[string]bool)
+
+	var build func(name string) (*Set, error)
+	build = func(name string) (*Set, error) {
+		if s, ok := sets[name]; ok {
+			return s, nil
+		}
+		c, ok := configs[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to find a fileset named %q", name)
+		}
+		if len(c.Weights) == 0 {
+			s, err := New(name, c, files)
+			if err != nil {
+				return nil, err
+			}
+			sets[name] = s
+			return s, nil
+		}
+
+		if c.Regex != "" {
+			return nil, fmt.Errorf("fileset %q has both a regex and weights", name)
+		}
+		if building[name] {
+			return nil, fmt.Errorf("fileset %q refers to itself", name)
+		}
+		building[name] = true
+		children := make(map[string]*Set)
+		for n := range c.Weights {
+			child, err := build(n)
+			if err != nil {
+				return nil, fmt.Errorf("fileset %q: %w", name, err)
+			}
+			children[n] = child
+		}
+		s, err := newWeighted(name, c.Weights, children)
+		if err != nil {
+			return nil, err
+		}
+		sets[name] = s
+		return s, nil
+	}
+
+	for name := range configs {
+		if _, err := build(name); err != nil {
+			return nil, fmt.Errorf("failed to parse fileset %q: %w", name, err)
+		}
+	}
+	return sets, nil
 }
 
 func New(name string, c Config, files map[string]File) (*Set, error) {
@@ -40,20 +107,70 @@ func New(name string, c Config, files map[string]File) (*Set, error) {
 	}
 
 	results := []File{}
+	names := []string{}
 	for name, file := range files {
 		if re.MatchString(name) {
 			results = append(results, file)
+			names = append(names, name)
 		}
 	}
 	return &Set{
 		files:	results,
+		names:	names,
 	}, nil
 }
 
+func newWeighted(name string, weights map[string]float64, children map[string]*Set) (*Set, error) {
+	set := &Set{}
+	seen := make(map[string]bool)
+	names := []string{}
+	for n := range weights {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		w := weights[n]
+		if w < 0 {
+			return nil, fmt.Errorf("fileset %q has negative weight %v for %q", name, w, n)
+		}
+		child := children[n]
+		set.children = append(set.children, child)
+		set.weights = append(set.weights, w)
+		set.totalWeight += w
+
+		// A file may appear in more than one child set.
+		for i, fn := range child.names {
+			if !seen[fn] {
+				seen[fn] = true
+				set.names = append(set.names, fn)
+				set.files = append(set.files, child.files[i])
+			}
+		}
+	}
+	if set.totalWeight <= 0 {
+		return nil, fmt.Errorf("fileset %q has no positive weights", name)
+	}
+	return set, nil
+}
+
 func (f *Set) Pick() File {
-	return f.files[rand.Int32N(int32(len(f.files)))]
+	if len(f.children) > 0 {
+		target := random.Float64() * f.totalWeight
+		for i, w := range f.weights {
+			target -= w
+			if target < 0 && len(f.children[i].files) > 0 {
+				return f.children[i].Pick()
+			}
+		}
+	}
+	return f.files[random.IntN(len(f.files))]
 }
 
 func (f *Set) Set() []File {
 	return f.files
 }
+
+// Names returns the names of the files in the set.
+func (f *Set) Names() []string {
+	return f.names
+}
@@ -0,0 +1,162 @@
+// Package intensity holds the installation's intensity: one knob, from 0
+// (sparse) to 1 (frenetic), that takes the whole installation from one
+// to the other. At 0.5, everything runs as configured.
+//
+// The knob works through sensitivities, which effects declare for their
+// parameters and players for their delays. A parameter with sensitivity
+// s is multiplied by 2^(s * (2 * intensity - 1)): with s = 1, it doubles
+// at full intensity and halves at none; a negative s, which suits delays,
+// works the other way. Parameters without a sensitivity don't change.
+//
+// The intensity is set by operators, via the admin API, or by a schedule
+// of levels through the evening.
+package intensity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// The intensity at which everything runs as configured.
+const Neutral = 0.5
+
+// Point is a point in an intensity schedule.
+type Point struct {
+	At	string	// a time of day, e.g. "21:30"
+	Level	float64
+}
+
+// How often a schedule updates the intensity.
+const scheduleInterval = 10 * time.Second
+
+var intensity struct {
+	mu		sync.Mutex
+	level		float64
+	setAt		time.Time	// when an operator last set it
+}
+
+func init() {
+	intensity.level = Neutral
+}
+
+// Level returns the intensity.
+func Level() float64 {
+	intensity.mu.Lock()
+	defer intensity.mu.Unlock()
+	return intensity.level
+}
+
+// Set sets the intensity. It overrides a schedule until the schedule
+// reaches its next point.
+func Set(level float64) error {
+	if level < 0 || level > 1 {
+		return fmt.Errorf("intensity %v must be between 0 and 1", level)
+	}
+	intensity.mu.Lock()
+	defer intensity.mu.Unlock()
+	log.Infof("intensity set to %.2f", level)
+	intensity.level = level
+	intensity.setAt = time.Now()
+	return nil
+}
+
+// Factor returns what to multiply a parameter with the given sensitivity
+// by, at the current intensity.
+func Factor(sensitivity float64) float64 {
+	return math.Exp2(sensitivity * (2 * Level() - 1))
+}
+
+// Scale returns a function giving Factor(sensitivity), for
+// random.Variable.ScaleBy.
+func Scale(sensitivity float64) func() float64 {
+	return func() float64 {
+		return Factor(sensitivity)
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// schedulePoint is a Point, parsed.
+type schedulePoint struct {
+	offset	time.Duration	// since midnight
+	level	float64
+}
+
+// CheckSchedule returns an error if a schedule is invalid.
+func CheckSchedule(points []Point) error {
+	_, err := parseSchedule(points)
+	return err
+}
+
+func parseSchedule(points []Point) ([]schedulePoint, error) {
+	parsed := []schedulePoint{}
+	for _, p := range points {
+		t, err := time.Parse("15:04", p.At)
+		if err != nil {
+			return nil, fmt.Errorf("intensity schedule time %q: %w", p.At, err)
+		}
+		if p.Level < 0 || p.Level > 1 {
+			return nil, fmt.Errorf("intensity schedule level %v at %s must be between 0 and 1", p.Level, p.At)
+		}
+		offset := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+		parsed = append(parsed, schedulePoint{offset: offset, level: p.Level})
+	}
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].offset < parsed[j].offset
+	})
+	return parsed, nil
+}
+
+// StartSchedule moves the intensity through the day, from each point's
+// level to the next's in a straight line, wrapping around at midnight.
+// The points must have been checked with CheckSchedule.
+func StartSchedule(points []Point) {
+	parsed, err := parseSchedule(points)
+	if err != nil || len(parsed) == 0 {
+		return
+	}
+	go func() {
+		for ; ; time.Sleep(scheduleInterval) {
+			level, since := scheduled(parsed, time.Now())
+			intensity.mu.Lock()
+			if intensity.setAt.Before(since) && intensity.level != level {
+				intensity.level = level
+			}
+			intensity.mu.Unlock()
+		}
+	}()
+}
+
+// scheduled returns the scheduled intensity at the given time, and when
+// the schedule passed its last point.
+func scheduled(points []schedulePoint, now time.Time) (float64, time.Time) {
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	// Find the points before and after now, wrapping around.
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].offset > offset
+	})
+	prev := points[(i + len(points) - 1) % len(points)]
+	next := points[i % len(points)]
+	prevAt := midnight.Add(prev.offset)
+	if prev.offset > offset {
+		prevAt = prevAt.AddDate(0, 0, -1)
+	}
+	nextAt := midnight.Add(next.offset)
+	if !nextAt.After(now) {
+		nextAt = nextAt.AddDate(0, 0, 1)
+	}
+
+	frac := 0.0
+	if span := nextAt.Sub(prevAt); span > 0 {
+		frac = float64(now.Sub(prevAt)) / float64(span)
+	}
+	return prev.level + (next.level - prev.level) * frac, prevAt
+}
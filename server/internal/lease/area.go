@@ -0,0 +1,60 @@
+package lease
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Area is a part of the installation that a lease can be limited to: the
+// clients in a zone, or those within a radius of a point, or both. If
+// Near is set, the clients nearest it are granted first, whatever the
+// allocation strategy, so that spatial effects get clients that are
+// together.
+type Area struct {
+	Zone	string
+	Near	*types.PhysLocation
+	Radius	float64		// in meters from Near; zero means no limit
+}
+
+// Check returns an error if the area is invalid.
+func (a Area) Check() error {
+	if a.Radius < 0 {
+		return fmt.Errorf("area radius %v must not be negative", a.Radius)
+	}
+	if a.Radius > 0 && a.Near == nil {
+		return fmt.Errorf("area radius %v needs a point to be near", a.Radius)
+	}
+	if a.Zone == "" && a.Near == nil {
+		return fmt.Errorf("area needs a zone, or a point to be near")
+	}
+	return nil
+}
+
+// contains returns whether a client at the given location, in the given
+// zone, is in the area.
+func (a *Area) contains(loc types.PhysLocation, zone string) bool {
+	if a.Zone != "" && zone != a.Zone {
+		return false
+	}
+	if a.Radius > 0 && space.Distance(*a.Near, loc) > a.Radius {
+		return false
+	}
+	return true
+}
+
+// nearest is a strategy that hands out the clients nearest a point.
+type nearest struct {
+	point	types.PhysLocation
+}
+
+func (s *nearest) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	sort.SliceStable(ids, func(i, j int) bool {
+		return space.Distance(s.point, d.locations[ids[i]]) <
+		    space.Distance(s.point, d.locations[ids[j]])
+	})
+	return ids[:min(n, len(ids))]
+}
@@ -0,0 +1,56 @@
+package lease
+
+import (
+	"testing"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+func TestAreaCheck(t *testing.T) {
+	origin := &types.PhysLocation{}
+	tests := []struct {
+		name	string
+		area	Area
+		ok	bool
+	}{
+		{"zone", Area{Zone: "stage"}, true},
+		{"near", Area{Near: origin}, true},
+		{"radius", Area{Near: origin, Radius: 5}, true},
+		{"zone and radius", Area{Zone: "stage", Near: origin, Radius: 5}, true},
+		{"empty", Area{}, false},
+		{"negative radius", Area{Near: origin, Radius: -1}, false},
+		{"radius without point", Area{Zone: "stage", Radius: 5}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.area.Check(); (err == nil) != tt.ok {
+			t.Errorf("%s: Check() = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestAreaContains(t *testing.T) {
+	center := &types.PhysLocation{X: 1, Y: 1}
+	tests := []struct {
+		name	string
+		area	Area
+		loc	types.PhysLocation
+		zone	string
+		want	bool
+	}{
+		{"in zone", Area{Zone: "stage"}, types.PhysLocation{X: 100}, "stage", true},
+		{"other zone", Area{Zone: "stage"}, types.PhysLocation{}, "lobby", false},
+		{"no zone", Area{Zone: "stage"}, types.PhysLocation{}, "", false},
+		{"inside radius", Area{Near: center, Radius: 5}, types.PhysLocation{X: 4, Y: 5}, "", true},
+		{"outside radius", Area{Near: center, Radius: 5}, types.PhysLocation{X: 5, Y: 5}, "", false},
+		{"no radius", Area{Near: center}, types.PhysLocation{X: 100}, "", true},
+		{"zone but too far", Area{Zone: "stage", Near: center, Radius: 1},
+		    types.PhysLocation{X: 5}, "stage", false},
+		{"near but other zone", Area{Zone: "stage", Near: center, Radius: 1},
+		    types.PhysLocation{X: 1, Y: 1}, "lobby", false},
+	}
+	for _, tt := range tests {
+		if got := tt.area.contains(tt.loc, tt.zone); got != tt.want {
+			t.Errorf("%s: contains(%v, %q) = %v, want %v", tt.name, tt.loc, tt.zone, got, tt.want)
+		}
+	}
+}
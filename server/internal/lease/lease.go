@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/blakej11/cricket/internal/log"
-	"github.com/blakej11/cricket/internal/random"
 	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 // Config describes how many clients are needed/desired for an effect.
@@ -17,11 +20,39 @@ type Config struct {
         Type		Type
         MinClients	int		// minimum number of clients needed
         MaxClients	int		// maximum number of clients allowed
-	FleetFraction	random.Config	// desired fraction of fleet
+	FleetFraction	random.Config	// desired fraction of fleet (or of Area)
 	MaxWait		random.Config
 
+	// If set, don't give this holder a client that it held last time.
+	AvoidRepeat	bool
+
+	// Only use clients that advertise all of these features.
+	Features	[]string
+
+	// If nonzero, only use clients whose request round-trip time has
+	// a standard deviation of at most this many seconds. Effects that
+	// need tight timing can use this to avoid jittery clients.
+	MaxJitter	float64
+
+	// If nonzero, don't use clients that have had a request fail in the
+	// last this many seconds. Effects that need reliable clients can
+	// use this to leave flaky ones to background effects.
+	AvoidFailedWithin float64
+
+	// If set, only use clients in this part of the installation.
+	Area		*Area
+
+	// How important the holder is. If a request can't get MinClients,
+	// holders with a lower priority are asked to finish early and give
+	// their clients back (once they've drained their queues); see
+	// Request. If it still hasn't got them halfway through MaxWait,
+	// holders with the same priority that have a few more clients than
+	// their own MinClients are asked too. The request waits for them
+	// for up to MaxWait, which should be long enough for that. The
+	// default is zero.
+	Priority	int
+
 	// could request specific IDs I guess
-	// could request something w/r/t PhysLocation
 }
 
 type Type int
@@ -36,20 +67,62 @@ const (
 // Params is the instantiation of a Config.
 type Params struct {
         Type		Type
+	name		string		// of the lease holder
         minClients	int
         maxClients	int
 	fleetFraction	*random.Variable
 	maxWait		*random.Variable
+	avoidRepeat	bool
+	features	[]string
+	maxJitter	time.Duration
+	avoidFailed	time.Duration
+	area		*Area
+	priority	int
 }
 
-func New(c Config) Params {
+// New instantiates a Config. The name identifies the lease holder in
+// logs and statistics.
+func New(name string, c Config) Params {
 	return Params{
 		Type:          c.Type,
+		name:          name,
 		minClients:    c.MinClients,
 		maxClients:    c.MaxClients,
 		fleetFraction: random.New(c.FleetFraction),
 		maxWait:       random.New(c.MaxWait),
+		avoidRepeat:   c.AvoidRepeat,
+		features:      c.Features,
+		maxJitter:     time.Duration(c.MaxJitter * float64(time.Second)),
+		avoidFailed:   time.Duration(c.AvoidFailedWithin * float64(time.Second)),
+		area:          c.Area,
+		priority:      c.Priority,
+	}
+}
+
+// Check returns an error if the configuration can't be satisfied.
+func (c Config) Check() error {
+	if c.MinClients < 0 || c.MaxClients < 0 {
+		return fmt.Errorf("client limits (%d, %d) must not be negative", c.MinClients, c.MaxClients)
+	}
+	if c.MaxClients > 0 && c.MaxClients < c.MinClients {
+		return fmt.Errorf("maximum clients %d is less than minimum %d", c.MaxClients, c.MinClients)
+	}
+	if err := random.FractionKind.Check(c.FleetFraction); err != nil {
+		return fmt.Errorf("fleet fraction: %w", err)
+	}
+	if err := random.DurationKind.Check(c.MaxWait); err != nil {
+		return fmt.Errorf("max wait: %w", err)
+	}
+	if c.MaxJitter < 0 || c.AvoidFailedWithin < 0 {
+		return fmt.Errorf("max jitter %v and avoid-failed time %v must not be negative",
+		    c.MaxJitter, c.AvoidFailedWithin)
 	}
+	if c.Area != nil {
+		if err := c.Area.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func ValidTypes() []Type {
@@ -72,7 +145,12 @@ func (ty *Type) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &s); err != nil {
 		return err
 	}
-	switch strings.ToLower(s) {
+	return ty.UnmarshalText([]byte(s))
+}
+
+// needed to unmarshal a type as a map key
+func (ty *Type) UnmarshalText(b []byte) error {
+	switch strings.ToLower(string(b)) {
 	default:
 		*ty = UnknownType
 	case "sound":
@@ -84,28 +162,68 @@ func (ty *Type) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// needed to unmarshal a type as a map key
-func (ty *Type) UnmarshalText(b []byte) error {
-        return ty.UnmarshalJSON(b)
-}
-
 func (ty Type) MarshalJSON() ([]byte, error) {
 	return json.Marshal(ty.String())
 }
 
+// needed to marshal a type as a map key
+func (ty Type) MarshalText() ([]byte, error) {
+	return []byte(ty.String()), nil
+}
+
 // ---------------------------------------------------------------------
 
 // Add allows the mDNS thread to add information about a newly
 // discovered client. This also undoes a Suspend operation.
-func Add(id types.ID, location types.PhysLocation) {
+func Add(id types.ID, location types.PhysLocation, zone string, caps types.Capabilities) {
 	for _, ty := range ValidTypes() {
-		enqueueReturnMessage(ty, &addMessage{id: id, location: location})
+		enqueueReturnMessage(ty, &addMessage{id: id, location: location, zone: zone, capabilities: caps})
 	}
 }
 
-// Request allows an effect to get a collection of clients.
-func Request(p Params) ([]types.ID, error) {
-	clientCh := make(chan []types.ID)
+// SetJitter records the latest estimate of a client's round-trip jitter.
+func SetJitter(id types.ID, jitter time.Duration) {
+	for _, ty := range ValidTypes() {
+		enqueueHint(ty, &jitterMessage{id: id, jitter: jitter})
+	}
+}
+
+// SetLocation records that a client is now somewhere else, e.g. because
+// it took over for a replaced one; see client.Alias.
+func SetLocation(id types.ID, location types.PhysLocation, zone string) {
+	for _, ty := range ValidTypes() {
+		enqueueReturnMessage(ty, &locationMessage{id: id, location: location, zone: zone})
+	}
+}
+
+// SetThrottle has a client sit out the given fraction of lease requests,
+// e.g. to save its battery; see client.EnergyModel. Zero undoes it.
+func SetThrottle(id types.ID, fraction float64) {
+	for _, ty := range ValidTypes() {
+		enqueueHint(ty, &throttleMessage{id: id, fraction: fraction})
+	}
+}
+
+// SetAsleep records whether a client is asleep (see client.SleepUntil),
+// so that it isn't handed to effects until it wakes up.
+func SetAsleep(id types.ID, asleep bool) {
+	for _, ty := range ValidTypes() {
+		enqueueHint(ty, &asleepMessage{id: id, asleep: asleep})
+	}
+}
+
+// RecordFailure records that a request to a client failed.
+func RecordFailure(id types.ID, when time.Time) {
+	for _, ty := range ValidTypes() {
+		enqueueHint(ty, &failureMessage{id: id, when: when})
+	}
+}
+
+// Request allows an effect to get a collection of clients. The Grant
+// says if a request with a higher priority needs the clients back; the
+// effect should then finish early and return them.
+func Request(p Params) ([]types.ID, *Grant, error) {
+	clientCh := make(chan *grantResponse)
 	errorCh := make(chan error)
 
 	enqueueNormalMessage(p.Type, &requestMessage{
@@ -115,11 +233,32 @@ func Request(p Params) ([]types.ID, error) {
 	})
 
 	select {
-	case clients := <-clientCh:
-		return clients, nil
+	case resp := <-clientCh:
+		return resp.clients, resp.grant, nil
 	case err := <-errorCh:
-		return nil, err
+		return nil, nil, err
+	}
+}
+
+// Grow asks for up to n more clients for a grant, while its holder is
+// running, e.g. because an effect is building up. The clients have to
+// suit the original request, and the grant won't grow past its
+// MaxClients. Grow waits up to the request's MaxWait for them, and
+// returns the ones it gets, which may be none. A grant that's been
+// preempted, or whose clients have all been returned, doesn't grow. To
+// give clients back before the holder is done, Return them.
+func Grow(g *Grant, n int) []types.ID {
+	if n <= 0 {
+		return nil
 	}
+	clientCh := make(chan *grantResponse)
+	enqueueNormalMessage(g.params.Type, &requestMessage{
+		params: g.params,
+		grow: g,
+		count: n,
+		clientResponse: clientCh,
+	})
+	return (<-clientCh).clients
 }
 
 // Return allows an effect to return a collection of clients.
@@ -129,14 +268,96 @@ func Return(ids []types.ID, ty Type) {
 	enqueueReturnMessage(ty, &returnMessage{ids: ids})
 }
 
+// HolderStats describes the clients one lease holder has received.
+type HolderStats struct {
+	Name		string
+	Grants		int	// successful requests
+	Failures	int	// failed requests
+	Holding		int	// clients currently held
+	Preempted	int	// times it was asked to give clients back
+	ClientSeconds	float64	// total time clients have been held
+}
+
+// Stats returns statistics about each lease holder of the given type.
+func Stats(ty Type) []HolderStats {
+	ch := make(chan []HolderStats)
+	enqueueReturnMessage(ty, &statsMessage{response: ch})
+	return <-ch
+}
+
+// Lease describes one past or present lease of a client.
+type Lease struct {
+	Holder	string
+	Start	time.Time
+	End	time.Time	// zero if the client is still held
+}
+
+// How many past leases to remember for each client.
+const historyLength = 20
+
+// History returns the recent leases of each client, oldest first.
+func History(ty Type) map[types.ID][]Lease {
+	ch := make(chan map[types.ID][]Lease)
+	enqueueReturnMessage(ty, &historyMessage{response: ch})
+	return <-ch
+}
+
 // ---------------------------------------------------------------------
 
 // All API calls turn into messages sent over these channels, to be serialized.
+// The channels are buffered, so callers such as the client admin thread
+// needn't wait while a lease thread is busy, e.g. waiting for clients to
+// satisfy a request. If a channel fills up anyway, the sender waits.
 func enqueueNormalMessage(ty Type, m message) {
-	data[ty].normalCh <- m
+	data[ty].send(ty, data[ty].normalCh, m)
 }
 func enqueueReturnMessage(ty Type, m message) {
-	data[ty].returnCh <- m
+	data[ty].send(ty, data[ty].returnCh, m)
+}
+
+// enqueueHint sends a message that only updates advisory information,
+// such as a client's jitter. If the channel is full, the message is
+// dropped rather than making a device thread wait.
+func enqueueHint(ty Type, m message) {
+	d := data[ty]
+	select {
+	case d.returnCh <- m:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// The number of messages each lease thread's channels can hold.
+const channelBuffer = 256
+
+func (d *leaseData) send(ty Type, ch chan message, m message) {
+	select {
+	case ch <- m:
+		return
+	default:
+	}
+	// Warn on the first overflow, and then less and less often.
+	if n := d.overflows.Add(1); n & (n - 1) == 0 {
+		log.Warningf("[lease %v] message channel full (%d times so far); waiting", ty, n)
+	}
+	ch <- m
+}
+
+// Backlog describes the messages waiting for a lease thread.
+type Backlog struct {
+	Queued		int	// messages waiting now
+	Overflows	int64	// times a sender had to wait for room
+	Dropped		int64	// advisory messages dropped for lack of room
+}
+
+// GetBacklog returns the backlog of the thread for a type of lease.
+func GetBacklog(ty Type) Backlog {
+	d := data[ty]
+	return Backlog{
+		Queued:		len(d.normalCh) + len(d.returnCh),
+		Overflows:	d.overflows.Load(),
+		Dropped:	d.dropped.Load(),
+	}
 }
 
 type message interface {
@@ -145,11 +366,30 @@ type message interface {
 
 type leaseData struct {
 	locations	map[types.ID]types.PhysLocation
+	zones		map[types.ID]string
+	capabilities	map[types.ID]types.Capabilities
+	jitter		map[types.ID]time.Duration
+	lastFailure	map[types.ID]time.Time
+	throttle	map[types.ID]float64	// see SetThrottle
+	asleep		map[types.ID]bool	// see SetAsleep
 	leased		map[types.ID]bool
 	idSlice		[]types.ID
 	next		int
+
+	holder		map[types.ID]string	// who holds each leased client
+	grants		map[types.ID]*Grant	// and what they were given with
+	since		map[types.ID]time.Time	// and since when
+	returned	map[types.ID]time.Time	// when each client was last free
+	history		map[types.ID][]Lease
+	stats		map[string]*HolderStats
+	strategy	strategy
+	waiting		*Waiting	// the request being handled, if any
 	normalCh	chan message // for request messages
 	returnCh	chan message // for add and return messages
+
+	// These are updated by senders, not by the lease thread.
+	overflows	atomic.Int64
+	dropped		atomic.Int64
 }
 
 var data map[Type]*leaseData
@@ -159,9 +399,22 @@ func init() {
 	for _, ty := range ValidTypes() {
 		data[ty] = &leaseData{
 			locations:	make(map[types.ID]types.PhysLocation),
+			zones:		make(map[types.ID]string),
+			capabilities:	make(map[types.ID]types.Capabilities),
+			jitter:		make(map[types.ID]time.Duration),
+			lastFailure:	make(map[types.ID]time.Time),
+			throttle:	make(map[types.ID]float64),
+			asleep:		make(map[types.ID]bool),
 			leased:		make(map[types.ID]bool),
-			normalCh:	make(chan message),
-			returnCh:	make(chan message),
+			holder:		make(map[types.ID]string),
+			grants:		make(map[types.ID]*Grant),
+			since:		make(map[types.ID]time.Time),
+			returned:	make(map[types.ID]time.Time),
+			history:	make(map[types.ID][]Lease),
+			stats:		make(map[string]*HolderStats),
+			strategy:	strategies[defaultStrategy],
+			normalCh:	make(chan message, channelBuffer),
+			returnCh:	make(chan message, channelBuffer),
 		}
 
 		go func() {
@@ -182,6 +435,8 @@ func init() {
 type addMessage struct {
 	id types.ID
 	location types.PhysLocation
+	zone string
+	capabilities types.Capabilities
 }
 
 func (r *addMessage) handle(ty Type) {
@@ -191,50 +446,181 @@ func (r *addMessage) handle(ty Type) {
 		log.Fatalf("duplicate request to add client %q", r.id)
 	}
 	d.locations[r.id] = r.location
+	d.zones[r.id] = r.zone
+	d.capabilities[r.id] = r.capabilities
 	d.leased[r.id] = false
+	d.returned[r.id] = time.Now()
 	d.idSlice = append(d.idSlice, r.id)
 }
 
+type jitterMessage struct {
+	id	types.ID
+	jitter	time.Duration
+}
+
+func (r *jitterMessage) handle(ty Type) {
+	data[ty].jitter[r.id] = r.jitter
+}
+
+type locationMessage struct {
+	id		types.ID
+	location	types.PhysLocation
+	zone		string
+}
+
+func (r *locationMessage) handle(ty Type) {
+	if _, ok := data[ty].locations[r.id]; ok {
+		data[ty].locations[r.id] = r.location
+		data[ty].zones[r.id] = r.zone
+	}
+}
+
+type throttleMessage struct {
+	id		types.ID
+	fraction	float64
+}
+
+func (r *throttleMessage) handle(ty Type) {
+	if r.fraction <= 0 {
+		delete(data[ty].throttle, r.id)
+		return
+	}
+	data[ty].throttle[r.id] = r.fraction
+}
+
+type asleepMessage struct {
+	id	types.ID
+	asleep	bool
+}
+
+func (r *asleepMessage) handle(ty Type) {
+	if !r.asleep {
+		delete(data[ty].asleep, r.id)
+		return
+	}
+	data[ty].asleep[r.id] = true
+}
+
+type failureMessage struct {
+	id	types.ID
+	when	time.Time
+}
+
+func (r *failureMessage) handle(ty Type) {
+	data[ty].lastFailure[r.id] = r.when
+}
+
 type requestMessage struct {
 	params		Params
-	clientResponse	chan []types.ID
+	grow		*Grant	// if growing an existing grant
+	count		int	// and by how many clients
+	clientResponse	chan *grantResponse
 	errorResponse	chan error
 }
 
+type grantResponse struct {
+	clients	[]types.ID
+	grant	*Grant
+}
+
 func (r *requestMessage) handle(ty Type) {
 	d := data[ty]
 	params := r.params
 
-	ctx, cancel := context.WithTimeout(context.Background(), params.maxWait.Duration())
+	maxWait := params.maxWait.Duration()
+	ctx, cancel := context.WithTimeout(context.Background(), maxWait)
 	defer cancel()
 
-	desired := int(math.Round(params.fleetFraction.Float64() * float64(len(d.idSlice))))
-	if params.maxClients > 0 {
-		desired = min(params.maxClients, desired)
+	g, desired, minimum := r.grow, 0, 0
+	if g == nil {
+		g = newGrant(params)
+		g.fraction, desired = d.desired(ty, params, maxWait)
+		minimum = params.minClients
+		g.target = desired
+	} else {
+		held := d.numGranted(g)
+		if g.preempted || held == 0 {
+			log.Infof("[lease %v] %q: not growing a grant that's preempted or returned", ty, params.name)
+			r.clientResponse <- &grantResponse{grant: g}
+			return
+		}
+		desired = r.count
+		if params.maxClients > 0 {
+			desired = min(desired, params.maxClients - held)
+		}
+		log.Infof("[lease %v] %q: growing by %d clients (%d leased), max wait %v: want %d",
+		    ty, params.name, r.count, d.numLeased(), maxWait, desired)
+		g.target = held + max(desired, 0)
 	}
-	desired = max(params.minClients, desired)
-	if desired == 0 {
-		r.clientResponse <- nil
+	if desired <= 0 {
+		r.clientResponse <- &grantResponse{grant: g}
 		return
 	}
+	d.waiting = &Waiting{Name: params.name, Want: desired, Min: minimum, Since: time.Now()}
+	defer func() {
+		d.waiting = nil
+	}()
 
 	results := []types.ID{}
+	// suits says whether a client would do, if it weren't leased.
+	suits := func(id types.ID) bool {
+		if d.asleep[id] {
+			return false
+		}
+		if params.avoidRepeat && d.lastHolder(id) == params.name {
+			return false
+		}
+		if !d.capabilities[id].Has(params.features) {
+			return false
+		}
+		if params.maxJitter > 0 && d.jitter[id] > params.maxJitter {
+			return false
+		}
+		if params.avoidFailed > 0 && time.Since(d.lastFailure[id]) < params.avoidFailed {
+			return false
+		}
+		if params.area != nil && !params.area.contains(d.locations[id], d.zones[id]) {
+			return false
+		}
+		return true
+	}
+	eligible := func(id types.ID) bool {
+		if d.leased[id] || !suits(id) {
+			return false
+		}
+		if t, ok := d.throttle[id]; ok && random.Float64() < t {
+			return false
+		}
+		return true
+	}
+	pick := d.strategy.pick
+	if params.area != nil && params.area.Near != nil {
+		pick = (&nearest{point: *params.area.Near}).pick
+	}
+
+	// A request that can't get its MinClients becomes starving after
+	// a while; see rebalanceFor.
+	var starved <-chan time.Time
+	if minimum > 0 {
+		starved = time.After(time.Duration(starvingFraction * float64(maxWait)))
+	}
+	starving := false
 
 waitLoop:
 	for {
-		for i := range d.idSlice {
-			index := (d.next + i) % len(d.idSlice)
-			id := d.idSlice[index]
-			if d.leased[id] {
-				continue
-			}
-			d.leased[id] = true
+		for _, id := range pick(d, desired - len(results), eligible) {
+			d.grant(id, g)
 			results = append(results, id)
-			if len(results) == desired {
-				d.next = index
-				r.clientResponse <- results
-				return
-			}
+		}
+		d.waiting.Have = len(results)
+		if len(results) == desired {
+			d.granted(ty, g, results)
+			r.clientResponse <- &grantResponse{clients: results, grant: g}
+			return
+		}
+		d.preemptFor(ty, params, minimum, len(results), suits)
+		if starving {
+			d.rebalanceFor(ty, params, minimum, len(results), suits)
 		}
 
 		// Didn't find enough clients. Wait for some to be returned
@@ -242,6 +628,9 @@ waitLoop:
 		select {
 		case msg := <-d.returnCh:
 			msg.handle(ty)
+			d.handleBurst(ctx, ty)
+		case <-starved:
+			starving, starved = true, nil
 		case <-ctx.Done():
 			break waitLoop
 		}
@@ -249,17 +638,70 @@ waitLoop:
 
 	// We got all the way through but haven't succeeded. What do?
 	num := len(results)
-	if num >= params.minClients {
-		r.clientResponse <- results
+	if num >= minimum {
+		d.granted(ty, g, results)
+		r.clientResponse <- &grantResponse{clients: results, grant: g}
 		return
 	}
 
-	err := fmt.Errorf("not enough clients available (%d, wanted at least %d)", num, params.minClients)
+	err := fmt.Errorf("not enough clients available (%d, wanted at least %d)", num, minimum)
+	log.Infof("[lease %v] %q: failed: %v", ty, params.name, err)
+	d.holderStats(params.name).Failures++
 	r.errorResponse <- err
 	ret := &returnMessage{ids: results}
 	ret.handle(ty)
 }
 
+// desired returns the fraction of the fleet a new request drew, and how
+// many clients it wants.
+func (d *leaseData) desired(ty Type, params Params, maxWait time.Duration) (float64, int) {
+	// With an area, the fleet fraction is of the clients in the area.
+	fleet := len(d.idSlice)
+	if params.area != nil {
+		fleet = 0
+		for _, id := range d.idSlice {
+			if params.area.contains(d.locations[id], d.zones[id]) {
+				fleet++
+			}
+		}
+	}
+	fraction := params.fleetFraction.Float64()
+	desired := int(math.Round(fraction * float64(fleet)))
+	if params.maxClients > 0 {
+		desired = min(params.maxClients, desired)
+	}
+	desired = max(params.minClients, desired)
+	log.Infof("[lease %v] %q: fraction %.3f of %d clients (%d leased), min %d, max %d, max wait %v: want %d",
+	    ty, params.name, fraction, fleet, d.numLeased(),
+	    params.minClients, params.maxClients, maxWait, desired)
+	return fraction, desired
+}
+
+// How long to wait for another add or return message before acting on
+// the ones received so far.
+const burstWindow = 50 * time.Millisecond
+
+// handleBurst handles any add and return messages that follow closely
+// on one just handled. When the whole installation powers on, dozens of
+// clients arrive within a second; this lets them all be considered at
+// once, rather than re-picking (and granting a client or two) after
+// each one.
+func (d *leaseData) handleBurst(ctx context.Context, ty Type) {
+	t := time.NewTimer(burstWindow)
+	defer t.Stop()
+	for {
+		select {
+		case msg := <-d.returnCh:
+			msg.handle(ty)
+			t.Reset(burstWindow)
+		case <-t.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 type returnMessage struct {
 	ids	[]types.ID
 }
@@ -274,6 +716,163 @@ func (r *returnMessage) handle(ty Type) {
 			log.Fatalf("returnClient: returning invalid lease on %q", id)
 		}
 		d.leased[id] = false
+
+		hs := d.holderStats(d.holder[id])
+		hs.Holding--
+		hs.ClientSeconds += time.Since(d.since[id]).Seconds()
+		delete(d.holder, id)
+		delete(d.grants, id)
+		delete(d.since, id)
+		d.returned[id] = time.Now()
+		// A client from a failed request never made it into the
+		// history, so the last lease there has already ended.
+		if h := d.history[id]; len(h) > 0 && h[len(h) - 1].End.IsZero() {
+			h[len(h) - 1].End = d.returned[id]
+		}
+	}
+}
+
+type historyMessage struct {
+	response	chan map[types.ID][]Lease
+}
+
+func (r *historyMessage) handle(ty Type) {
+	d := data[ty]
+	history := make(map[types.ID][]Lease)
+	for id, h := range d.history {
+		history[id] = append([]Lease{}, h...)
+	}
+	r.response <- history
+}
+
+// Check verifies the internal consistency of the lease accounting for
+// a type, and returns an error describing the first problem found.
+func Check(ty Type) error {
+	ch := make(chan error)
+	enqueueReturnMessage(ty, &checkMessage{response: ch})
+	return <-ch
+}
+
+type checkMessage struct {
+	response	chan error
+}
+
+func (r *checkMessage) handle(ty Type) {
+	r.response <- data[ty].check()
+}
+
+func (d *leaseData) check() error {
+	if len(d.idSlice) != len(d.leased) {
+		return fmt.Errorf("%d clients in rotation, but %d known", len(d.idSlice), len(d.leased))
+	}
+	for id, leased := range d.leased {
+		_, held := d.holder[id]
+		if leased != held {
+			return fmt.Errorf("client %q: leased is %v, but has holder is %v", id, leased, held)
+		}
+	}
+	holding := 0
+	for _, hs := range d.stats {
+		if hs.Holding < 0 {
+			return fmt.Errorf("holder %q holds %d clients", hs.Name, hs.Holding)
+		}
+		holding += hs.Holding
+	}
+	if n := d.numLeased(); holding != n {
+		return fmt.Errorf("holders hold %d clients, but %d are leased", holding, n)
+	}
+	return nil
+}
+
+type statsMessage struct {
+	response	chan []HolderStats
+}
+
+func (r *statsMessage) handle(ty Type) {
+	d := data[ty]
+	now := time.Now()
+	stats := []HolderStats{}
+	for _, hs := range d.stats {
+		s := *hs
+		// Include time accrued by clients that haven't been returned.
+		for id, h := range d.holder {
+			if h == s.Name {
+				s.ClientSeconds += now.Sub(d.since[id]).Seconds()
+			}
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	r.response <- stats
+}
+
+// ---------------------------------------------------------------------
+
+// grant marks a client as leased as part of a grant.
+func (d *leaseData) grant(id types.ID, g *Grant) {
+	name := g.params.name
+	d.leased[id] = true
+	d.holder[id] = name
+	d.grants[id] = g
+	d.since[id] = time.Now()
+	d.holderStats(name).Holding++
+}
+
+// lastHolder returns the name of the most recent holder of a client.
+func (d *leaseData) lastHolder(id types.ID) string {
+	h := d.history[id]
+	if len(h) == 0 {
+		return ""
+	}
+	return h[len(h) - 1].Holder
+}
+
+// granted records a successful request. Clients only go into the
+// history here, since a request that fails gives back the clients it
+// had gathered.
+func (d *leaseData) granted(ty Type, g *Grant, ids []types.ID) {
+	name := g.params.name
+	if g.start.IsZero() {
+		g.start = time.Now()
+	}
+	d.holderStats(name).Grants++
+	for _, id := range ids {
+		h := append(d.history[id], Lease{Holder: name, Start: d.since[id]})
+		if len(h) > historyLength {
+			h = h[len(h) - historyLength:]
+		}
+		d.history[id] = h
+	}
+	log.Infof("[lease %v] %q: granted %d clients %v", ty, name, len(ids), ids)
+}
+
+func (d *leaseData) holderStats(name string) *HolderStats {
+	if _, ok := d.stats[name]; !ok {
+		d.stats[name] = &HolderStats{Name: name}
+	}
+	return d.stats[name]
+}
+
+// numGranted returns how many clients a grant holds.
+func (d *leaseData) numGranted(g *Grant) int {
+	n := 0
+	for _, h := range d.grants {
+		if h == g {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *leaseData) numLeased() int {
+	n := 0
+	for _, l := range d.leased {
+		if l {
+			n++
+		}
 	}
+	return n
 }
 
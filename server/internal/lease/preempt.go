@@ -0,0 +1,122 @@
+package lease
+
+import (
+	"sort"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// A Grant is the set of clients given to one request. It remembers the
+// request, so that the holder can ask for more clients like them (see
+// Grow), and its priority, so that a more important request can preempt
+// it: ask its holder to finish early and give the clients back. Holders
+// learn of this through Preempted; one that doesn't listen keeps its
+// clients until it's done with them.
+type Grant struct {
+	params		Params
+	preempt		chan struct{}	// closed when preempted
+
+	// These are only used by the lease thread.
+	preempted	bool
+	start		time.Time	// when the request was granted
+	fraction	float64	// of the fleet the request drew
+	target		int	// clients wanted, including any growth
+}
+
+func newGrant(p Params) *Grant {
+	return &Grant{
+		params:		p,
+		preempt:	make(chan struct{}),
+	}
+}
+
+// Preempted returns a channel that's closed if a request with a higher
+// priority needs the grant's clients back.
+func (g *Grant) Preempted() <-chan struct{} {
+	return g.preempt
+}
+
+// preemptFor preempts lower-priority grants, lowest priority first, until
+// enough clients that suit a request are on their way back for it to
+// have the given minimum. Clients from grants already preempted count as on
+// their way back.
+func (d *leaseData) preemptFor(ty Type, p Params, minimum, have int, suits func(types.ID) bool) {
+	d.takeBack(ty, p, minimum - have, suits, "preempting", func(g *Grant) bool {
+		return g.params.priority < p.priority
+	})
+}
+
+// A request that can't get its MinClients is starving once it's waited
+// this fraction of its MaxWait. It may then have clients taken back for
+// it from grants of the same priority that have plenty (see rebalanceFor).
+// Waiting a while first, and only taking from grants that have had their
+// clients for a while and have more than a few to spare, keeps two
+// requests from taking clients back and forth.
+const (
+	starvingFraction	= 0.5
+	rebalanceMinHeld	= 30 * time.Second
+	rebalanceMargin		= 2	// clients beyond the grant's MinClients
+)
+
+// rebalanceFor preempts grants with the same priority as a starving
+// request, and more clients than they need, until enough clients that
+// suit the request are on their way back for it to have the given
+// minimum. Lower-priority grants have already been preempted by
+// preemptFor.
+func (d *leaseData) rebalanceFor(ty Type, p Params, minimum, have int, suits func(types.ID) bool) {
+	held := make(map[*Grant]int)
+	for _, g := range d.grants {
+		held[g]++
+	}
+	now := time.Now()
+	d.takeBack(ty, p, minimum - have, suits, "rebalancing from", func(g *Grant) bool {
+		return g.params.priority == p.priority && !g.start.IsZero() &&
+		    held[g] - g.params.minClients >= rebalanceMargin &&
+		    now.Sub(g.start) >= rebalanceMinHeld
+	})
+}
+
+// takeBack preempts grants that may be taken from, lowest priority
+// first, until need clients that suit a request are on their way back.
+// Clients from grants already preempted count as on their way back.
+func (d *leaseData) takeBack(ty Type, p Params, need int, suits func(types.ID) bool, verb string, mayTake func(*Grant) bool) {
+	if need <= 0 {
+		return
+	}
+
+	byGrant := make(map[*Grant]int)
+	for id, g := range d.grants {
+		if !suits(id) {
+			continue
+		}
+		if g.preempted {
+			need--
+		} else if mayTake(g) {
+			byGrant[g]++
+		}
+	}
+	grants := []*Grant{}
+	for g := range byGrant {
+		grants = append(grants, g)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].params.priority != grants[j].params.priority {
+			return grants[i].params.priority < grants[j].params.priority
+		}
+		return grants[i].params.name < grants[j].params.name
+	})
+
+	for _, g := range grants {
+		if need <= 0 {
+			return
+		}
+		log.Infof("[lease %v] %q (priority %d) %s %q (priority %d) for %d clients",
+		    ty, p.name, p.priority, verb, g.params.name, g.params.priority, byGrant[g])
+		g.preempted = true
+		close(g.preempt)
+		d.holderStats(g.params.name).Preempted++
+		need -= byGrant[g]
+	}
+}
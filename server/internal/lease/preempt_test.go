@@ -0,0 +1,46 @@
+package lease
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+func TestRebalanceFor(t *testing.T) {
+	old := time.Now().Add(-time.Minute)
+	grant := func(name string, minClients, priority int, start time.Time) *Grant {
+		g := newGrant(Params{name: name, minClients: minClients, priority: priority})
+		g.start = start
+		return g
+	}
+	plenty := grant("plenty", 1, 0, old)		// 4 clients, 3 to spare
+	tight := grant("tight", 3, 0, old)		// 4 clients, 1 to spare
+	recent := grant("recent", 1, 0, time.Now())	// 4 clients, but only just
+	important := grant("important", 1, 1, old)	// 4 clients, higher priority
+	d := &leaseData{
+		grants:	make(map[types.ID]*Grant),
+		stats:	make(map[string]*HolderStats),
+	}
+	for i, g := range []*Grant{plenty, tight, recent, important} {
+		for j := range 4 {
+			d.grants[types.ID(string(rune('a' + i)) + string(rune('0' + j)))] = g
+		}
+	}
+
+	d.rebalanceFor(Sound, Params{name: "starving"}, 3, 0, all)
+	if !plenty.preempted {
+		t.Errorf("the grant with clients to spare wasn't preempted")
+	}
+	for _, g := range []*Grant{tight, recent, important} {
+		if g.preempted {
+			t.Errorf("%q was preempted", g.params.name)
+		}
+	}
+
+	// What's on its way back already counts.
+	d.rebalanceFor(Sound, Params{name: "starving"}, 3, 0, all)
+	if d.stats["plenty"].Preempted != 1 {
+		t.Errorf("plenty preempted %d times, want 1", d.stats["plenty"].Preempted)
+	}
+}
@@ -0,0 +1,98 @@
+package lease
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// TypeSnapshot describes who holds the clients of one lease type.
+type TypeSnapshot struct {
+	FleetSize	int
+	Unallocated	[]types.ID	// clients that no one holds
+	Asleep		[]types.ID	// of those, the ones that can't be leased now
+	Holders		[]HolderSnapshot
+	Waiting		*Waiting	// the request being waited on, if any
+}
+
+// HolderSnapshot describes the clients held under one grant. A holder
+// that asks for clients more than once, e.g. an effect with overlapping
+// runs, shows up once per grant.
+type HolderSnapshot struct {
+	Name		string
+	TargetFraction	float64	// of the fleet it drew when it asked
+	TargetCount	int	// clients it wanted, including any growth
+	Clients		[]types.ID
+}
+
+// Waiting describes a request that a lease thread is waiting to satisfy.
+// A request that's stuck here, or never gets here, is usually why an
+// effect doesn't start.
+type Waiting struct {
+	Name	string
+	Want	int
+	Min	int
+	Have	int
+	Since	time.Time
+}
+
+// Snapshot returns, for each lease type, who holds which clients and
+// who's waiting for more.
+func Snapshot() map[Type]TypeSnapshot {
+	result := make(map[Type]TypeSnapshot)
+	for _, ty := range ValidTypes() {
+		ch := make(chan TypeSnapshot)
+		enqueueReturnMessage(ty, &snapshotMessage{response: ch})
+		result[ty] = <-ch
+	}
+	return result
+}
+
+type snapshotMessage struct {
+	response	chan TypeSnapshot
+}
+
+func (r *snapshotMessage) handle(ty Type) {
+	d := data[ty]
+	s := TypeSnapshot{
+		FleetSize:	len(d.idSlice),
+		Unallocated:	[]types.ID{},
+		Asleep:		[]types.ID{},
+		Holders:	[]HolderSnapshot{},
+	}
+	held := make(map[*Grant][]types.ID)
+	for _, id := range d.idSlice {
+		if g, ok := d.grants[id]; ok {
+			held[g] = append(held[g], id)
+			continue
+		}
+		s.Unallocated = append(s.Unallocated, id)
+		if d.asleep[id] {
+			s.Asleep = append(s.Asleep, id)
+		}
+	}
+	slices.Sort(s.Unallocated)
+	slices.Sort(s.Asleep)
+	for g, ids := range held {
+		slices.Sort(ids)
+		s.Holders = append(s.Holders, HolderSnapshot{
+			Name:		g.params.name,
+			TargetFraction:	g.fraction,
+			TargetCount:	g.target,
+			Clients:	ids,
+		})
+	}
+	sort.Slice(s.Holders, func(i, j int) bool {
+		if s.Holders[i].Name != s.Holders[j].Name {
+			return s.Holders[i].Name < s.Holders[j].Name
+		}
+		return s.Holders[i].Clients[0] < s.Holders[j].Clients[0]
+	})
+	if d.waiting != nil {
+		w := *d.waiting
+		s.Waiting = &w
+	}
+	r.response <- s
+}
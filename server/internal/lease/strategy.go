@@ -0,0 +1,163 @@
+package lease
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+	"github.com/blakej11/cricket/pkg/weightedset"
+)
+
+// A strategy decides which unleased clients a request is given.
+type strategy interface {
+	// pick returns up to n unleased clients for which eligible is
+	// true, in the order they should be granted.
+	pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID
+}
+
+var strategies = map[string]strategy{
+	"round-robin":		&roundRobin{},
+	"random":		&randomOrder{},
+	"least-recent":		&leastRecent{},
+	"weighted-random":	&weightedRandom{},
+	"strict-priority":	&strictPriority{},
+	"spatial-cluster":	&spatialCluster{},
+}
+
+const defaultStrategy = "round-robin"
+
+// SetStrategy chooses how clients of the given type are allocated.
+// The choices are:
+//
+// - "round-robin" (the default) hands out clients in the order they
+//   were discovered, picking up where the previous request left off.
+//
+// - "random" hands out clients in a random order.
+//
+// - "least-recent" hands out the clients that have been idle longest.
+//
+// - "weighted-random" hands out clients in a random order, favoring
+//   clients in proportion to how long they have been idle.
+//
+// - "strict-priority" always hands out the most reliable free clients:
+//   those with the least jitter, then those that failed longest ago,
+//   then in discovery order. The rest are only used when those are busy.
+//
+// - "spatial-cluster" hands out clients that are close together: a
+//   client chosen at random, then the clients nearest it. Clients
+//   without a configured location are treated as being at the origin.
+func SetStrategy(ty Type, name string) error {
+	s, ok := strategies[name]
+	if !ok {
+		return fmt.Errorf("unknown %v allocation strategy %q", ty, name)
+	}
+	enqueueReturnMessage(ty, &strategyMessage{strategy: s})
+	return nil
+}
+
+type strategyMessage struct {
+	strategy	strategy
+}
+
+func (r *strategyMessage) handle(ty Type) {
+	data[ty].strategy = r.strategy
+}
+
+// ---------------------------------------------------------------------
+
+// free returns the eligible clients, in discovery order.
+func (d *leaseData) free(eligible func(types.ID) bool) []types.ID {
+	ids := []types.ID{}
+	for _, id := range d.idSlice {
+		if eligible(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type roundRobin struct {}
+
+func (s *roundRobin) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := []types.ID{}
+	start := d.next
+	for i := range d.idSlice {
+		if len(ids) == n {
+			break
+		}
+		index := (start + i) % len(d.idSlice)
+		id := d.idSlice[index]
+		if !eligible(id) {
+			continue
+		}
+		ids = append(ids, id)
+		d.next = index
+	}
+	return ids
+}
+
+type randomOrder struct {}
+
+func (s *randomOrder) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	random.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return ids[:min(n, len(ids))]
+}
+
+type leastRecent struct {}
+
+func (s *leastRecent) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	sort.SliceStable(ids, func(i, j int) bool {
+		return d.returned[ids[i]].Before(d.returned[ids[j]])
+	})
+	return ids[:min(n, len(ids))]
+}
+
+type weightedRandom struct {}
+
+func (s *weightedRandom) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	now := time.Now()
+	weights := []float64{}
+	for _, id := range ids {
+		// Clients that were just returned still get a small chance.
+		weights = append(weights, max(now.Sub(d.returned[id]).Seconds(), 0.001))
+	}
+	ids = weightedset.Slice(ids, weights)
+	return ids[:min(n, len(ids))]
+}
+
+type strictPriority struct {}
+
+func (s *strictPriority) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	sort.SliceStable(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+		if d.jitter[a] != d.jitter[b] {
+			return d.jitter[a] < d.jitter[b]
+		}
+		return d.lastFailure[a].Before(d.lastFailure[b])
+	})
+	return ids[:min(n, len(ids))]
+}
+
+type spatialCluster struct {}
+
+func (s *spatialCluster) pick(d *leaseData, n int, eligible func(types.ID) bool) []types.ID {
+	ids := d.free(eligible)
+	if len(ids) == 0 {
+		return ids
+	}
+	locs := make(map[types.ID]types.PhysLocation)
+	for _, id := range ids {
+		locs[id] = d.locations[id]
+	}
+	seed := d.locations[ids[random.IntN(len(ids))]]
+	return space.Nearest(seed, locs, n)
+}
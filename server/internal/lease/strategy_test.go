@@ -0,0 +1,71 @@
+package lease
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+func all(types.ID) bool {
+	return true
+}
+
+func TestStrictPriority(t *testing.T) {
+	now := time.Now()
+	d := &leaseData{
+		idSlice:	[]types.ID{"a", "b", "c", "d", "e"},
+		jitter: map[types.ID]time.Duration{
+			"a":	20 * time.Millisecond,
+			"b":	5 * time.Millisecond,
+			"c":	5 * time.Millisecond,
+		},
+		lastFailure: map[types.ID]time.Time{
+			"b":	now.Add(-time.Minute),
+			"d":	now.Add(-time.Hour),
+		},
+	}
+	s := &strictPriority{}
+	// Least jitter first; then, of d and e, e never failed, and of b
+	// and c, c never failed.
+	want := []types.ID{"e", "d", "c", "b", "a"}
+	for range 3 {
+		if got := s.pick(d, 5, all); !slices.Equal(got, want) {
+			t.Fatalf("pick = %v, want %v", got, want)
+		}
+	}
+	if got := s.pick(d, 2, func(id types.ID) bool { return id != "e" }); !slices.Equal(got, []types.ID{"d", "c"}) {
+		t.Errorf("pick without e = %v, want [d c]", got)
+	}
+}
+
+func TestSpatialCluster(t *testing.T) {
+	d := &leaseData{
+		idSlice:	[]types.ID{"a1", "a2", "a3", "b1", "b2", "b3"},
+		locations: map[types.ID]types.PhysLocation{
+			"a1":	{X: 0, Y: 0},
+			"a2":	{X: 1, Y: 0},
+			"a3":	{X: 0, Y: 1},
+			"b1":	{X: 50, Y: 50},
+			"b2":	{X: 51, Y: 50},
+			"b3":	{X: 50, Y: 51},
+		},
+	}
+	s := &spatialCluster{}
+	for range 20 {
+		got := s.pick(d, 3, all)
+		if len(got) != 3 {
+			t.Fatalf("pick = %v, want 3 clients", got)
+		}
+		group := got[0][0]
+		for _, id := range got {
+			if id[0] != group {
+				t.Fatalf("pick = %v, not one cluster", got)
+			}
+		}
+	}
+	if got := s.pick(d, 3, func(types.ID) bool { return false }); len(got) != 0 {
+		t.Errorf("pick of no clients = %v", got)
+	}
+}
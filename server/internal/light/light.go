@@ -2,19 +2,34 @@ package light
 
 import (
 	"context"
+	"math"
+	"sync"
 	"time"
 
+	"github.com/blakej11/cricket/internal/bus"
 	"github.com/blakej11/cricket/internal/client"
 	"github.com/blakej11/cricket/internal/effect"
 	"github.com/blakej11/cricket/internal/lease"
 	_ "github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/space"
 	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 func init() {
 	effect.RegisterAlgorithm(lease.Light, "darkness", &darkness{})
 	effect.RegisterAlgorithm(lease.Light, "blink", &blink{})
 	effect.RegisterAlgorithm(lease.Light, "unison", &unison{})
+	effect.RegisterAlgorithm(lease.Light, "breathe", &breathe{})
+	effect.RegisterAlgorithm(lease.Light, "lightning", &lightning{})
+	effect.RegisterAlgorithm(lease.Light, "constellation", &constellation{})
+	effect.RegisterAlgorithm(lease.Light, "gradient", &gradient{})
+}
+
+// blinkSpeedInfo describes the speed parameter shared by blinking algorithms.
+var blinkSpeedInfo = effect.ParamInfo{
+	Description:	"how fast the LED ramps up and down; a blink takes 512/speed milliseconds",
+	Unit:		"brightness steps per millisecond",
 }
 
 // ---------------------------------------------------------------------
@@ -23,7 +38,9 @@ func init() {
 type darkness struct {}
 
 func (d *darkness) GetRequirements() effect.AlgRequirements {
-	return effect.AlgRequirements{}
+	return effect.AlgRequirements{
+		Description:	"Makes no light.",
+	}
 }
 
 func (d *darkness) Run(ctx context.Context, params effect.AlgParams) {
@@ -41,6 +58,15 @@ type blink struct {}
 func (b *blink) GetRequirements() effect.AlgRequirements {
 	return effect.AlgRequirements{
 		Parameters:	[]string{"blinkSpeed", "blinkDelay"},
+		Description:	"Each client blinks on its own schedule, out of sync with the others.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"blinkSpeed":	blinkSpeedInfo,
+			"blinkDelay": {
+				Description:	"pause between a client's blinks",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
 	}
 }
 
@@ -54,19 +80,18 @@ func (b *blink) Run(ctx context.Context, params effect.AlgParams) {
 			// and the changes aren't thread safe.
 			delay := *blinkDelay
 			delay.Reset()
-			clients := []types.ID{c}
+			pacer := client.NewPacer([]types.ID{c})
 
 			for ctx.Err() == nil {
-				dur := delay.Duration()
-				time.Sleep(dur)
+				pacer.Advance(ctx, delay.Duration())
 				cmd := &client.Blink{
 					Speed:	blinkSpeed.Float64(),
 					Delay:	0,
 					Jitter:	0,
 					Reps:	1,
 				}
-				client.Action(clients, ctx, cmd, time.Now())
-				time.Sleep(cmd.Duration())
+				pacer.Action(ctx, cmd)
+				pacer.Advance(ctx, cmd.Duration())
 			}
 		}()
 	}
@@ -81,6 +106,33 @@ type unison struct {}
 func (u *unison) GetRequirements() effect.AlgRequirements {
 	return effect.AlgRequirements{
 		Parameters:	[]string{"blinkSpeed", "blinkDelay", "blinkReps", "groupDelay", "groupReps"},
+		Description:	"All clients blink together, in groups of blinks.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"blinkSpeed":	blinkSpeedInfo,
+			"blinkDelay": {
+				Description:	"pause between blinks in a group; the variance is used as on-device jitter",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+				Default:	&random.Config{},
+			},
+			"blinkReps": {
+				Description:	"blinks per group",
+				Unit:		"count",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 1},
+			},
+			"groupDelay": {
+				Description:	"pause between groups",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+			"groupReps": {
+				Description:	"number of groups; 0 is treated as 1",
+				Unit:		"count",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 1},
+			},
+		},
 	}
 }
 
@@ -94,6 +146,7 @@ func (u *unison) Run(ctx context.Context, params effect.AlgParams) {
 		groupReps = 1
 	}
 
+	pacer := client.NewPacer(params.Clients)
 	for ctx.Err() == nil && groupReps > 0 {
 		cmd := &client.Blink{
 			Speed:	blinkSpeed.Float64(),
@@ -101,10 +154,492 @@ func (u *unison) Run(ctx context.Context, params effect.AlgParams) {
 			Jitter:	blinkDelay.VarianceDuration(),
 			Reps:	blinkReps.Int(),
 		}
-		client.Action(params.Clients, ctx, cmd, time.Now())
-		time.Sleep(cmd.Duration())
-		time.Sleep(groupDelay.Duration())
+		pacer.Action(ctx, cmd)
+		pacer.AdvanceQueued(ctx, lease.Light, cmd.Duration(), groupDelay.Duration())
 		groupReps--
 	}
 }
 
+
+// ---------------------------------------------------------------------
+
+// breathe makes crickets glow and dim slowly, like breathing.
+type breathe struct {}
+
+// The number of fades that approximate each breath's sine wave.
+const breathSteps = 16
+
+// The shortest fade a breath is made of, so a tiny period can't flood
+// the devices with requests.
+const minBreathStep = 50 * time.Millisecond
+
+func (b *breathe) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		Parameters:	[]string{"period", "depth", "phaseSpread"},
+		Description:	"Each client's light rises and falls smoothly, rather than blinking.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"period": {
+				Description:	"how long one breath takes",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+			"depth": {
+				Description:	"how far the light dims between breaths, from 0 (not at all) to 1 (fully off)",
+				Unit:		"fraction of full brightness",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 1},
+			},
+			"phaseSpread": {
+				Description:	"how far apart the clients' breaths are, from 0 (in sync) to 1 (spread over a whole breath)",
+				Unit:		"fraction of a breath",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 1},
+			},
+		},
+	}
+}
+
+func (b *breathe) Run(ctx context.Context, params effect.AlgParams) {
+	spread := min(max(params.Parameters["phaseSpread"].Float64(), 0), 1)
+	if spread == 0 {
+		// A single timeline, so a changing period can't pull
+		// the clients apart.
+		b.breathe(ctx, params, params.Clients, 0)
+		return
+	}
+
+	// Wait for every client's last fade to be sent, so it's sent
+	// before the clients are returned.
+	var wg sync.WaitGroup
+	for i, c := range params.Clients {
+		phase := spread * float64(i) / float64(len(params.Clients))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.breathe(ctx, params, []types.ID{c}, phase)
+		}()
+	}
+	wg.Wait()
+}
+
+// breathe runs breaths on some clients, starting the given fraction of
+// the way through a breath, until the context is done.
+func (b *breathe) breathe(ctx context.Context, params effect.AlgParams, clients []types.ID, phase float64) {
+	// The variables might be changing, and the changes aren't
+	// thread safe.
+	period := *params.Parameters["period"]
+	period.Reset()
+	depth := *params.Parameters["depth"]
+	depth.Reset()
+
+	pacer := client.NewPacer(clients)
+	for ctx.Err() == nil {
+		// Each breath gets its own period and depth.
+		step := max(period.Duration() / breathSteps, minBreathStep)
+		d := min(max(depth.Float64(), 0), 1)
+		for i := 0; i < breathSteps && ctx.Err() == nil; i++ {
+			phase += 1.0 / breathSteps
+			cmd := &client.Fade{
+				Level:	breathLevel(phase, d),
+				Over:	step,
+			}
+			pacer.Action(ctx, cmd)
+			pacer.AdvanceQueued(ctx, lease.Light, cmd.Duration(), 0)
+		}
+	}
+
+	// Don't leave the lights glowing.
+	client.Action(clients, context.Background(), &client.Fade{Over: time.Second}, time.Now())
+}
+
+// breathLevel returns the brightness at the given fraction of the way
+// through a breath. A breath starts and ends at its dimmest.
+func breathLevel(phase, depth float64) int {
+	dim := depth * (1 + math.Cos(2 * math.Pi * phase)) / 2
+	return int(math.Round(types.MaxBrightness * (1 - dim)))
+}
+
+// ---------------------------------------------------------------------
+
+// lightning makes clusters of rapid flashes on random groups of
+// crickets, and now and then on all of them.
+type lightning struct {}
+
+// LightningKey is the key of the message lightning sends on the bus for
+// each strike, so e.g. a thunder effect can follow it. The Value is the
+// fraction of the effect's clients that flashed.
+const LightningKey = "lightning"
+
+const (
+	// How fast a lightning flash ramps up and down; see client.Blink.
+	lightningSpeed = 32.0
+
+	// How long to wait before checking again when no strikes are due.
+	lightningIdle = time.Second
+)
+
+func (l *lightning) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		Parameters:	[]string{"strikeRate", "strikeSize", "fleetChance", "flashes", "flashDelay"},
+		Description:	"Strikes of rapid flashes on random groups of clients, and occasionally on all of them. The strike rate follows the shared intensity on the bus.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"strikeRate": {
+				Description:	"strikes per second at full intensity; use Changes to build the storm up and let it die away",
+				Unit:		"per second",
+				Kind:		random.CountKind,
+			},
+			"strikeSize": {
+				Description:	"the fraction of clients that flash in an ordinary strike; at least one always does",
+				Unit:		"fraction of clients",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 0.3},
+			},
+			"fleetChance": {
+				Description:	"the chance that a strike flashes every client",
+				Unit:		"probability",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 0.1},
+			},
+			"flashes": {
+				Description:	"flashes in each strike",
+				Unit:		"count",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 3, Variance: 1},
+			},
+			"flashDelay": {
+				Description:	"pause between a strike's flashes; the variance is used as on-device jitter",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+				Default:	&random.Config{Mean: 0.06, Variance: 0.04},
+			},
+		},
+	}
+}
+
+func (l *lightning) Run(ctx context.Context, params effect.AlgParams) {
+	strikeRate := params.Parameters["strikeRate"]
+	strikeSize := params.Parameters["strikeSize"]
+	fleetChance := params.Parameters["fleetChance"]
+	flashes := params.Parameters["flashes"]
+	flashDelay := params.Parameters["flashDelay"]
+
+	intensities := params.Bus.Subscribe(ctx, bus.IntensityKey)
+	intensity := 1.0
+
+	for ctx.Err() == nil {
+		wait := lightningIdle
+		if rate := strikeRate.Float64() * intensity; rate > 0 {
+			// Strikes arrive as a Poisson process.
+			wait = time.Duration(random.ExpFloat64() / rate * float64(time.Second))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case m := <-intensities:
+			// The wait is memoryless, so just start a new one
+			// at the new rate.
+			t.Stop()
+			intensity = min(max(m.Value, 0), 1)
+			continue
+		case <-t.C:
+		}
+
+		clients := params.Clients
+		if random.Float64() >= fleetChance.Float64() {
+			n := int(math.Round(strikeSize.Float64() * float64(len(clients))))
+			clients = pickClients(clients, min(max(n, 1), len(clients)))
+		}
+		cmd := &client.Blink{
+			Speed:	lightningSpeed,
+			Delay:	flashDelay.MeanDuration(),
+			Jitter:	flashDelay.VarianceDuration(),
+			Reps:	max(flashes.Int(), 1),
+		}
+		client.Action(clients, ctx, cmd, time.Now())
+		params.Publish(LightningKey, float64(len(clients)) / float64(len(params.Clients)))
+	}
+}
+
+// pickClients returns n of the given clients, chosen at random.
+func pickClients(clients []types.ID, n int) []types.ID {
+	picked := make([]types.ID, 0, n)
+	for _, i := range random.Perm(len(clients))[:n] {
+		picked = append(picked, clients[i])
+	}
+	return picked
+}
+
+// ---------------------------------------------------------------------
+
+// constellation keeps a sparse set of crickets twinkling dimly, and
+// slowly changes which ones. It's meant for long quiet periods, so it
+// keeps most lights off and the rest low.
+type constellation struct {}
+
+const (
+	// The shortest and longest fades that a twinkle is made of.
+	twinkleMin = 500 * time.Millisecond
+	twinkleMax = 2 * time.Second
+
+	// How long a star takes to fade out when it's replaced.
+	starFadeOut = 2 * time.Second
+)
+
+func (c *constellation) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		Parameters:	[]string{"activeFraction", "brightness", "dwell", "changeRate"},
+		Description:	"A few clients twinkle dimly, and which ones slowly changes; the rest stay dark.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"activeFraction": {
+				Description:	"the fraction of clients that are lit at once; at least one is",
+				Unit:		"fraction of clients",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 0.1},
+			},
+			"brightness": {
+				Description:	"how bright a twinkle gets",
+				Unit:		"fraction of full brightness",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 0.15},
+			},
+			"dwell": {
+				Description:	"the least time a client stays lit before it can be replaced",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+				Default:	&random.Config{Mean: 60},
+			},
+			"changeRate": {
+				Description:	"how often a lit client is replaced by a dark one",
+				Unit:		"per minute",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 1},
+			},
+		},
+	}
+}
+
+// A star is a client that's currently lit.
+type star struct {
+	id	types.ID
+	since	time.Time
+	dwell	time.Duration
+	cancel	context.CancelFunc
+}
+
+func (c *constellation) Run(ctx context.Context, params effect.AlgParams) {
+	activeFraction := params.Parameters["activeFraction"]
+	brightness := params.Parameters["brightness"]
+	dwell := params.Parameters["dwell"]
+	changeRate := params.Parameters["changeRate"]
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	light := func(id types.ID) *star {
+		starCtx, cancel := context.WithCancel(ctx)
+		level := min(max(brightness.Float64(), 0), 1) * types.MaxBrightness
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			twinkle(starCtx, id, int(math.Round(level)))
+		}()
+		return &star{id: id, since: time.Now(), dwell: dwell.Duration(), cancel: cancel}
+	}
+
+	order := random.Perm(len(params.Clients))
+	n := int(math.Round(activeFraction.Float64() * float64(len(order))))
+	n = min(max(n, 1), len(order))
+	stars := []*star{}
+	for _, i := range order[:n] {
+		stars = append(stars, light(params.Clients[i]))
+	}
+	dark := []types.ID{}
+	for _, i := range order[n:] {
+		dark = append(dark, params.Clients[i])
+	}
+
+	for ctx.Err() == nil {
+		wait := time.Minute
+		if rate := changeRate.Float64(); rate > 0 {
+			wait = time.Duration(random.ExpFloat64() / rate * float64(time.Minute))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if changeRate.Float64() <= 0 || len(dark) == 0 {
+			continue
+		}
+
+		// Replace the longest-lit star, if it's been lit long enough.
+		oldest := 0
+		for i, s := range stars {
+			if s.since.Before(stars[oldest].since) {
+				oldest = i
+			}
+		}
+		s := stars[oldest]
+		if time.Since(s.since) < s.dwell {
+			continue
+		}
+		s.cancel()
+		j := random.IntN(len(dark))
+		stars[oldest] = light(dark[j])
+		dark[j] = s.id
+	}
+}
+
+// twinkle makes a client's light wander at random between half of level
+// and level, until the context is done, and then fades it out.
+func twinkle(ctx context.Context, id types.ID, level int) {
+	pacer := client.NewPacer([]types.ID{id})
+	for ctx.Err() == nil {
+		cmd := &client.Fade{
+			Level:	level / 2 + random.IntN(level / 2 + 1),
+			Over:	twinkleMin + time.Duration(random.Int64N(int64(twinkleMax - twinkleMin))),
+		}
+		pacer.Action(ctx, cmd)
+		pacer.AdvanceQueued(ctx, lease.Light, cmd.Duration(), 0)
+	}
+	client.Action([]types.ID{id}, context.Background(), &client.Fade{Over: starFadeOut}, time.Now())
+}
+
+// ---------------------------------------------------------------------
+
+// gradient pulses crickets faster and brighter the nearer they are to a
+// focus point, which can move around the installation.
+type gradient struct {}
+
+const (
+	// How often the focus and intensity are sampled.
+	gradientTick = 250 * time.Millisecond
+
+	// Clients whose share of the intensity is less than this stay dark.
+	gradientCutoff = 0.05
+)
+
+func (g *gradient) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		Parameters:	[]string{"focusX", "focusY", "radius", "intensity", "period"},
+		Description:	"Clients pulse faster and brighter the nearer they are to a focus point; use Changes on the focus to sweep it around the installation. The intensity is also scaled by the shared intensity on the bus.",
+		ParamInfo:	map[string]effect.ParamInfo{
+			"focusX": {
+				Description:	"X coordinate of the focus, in the same terms as the clients' locations",
+				Unit:		"meters",
+				Kind:		random.AnyKind,
+			},
+			"focusY": {
+				Description:	"Y coordinate of the focus",
+				Unit:		"meters",
+				Kind:		random.AnyKind,
+			},
+			"radius": {
+				Description:	"how far from the focus the activity reaches, fading out linearly",
+				Unit:		"meters",
+				Kind:		random.CountKind,
+			},
+			"intensity": {
+				Description:	"the brightness and speed at the focus, from 0 to 1",
+				Unit:		"fraction",
+				Kind:		random.AnyKind,
+				Default:	&random.Config{Mean: 1},
+			},
+			"period": {
+				Description:	"time between pulses at the focus at full intensity; pulses further out are slower",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
+	}
+}
+
+// gradientState is what the clients' goroutines need to know about the
+// focus, as of the last sample.
+type gradientState struct {
+	mu		sync.Mutex
+	focus		types.PhysLocation
+	radius		float64
+	intensity	float64
+}
+
+// weight returns how much of the intensity a client at loc gets.
+func (s *gradientState) weight(loc types.PhysLocation) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.radius <= 0 {
+		return 0
+	}
+	return s.intensity * max(1 - space.Distance(loc, s.focus) / s.radius, 0)
+}
+
+func (g *gradient) Run(ctx context.Context, params effect.AlgParams) {
+	focusX := params.Parameters["focusX"]
+	focusY := params.Parameters["focusY"]
+	radius := params.Parameters["radius"]
+	intensity := params.Parameters["intensity"]
+
+	locations := client.Locations(params.Clients)
+
+	state := &gradientState{}
+	shared := 1.0
+	sample := func() {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		state.focus = types.PhysLocation{X: focusX.Float64(), Y: focusY.Float64()}
+		state.radius = radius.Float64()
+		state.intensity = min(intensity.Float64(), 1) * shared
+	}
+	sample()
+
+	var wg sync.WaitGroup
+	for _, c := range params.Clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.pulse(ctx, params, c, locations[c], state)
+		}()
+	}
+
+	intensities := params.Bus.Subscribe(ctx, bus.IntensityKey)
+	ticker := time.NewTicker(gradientTick)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case m := <-intensities:
+			shared = min(max(m.Value, 0), 1)
+		case <-ticker.C:
+			sample()
+		}
+	}
+	wg.Wait()
+}
+
+// pulse makes one client pulse according to its share of the intensity,
+// until the context is done.
+func (g *gradient) pulse(ctx context.Context, params effect.AlgParams, id types.ID, loc types.PhysLocation, state *gradientState) {
+	// The period might be a changing variable, and the changes
+	// aren't thread safe.
+	period := *params.Parameters["period"]
+	period.Reset()
+	pacer := client.NewPacer([]types.ID{id})
+
+	for ctx.Err() == nil {
+		w := state.weight(loc)
+		if w < gradientCutoff {
+			pacer.Advance(ctx, gradientTick)
+			continue
+		}
+		// A pulse is a rise and a fall, each half of the period.
+		half := max(time.Duration(float64(period.Duration()) / w), 2 * minBreathStep) / 2
+		level := int(math.Round(w * types.MaxBrightness))
+		for _, cmd := range []*client.Fade{{Level: level, Over: half}, {Level: 0, Over: half}} {
+			pacer.Action(ctx, cmd)
+			pacer.AdvanceQueued(ctx, lease.Light, cmd.Duration(), 0)
+		}
+	}
+}
@@ -11,28 +11,39 @@ import (
 	zeroconf "github.com/libp2p/zeroconf/v2"
 )
 
-func Start() {
-	go resolver()
+// Start looks for clients on the network, and adds the ones in the given
+// fleet namespace; see types.Capabilities. Clients in other namespaces
+// belong to other installations sharing the network, and are left alone.
+// The empty namespace is that of clients that don't advertise one.
+func Start(namespace string) {
+	go resolver(namespace)
 }
 
-func resolver() {
+func resolver(namespace string) {
 	entries := make(chan *zeroconf.ServiceEntry)
 
 	go func(results <-chan *zeroconf.ServiceEntry) {
+		foreign := make(map[types.ID]bool)
 		for entry := range results {
-			if len(entry.AddrIPv4) < 1 {
+			id, ok := parseInstance(entry.Instance)
+			if !ok {
 				continue
 			}
-			s := strings.Split(entry.Instance, " ")
-			if len(s) < 2 || !strings.HasPrefix(s[0], "Cricket") {
+
+			caps := types.ParseCapabilities(entry.Text)
+			if caps.Namespace != namespace {
+				if !foreign[id] {
+					log.Infof("ignoring client %q in fleet namespace %q", id, caps.Namespace)
+					foreign[id] = true
+				}
 				continue
 			}
-			id := types.ID(s[1])
-			loc := types.NetLocation{
-				Address: entry.AddrIPv4[0],
-				Port:    entry.Port,
+
+			locs := addresses(entry)
+			if len(locs) == 0 {
+				continue
 			}
-			client.Add(id, loc)
+			client.Add(id, locs, caps)
 		}
 	}(entries)
 
@@ -43,3 +54,34 @@ func resolver() {
 	}
 	<-ctx.Done()	// should not be reached
 }
+
+// parseInstance extracts the client ID from an mDNS instance name of the
+// form "Cricket ID". Anything else on the network is ignored, as is an
+// invalid ID.
+func parseInstance(instance string) (types.ID, bool) {
+	// Some resolvers leave the DNS escaping in place.
+	s := strings.Fields(strings.ReplaceAll(instance, "\\ ", " "))
+	if len(s) < 2 || !strings.HasPrefix(s[0], "Cricket") {
+		return "", false
+	}
+	id := types.ID(s[1])
+	return id, id.Valid()
+}
+
+// addresses returns all of an entry's addresses, most preferred first.
+// IPv4 comes first, and link-local IPv6 last, since the entry doesn't
+// say which interface a link-local address is on.
+func addresses(entry *zeroconf.ServiceEntry) []types.NetLocation {
+	locs := []types.NetLocation{}
+	for _, a := range entry.AddrIPv4 {
+		locs = append(locs, types.NetLocation{Address: a, Port: entry.Port})
+	}
+	for _, linkLocal := range []bool{false, true} {
+		for _, a := range entry.AddrIPv6 {
+			if a.IsLinkLocalUnicast() == linkLocal {
+				locs = append(locs, types.NetLocation{Address: a, Port: entry.Port})
+			}
+		}
+	}
+	return locs
+}
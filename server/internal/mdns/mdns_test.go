@@ -0,0 +1,24 @@
+package mdns
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParseInstance(f *testing.F) {
+	for _, s := range []string{"Cricket abc123", "Cricket\\ abc123", "Cricket", "Printer abc", "", "Cricket a\x00b", "Cricket  x y"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, instance string) {
+		id, ok := parseInstance(instance)
+		if !ok {
+			return
+		}
+		if !id.Valid() {
+			t.Errorf("parseInstance(%q) accepted invalid ID %q", instance, id)
+		}
+		if !strings.Contains(instance, string(id)) {
+			t.Errorf("parseInstance(%q) = %q, not part of the name", instance, id)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+// Package occupancy holds how many visitors are at the installation, as
+// reported by a feed such as a door counter's webhook, so that effects
+// can sound fuller when there's a crowd and more intimate when it's
+// nearly empty.
+//
+// The count works like the intensity package's knob. It's turned into a
+// level from 0 (empty) to 1 (at capacity), and a parameter with crowd
+// sensitivity s is multiplied by 2^(s * (2 * level - 1)): with s = 1, it
+// doubles at capacity and halves when empty. Half capacity leaves
+// parameters as configured, as does a count that's never been reported
+// or that's gone stale because the feed stopped.
+package occupancy
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Config describes the visitor count feed.
+type Config struct {
+	// How many visitors make the installation as full as it gets.
+	Capacity	int
+
+	// If nonzero, a count that hasn't been updated for this many
+	// minutes is ignored.
+	StaleMinutes	float64
+}
+
+// Check returns an error if the configuration is invalid.
+func (c Config) Check() error {
+	if c.Capacity <= 0 {
+		return fmt.Errorf("occupancy capacity %d must be positive", c.Capacity)
+	}
+	if c.StaleMinutes < 0 {
+		return fmt.Errorf("occupancy stale time %v must not be negative", c.StaleMinutes)
+	}
+	return nil
+}
+
+// Count is the latest visitor count.
+type Count struct {
+	Visitors	int
+	Level		float64		// see the package comment
+	Updated		time.Time	// zero if never reported
+	Stale		bool
+}
+
+var occupancy struct {
+	mu		sync.Mutex
+	configured	bool
+	capacity	int
+	stale		time.Duration
+	visitors	int
+	updated		time.Time
+}
+
+// Configure sets up the count, which can't be reported until this is
+// called. The configuration must have been checked with Check.
+func Configure(c Config) {
+	occupancy.mu.Lock()
+	defer occupancy.mu.Unlock()
+	occupancy.configured = true
+	occupancy.capacity = c.Capacity
+	occupancy.stale = time.Duration(c.StaleMinutes * float64(time.Minute))
+}
+
+// Set reports how many visitors there are.
+func Set(visitors int) error {
+	occupancy.mu.Lock()
+	defer occupancy.mu.Unlock()
+	if !occupancy.configured {
+		return fmt.Errorf("no occupancy feed is configured")
+	}
+	if visitors < 0 {
+		return fmt.Errorf("visitor count %d must not be negative", visitors)
+	}
+	setLocked(visitors)
+	return nil
+}
+
+// Adjust reports visitors coming in (a positive delta) or going out (a
+// negative one), for counters that report each crossing. The count
+// doesn't go below zero, since counters miss people.
+func Adjust(delta int) error {
+	occupancy.mu.Lock()
+	defer occupancy.mu.Unlock()
+	if !occupancy.configured {
+		return fmt.Errorf("no occupancy feed is configured")
+	}
+	setLocked(max(occupancy.visitors + delta, 0))
+	return nil
+}
+
+// setLocked records a new count. The caller must hold occupancy.mu.
+func setLocked(visitors int) {
+	if visitors != occupancy.visitors {
+		log.Infof("visitor count is now %d", visitors)
+	}
+	occupancy.visitors = visitors
+	occupancy.updated = time.Now()
+}
+
+// Get returns the latest count.
+func Get() Count {
+	occupancy.mu.Lock()
+	defer occupancy.mu.Unlock()
+	level, ok := levelLocked()
+	return Count{
+		Visitors:	occupancy.visitors,
+		Level:		level,
+		Updated:	occupancy.updated,
+		Stale:		!ok && !occupancy.updated.IsZero(),
+	}
+}
+
+// levelLocked returns the level of the count, or the neutral level and
+// false if there's no count to go by. The caller must hold occupancy.mu.
+func levelLocked() (float64, bool) {
+	if !occupancy.configured || occupancy.updated.IsZero() {
+		return 0.5, false
+	}
+	if occupancy.stale > 0 && time.Since(occupancy.updated) > occupancy.stale {
+		return 0.5, false
+	}
+	return min(float64(occupancy.visitors) / float64(occupancy.capacity), 1), true
+}
+
+// Factor returns what to multiply a parameter with the given crowd
+// sensitivity by, at the latest count.
+func Factor(sensitivity float64) float64 {
+	occupancy.mu.Lock()
+	level, _ := levelLocked()
+	occupancy.mu.Unlock()
+	return math.Exp2(sensitivity * (2 * level - 1))
+}
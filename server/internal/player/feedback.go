@@ -0,0 +1,142 @@
+package player
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operators can tell a player they want more or less of an effect, or
+// none of it for the rest of the session. Each vote multiplies the
+// effect's weight by a factor that shrinks with every vote on the same
+// effect, like the cooling schedule of simulated annealing: the first
+// few votes make big changes, and later ones fine-tune.
+
+// A Vote is an operator's opinion of an effect.
+type Vote int
+const (
+	More	Vote = iota
+	Less
+	Never	// don't run the effect again this session
+	Reset	// forget the votes on the effect
+)
+
+const (
+	// How much the first vote changes an effect's weight, as a
+	// fraction of the weight.
+	feedbackStep = 0.5
+
+	// How much each vote on an effect shrinks the next one's step.
+	feedbackCooling = 0.7
+)
+
+// ParseVote parses "more", "less", "never", or "reset".
+func ParseVote(s string) (Vote, error) {
+	switch strings.ToLower(s) {
+	case "more":
+		return More, nil
+	case "less":
+		return Less, nil
+	case "never":
+		return Never, nil
+	case "reset":
+		return Reset, nil
+	}
+	return 0, fmt.Errorf("unknown vote %q (want more, less, never, or reset)", s)
+}
+
+// Feedback is the result of the votes on an effect.
+type Feedback struct {
+	Factor	float64	// multiplies the effect's weight
+	Never	bool
+	Votes	int
+}
+
+// weight scales a weight by the feedback.
+func (f *Feedback) weight(w float64) float64 {
+	if f == nil {
+		return w
+	}
+	if f.Never {
+		return 0
+	}
+	return w * f.Factor
+}
+
+// Vote records an operator's vote on the named effect, or on the effect
+// the player started most recently if name is empty. It returns the
+// effect's name and its feedback so far.
+func (p *Player) Vote(name string, v Vote) (string, Feedback, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == "" {
+		name = p.lastStarted
+		if name == "" {
+			return "", Feedback{}, fmt.Errorf("%v player hasn't started any effects", p.ty)
+		}
+	}
+	if !p.hasEffect(name) {
+		return "", Feedback{}, fmt.Errorf("%v player has no effect %q", p.ty, name)
+	}
+
+	f, ok := p.feedback[name]
+	if !ok {
+		f = &Feedback{Factor: 1}
+		p.feedback[name] = f
+	}
+	step := feedbackStep
+	for range f.Votes {
+		step *= feedbackCooling
+	}
+	switch v {
+	case More:
+		f.Factor *= 1 + step
+	case Less:
+		f.Factor /= 1 + step
+	case Never:
+		f.Never = true
+	case Reset:
+		delete(p.feedback, name)
+		return name, Feedback{Factor: 1}, nil
+	}
+	f.Votes++
+	return name, *f, nil
+}
+
+// Feedback returns the feedback on each effect that has any.
+func (p *Player) Feedback() map[string]Feedback {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make(map[string]Feedback)
+	for name, f := range p.feedback {
+		result[name] = *f
+	}
+	return result
+}
+
+// SetFeedback replaces the feedback on every effect, e.g. with saved
+// feedback from earlier in the session. Effects the player doesn't have
+// are ignored.
+func (p *Player) SetFeedback(fb map[string]Feedback) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.feedback = make(map[string]*Feedback)
+	for name, f := range fb {
+		if p.hasEffect(name) {
+			p.feedback[name] = &f
+		}
+	}
+}
+
+func (p *Player) hasEffect(name string) bool {
+	for _, e := range p.effects {
+		if e.name == name {
+			return true
+		}
+	}
+	for _, r := range p.rare {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
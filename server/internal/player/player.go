@@ -2,19 +2,43 @@ package player
 
 import (
 	"fmt"
-	"math/rand/v2"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/intensity"
 	"github.com/blakej11/cricket/internal/lease"
 	"github.com/blakej11/cricket/internal/log"
-	"github.com/blakej11/cricket/internal/random"
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 type Config struct {
 	StartupDelay	random.Config
 	Delay		random.Config
 	Weights		map[string]float64
+
+	// Effects can also be chosen by tag. Each key is a tag expression
+	// (see effect.TagExpr), and every effect it matches gets its weight,
+	// unless the effect is named in Weights. An effect matched by more
+	// than one expression gets the largest of their weights.
+	TagWeights	map[string]float64
+
+	// Rare effects are run on their own schedules, alongside the
+	// weighted choices above. Each maps an effect name to the time
+	// between runs, in seconds; an exponential distribution makes
+	// them happen at random, on average Mean seconds apart. A rare
+	// effect's lease usually wants just MinClients (with no
+	// FleetFraction) and a short MaxWait, so that it gets a handful
+	// of clients right away or else skips that run.
+	Rare		map[string]random.Config
+
+	// How much the delays between effects, and between runs of rare
+	// effects, follow the installation's intensity; see the intensity
+	// package. This is usually negative, so that effects come closer
+	// together as the intensity rises.
+	DelaySensitivity	float64
 }
 
 // ---------------------------------------------------------------------
@@ -26,11 +50,27 @@ type weightedEffect struct {
 	effect		*effect.Effect
 }
 
+type rareEffect struct {
+	name		string
+	interval	*random.Variable
+	effect		*effect.Effect
+}
+
 type Player struct {
 	ty		lease.Type
 	startupDelay	*random.Variable
 	delay		*random.Variable
 	effects		[]*weightedEffect
+	rare		[]*rareEffect
+
+	// Operators' feedback, which the admin API changes while the
+	// player runs; see Vote.
+	mu		sync.Mutex
+	feedback	map[string]*Feedback
+	lastStarted	string
+
+	// Whether the player is holding off on starting effects; see Hold.
+	held		bool
 }
 
 func New(ty lease.Type, config Config, effects map[string]*effect.Effect) (*Player, error) {
@@ -39,6 +79,10 @@ func New(ty lease.Type, config Config, effects map[string]*effect.Effect) (*Play
 		startupDelay:	random.New(config.StartupDelay),
 		delay:		random.New(config.Delay),
 		effects:	[]*weightedEffect{},
+		feedback:	make(map[string]*Feedback),
+	}
+	if config.DelaySensitivity != 0 {
+		player.delay.ScaleBy(intensity.Scale(config.DelaySensitivity))
 	}
 
 	for name, weight := range config.Weights {
@@ -53,28 +97,163 @@ func New(ty lease.Type, config Config, effects map[string]*effect.Effect) (*Play
 		})
 	}
 
+	tagged, err := tagWeights(config.TagWeights, effects)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(tagged)) {
+		if _, ok := config.Weights[name]; ok {
+			continue
+		}
+		player.effects = append(player.effects, &weightedEffect{
+			name:		name,
+			baseWeight:	tagged[name],
+			weight:		tagged[name],
+			effect:		effects[name],
+		})
+	}
+
+	for name, interval := range config.Rare {
+		if _, ok := effects[name]; !ok {
+			return nil, fmt.Errorf("player couldn't find rare effect named %q", name)
+		}
+		if err := random.DurationKind.Check(interval); err != nil {
+			return nil, fmt.Errorf("rare effect %q interval: %w", name, err)
+		}
+		r := &rareEffect{
+			name:		name,
+			interval:	random.New(interval),
+			effect:		effects[name],
+		}
+		if config.DelaySensitivity != 0 {
+			r.interval.ScaleBy(intensity.Scale(config.DelaySensitivity))
+		}
+		player.rare = append(player.rare, r)
+	}
+
 	return player, nil
 }
 
+// tagWeights returns the weight of each effect selected by a tag expression.
+func tagWeights(exprs map[string]float64, effects map[string]*effect.Effect) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for text, weight := range exprs {
+		expr, err := effect.ParseTagExpr(text)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for name, e := range effects {
+			if !expr.Match(e.Tags()) {
+				continue
+			}
+			matched = true
+			if w, ok := weights[name]; !ok || weight > w {
+				weights[name] = weight
+			}
+		}
+		if !matched {
+			log.Warningf("tag expression %q doesn't match any effects", text)
+		}
+	}
+	return weights, nil
+}
+
+// Weights returns the weight of each effect, as configured or as last
+// set by SetWeights.
+func (p *Player) Weights() map[string]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w := make(map[string]float64)
+	for _, e := range p.effects {
+		w[e.name] = e.baseWeight
+	}
+	return w
+}
+
+// SetWeights changes the weights of the named effects. Effects that
+// aren't named keep their weights, and names of effects that the player
+// doesn't have are ignored.
+func (p *Player) SetWeights(weights map[string]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.effects {
+		if w, ok := weights[e.name]; ok {
+			e.baseWeight = max(w, 0)
+			e.weight = e.baseWeight
+		}
+	}
+}
+
 func (p *Player) Start() {
 	go p.start()
+	for _, r := range p.rare {
+		go p.startRare(r)
+	}
+}
+
+// Hold stops the player from starting any more effects, until Release.
+// Effects that are already running carry on.
+func (p *Player) Hold() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.held = true
 }
 
+// Release undoes Hold.
+func (p *Player) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.held = false
+}
+
+// pickEffect picks one of the player's effects to run, or nil if it
+// shouldn't run one now. Effects that the weather suppresses aren't
+// picked.
 func (p *Player) pickEffect() *weightedEffect {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.held {
+		return nil
+	}
+	weights := make([]float64, len(p.effects))
 	sum := 0.0
-	for _, e := range p.effects {
-		sum += e.weight
+	for i, e := range p.effects {
+		if e.effect.Suppressed() != nil {
+			continue
+		}
+		weights[i] = p.feedback[e.name].weight(e.weight)
+		sum += weights[i]
 	}
-	target := rand.Float64() * sum
-	for _, e := range p.effects {
-		target -= e.weight
-		if target <= 0.0 {
+	if sum == 0 {
+		return nil
+	}
+	target := random.Float64() * sum
+	for i, e := range p.effects {
+		target -= weights[i]
+		if target <= 0.0 && weights[i] > 0 {
 			return e
 		}
 	}
 	return nil
 }
 
+// started notes that an effect has been started, for Vote.
+func (p *Player) started(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastStarted = name
+}
+
+// vetoed returns whether operators never want the named effect again,
+// or don't want it right now because the player is held.
+func (p *Player) vetoed(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.feedback[name]
+	return p.held || (ok && f.Never)
+}
+
 func (p *Player) start() {
 	startupDelay := p.startupDelay.Float64()
 	if startupDelay > 0 {
@@ -88,11 +267,16 @@ func (p *Player) start() {
 		if eff != nil {
 			err := eff.effect.Run()
 			log.Infof("running %v effect %q returned %v", p.ty, eff.name, err)
+			if err == nil {
+				p.started(eff.name)
+			}
+			p.mu.Lock()
 			if err == nil {
 				eff.weight = eff.baseWeight
 			} else {
 				eff.weight++
 			}
+			p.mu.Unlock()
 		}
 
 		// don't just spin-loop if no delay is configured
@@ -101,6 +285,25 @@ func (p *Player) start() {
 	}
 }
 
+// startRare runs a rare effect every so often. If there aren't enough
+// clients free when it's time, that run is skipped.
+func (p *Player) startRare(r *rareEffect) {
+	for {
+		// don't spin-loop if no interval is configured
+		dur := max(r.interval.Duration(), time.Second)
+		time.Sleep(dur)
+
+		if p.vetoed(r.name) {
+			continue
+		}
+		err := r.effect.Run()
+		log.Infof("running rare %v effect %q returned %v", p.ty, r.name, err)
+		if err == nil {
+			p.started(r.name)
+		}
+	}
+}
+
 // - have some bags of Effects (non-partial, partial, "use 'the rest'"), fully
 //   specified
 // - allow algs to say "only do one of me at a time" (e.g. owls)
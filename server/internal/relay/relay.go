@@ -0,0 +1,212 @@
+// Package relay drives GPIO output lines on the server's host, e.g. a
+// Raspberry Pi, in response to what the installation is doing, so that
+// relays for fog machines, lights, or other show control equipment can
+// follow along without any extra software.
+//
+// Each output line follows one thing: an effect (on while it runs), a
+// message on the effect bus (a pulse for each one), or the
+// installation's intensity (on while it's above a threshold).
+package relay
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/bus"
+	"github.com/blakej11/cricket/internal/effect"
+	"github.com/blakej11/cricket/internal/intensity"
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Config describes an output line, and what turns it on. Exactly one of
+// Effect, Message, and IntensityAbove must be set.
+type Config struct {
+	// The sysfs value file of a GPIO line, e.g.
+	// "/sys/class/gpio/gpio27/value", which must already be exported
+	// as an output. "1" turns it on, or "0" if ActiveLow is set.
+	GPIO		string
+	ActiveLow	bool
+
+	// On while the named effect is running, or for Pulse seconds
+	// when it starts, if Pulse is set.
+	Effect		string
+
+	// On for Pulse seconds (by default, defaultPulse) after each
+	// message with this key on the effect bus, e.g. "thunderclap".
+	Message		string
+
+	// On while the installation's intensity is above this.
+	IntensityAbove	*float64
+
+	Pulse		float64
+}
+
+const (
+	// How long a message turns a line on for, if Pulse isn't set.
+	defaultPulse = time.Second
+
+	// How often a line that follows the intensity looks at it.
+	intensityPoll = time.Second
+)
+
+// Check returns an error if the configuration is invalid.
+func (c Config) Check() error {
+	if c.GPIO == "" {
+		return fmt.Errorf("output needs a GPIO value file")
+	}
+	set := 0
+	if c.Effect != "" {
+		set++
+	}
+	if c.Message != "" {
+		set++
+	}
+	if c.IntensityAbove != nil {
+		set++
+		if t := *c.IntensityAbove; t < 0 || t >= 1 {
+			return fmt.Errorf("output %q: intensity threshold %v must be in [0, 1)", c.GPIO, t)
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("output %q must follow exactly one of Effect, Message, and IntensityAbove", c.GPIO)
+	}
+	if c.Pulse < 0 {
+		return fmt.Errorf("output %q: pulse %v must not be negative", c.GPIO, c.Pulse)
+	}
+	return nil
+}
+
+// Start turns the output lines off, and then has them follow what they're
+// configured to. The configurations must have been checked with Check.
+func Start(configs []Config) {
+	for _, c := range configs {
+		l := &line{path: c.GPIO, activeLow: c.ActiveLow}
+		l.set(false)
+		switch {
+		case c.Effect != "":
+			go followEffect(l, c.Effect, seconds(c.Pulse))
+		case c.Message != "":
+			pulse := seconds(c.Pulse)
+			if pulse == 0 {
+				pulse = defaultPulse
+			}
+			go followMessages(l, c.Message, pulse)
+		case c.IntensityAbove != nil:
+			go followIntensity(l, *c.IntensityAbove)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// followEffect turns the line on while the effect runs, or pulses it
+// when the effect starts.
+func followEffect(l *line, name string, pulse time.Duration) {
+	started := bus.Shared.Subscribe(context.Background(), effect.StartedKey)
+	finished := bus.Shared.Subscribe(context.Background(), effect.FinishedKey)
+	running := 0
+	for {
+		select {
+		case m := <-started:
+			if m.Sender != name {
+				continue
+			}
+			if pulse > 0 {
+				l.pulse(pulse)
+				continue
+			}
+			running++
+		case m := <-finished:
+			if m.Sender != name || pulse > 0 {
+				continue
+			}
+			running = max(running - 1, 0)
+		}
+		l.set(running > 0)
+	}
+}
+
+// followMessages pulses the line for each message with the given key.
+func followMessages(l *line, key string, pulse time.Duration) {
+	for range bus.Shared.Subscribe(context.Background(), key) {
+		l.pulse(pulse)
+	}
+}
+
+// followIntensity turns the line on while the intensity is above the
+// threshold.
+func followIntensity(l *line, threshold float64) {
+	for ; ; time.Sleep(intensityPoll) {
+		l.set(intensity.Level() > threshold)
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// line is a GPIO output line.
+type line struct {
+	path		string
+	activeLow	bool
+
+	mu		sync.Mutex
+	on		bool
+	written		bool	// whether on has been written yet
+	pulseSeq	uint64	// of the latest pulse, to end only that one
+	failing		bool
+}
+
+// set turns the line on or off, ending any pulse.
+func (l *line) set(on bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pulseSeq++
+	l.writeLocked(on)
+}
+
+// pulse turns the line on for the given time, or until set is called.
+// A pulse that starts during another one extends it.
+func (l *line) pulse(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pulseSeq++
+	seq := l.pulseSeq
+	l.writeLocked(true)
+	time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.pulseSeq == seq {
+			l.writeLocked(false)
+		}
+	})
+}
+
+// writeLocked writes the line's value, if it's changed. The caller must
+// hold l.mu.
+func (l *line) writeLocked(on bool) {
+	if l.written && l.on == on {
+		return
+	}
+	value := on != l.activeLow
+	b := []byte("0")
+	if value {
+		b = []byte("1")
+	}
+	if err := os.WriteFile(l.path, b, 0); err != nil {
+		if !l.failing {
+			log.Errorf("can't write output GPIO: %v", err)
+			l.failing = true
+		}
+		return
+	}
+	if l.failing {
+		log.Infof("output GPIO %q is writable again", l.path)
+		l.failing = false
+	}
+	l.on = on
+	l.written = true
+}
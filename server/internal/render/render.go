@@ -0,0 +1,154 @@
+// Package render mixes what a virtual fleet was asked to play into a
+// stereo recording, so a soundscape can be auditioned away from the
+// installation. Each client is panned according to where it is, from
+// left to right along the X axis.
+//
+// The mix is only approximate: there's no sense of distance or of the
+// room, and the crickets' own speakers sound nothing like headphones.
+package render
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/virtual"
+)
+
+// Config describes a recording.
+type Config struct {
+	// A directory holding decoded copies of the clients' files, laid
+	// out as on their SD cards, e.g. "01/002.wav" for folder 1, file 2.
+	// They must be 16-bit PCM.
+	AudioDir	string
+
+	Rate		int		// samples per second
+	Start		time.Time	// the start of the recording
+	Length		time.Duration
+}
+
+// The loudest the mix may get before it's scaled down.
+const headroom = 0.95
+
+// Mix writes a WAV recording of the given clients' sounds, panned
+// according to their locations.
+func Mix(w io.Writer, c Config, sounds map[types.ID][]virtual.Sound, locs map[types.ID]types.PhysLocation) error {
+	if c.Rate <= 0 {
+		return fmt.Errorf("rate %d must be positive", c.Rate)
+	}
+	n := int(c.Length.Seconds() * float64(c.Rate))
+	left := make([]float64, n)
+	right := make([]float64, n)
+
+	pan := panner(locs)
+	files := make(map[[2]int][]float64)
+	missing := make(map[[2]int]bool)
+	for id, ss := range sounds {
+		l, r := pan(id)
+		for _, s := range ss {
+			key := [2]int{s.Folder, s.File}
+			if _, ok := files[key]; !ok && !missing[key] {
+				samples, err := readWAV(audioPath(c.AudioDir, s.Folder, s.File), c.Rate)
+				if err != nil {
+					log.Warningf("leaving out folder %d file %d: %v", s.Folder, s.File, err)
+					missing[key] = true
+					continue
+				}
+				files[key] = samples
+			}
+			if missing[key] {
+				continue
+			}
+			gain := volumeGain(s.Volume)
+			for _, at := range repStarts(s) {
+				mixIn(left, right, files[key], c.sampleAt(at), c.sampleAt(s.Cut), gain * l, gain * r)
+			}
+		}
+	}
+
+	peak := 0.0
+	for i := range left {
+		peak = max(peak, math.Abs(left[i]), math.Abs(right[i]))
+	}
+	if peak > headroom {
+		scale := headroom / peak
+		log.Infof("scaling the mix by %.2f to avoid clipping", scale)
+		for i := range left {
+			left[i] *= scale
+			right[i] *= scale
+		}
+	}
+	return writeWAV(w, left, right, c.Rate)
+}
+
+// sampleAt returns the index of the sample at the given time, or -1 if
+// the time is zero.
+func (c Config) sampleAt(t time.Time) int {
+	if t.IsZero() {
+		return -1
+	}
+	return int(t.Sub(c.Start).Seconds() * float64(c.Rate))
+}
+
+func audioPath(dir string, folder, file int) string {
+	return filepath.Join(dir, fmt.Sprintf("%02d", folder), fmt.Sprintf("%03d.wav", file))
+}
+
+// repStarts returns when each of a sound's reps starts, with jitter
+// chosen the way the device would choose it.
+func repStarts(s virtual.Sound) []time.Time {
+	starts := []time.Time{}
+	at := s.Start
+	for i := 0; i < s.Reps; i++ {
+		starts = append(starts, at)
+		at = at.Add(s.Duration + s.Delay)
+		if s.Jitter > 0 {
+			at = at.Add(rand.N(s.Jitter))
+		}
+	}
+	return starts
+}
+
+// mixIn adds samples to the mix starting at the given index, stopping at
+// cut if it's not negative.
+func mixIn(left, right, samples []float64, start, cut int, l, r float64) {
+	end := start + len(samples)
+	if cut >= 0 {
+		end = min(end, cut)
+	}
+	for i := max(start, 0); i < min(end, len(left)); i++ {
+		v := samples[i - start]
+		left[i] += v * l
+		right[i] += v * r
+	}
+}
+
+// volumeGain approximates how loud a device's volume setting is. Volume
+// steps sound roughly even, so the gain is taken to go as the square.
+func volumeGain(volume int) float64 {
+	v := float64(volume) / types.MaxVolume
+	return v * v
+}
+
+// panner returns a function giving each client's left and right gains,
+// using equal-power panning across the span of the clients' locations.
+func panner(locs map[types.ID]types.PhysLocation) func(types.ID) (float64, float64) {
+	all := []types.PhysLocation{}
+	for _, l := range locs {
+		all = append(all, l)
+	}
+	lo, hi, ok := space.Bounds(all)
+	return func(id types.ID) (float64, float64) {
+		p := 0.5
+		if loc, found := locs[id]; found && ok && hi.X > lo.X {
+			p = (loc.X - lo.X) / (hi.X - lo.X)
+		}
+		return math.Cos(p * math.Pi / 2), math.Sin(p * math.Pi / 2)
+	}
+}
@@ -0,0 +1,141 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// readWAV reads a 16-bit PCM WAV file, and returns its samples mixed
+// down to mono and resampled to the given rate, scaled to [-1, 1].
+func readWAV(path string, rate int) ([]float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%q isn't a WAV file", path)
+	}
+
+	var format, channels, bits uint16
+	var fileRate uint32
+	var data []byte
+	for r := bytes.NewReader(b[12:]); ; {
+		var hdr struct {
+			ID	[4]byte
+			Size	uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%q: %w", path, err)
+		}
+		chunk := make([]byte, hdr.Size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, fmt.Errorf("%q: short %q chunk: %w", path, hdr.ID[:], err)
+		}
+		if hdr.Size % 2 == 1 {
+			r.ReadByte()	// chunks are padded to an even size
+		}
+		switch string(hdr.ID[:]) {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, fmt.Errorf("%q: short format chunk", path)
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:])
+			channels = binary.LittleEndian.Uint16(chunk[2:])
+			fileRate = binary.LittleEndian.Uint32(chunk[4:])
+			bits = binary.LittleEndian.Uint16(chunk[14:])
+		case "data":
+			data = chunk
+		}
+	}
+	if format != 1 || bits != 16 || channels == 0 || fileRate == 0 {
+		return nil, fmt.Errorf("%q must be 16-bit PCM (has format %d, %d bits, %d channels)",
+		    path, format, bits, channels)
+	}
+
+	frames := len(data) / int(2 * channels)
+	mono := make([]float64, frames)
+	for i := range mono {
+		sum := 0.0
+		for c := 0; c < int(channels); c++ {
+			off := (i * int(channels) + c) * 2
+			sum += float64(int16(binary.LittleEndian.Uint16(data[off:])))
+		}
+		mono[i] = sum / float64(channels) / math.MaxInt16
+	}
+	return resample(mono, int(fileRate), rate), nil
+}
+
+// resample converts samples from one rate to another, by linear
+// interpolation. That's crude, but this is only for auditioning.
+func resample(in []float64, from, to int) []float64 {
+	if from == to || len(in) == 0 {
+		return in
+	}
+	out := make([]float64, len(in) * to / from)
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j + 1 >= len(in) {
+			out[i] = in[len(in) - 1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = in[j] * (1 - frac) + in[j + 1] * frac
+	}
+	return out
+}
+
+// writeWAV writes 16-bit stereo PCM samples, which should be in [-1, 1].
+func writeWAV(w io.Writer, left, right []float64, rate int) error {
+	dataSize := uint32(len(left) * 4)
+	hdr := struct {
+		RIFF		[4]byte
+		Size		uint32
+		WAVE		[4]byte
+		Fmt		[4]byte
+		FmtSize		uint32
+		Format		uint16
+		Channels	uint16
+		Rate		uint32
+		ByteRate	uint32
+		BlockAlign	uint16
+		Bits		uint16
+		Data		[4]byte
+		DataSize	uint32
+	}{
+		RIFF:		[4]byte{'R', 'I', 'F', 'F'},
+		Size:		36 + dataSize,
+		WAVE:		[4]byte{'W', 'A', 'V', 'E'},
+		Fmt:		[4]byte{'f', 'm', 't', ' '},
+		FmtSize:	16,
+		Format:		1,
+		Channels:	2,
+		Rate:		uint32(rate),
+		ByteRate:	uint32(rate * 4),
+		BlockAlign:	4,
+		Bits:		16,
+		Data:		[4]byte{'d', 'a', 't', 'a'},
+		DataSize:	dataSize,
+	}
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(left) * 4)
+	for i := range left {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(toInt16(left[i])))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(toInt16(right[i])))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func toInt16(v float64) int16 {
+	return int16(math.Round(min(max(v, -1), 1) * math.MaxInt16))
+}
@@ -0,0 +1,257 @@
+// Package session keeps statistics for a session, i.e. one evening's run
+// of the installation, and writes a summary of it when it's over, for
+// the installation's log book.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// How often clients' state is sampled during a session.
+const sampleInterval = 30 * time.Second
+
+// Summary describes a session.
+type Summary struct {
+	Name	string
+	Start	time.Time
+	End	time.Time	// zero while the session is running
+
+	// The show seed, to recreate the session roughly; see
+	// random.SetSeed.
+	Seed	string
+
+	// How many times each effect ran, and how many times one couldn't
+	// start, e.g. because there weren't enough clients.
+	EffectRuns	map[string]int
+	EffectFailures	map[string]int
+
+	Clients		map[types.ID]*ClientSummary
+}
+
+// ClientSummary describes a client's part in a session.
+type ClientSummary struct {
+	// Roughly how long the client was reachable, to the nearest
+	// sample.
+	UptimeSeconds	float64
+
+	// Requests to the client that failed during the session.
+	Failures	int
+
+	// The first and last battery voltages seen during the session, and
+	// the difference between them.
+	StartVoltage	float32
+	EndVoltage	float32
+	VoltageUsed	float32
+
+	// The weakest Wi-Fi signal and the highest temperature seen during
+	// the session, if the client reports them.
+	MinRSSI		int	`json:",omitempty"`
+	MaxTemperature	float32	`json:",omitempty"`
+
+	failuresBefore	int
+}
+
+var session struct {
+	mu	sync.Mutex
+	current	*Summary
+	dir	string
+	stop	chan struct{}
+	done	chan struct{}
+	sampled	time.Time
+}
+
+// SetDir sets the directory that session summaries are written to. If
+// it's never set, summaries are only logged.
+func SetDir(dir string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.dir = dir
+}
+
+// Start starts a session. It fails if one is already running.
+func Start(name string) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.current != nil {
+		return fmt.Errorf("session %q is already running", session.current.Name)
+	}
+	now := time.Now()
+	if name == "" {
+		name = now.Format("2006-01-02")
+	}
+	s := &Summary{
+		Name:		name,
+		Start:		now,
+		Seed:		random.Seed(),
+		EffectRuns:	make(map[string]int),
+		EffectFailures:	make(map[string]int),
+		Clients:	make(map[types.ID]*ClientSummary),
+	}
+	session.current = s
+	session.sampled = now
+	session.stop = make(chan struct{})
+	session.done = make(chan struct{})
+	sampleLocked(now)
+	go sampler(session.stop, session.done)
+
+	log.Infof("session %q started with show seed %q", name, s.Seed)
+	return nil
+}
+
+// Stop ends the running session, and writes its summary to the session
+// directory. It returns the summary.
+func Stop() (Summary, error) {
+	session.mu.Lock()
+	if session.current == nil {
+		session.mu.Unlock()
+		return Summary{}, fmt.Errorf("no session is running")
+	}
+	stop, done := session.stop, session.done
+	session.mu.Unlock()
+
+	close(stop)
+	<-done
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	now := time.Now()
+	sampleLocked(now)
+	s := session.current
+	s.End = now
+	session.current = nil
+
+	log.Infof("session %q ended after %v: %d effect runs, %d clients",
+	    s.Name, s.End.Sub(s.Start).Round(time.Second), total(s.EffectRuns), len(s.Clients))
+	if session.dir == "" {
+		return *s, nil
+	}
+	return *s, write(*s, session.dir)
+}
+
+// Current returns the running session's statistics so far, if there's
+// a session running.
+func Current() (Summary, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.current == nil {
+		return Summary{}, false
+	}
+	sampleLocked(time.Now())
+	return copySummary(session.current), true
+}
+
+// EffectRan records that an effect was asked to run, and whether it
+// could.
+func EffectRan(name string, err error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.current == nil {
+		return
+	}
+	if err != nil {
+		session.current.EffectFailures[name]++
+	} else {
+		session.current.EffectRuns[name]++
+	}
+}
+
+// ---------------------------------------------------------------------
+
+func sampler(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	t := time.NewTicker(sampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			session.mu.Lock()
+			sampleLocked(now)
+			session.mu.Unlock()
+		}
+	}
+}
+
+// sampleLocked updates the running session's client statistics. Each
+// client that's reachable now is credited with the time since the last
+// sample. The caller must hold session.mu.
+func sampleLocked(now time.Time) {
+	s := session.current
+	elapsed := now.Sub(session.sampled).Seconds()
+	session.sampled = now
+	telemetry := client.Telemetry()
+	for _, info := range client.List() {
+		cs, ok := s.Clients[info.ID]
+		if !ok {
+			cs = &ClientSummary{failuresBefore: info.Failures}
+			s.Clients[info.ID] = cs
+		} else if info.State != client.Offline {
+			cs.UptimeSeconds += elapsed
+		}
+		cs.Failures = info.Failures - cs.failuresBefore
+		if info.Voltage > 0 {
+			if cs.StartVoltage == 0 {
+				cs.StartVoltage = info.Voltage
+			}
+			cs.EndVoltage = info.Voltage
+			cs.VoltageUsed = cs.StartVoltage - cs.EndVoltage
+		}
+		if t, ok := telemetry[info.ID]; ok {
+			if t.RSSI != 0 && (cs.MinRSSI == 0 || t.RSSI < cs.MinRSSI) {
+				cs.MinRSSI = t.RSSI
+			}
+			cs.MaxTemperature = max(cs.MaxTemperature, t.Temperature)
+		}
+	}
+}
+
+func copySummary(s *Summary) Summary {
+	c := *s
+	c.EffectRuns = make(map[string]int)
+	for k, v := range s.EffectRuns {
+		c.EffectRuns[k] = v
+	}
+	c.EffectFailures = make(map[string]int)
+	for k, v := range s.EffectFailures {
+		c.EffectFailures[k] = v
+	}
+	c.Clients = make(map[types.ID]*ClientSummary)
+	for k, v := range s.Clients {
+		cs := *v
+		c.Clients[k] = &cs
+	}
+	return c
+}
+
+func total(m map[string]int) int {
+	n := 0
+	for _, v := range m {
+		n += v
+	}
+	return n
+}
+
+// write saves a session summary as a JSON file in dir.
+func write(s Summary, dir string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "session-" + s.Start.Format("20060102-150405") + ".json")
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write session summary: %w", err)
+	}
+	log.Infof("wrote session summary to %s", path)
+	return nil
+}
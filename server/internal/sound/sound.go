@@ -4,13 +4,18 @@ import (
 	"context"
 	"math"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/coverage"
 	"github.com/blakej11/cricket/internal/effect"
 	"github.com/blakej11/cricket/internal/lease"
 	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/space"
+	"github.com/blakej11/cricket/internal/startle"
 	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 func init() {
@@ -18,6 +23,11 @@ func init() {
 	effect.RegisterAlgorithm(lease.Sound, "nonrandom", &nonrandom{})
 	effect.RegisterAlgorithm(lease.Sound, "loop", &loop{})
 	effect.RegisterAlgorithm(lease.Sound, "shuffle", &shuffle{})
+	effect.RegisterAlgorithm(lease.Sound, "rainfall", &rainfall{})
+	effect.RegisterAlgorithm(lease.Sound, "antiphony", &antiphony{spatial: true})
+	effect.RegisterAlgorithm(lease.Sound, "scattered-antiphony", &antiphony{spatial: false})
+	effect.RegisterAlgorithm(lease.Sound, "soloist", &soloist{})
+	effect.RegisterAlgorithm(lease.Sound, "echo", &echo{})
 }
 
 // ---------------------------------------------------------------------
@@ -27,7 +37,9 @@ type silence struct {
 }
 
 func (s *silence) GetRequirements() effect.AlgRequirements {
-	return effect.AlgRequirements{}
+	return effect.AlgRequirements{
+		Description:	"Plays no sound.",
+	}
 }
 
 func (s *silence) Run(ctx context.Context, params effect.AlgParams) {
@@ -46,6 +58,17 @@ func (n *nonrandom) GetRequirements() effect.AlgRequirements {
 	return effect.AlgRequirements{
 		FileSets:	[]string{"main"},
 		Parameters:	[]string{"groupDelay"},
+		Description:	"Plays every file in the fileset, in order, on all clients at once.",
+		FileSetInfo:	map[string]string{
+			"main":		"the files to play",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"groupDelay": {
+				Description:	"pause between files",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
 	}
 }
 
@@ -60,6 +83,10 @@ func (n *nonrandom) Run(ctx context.Context, params effect.AlgParams) {
 		return set[i].File < set[j].File
 	})
 
+	// The files play back to back if groupDelay is zero, so let one
+	// client's actual timing keep the others together.
+	pacer := client.NewPacer(params.Clients)
+	pacer.FollowLeader(client.ElectLeader(params.Clients), lease.Sound)
 	for _, f := range set {
 		cmd := &client.Play{
 			File: f,
@@ -68,9 +95,8 @@ func (n *nonrandom) Run(ctx context.Context, params effect.AlgParams) {
 			Delay: 0,
 			Jitter: 0,
 		}
-		client.Action(params.Clients, ctx, cmd, time.Now())
-		time.Sleep(cmd.Duration())
-		time.Sleep(groupDelay.Duration())
+		pacer.Action(ctx, cmd)
+		pacer.AdvanceQueued(ctx, lease.Sound, cmd.Duration(), groupDelay.Duration())
 	}
 }
 
@@ -83,6 +109,29 @@ func (l *loop) GetRequirements() effect.AlgRequirements {
 	return effect.AlgRequirements{
 		FileSets:	[]string{"main"},
 		Parameters:	[]string{"fileReps", "fileDelay", "groupDelay"},
+		Description:	"Repeatedly plays a random file from the fileset on all clients at about the same time.",
+		FileSetInfo:	map[string]string{
+			"main":		"the files to choose from",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"fileReps": {
+				Description:	"times to play each chosen file",
+				Unit:		"count",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 1},
+			},
+			"fileDelay": {
+				Description:	"pause between repetitions of a file; the variance is used as on-device jitter",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+				Default:	&random.Config{},
+			},
+			"groupDelay": {
+				Description:	"pause after all repetitions of a file are done",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
 	}
 }
 
@@ -92,9 +141,22 @@ func (l *loop) Run(ctx context.Context, params effect.AlgParams) {
 	fileDelay := params.Parameters["fileDelay"]
 	groupDelay := params.Parameters["groupDelay"]
 
-	clients := params.Clients
+	// Don't pile up plays on the clients while they're startled.
+	hushes := params.Bus.Subscribe(ctx, startle.HushKey)
 
+	pacer := client.NewPacer(params.Clients)
+	var results <-chan client.Result
 	for ctx.Err() == nil {
+		// Leave out any clients whose last play failed, for a round.
+		pacer.SetClients(succeeded(params.Clients, results))
+
+		select {
+		case m := <-hushes:
+			pacer.Advance(ctx, time.Duration(m.Value * float64(time.Second)))
+			continue
+		default:
+		}
+
 		file := fileSet.Pick()
 		reps := fileReps.Int()
 
@@ -119,14 +181,36 @@ func (l *loop) Run(ctx context.Context, params effect.AlgParams) {
 			Delay:	fileDelay.MeanDuration(),
 			Jitter:	fileDelay.VarianceDuration(),
 		}
-		client.Action(clients, ctx, cmd, time.Now())
+		results = pacer.ActionWithResults(ctx, cmd)
+		pacer.Advance(ctx, cmd.Duration() + groupDelay.Duration())
+	}
+}
 
-		dur := time.Duration(cmd.Duration() + groupDelay.Duration())
-		sleepTimer := time.NewTimer(dur)
+// succeeded returns the clients that haven't reported a failure on the
+// results channel, without waiting for results that haven't arrived.
+func succeeded(clients []types.ID, results <-chan client.Result) []types.ID {
+	failed := make(map[types.ID]bool)
+	for done := false; results != nil && !done; {
 		select {
-			case <-sleepTimer.C:
+		case r := <-results:
+			if r.Err != nil {
+				failed[r.ID] = true
+			}
+		default:
+			done = true
+		}
+	}
+	if len(failed) == 0 {
+		return clients
+	}
+	ok := []types.ID{}
+	for _, c := range clients {
+		if !failed[c] {
+			ok = append(ok, c)
 		}
 	}
+	log.Infof("skipping %d clients whose last play failed", len(failed))
+	return ok
 }
 
 // ---------------------------------------------------------------------
@@ -137,7 +221,9 @@ type shuffle struct {}
 
 func (s *shuffle) GetRequirements() effect.AlgRequirements {
 	l := &loop{}
-	return l.GetRequirements()
+	reqs := l.GetRequirements()
+	reqs.Description = "Like loop, but each client chooses and plays its files independently."
+	return reqs
 }
 
 func (s *shuffle) Run(ctx context.Context, params effect.AlgParams) {
@@ -152,3 +238,402 @@ func (s *shuffle) Run(ctx context.Context, params effect.AlgParams) {
 	<-ctx.Done()
 }
 
+
+// ---------------------------------------------------------------------
+
+// rainfall plays short droplet sounds on each client independently, at
+// a density that can rise and fall over the course of the effect.
+type rainfall struct {}
+
+// How long to wait before checking again when the density is zero.
+const rainfallIdle = time.Second
+
+func (r *rainfall) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		FileSets:	[]string{"drops"},
+		Parameters:	[]string{"density"},
+		Description:	"Each client plays random droplet sounds at random times, at a density that can ramp up and down.",
+		FileSetInfo:	map[string]string{
+			"drops":	"the droplet sounds; each client picks its own, so clients don't phase against each other",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"density": {
+				Description:	"droplets per second on each client; use Changes to build the rain up and let it die away",
+				Unit:		"per second",
+				Kind:		random.CountKind,
+			},
+		},
+	}
+}
+
+func (r *rainfall) Run(ctx context.Context, params effect.AlgParams) {
+	drops := params.FileSets["drops"]
+	density := params.Parameters["density"]
+
+	for _, c := range params.Clients {
+		go func() {
+			// The density might be a changing variable, and the
+			// changes aren't thread safe.
+			density := *density
+			density.Reset()
+			pacer := client.NewPacer([]types.ID{c})
+
+			for ctx.Err() == nil {
+				d := density.Float64()
+				if d <= 0 {
+					pacer.Advance(ctx, rainfallIdle)
+					continue
+				}
+				// Droplets arrive as a Poisson process.
+				interval := time.Duration(random.ExpFloat64() / d * float64(time.Second))
+				pacer.Advance(ctx, interval)
+				if ctx.Err() != nil {
+					return
+				}
+
+				cmd := &client.Play{
+					File:	drops.Pick(),
+					Reps:	1,
+				}
+				pacer.Action(ctx, cmd)
+				// Don't let droplets pile up in the device's queue.
+				pacer.Advance(ctx, cmd.Duration())
+			}
+		}()
+	}
+	<-ctx.Done()
+}
+
+// ---------------------------------------------------------------------
+
+// antiphony splits its clients into groups, which take turns playing
+// phrases, like choirs singing call and response.
+type antiphony struct {
+	// If set, groups are made of nearby clients; otherwise they're
+	// chosen at random.
+	spatial	bool
+}
+
+func (a *antiphony) GetRequirements() effect.AlgRequirements {
+	desc := "Splits the clients into groups of nearby clients, which take turns playing a random file from the fileset."
+	if !a.spatial {
+		desc = "Like antiphony, but the groups are chosen at random rather than by location."
+	}
+	return effect.AlgRequirements{
+		FileSets:	[]string{"main"},
+		Parameters:	[]string{"groups", "phraseGap"},
+		Description:	desc,
+		FileSetInfo:	map[string]string{
+			"main":		"the phrases to choose from",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"groups": {
+				Description:	"number of groups; at least 2",
+				Unit:		"count",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 2},
+			},
+			"phraseGap": {
+				Description:	"pause between one group's phrase and the next group's",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
+	}
+}
+
+func (a *antiphony) Run(ctx context.Context, params effect.AlgParams) {
+	fileSet := params.FileSets["main"]
+	phraseGap := params.Parameters["phraseGap"]
+
+	if len(params.Clients) == 0 {
+		<-ctx.Done()
+		return
+	}
+	n := min(max(params.Parameters["groups"].Int(), 2), len(params.Clients))
+	groups := randomGroups(params.Clients, n)
+	if a.spatial {
+		groups = spatialGroups(params.Clients, n)
+	}
+
+	pacer := client.NewPacer(params.Clients)
+	for ctx.Err() == nil {
+		for _, g := range groups {
+			if ctx.Err() != nil {
+				return
+			}
+			cmd := &client.Play{
+				File:	fileSet.Pick(),
+				Reps:	1,
+			}
+			pacer.SetClients(g)
+			pacer.Action(ctx, cmd)
+			pacer.Advance(ctx, cmd.Duration() + phraseGap.Duration())
+		}
+	}
+}
+
+// randomGroups deals the clients out into n groups at random.
+func randomGroups(clients []types.ID, n int) [][]types.ID {
+	shuffled := append([]types.ID{}, clients...)
+	random.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	groups := make([][]types.ID, n)
+	for i, id := range shuffled {
+		groups[i % n] = append(groups[i % n], id)
+	}
+	return groups
+}
+
+// spatialGroups splits the clients into n groups of nearby clients,
+// ordered across the installation so that the call and response move
+// through the space. If that doesn't work, the groups are random.
+func spatialGroups(clients []types.ID, n int) [][]types.ID {
+	located := make(map[types.ID]types.Client)
+	for id, loc := range client.Locations(clients) {
+		located[id] = types.Client{PhysLocation: loc}
+	}
+	zones, err := coverage.SuggestZones(located, n)
+	if err != nil {
+		log.Warningf("can't group clients by location: %v", err)
+		return randomGroups(clients, n)
+	}
+
+	byZone := make(map[string][]types.ID)
+	for _, id := range clients {
+		byZone[zones[id]] = append(byZone[zones[id]], id)
+	}
+	names := []string{}
+	for name := range byZone {
+		names = append(names, name)
+	}
+	// "zone10" comes after "zone9".
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	if len(names) < n {
+		// e.g. the clients' locations haven't been configured
+		log.Warningf("can't find %d separate groups of clients by location; grouping them at random", n)
+		return randomGroups(clients, n)
+	}
+	groups := [][]types.ID{}
+	for _, name := range names {
+		groups = append(groups, byZone[name])
+	}
+	return groups
+}
+
+// ---------------------------------------------------------------------
+
+// soloist keeps most clients quietly playing chorus files, while every
+// so often one client, chosen in rotation, plays a featured file louder.
+type soloist struct {}
+
+func (s *soloist) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		FileSets:	[]string{"chorus", "solo"},
+		Parameters:	[]string{"chorusVolume", "soloVolume", "soloDelay"},
+		Description:	"Clients play chorus files independently, while one at a time, in rotation, plays a solo.",
+		FileSetInfo:	map[string]string{
+			"chorus":	"the background files",
+			"solo":		"the featured files",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"chorusVolume": {
+				Description:	"volume of the chorus; 0 means the client's usual volume",
+				Unit:		"volume",
+				Kind:		random.VolumeKind,
+			},
+			"soloVolume": {
+				Description:	"volume of each solo; 0 means the client's usual volume",
+				Unit:		"volume",
+				Kind:		random.VolumeKind,
+			},
+			"soloDelay": {
+				Description:	"time from the start of one solo to the start of the next",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
+	}
+}
+
+func (s *soloist) Run(ctx context.Context, params effect.AlgParams) {
+	chorus := params.FileSets["chorus"]
+	solo := params.FileSets["solo"]
+	soloVolume := params.Volume("soloVolume")
+	soloDelay := params.Parameters["soloDelay"]
+
+	// When each client's current solo is expected to end.
+	var mu sync.Mutex
+	soloing := make(map[types.ID]time.Time)
+
+	for _, c := range params.Clients {
+		go func() {
+			// The volume might be a changing variable, and the
+			// changes aren't thread safe.
+			v := *params.Parameters["chorusVolume"]
+			v.Reset()
+			chorusVolume := random.VolumeVar{Variable: &v}
+			pacer := client.NewPacer([]types.ID{c})
+
+			for ctx.Err() == nil {
+				mu.Lock()
+				until := soloing[c]
+				mu.Unlock()
+				if d := time.Until(until); d > 0 {
+					pacer.Advance(ctx, d)
+					continue
+				}
+				cmd := &client.Play{
+					File:	chorus.Pick(),
+					Volume:	chorusVolume.Get(),
+					Reps:	1,
+				}
+				pacer.Action(ctx, cmd)
+				pacer.Advance(ctx, cmd.Duration())
+			}
+		}()
+	}
+
+	// Each solo goes to the client that soloed least recently.
+	lastSolo := make(map[types.ID]time.Time)
+	pacer := client.NewPacer(nil)
+	for ctx.Err() == nil {
+		pacer.Advance(ctx, soloDelay.Duration())
+		if ctx.Err() != nil || len(params.Clients) == 0 {
+			continue
+		}
+		next := params.Clients[random.IntN(len(params.Clients))]
+		for _, c := range params.Clients {
+			if lastSolo[c].Before(lastSolo[next]) {
+				next = c
+			}
+		}
+		lastSolo[next] = time.Now()
+
+		cmd := &client.Play{
+			File:	solo.Pick(),
+			Volume:	soloVolume.Get(),
+			Reps:	1,
+		}
+		// The solo goes into the client's queue behind whatever
+		// chorus file it's playing, so the chorus should resume
+		// only once the solo is over.
+		slot := client.Expect(next, lease.Sound, cmd.Duration())
+		mu.Lock()
+		soloing[next] = slot.End
+		mu.Unlock()
+		log.Infof("%v is the soloist until %v", next, slot.End.Format(time.TimeOnly))
+		client.Action([]types.ID{next}, ctx, cmd, time.Now())
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// echo plays a call on one client, and then plays it again, later and
+// quieter, on the clients further along an axis from it, as though it
+// were echoing off a wall.
+type echo struct {}
+
+func (e *echo) GetRequirements() effect.AlgRequirements {
+	return effect.AlgRequirements{
+		FileSets:	[]string{"main"},
+		Parameters:	[]string{"direction", "width", "speed", "volume", "falloff", "groupDelay"},
+		Description:	"A random client plays a call, and clients further along an axis from it echo it, each after a delay and at a volume that depend on its distance.",
+		FileSetInfo:	map[string]string{
+			"main":		"the calls to choose from",
+		},
+		ParamInfo:	map[string]effect.ParamInfo{
+			"direction": {
+				Description:	"the direction the echo travels, counterclockwise from the X axis",
+				Unit:		"degrees",
+				Kind:		random.AnyKind,
+			},
+			"width": {
+				Description:	"how far to either side of the axis a client can be and still echo",
+				Unit:		"meters",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 1},
+			},
+			"speed": {
+				Description:	"how fast the echo travels; much slower than real sound, so it can be heard",
+				Unit:		"meters per second",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 10},
+			},
+			"volume": {
+				Description:	"volume of the call; 0 means the client's usual volume, with no echoes",
+				Unit:		"volume",
+				Kind:		random.VolumeKind,
+			},
+			"falloff": {
+				Description:	"how much quieter the echo gets with distance; it stops when it reaches 0",
+				Unit:		"volume per meter",
+				Kind:		random.CountKind,
+				Default:	&random.Config{Mean: 2},
+			},
+			"groupDelay": {
+				Description:	"pause after the last echo of a call",
+				Unit:		"seconds",
+				Kind:		random.DurationKind,
+			},
+		},
+	}
+}
+
+func (e *echo) Run(ctx context.Context, params effect.AlgParams) {
+	fileSet := params.FileSets["main"]
+	direction := params.Parameters["direction"]
+	width := params.Parameters["width"]
+	speed := params.Parameters["speed"]
+	volume := params.Volume("volume")
+	falloff := params.Parameters["falloff"]
+	groupDelay := params.Parameters["groupDelay"]
+
+	if len(params.Clients) == 0 {
+		<-ctx.Done()
+		return
+	}
+	locations := client.Locations(params.Clients)
+	pacer := client.NewPacer(nil)
+	for ctx.Err() == nil {
+		source := params.Clients[random.IntN(len(params.Clients))]
+		call := &client.Play{
+			File:	fileSet.Pick(),
+			Volume:	volume.Get(),
+			Reps:	1,
+		}
+		pacer.SetClients([]types.ID{source})
+		pacer.Action(ctx, call)
+		start := time.Now()
+
+		angle := space.Radians(direction.Float64())
+		w, s, f := width.Float64(), speed.Float64(), falloff.Float64()
+		last := time.Duration(0)
+		origin, located := locations[source]
+		for id, loc := range locations {
+			if id == source || !located || call.Volume == 0 || s <= 0 {
+				continue
+			}
+			along, across := space.Project(loc, origin, angle)
+			v := call.Volume - int(math.Round(f * along))
+			if along <= 0 || across > w || v <= 0 {
+				continue
+			}
+			delay := time.Duration(along / s * float64(time.Second))
+			echo := &client.Play{
+				File:	call.File,
+				Volume:	v,
+				Reps:	1,
+			}
+			client.Action([]types.ID{id}, ctx, echo, start.Add(delay))
+			last = max(last, delay)
+		}
+		pacer.Advance(ctx, last + call.Duration() + groupDelay.Duration())
+	}
+}
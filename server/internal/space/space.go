@@ -0,0 +1,212 @@
+// Package space does geometry on clients' physical locations, for
+// effects and tools that care where the clients are.
+package space
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Distance returns the distance between two locations, in meters.
+func Distance(a, b types.PhysLocation) float64 {
+	return math.Hypot(a.X - b.X, a.Y - b.Y)
+}
+
+// Direction returns the direction from one location to another, in
+// radians counterclockwise from the X axis.
+func Direction(from, to types.PhysLocation) float64 {
+	return math.Atan2(to.Y - from.Y, to.X - from.X)
+}
+
+// Radians converts an angle in degrees, as used in configs, to radians.
+func Radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Project returns how far p is from origin along a line through origin
+// at the given angle (in radians, counterclockwise from the X axis), and
+// how far p is from that line, to either side.
+func Project(p, origin types.PhysLocation, angle float64) (along, across float64) {
+	dx, dy := p.X - origin.X, p.Y - origin.Y
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	return dx * cos + dy * sin, math.Abs(dy * cos - dx * sin)
+}
+
+// Bounds returns the corners of the smallest box that holds all of the
+// locations. It returns false if there are none.
+func Bounds(locs []types.PhysLocation) (lo, hi types.PhysLocation, ok bool) {
+	if len(locs) == 0 {
+		return lo, hi, false
+	}
+	lo, hi = locs[0], locs[0]
+	for _, l := range locs[1:] {
+		lo.X, hi.X = min(lo.X, l.X), max(hi.X, l.X)
+		lo.Y, hi.Y = min(lo.Y, l.Y), max(hi.Y, l.Y)
+	}
+	return lo, hi, true
+}
+
+// Centroid returns the average of the locations.
+func Centroid(locs []types.PhysLocation) types.PhysLocation {
+	c := types.PhysLocation{}
+	if len(locs) == 0 {
+		return c
+	}
+	for _, l := range locs {
+		c.X += l.X
+		c.Y += l.Y
+	}
+	c.X /= float64(len(locs))
+	c.Y /= float64(len(locs))
+	return c
+}
+
+// Nearest returns the IDs of up to n of the given clients that are
+// nearest to p, nearest first. Ties are broken by ID, so the result is
+// deterministic.
+func Nearest(p types.PhysLocation, locs map[types.ID]types.PhysLocation, n int) []types.ID {
+	ids := []types.ID{}
+	for id := range locs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		di, dj := Distance(p, locs[ids[i]]), Distance(p, locs[ids[j]])
+		if di != dj {
+			return di < dj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids[:min(max(n, 0), len(ids))]
+}
+
+// ---------------------------------------------------------------------
+
+// clusterIterations bounds the number of refinement passes.
+const clusterIterations = 100
+
+// Cluster groups points into k clusters of nearby points, using k-means
+// clustering. It returns the index of each point's cluster, and each
+// cluster's center. The result is deterministic for a given set of
+// points.
+func Cluster(points []types.PhysLocation, k int) ([]int, []types.PhysLocation, error) {
+	if k <= 0 {
+		return nil, nil, fmt.Errorf("number of clusters must be positive, not %d", k)
+	}
+	if k > len(points) {
+		return nil, nil, fmt.Errorf("can't make %d clusters out of %d points", k, len(points))
+	}
+
+	centers := initialCenters(points, k)
+	assignment := make([]int, len(points))
+	for iter := 0; iter < clusterIterations; iter++ {
+		changed := iter == 0
+		for i, p := range points {
+			if best := nearestCenter(p, centers); best != assignment[i] {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		members := make([][]types.PhysLocation, k)
+		for i, p := range points {
+			members[assignment[i]] = append(members[assignment[i]], p)
+		}
+		for j := range centers {
+			if len(members[j]) > 0 {
+				centers[j] = Centroid(members[j])
+			}
+		}
+	}
+	return assignment, centers, nil
+}
+
+// initialCenters picks k starting centers with the k-means++ method,
+// using a fixed seed so results are repeatable.
+func initialCenters(points []types.PhysLocation, k int) []types.PhysLocation {
+	r := rand.New(rand.NewPCG(1, 2))
+	centers := []types.PhysLocation{points[r.IntN(len(points))]}
+	for len(centers) < k {
+		weights := make([]float64, len(points))
+		total := 0.0
+		for i, p := range points {
+			d := Distance(p, centers[nearestCenter(p, centers)])
+			weights[i] = d * d
+			total += weights[i]
+		}
+		if total == 0 {
+			// All remaining points coincide with centers.
+			centers = append(centers, points[len(centers)])
+			continue
+		}
+		target := r.Float64() * total
+		next := points[len(points) - 1]
+		for i, w := range weights {
+			target -= w
+			if target <= 0 {
+				next = points[i]
+				break
+			}
+		}
+		centers = append(centers, next)
+	}
+	return centers
+}
+
+func nearestCenter(p types.PhysLocation, centers []types.PhysLocation) int {
+	best, bestDist := 0, math.Inf(1)
+	for j, c := range centers {
+		if d := Distance(p, c); d < bestDist {
+			best, bestDist = j, d
+		}
+	}
+	return best
+}
+
+// ---------------------------------------------------------------------
+
+// A Graph maps each client to its neighbors, nearest first.
+type Graph map[types.ID][]types.ID
+
+// NeighborGraph connects each client to the k clients nearest to it.
+// Neighborliness isn't symmetric: a client on the edge of a cluster may
+// count the cluster's members as neighbors without them counting it.
+func NeighborGraph(locs map[types.ID]types.PhysLocation, k int) Graph {
+	g := make(Graph)
+	for id, loc := range locs {
+		ns := []types.ID{}
+		for _, n := range Nearest(loc, locs, k + 1) {
+			if n != id && len(ns) < k {
+				ns = append(ns, n)
+			}
+		}
+		g[id] = ns
+	}
+	return g
+}
+
+// Restrict returns the part of the graph among the given clients, e.g.
+// the ones an effect has leased. Clients may be left with fewer
+// neighbors than before, or none.
+func (g Graph) Restrict(ids []types.ID) Graph {
+	in := make(map[types.ID]bool)
+	for _, id := range ids {
+		in[id] = true
+	}
+	r := make(Graph)
+	for _, id := range ids {
+		ns := []types.ID{}
+		for _, n := range g[id] {
+			if in[n] {
+				ns = append(ns, n)
+			}
+		}
+		r[id] = ns
+	}
+	return r
+}
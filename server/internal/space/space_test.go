@@ -0,0 +1,206 @@
+package space
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+const epsilon = 1e-9
+
+func loc(x, y float64) types.PhysLocation {
+	return types.PhysLocation{X: x, Y: y}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a - b) < epsilon
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b	types.PhysLocation
+		want	float64
+	}{
+		{loc(0, 0), loc(0, 0), 0},
+		{loc(0, 0), loc(3, 4), 5},
+		{loc(3, 4), loc(0, 0), 5},
+		{loc(-1, -1), loc(2, 3), 5},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); !near(got, tt.want) {
+			t.Errorf("Distance(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDirection(t *testing.T) {
+	tests := []struct {
+		from, to	types.PhysLocation
+		want		float64
+	}{
+		{loc(0, 0), loc(1, 0), 0},
+		{loc(0, 0), loc(0, 1), math.Pi / 2},
+		{loc(1, 1), loc(0, 1), math.Pi},
+		{loc(0, 0), loc(0, -2), -math.Pi / 2},
+		{loc(0, 0), loc(1, 1), Radians(45)},
+	}
+	for _, tt := range tests {
+		if got := Direction(tt.from, tt.to); !near(got, tt.want) {
+			t.Errorf("Direction(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	tests := []struct {
+		p, origin	types.PhysLocation
+		angle		float64
+		along, across	float64
+	}{
+		{loc(3, 4), loc(0, 0), 0, 3, 4},
+		{loc(3, -4), loc(0, 0), 0, 3, 4},
+		{loc(3, 4), loc(0, 0), math.Pi / 2, 4, 3},
+		{loc(-2, 0), loc(0, 0), 0, -2, 0},
+		{loc(2, 2), loc(1, 1), Radians(45), math.Sqrt2, 0},
+		{loc(1, 2), loc(1, 1), Radians(45), math.Sqrt2 / 2, math.Sqrt2 / 2},
+	}
+	for _, tt := range tests {
+		along, across := Project(tt.p, tt.origin, tt.angle)
+		if !near(along, tt.along) || !near(across, tt.across) {
+			t.Errorf("Project(%v, %v, %v) = %v, %v, want %v, %v",
+			    tt.p, tt.origin, tt.angle, along, across, tt.along, tt.across)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	if _, _, ok := Bounds(nil); ok {
+		t.Errorf("Bounds(nil) is ok")
+	}
+	lo, hi, ok := Bounds([]types.PhysLocation{loc(1, 5), loc(-2, 3), loc(4, -1)})
+	if !ok || lo != loc(-2, -1) || hi != loc(4, 5) {
+		t.Errorf("Bounds = %v, %v, %v, want (-2, -1), (4, 5), true", lo, hi, ok)
+	}
+	lo, hi, ok = Bounds([]types.PhysLocation{loc(1, 2)})
+	if !ok || lo != loc(1, 2) || hi != loc(1, 2) {
+		t.Errorf("Bounds of one point = %v, %v, %v", lo, hi, ok)
+	}
+}
+
+func TestCentroid(t *testing.T) {
+	if got := Centroid(nil); got != loc(0, 0) {
+		t.Errorf("Centroid(nil) = %v", got)
+	}
+	got := Centroid([]types.PhysLocation{loc(0, 0), loc(4, 0), loc(2, 6)})
+	if !near(got.X, 2) || !near(got.Y, 2) {
+		t.Errorf("Centroid = %v, want (2, 2)", got)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	locs := map[types.ID]types.PhysLocation{
+		"a":	loc(0, 0),
+		"b":	loc(1, 0),
+		"c":	loc(0, 1),
+		"d":	loc(5, 5),
+	}
+	tests := []struct {
+		p	types.PhysLocation
+		n	int
+		want	[]types.ID
+	}{
+		{loc(0, 0), 4, []types.ID{"a", "b", "c", "d"}},
+		{loc(0, 0), 2, []types.ID{"a", "b"}},	// b and c tie; b sorts first
+		{loc(6, 6), 1, []types.ID{"d"}},
+		{loc(0, 0), 10, []types.ID{"a", "b", "c", "d"}},
+		{loc(0, 0), 0, []types.ID{}},
+		{loc(0, 0), -1, []types.ID{}},
+	}
+	for _, tt := range tests {
+		if got := Nearest(tt.p, locs, tt.n); !slices.Equal(got, tt.want) {
+			t.Errorf("Nearest(%v, %d) = %v, want %v", tt.p, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	// Two groups, far apart.
+	points := []types.PhysLocation{
+		loc(0, 0), loc(1, 0), loc(0, 1),
+		loc(20, 20), loc(21, 20), loc(20, 21),
+	}
+	assignment, centers, err := Cluster(points, 2)
+	if err != nil {
+		t.Fatalf("Cluster: %v", err)
+	}
+	if len(centers) != 2 {
+		t.Fatalf("got %d centers, want 2", len(centers))
+	}
+	for i := range 3 {
+		if assignment[i] != assignment[0] || assignment[i + 3] != assignment[3] {
+			t.Fatalf("assignment %v splits a group", assignment)
+		}
+	}
+	if assignment[0] == assignment[3] {
+		t.Fatalf("assignment %v joins the groups", assignment)
+	}
+	for _, g := range []int{0, 3} {
+		c := centers[assignment[g]]
+		want := Centroid(points[g:g + 3])
+		if !near(c.X, want.X) || !near(c.Y, want.Y) {
+			t.Errorf("center %v, want %v", c, want)
+		}
+	}
+
+	again, _, _ := Cluster(points, 2)
+	if !slices.Equal(assignment, again) {
+		t.Errorf("Cluster isn't deterministic: %v, then %v", assignment, again)
+	}
+
+	if _, _, err := Cluster(points, 0); err == nil {
+		t.Errorf("Cluster with k = 0 succeeded")
+	}
+	if _, _, err := Cluster(points, 7); err == nil {
+		t.Errorf("Cluster with more clusters than points succeeded")
+	}
+
+	// Coinciding points still get k clusters.
+	same := []types.PhysLocation{loc(1, 1), loc(1, 1), loc(1, 1)}
+	if _, centers, err := Cluster(same, 3); err != nil || len(centers) != 3 {
+		t.Errorf("Cluster of coinciding points = %v, %v", centers, err)
+	}
+}
+
+func TestNeighborGraph(t *testing.T) {
+	locs := map[types.ID]types.PhysLocation{
+		"a":	loc(0, 0),
+		"b":	loc(1, 0),
+		"c":	loc(3, 0),
+		"d":	loc(10, 0),
+	}
+	g := NeighborGraph(locs, 1)
+	want := map[types.ID][]types.ID{
+		"a":	{"b"},
+		"b":	{"a"},
+		"c":	{"b"},
+		"d":	{"c"},
+	}
+	for id, ns := range want {
+		if !slices.Equal(g[id], ns) {
+			t.Errorf("neighbors of %v = %v, want %v", id, g[id], ns)
+		}
+	}
+
+	r := NeighborGraph(locs, 2).Restrict([]types.ID{"a", "c", "d"})
+	if len(r) != 3 {
+		t.Errorf("restricted graph has %d clients, want 3", len(r))
+	}
+	if !slices.Equal(r["a"], []types.ID{"c"}) {
+		t.Errorf("restricted neighbors of a = %v, want [c]", r["a"])
+	}
+	if _, ok := r["b"]; ok {
+		t.Errorf("restricted graph still has b")
+	}
+}
@@ -0,0 +1,112 @@
+// Package startle makes the crickets fall silent when something startles
+// them, as real crickets do. A trigger pauses every client's sound for a
+// few seconds, and then the clients resume one by one, raggedly.
+//
+// Triggers are messages on the effect bus (e.g. a thunder effect's
+// "thunderclap"), or calls to Trigger from elsewhere (e.g. a motion
+// sensor via the admin API). Running effects are told about the hush
+// by a "hush" message on the bus, whose Value is the length of the hush
+// in seconds, and a "resume" message when it's over.
+package startle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blakej11/cricket/internal/bus"
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/log"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Config describes the startle behavior.
+type Config struct {
+	Triggers	[]string	// bus message keys that startle the crickets
+	Hush		random.Config	// how long to stay silent, in seconds
+	Ragged		random.Config	// extra per-client delay before resuming, in seconds
+}
+
+// Keys of the messages sent on the bus.
+const (
+	HushKey		= "hush"
+	ResumeKey	= "resume"
+)
+
+var startle struct {
+	ch	chan struct{}
+	hush	*random.Variable
+	ragged	*random.Variable
+}
+
+// Check returns an error if the configuration is invalid.
+func (c Config) Check() error {
+	if err := random.DurationKind.Check(c.Hush); err != nil {
+		return fmt.Errorf("startle hush: %w", err)
+	}
+	if err := random.DurationKind.Check(c.Ragged); err != nil {
+		return fmt.Errorf("startle ragged: %w", err)
+	}
+	return nil
+}
+
+// Start begins listening for triggers.
+func Start(c Config) {
+	startle.ch = make(chan struct{}, 1)
+	startle.hush = random.New(c.Hush)
+	startle.ragged = random.New(c.Ragged)
+
+	for _, key := range c.Triggers {
+		msgs := bus.Shared.Subscribe(context.Background(), key)
+		go func() {
+			for m := range msgs {
+				log.Infof("startled by %q from %q", m.Key, m.Sender)
+				Trigger()
+			}
+		}()
+	}
+	go run()
+}
+
+// Trigger startles the crickets. Triggers that arrive during a hush
+// are ignored.
+func Trigger() {
+	if startle.ch == nil {
+		log.Warningf("startle triggered, but not configured")
+		return
+	}
+	select {
+	case startle.ch <- struct{}{}:
+	default:
+	}
+}
+
+func run() {
+	for range startle.ch {
+		hush := startle.hush.Duration()
+		now := time.Now()
+		ids := client.IDs()
+		log.Infof("startle: hushing %d clients for %.1f sec", len(ids), hush.Seconds())
+
+		bus.Shared.Publish(bus.Message{Key: HushKey, Sender: "startle", Value: hush.Seconds()})
+		client.Action(ids, context.Background(), &client.Pause{}, now)
+		last := now.Add(hush)
+		for _, id := range ids {
+			resume := now.Add(hush + startle.ragged.Duration())
+			client.Action([]types.ID{id}, context.Background(), &client.Unpause{}, resume)
+			if resume.After(last) {
+				last = resume
+			}
+		}
+
+		time.Sleep(time.Until(last))
+		bus.Shared.Publish(bus.Message{Key: ResumeKey, Sender: "startle"})
+
+		// Drop any triggers that arrived during the hush.
+		select {
+		case <-startle.ch:
+		default:
+		}
+	}
+}
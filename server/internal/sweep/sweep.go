@@ -0,0 +1,239 @@
+// Package sweep runs an effect over and over against a virtual fleet,
+// varying its parameters each time, and reports what the effect asked
+// the fleet to do. This helps when choosing parameter ranges.
+package sweep
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blakej11/cricket/internal/client"
+	"github.com/blakej11/cricket/internal/config"
+	"github.com/blakej11/cricket/internal/types"
+	"github.com/blakej11/cricket/internal/virtual"
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Config describes a parameter sweep.
+type Config struct {
+	Effect		string			// the effect to run
+	Clients		int			// size of the virtual fleet
+	Runs		int			// runs per parameter setting
+	Host		string			// address for the fleet; default 127.0.0.1
+
+	// Either Grid or Random should be given.
+	Grid		map[string]Axis		// try every combination
+	Random		map[string]Range	// try random combinations
+	Samples		int			// number of random combinations
+}
+
+// Axis lists the values to try for one parameter in a grid search.
+// If either list is empty, the configured value is used.
+type Axis struct {
+	Means		[]float64
+	Variances	[]float64
+}
+
+// Range bounds the values tried for one parameter in a random search.
+type Range struct {
+	MinMean		float64
+	MaxMean		float64
+	MinVariance	float64
+	MaxVariance	float64
+}
+
+// Result summarizes the runs made with one parameter setting.
+type Result struct {
+	Setting		map[string]random.Config
+	Runs		int
+	Failures	int			// runs whose lease failed
+	Requests	map[string]float64	// mean requests per run, by endpoint
+	Clients		float64			// mean clients commanded per run
+	Elapsed		time.Duration		// mean wall-clock time per run
+}
+
+// Run performs the sweep described by sc, using effects from cfg,
+// and writes a report to w.
+func Run(cfg *config.ConfigImpl, sc Config, w io.Writer) ([]Result, error) {
+	base, ok := cfg.EffectConfig(sc.Effect)
+	if !ok {
+		return nil, fmt.Errorf("failed to find effect %q", sc.Effect)
+	}
+	if sc.Clients <= 0 {
+		return nil, fmt.Errorf("sweep needs at least one client")
+	}
+	for name := range sc.Grid {
+		if _, ok := base.Parameters[name]; !ok {
+			return nil, fmt.Errorf("effect %q has no parameter %q", sc.Effect, name)
+		}
+	}
+	for name := range sc.Random {
+		if _, ok := base.Parameters[name]; !ok {
+			return nil, fmt.Errorf("effect %q has no parameter %q", sc.Effect, name)
+		}
+	}
+	settings := gridSettings(base.Parameters, sc.Grid)
+	if len(sc.Random) > 0 {
+		settings = randomSettings(base.Parameters, sc.Random, sc.Samples)
+	}
+
+	durations := make(map[[2]int]time.Duration)
+	for _, f := range cfg.Files() {
+		durations[[2]int{f.Folder, f.File}] = time.Duration(f.Duration * float64(time.Second))
+	}
+	host := sc.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	fleet, err := virtual.NewOn(host, sc.Clients, func(folder, file int) time.Duration {
+		return durations[[2]int{folder, file}]
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer fleet.Close()
+	for _, d := range fleet.Devices() {
+		client.Add(d.ID(), []types.NetLocation{d.NetLocation()}, d.Capabilities())
+	}
+	// Let the clients finish their startup commands.
+	time.Sleep(time.Second)
+
+	results := []Result{}
+	for _, setting := range settings {
+		res, err := runSetting(cfg, sc, fleet, setting)
+		if err != nil {
+			return nil, err
+		}
+		report(w, res)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func runSetting(cfg *config.ConfigImpl, sc Config, fleet *virtual.Fleet, setting map[string]random.Config) (Result, error) {
+	res := Result{
+		Setting:	setting,
+		Requests:	make(map[string]float64),
+	}
+	runs := max(sc.Runs, 1)
+	succeeded := 0
+	for i := 0; i < runs; i++ {
+		e, err := cfg.NewEffect(sc.Effect, setting)
+		if err != nil {
+			return res, err
+		}
+		fleet.ResetCounts()
+		start := time.Now()
+		if err := e.RunAndWait(); err != nil {
+			res.Failures++
+			continue
+		}
+		res.Elapsed += time.Since(start)
+		succeeded++
+
+		for k, v := range fleet.Counts() {
+			res.Requests[k] += float64(v)
+		}
+		for _, d := range fleet.Devices() {
+			c := d.Counts()
+			if c["play"] > 0 || c["blink"] > 0 {
+				res.Clients++
+			}
+		}
+	}
+	res.Runs = runs
+	if succeeded > 0 {
+		for k := range res.Requests {
+			res.Requests[k] /= float64(succeeded)
+		}
+		res.Clients /= float64(succeeded)
+		res.Elapsed /= time.Duration(succeeded)
+	}
+	return res, nil
+}
+
+func report(w io.Writer, res Result) {
+	params := []string{}
+	for _, n := range sortedKeys(res.Setting) {
+		p := res.Setting[n]
+		params = append(params, fmt.Sprintf("%s=%.3g/%.3g", n, p.Mean, p.Variance))
+	}
+	reqs := []string{}
+	for _, n := range sortedKeys(res.Requests) {
+		reqs = append(reqs, fmt.Sprintf("%s=%.1f", n, res.Requests[n]))
+	}
+	fmt.Fprintf(w, "[ %s ] runs %d (%d failed), clients %.1f, elapsed %.1fs, requests [ %s ]\n",
+	    strings.Join(params, " "), res.Runs, res.Failures, res.Clients,
+	    res.Elapsed.Seconds(), strings.Join(reqs, " "))
+}
+
+// ---------------------------------------------------------------------
+
+// gridSettings returns every combination of the values in grid.
+func gridSettings(base map[string]random.Config, grid map[string]Axis) []map[string]random.Config {
+	settings := []map[string]random.Config{{}}
+	for _, name := range sortedKeys(grid) {
+		axis := grid[name]
+		means := axis.Means
+		if len(means) == 0 {
+			means = []float64{base[name].Mean}
+		}
+		variances := axis.Variances
+		if len(variances) == 0 {
+			variances = []float64{base[name].Variance}
+		}
+
+		next := []map[string]random.Config{}
+		for _, s := range settings {
+			for _, m := range means {
+				for _, v := range variances {
+					p := base[name]
+					p.Mean = m
+					p.Variance = v
+					n := copySetting(s)
+					n[name] = p
+					next = append(next, n)
+				}
+			}
+		}
+		settings = next
+	}
+	return settings
+}
+
+// randomSettings returns n combinations drawn uniformly from ranges.
+func randomSettings(base map[string]random.Config, ranges map[string]Range, n int) []map[string]random.Config {
+	settings := []map[string]random.Config{}
+	for i := 0; i < max(n, 1); i++ {
+		s := make(map[string]random.Config)
+		for name, r := range ranges {
+			p := base[name]
+			p.Mean = r.MinMean + rand.Float64() * (r.MaxMean - r.MinMean)
+			p.Variance = r.MinVariance + rand.Float64() * (r.MaxVariance - r.MinVariance)
+			s[name] = p
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+func copySetting(s map[string]random.Config) map[string]random.Config {
+	n := make(map[string]random.Config)
+	for k, v := range s {
+		n[k] = v
+	}
+	return n
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,10 @@ package types
 
 import (
 	"net"
+	"strconv"
+	"strings"
+
+	"github.com/blakej11/cricket/pkg/random"
 )
 
 // These are the types that don't belong anywhere else.
@@ -10,11 +14,59 @@ import (
 type NetLocation struct {
         Address		net.IP
         Port		int
+	Zone		string	// for IPv6 link-local addresses, e.g. "eth0"
+}
+
+// Host returns the address, with its zone if it has one.
+func (l NetLocation) Host() string {
+	if l.Zone != "" {
+		return l.Address.String() + "%" + l.Zone
+	}
+	return l.Address.String()
+}
+
+// HostPort returns the location in "host:port" form, with brackets
+// around IPv6 addresses.
+func (l NetLocation) HostPort() string {
+	return net.JoinHostPort(l.Host(), strconv.Itoa(l.Port))
+}
+
+func (l NetLocation) String() string {
+	return l.HostPort()
+}
+
+// Equal reports whether two locations are the same.
+func (l NetLocation) Equal(m NetLocation) bool {
+	return l.Address.Equal(m.Address) && l.Port == m.Port && l.Zone == m.Zone
 }
 
+// MaxVolume is the loudest volume a client can be set to.
+const MaxVolume = random.MaxVolume
+
+// MaxBrightness is the brightest a client's light can be set to.
+const MaxBrightness = 255
+
 // ID is the main way that clients are referred to.
 type ID string
 
+// maxIDLength bounds the length of an ID, so a misbehaving device can't
+// fill the logs and admin pages with junk.
+const maxIDLength = 64
+
+// Valid reports whether an ID advertised by a device is acceptable:
+// nonempty, not too long, and plain printable ASCII.
+func (id ID) Valid() bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 // Client describes configuration parameters for a single client.
 type Client struct {
 	// A more familiar name for the client.
@@ -22,9 +74,110 @@ type Client struct {
 
 	// Where the client is located physically.
 	PhysLocation
+
+	// The named area the client is in, e.g. "garden". Optional.
+	Zone		string
+
+	// How far away the client's speaker can be heard, in meters.
+	// Zero means unknown.
+	SpeakerRadius	float64
 }
 
+// PhysLocation is a position in the installation, in meters from some
+// arbitrary origin.
 type PhysLocation struct {
-	// Nothing right now.
+	X, Y		float64
+}
+
+// Capabilities describes a client's hardware and firmware, as advertised
+// in its mDNS TXT record. The record is a list of "key=value" strings;
+// "fw" and "hw" give the firmware version and hardware revision,
+// "ns" gives the fleet namespace the client belongs to, "features" is a
+// comma-separated list of feature flags, and a key with no value is also
+// taken to be a feature flag.
+type Capabilities struct {
+	Firmware	string
+	Hardware	string
+	Namespace	string
+	Features	map[string]bool
+	Info		map[string]string	// every key in the record
 }
 
+// ParseCapabilities parses an mDNS TXT record.
+func ParseCapabilities(txt []string) Capabilities {
+	c := Capabilities{
+		Features:	make(map[string]bool),
+		Info:		make(map[string]string),
+	}
+	for _, t := range txt {
+		k, v, hasValue := strings.Cut(t, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if k == "" {
+			continue
+		}
+		c.Info[k] = v
+		switch {
+		case k == "fw":
+			c.Firmware = v
+		case k == "hw":
+			c.Hardware = v
+		case k == "ns":
+			c.Namespace = v
+		case k == "features":
+			for _, f := range strings.Split(v, ",") {
+				if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+					c.Features[f] = true
+				}
+			}
+		case !hasValue:
+			c.Features[k] = true
+		}
+	}
+	return c
+}
+
+// Has reports whether the client has all of the given features.
+func (c Capabilities) Has(features []string) bool {
+	for _, f := range features {
+		if !c.Features[strings.ToLower(f)] {
+			return false
+		}
+	}
+	return true
+}
+
+// FirmwareAtLeast reports whether the client's firmware version is at
+// least the given dotted version, e.g. "1.2". A client that doesn't
+// advertise a version is assumed to have the oldest firmware.
+func (c Capabilities) FirmwareAtLeast(version string) bool {
+	if version == "" {
+		return true
+	}
+	if c.Firmware == "" {
+		return false
+	}
+	have := strings.Split(c.Firmware, ".")
+	want := strings.Split(version, ".")
+	for i := 0; i < max(len(have), len(want)); i++ {
+		h, w := "0", "0"
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		hn, herr := strconv.Atoi(h)
+		wn, werr := strconv.Atoi(w)
+		if herr != nil || werr != nil {
+			if h != w {
+				return h > w
+			}
+			continue
+		}
+		if hn != wn {
+			return hn > wn
+		}
+	}
+	return true
+}
@@ -0,0 +1,546 @@
+// Package virtual implements simulated crickets. They speak the same HTTP
+// protocol as the real firmware, so the rest of the server can't tell
+// them apart from hardware.
+package virtual
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/types"
+)
+
+// Fleet is a collection of virtual crickets.
+type Fleet struct {
+	devices	[]*Device
+}
+
+// Device is a single virtual cricket.
+type Device struct {
+	id		types.ID
+	host		string
+	duration	DurationFunc
+	mux		*http.ServeMux
+
+	mu		sync.Mutex
+	listener	net.Listener
+	failing		bool	// fail every request, as if unreachable
+	asleepUntil	time.Time	// fail every request until then
+	counts		map[string]int
+	soundQueue	[]queued	// queued sounds
+	lightQueue	[]queued	// queued blinks
+	volume		int
+	rssi		int	// reported by the "rssi" endpoint
+	clipAbove	int	// if nonzero, plays louder than this clip
+	clips		int	// since the last "level" query
+	tokens		[]string	// of recent queueing commands
+	recording	bool
+	sounds		[]Sound		// if recording, what was asked to play
+}
+
+// queued is an item in one of a device's queues.
+type queued struct {
+	end	time.Time
+	token	string	// the server's token for the command, if any
+}
+
+// Sound describes a play request, as the device would carry it out.
+type Sound struct {
+	Start		time.Time	// when the first rep starts
+	Folder, File	int
+	Duration	time.Duration	// of one rep, not counting Delay
+	Volume		int
+	Reps		int
+	Delay		time.Duration	// between reps
+	Jitter		time.Duration	// most extra random delay between reps
+
+	// When the sound was cut off by a stop or clear, if it was.
+	Cut		time.Time
+}
+
+// End returns when the sound would end if it weren't cut off.
+func (s Sound) End() time.Time {
+	return s.Start.Add((s.Duration + s.Delay) * time.Duration(s.Reps))
+}
+
+const (
+	// The volume a device starts at, as the firmware does.
+	initialVolume = 8
+
+	// A good Wi-Fi signal, in dBm.
+	defaultRSSI = -55
+)
+
+// DurationFunc reports how long a file on the device takes to play.
+type DurationFunc func(folder, file int) time.Duration
+
+// New starts a fleet of n virtual crickets, listening on the IPv4
+// loopback interface.
+func New(n int, duration DurationFunc) (*Fleet, error) {
+	return NewOn("127.0.0.1", n, duration)
+}
+
+// NewWithIDs is like New, but the crickets have the given IDs, e.g. those
+// of configured clients, rather than made-up ones.
+func NewWithIDs(ids []types.ID, duration DurationFunc) (*Fleet, error) {
+	f := &Fleet{}
+	for _, id := range ids {
+		d, err := newDevice("127.0.0.1", id, duration)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.devices = append(f.devices, d)
+	}
+	return f, nil
+}
+
+// NewOn is like New, but the crickets listen on the given address,
+// e.g. "::1" to test IPv6.
+func NewOn(host string, n int, duration DurationFunc) (*Fleet, error) {
+	f := &Fleet{}
+	for i := 0; i < n; i++ {
+		d, err := newDevice(host, types.ID(fmt.Sprintf("virtual%04d", i)), duration)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.devices = append(f.devices, d)
+	}
+	return f, nil
+}
+
+// Devices returns the devices in the fleet.
+func (f *Fleet) Devices() []*Device {
+	return f.devices
+}
+
+// Counts returns the number of requests each endpoint has received,
+// summed over the whole fleet.
+func (f *Fleet) Counts() map[string]int {
+	total := make(map[string]int)
+	for _, d := range f.devices {
+		for k, v := range d.Counts() {
+			total[k] += v
+		}
+	}
+	return total
+}
+
+// ResetCounts zeroes the request counts on every device.
+func (f *Fleet) ResetCounts() {
+	for _, d := range f.devices {
+		d.mu.Lock()
+		d.counts = make(map[string]int)
+		d.mu.Unlock()
+	}
+}
+
+// RecordSounds makes every device in the fleet keep a record of what it's
+// asked to play, for Device.Sounds. It's off by default, since the
+// record grows without bound.
+func (f *Fleet) RecordSounds() {
+	for _, d := range f.devices {
+		d.mu.Lock()
+		d.recording = true
+		d.mu.Unlock()
+	}
+}
+
+// Close shuts down every device in the fleet.
+func (f *Fleet) Close() {
+	for _, d := range f.devices {
+		d.mu.Lock()
+		d.listener.Close()
+		d.mu.Unlock()
+	}
+}
+
+// ---------------------------------------------------------------------
+
+func newDevice(host string, id types.ID, duration DurationFunc) (*Device, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start virtual cricket %q: %w", id, err)
+	}
+	d := &Device{
+		id:		id,
+		host:		host,
+		listener:	l,
+		duration:	duration,
+		counts:		make(map[string]int),
+		volume:		initialVolume,
+		rssi:		defaultRSSI,
+	}
+
+	mux := http.NewServeMux()
+	for _, endpoint := range []string{"ping", "pause", "unpause"} {
+		mux.HandleFunc("/" + endpoint, d.handle(endpoint, nil))
+	}
+	mux.HandleFunc("/setvolume", d.handle("setvolume", d.setVolume))
+	mux.HandleFunc("/play", d.handle("play", d.once(d.play)))
+	mux.HandleFunc("/blink", d.handle("blink", d.once(d.blink)))
+	mux.HandleFunc("/fade", d.handle("fade", d.once(d.fade)))
+	mux.HandleFunc("/stop", d.handle("stop", d.stop))
+	mux.HandleFunc("/clear", d.handle("clear", d.clear))
+	mux.HandleFunc("/level", d.handle("level", d.level))
+	mux.HandleFunc("/rssi", d.handle("rssi", func(r *http.Request) (string, error) {
+		return strconv.Itoa(d.rssi), nil
+	}))
+	mux.HandleFunc("/temperature", d.handle("temperature", func(r *http.Request) (string, error) {
+		return "45.0", nil
+	}))
+	mux.HandleFunc("/battery", d.handle("battery", func(r *http.Request) (string, error) {
+		return "4.10", nil
+	}))
+	mux.HandleFunc("/queue", d.handle("queue", d.queue))
+	mux.HandleFunc("/sleep", d.handle("sleep", d.sleep))
+	mux.HandleFunc("/soundpending", d.handle("soundpending", func(r *http.Request) (string, error) {
+		return strconv.Itoa(pending(&d.soundQueue)), nil
+	}))
+	mux.HandleFunc("/lightpending", d.handle("lightpending", func(r *http.Request) (string, error) {
+		return strconv.Itoa(pending(&d.lightQueue)), nil
+	}))
+	d.mux = mux
+	go http.Serve(l, mux)
+
+	return d, nil
+}
+
+// SetFailing makes the device fail (or stop failing) every request, as
+// if it had dropped off the network.
+func (d *Device) SetFailing(failing bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failing = failing
+}
+
+// Move makes the device listen on a new port, as if it had been given a
+// new address. It must be re-added to the server to be reachable again.
+func (d *Device) Move() error {
+	l, err := net.Listen("tcp", net.JoinHostPort(d.host, "0"))
+	if err != nil {
+		return fmt.Errorf("failed to move virtual cricket %q: %w", d.id, err)
+	}
+	d.mu.Lock()
+	old := d.listener
+	d.listener = l
+	d.mu.Unlock()
+	old.Close()
+	go http.Serve(l, d.mux)
+	return nil
+}
+
+// SetRSSI sets the Wi-Fi signal strength the device reports, in dBm.
+func (d *Device) SetRSSI(rssi int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rssi = rssi
+}
+
+// SetClipAbove makes the device's output clip whenever it plays louder
+// than the given volume, as reported by its "level" endpoint. Zero means
+// it never clips.
+func (d *Device) SetClipAbove(volume int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clipAbove = volume
+}
+
+// Reboot empties the device's queues, as a real reboot would.
+func (d *Device) Reboot() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.soundQueue = nil
+	d.lightQueue = nil
+	d.cutSounds()
+}
+
+// ID returns the device's ID.
+func (d *Device) ID() types.ID {
+	return d.id
+}
+
+// The firmware version a device advertises, as in the firmware.
+const firmwareVersion = "1.1"
+
+// Capabilities returns what the device advertises about itself, as the
+// firmware does in its mDNS record.
+func (d *Device) Capabilities() types.Capabilities {
+	return types.ParseCapabilities([]string{"fw=" + firmwareVersion})
+}
+
+// NetLocation returns the address that the device is listening on.
+func (d *Device) NetLocation() types.NetLocation {
+	d.mu.Lock()
+	addr := d.listener.Addr().(*net.TCPAddr)
+	d.mu.Unlock()
+	return types.NetLocation{
+		Address:	addr.IP,
+		Port:		addr.Port,
+		Zone:		addr.Zone,
+	}
+}
+
+// Sounds returns the sounds the device has been asked to play since the
+// fleet started recording them.
+func (d *Device) Sounds() []Sound {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sounds := make([]Sound, len(d.sounds))
+	copy(sounds, d.sounds)
+	return sounds
+}
+
+// Counts returns the number of requests each endpoint has received.
+func (d *Device) Counts() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := make(map[string]int)
+	for k, v := range d.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+func (d *Device) handle(endpoint string, f func(*http.Request) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.failing {
+			http.Error(w, "injected failure", http.StatusServiceUnavailable)
+			return
+		}
+		if time.Now().Before(d.asleepUntil) {
+			http.Error(w, "asleep", http.StatusServiceUnavailable)
+			return
+		}
+		d.counts[endpoint]++
+		body := ""
+		if f != nil {
+			var err error
+			if body, err = f(r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		fmt.Fprintln(w, body)
+	}
+}
+
+// The number of command tokens a device remembers, as in the firmware.
+const rememberedTokens = 16
+
+// once wraps a queueing command's handler so that a repeat of a command,
+// with the same token, succeeds without doing anything.
+func (d *Device) once(f func(*http.Request) (string, error)) func(*http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		token := r.FormValue("token")
+		if token != "" && slices.Contains(d.tokens, token) {
+			return "", nil
+		}
+		body, err := f(r)
+		if err == nil && token != "" {
+			d.tokens = append(d.tokens, token)
+			if len(d.tokens) > rememberedTokens {
+				d.tokens = d.tokens[1:]
+			}
+		}
+		return body, err
+	}
+}
+
+func (d *Device) play(r *http.Request) (string, error) {
+	folder := intArg(r, "folder")
+	file := intArg(r, "file")
+	reps := max(intArg(r, "reps"), 1)
+	delay := time.Duration(intArg(r, "delay")) * time.Millisecond
+	volume := intArg(r, "volume")
+	if folder < 1 || folder > 99 {
+		return "", fmt.Errorf("folder %d must be between 1 and 99 inclusive", folder)
+	}
+	if file < 1 || file > 255 {
+		return "", fmt.Errorf("file %d must be between 1 and 255 inclusive", file)
+	}
+	if volume < 0 || volume > types.MaxVolume {
+		return "", fmt.Errorf("volume %d must be between 0 and %d inclusive", volume, types.MaxVolume)
+	}
+	if volume > 0 {
+		d.volume = volume
+	}
+	if d.clipAbove > 0 && d.volume > d.clipAbove {
+		d.clips++
+	}
+	var dur time.Duration
+	if d.duration != nil {
+		dur = d.duration(folder, file)
+	}
+	end := enqueue(&d.soundQueue, (dur + delay) * time.Duration(reps), r.FormValue("token"))
+	if !d.recording {
+		return "", nil
+	}
+	d.sounds = append(d.sounds, Sound{
+		Start:		end.Add(-(dur + delay) * time.Duration(reps)),
+		Folder:		folder,
+		File:		file,
+		Duration:	dur,
+		Volume:		d.volume,
+		Reps:		reps,
+		Delay:		delay,
+		Jitter:		time.Duration(intArg(r, "jitter")) * time.Millisecond,
+	})
+	return "", nil
+}
+
+func (d *Device) level(r *http.Request) (string, error) {
+	peak := float64(d.volume) / types.MaxVolume
+	if d.clips > 0 {
+		peak = 1
+	}
+	body := fmt.Sprintf("%.2f %d", peak, d.clips)
+	d.clips = 0
+	return body, nil
+}
+
+func (d *Device) setVolume(r *http.Request) (string, error) {
+	volume := intArg(r, "volume")
+	if volume < 0 || volume > types.MaxVolume {
+		return "", fmt.Errorf("volume must be between 0 and %d inclusive", types.MaxVolume)
+	}
+	d.volume = volume
+	return "", nil
+}
+
+func (d *Device) blink(r *http.Request) (string, error) {
+	speed, _ := strconv.ParseFloat(r.FormValue("speed"), 64)
+	reps := intArg(r, "reps")
+	delay := intArg(r, "delay")
+	if speed < 0.001 {
+		return "", fmt.Errorf("speed must be faster")
+	}
+	if reps <= 0 {
+		return "", fmt.Errorf("reps must be a positive number")
+	}
+	msec := ((256.0 / speed) * 2.0 + float64(delay)) * float64(reps)
+	enqueue(&d.lightQueue, time.Duration(msec * float64(time.Millisecond)), r.FormValue("token"))
+	return "", nil
+}
+
+func (d *Device) fade(r *http.Request) (string, error) {
+	level := intArg(r, "level")
+	msec := intArg(r, "ms")
+	if level < 0 || level > types.MaxBrightness {
+		return "", fmt.Errorf("level must be between 0 and %d", types.MaxBrightness)
+	}
+	if msec < 0 {
+		return "", fmt.Errorf("ms must not be negative")
+	}
+	enqueue(&d.lightQueue, time.Duration(msec) * time.Millisecond, r.FormValue("token"))
+	return "", nil
+}
+
+func (d *Device) stop(r *http.Request) (string, error) {
+	d.soundQueue = nil
+	d.cutSounds()
+	return "", nil
+}
+
+// sleep makes the device unreachable for a while. It wakes up as if it
+// had restarted, as the firmware does.
+func (d *Device) sleep(r *http.Request) (string, error) {
+	ms, err := strconv.ParseInt(r.FormValue("ms"), 10, 64)
+	if err != nil || ms <= 0 {
+		return "", fmt.Errorf("ms must be a positive number")
+	}
+	d.asleepUntil = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	d.soundQueue = nil
+	d.lightQueue = nil
+	d.cutSounds()
+	d.volume = initialVolume
+	return "", nil
+}
+
+// cutSounds records that whatever is playing or queued has been cut off.
+func (d *Device) cutSounds() {
+	now := time.Now()
+	for i := len(d.sounds) - 1; i >= 0 && d.sounds[i].End().After(now); i-- {
+		if d.sounds[i].Cut.IsZero() {
+			d.sounds[i].Cut = now
+		}
+	}
+}
+
+// queue lists the tokens of the items in one of the device's queues.
+func (d *Device) queue(r *http.Request) (string, error) {
+	var q *[]queued
+	switch r.FormValue("queue") {
+	case "sound":
+		q = &d.soundQueue
+	case "light":
+		q = &d.lightQueue
+	default:
+		return "", fmt.Errorf("queue must be either \"sound\" or \"light\"")
+	}
+	pending(q)
+	var b strings.Builder
+	for _, item := range *q {
+		if item.token != "" {
+			b.WriteString(item.token + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// Enqueue adds a play with the given token to the device's sound queue,
+// as if someone else had sent it, e.g. a server that has since
+// restarted.
+func (d *Device) Enqueue(dur time.Duration, token string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	enqueue(&d.soundQueue, dur, token)
+}
+
+func (d *Device) clear(r *http.Request) (string, error) {
+	switch r.FormValue("queue") {
+	case "sound":
+		d.soundQueue = nil
+		d.cutSounds()
+	case "light":
+		d.lightQueue = nil
+	default:
+		return "", fmt.Errorf("queue must be either \"sound\" or \"light\"")
+	}
+	return "", nil
+}
+
+// enqueue adds an item of the given duration after everything already
+// in the queue, and returns when it will end.
+func enqueue(q *[]queued, dur time.Duration, token string) time.Time {
+	start := time.Now()
+	if n := len(*q); n > 0 && (*q)[n-1].end.After(start) {
+		start = (*q)[n-1].end
+	}
+	*q = append(*q, queued{end: start.Add(dur), token: token})
+	return start.Add(dur)
+}
+
+// pending discards finished items from the queue, and returns the number
+// of items that remain.
+func pending(q *[]queued) int {
+	now := time.Now()
+	for len(*q) > 0 && !(*q)[0].end.After(now) {
+		*q = (*q)[1:]
+	}
+	return len(*q)
+}
+
+func intArg(r *http.Request, name string) int {
+	v, _ := strconv.Atoi(r.FormValue(name))
+	return v
+}
@@ -0,0 +1,78 @@
+package weather
+
+import (
+	"fmt"
+)
+
+// Dolbear's law relates the temperature to how fast snowy tree crickets
+// chirp: in degrees Celsius, about 7.2 * T - 32 chirps a minute. Other
+// crickets are slower or faster, but they all speed up as it warms, by
+// about the same proportion, so the law is applied as a ratio: a bound
+// parameter is as configured at the reference temperature, and scaled
+// by how much faster or slower a cricket would chirp at the current one.
+//
+// The law comes in two forms, for the two kinds of parameter a cricket
+// effect has:
+const (
+	// Dolbear is for the time between chirps, which shrinks as it
+	// warms.
+	Dolbear		= "dolbear"
+
+	// DolbearRate is for the number of chirps in a given time, which
+	// grows as it warms.
+	DolbearRate	= "dolbear-rate"
+)
+
+const (
+	// The reference temperature, if a binding doesn't give one.
+	dolbearReference = 20.0
+
+	// Real crickets stop when it's too cold for the law to give any
+	// chirps at all (below about 4.5 degrees); effects instead go on
+	// as slowly as this, rather than stopping or blowing up.
+	minChirpsPerMinute = 4.0
+)
+
+// chirpsPerMinute is Dolbear's law, for a temperature in degrees Celsius.
+func chirpsPerMinute(celsius float64) float64 {
+	return max(7.2 * celsius - 32, minChirpsPerMinute)
+}
+
+func (b Binding) checkLaw() error {
+	switch b.Law {
+	case Dolbear, DolbearRate:
+	default:
+		return fmt.Errorf("unknown law %q (has %q and %q)", b.Law, Dolbear, DolbearRate)
+	}
+	if b.Variable != "" && b.Variable != Temperature {
+		return fmt.Errorf("law %q follows %q, not %q", b.Law, Temperature, b.Variable)
+	}
+	if b.Sensitivity != 0 {
+		return fmt.Errorf("law %q doesn't take a sensitivity", b.Law)
+	}
+	if r := b.reference(); chirpsPerMinute(r) <= minChirpsPerMinute {
+		return fmt.Errorf("law %q's reference temperature %v is too cold for crickets", b.Law, r)
+	}
+	return nil
+}
+
+func (b Binding) reference() float64 {
+	if b.Reference == 0 {
+		return dolbearReference
+	}
+	return b.Reference
+}
+
+// lawFactor returns what to multiply a parameter following Dolbear's law
+// by, now.
+func (b Binding) lawFactor() float64 {
+	t, ok := Value(Temperature)
+	if !ok {
+		return 1
+	}
+	speedup := chirpsPerMinute(t) / chirpsPerMinute(b.reference())
+	if b.Law == DolbearRate {
+		return speedup
+	}
+	return 1 / speedup
+}
@@ -0,0 +1,288 @@
+// Package weather holds the real conditions outside an installation, as
+// named variables that effects can follow:
+//
+//   - "temperature", in degrees Celsius
+//   - "wind", the wind speed in meters per second
+//   - "rain", in millimeters over the provider's latest interval
+//
+// The variables come from a weather provider that's polled every so
+// often, and operators (or sensors, via the admin API) can set them too.
+// A variable that hasn't been set has no value, and nothing follows it.
+//
+// Effects use the variables in two ways. A binding scales a parameter
+// the way a sensitivity does in the intensity package: a parameter with
+// a binding of sensitivity s is multiplied by 2^(s * (value - reference)),
+// so that e.g. cold nights can slow a chirp. A binding can instead follow
+// Dolbear's law, which real crickets do; see dolbear.go. And an effect
+// can be suppressed while a variable is above a threshold, so that an
+// artificial storm doesn't compete with a real one.
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/blakej11/cricket/internal/log"
+)
+
+// Names of the variables.
+const (
+	Temperature	= "temperature"
+	Wind		= "wind"
+	Rain		= "rain"
+)
+
+// Names are the names of all of the variables.
+var Names = []string{Temperature, Wind, Rain}
+
+// Config describes the weather provider, which is Open-Meteo's forecast
+// API unless URL is set to something that answers in the same way.
+type Config struct {
+	Latitude	float64
+	Longitude	float64
+	URL		string
+	PollMinutes	float64	// by default, defaultPoll
+}
+
+const (
+	defaultURL	= "https://api.open-meteo.com/v1/forecast"
+	defaultPoll	= 15 * time.Minute
+	fetchTimeout	= 30 * time.Second
+)
+
+// Check returns an error if the configuration is invalid.
+func (c Config) Check() error {
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return fmt.Errorf("weather latitude %v must be in [-90, 90]", c.Latitude)
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return fmt.Errorf("weather longitude %v must be in [-180, 180]", c.Longitude)
+	}
+	if c.URL != "" {
+		if _, err := url.Parse(c.URL); err != nil {
+			return fmt.Errorf("weather URL: %w", err)
+		}
+	}
+	if c.PollMinutes < 0 {
+		return fmt.Errorf("weather poll interval %v must not be negative", c.PollMinutes)
+	}
+	return nil
+}
+
+// Reading is a variable's value, and when it was set.
+type Reading struct {
+	Value	float64
+	At	time.Time
+	Source	string	// "provider" or "operator"
+}
+
+var weather struct {
+	mu		sync.Mutex
+	readings	map[string]Reading
+}
+
+func init() {
+	weather.readings = make(map[string]Reading)
+}
+
+// Value returns the named variable's value, and whether it has one.
+func Value(name string) (float64, bool) {
+	weather.mu.Lock()
+	defer weather.mu.Unlock()
+	r, ok := weather.readings[name]
+	return r.Value, ok
+}
+
+// Readings returns all of the variables that have values.
+func Readings() map[string]Reading {
+	weather.mu.Lock()
+	defer weather.mu.Unlock()
+	readings := make(map[string]Reading)
+	for name, r := range weather.readings {
+		readings[name] = r
+	}
+	return readings
+}
+
+// Set sets the named variable, e.g. from a sensor. It holds until the
+// provider next reports the variable, if there's a provider.
+func Set(name string, value float64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("weather variable %q can't be %v", name, value)
+	}
+	log.Infof("weather %s set to %v", name, value)
+	set(name, value, "operator")
+	return nil
+}
+
+func set(name string, value float64, source string) {
+	weather.mu.Lock()
+	defer weather.mu.Unlock()
+	weather.readings[name] = Reading{Value: value, At: time.Now(), Source: source}
+}
+
+func checkName(name string) error {
+	if !slices.Contains(Names, name) {
+		return fmt.Errorf("unknown weather variable %q (has %v)", name, Names)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// Binding describes how a parameter follows a variable; see the package
+// comment. At the reference value, or if the variable has no value, the
+// parameter is as configured.
+type Binding struct {
+	Variable	string
+	Reference	float64
+	Sensitivity	float64
+
+	// If set, one of the laws in dolbear.go, which replaces
+	// Sensitivity.
+	Law		string
+}
+
+// Check returns an error if the binding is invalid.
+func (b Binding) Check() error {
+	if b.Law != "" {
+		return b.checkLaw()
+	}
+	return checkName(b.Variable)
+}
+
+// Factor returns what to multiply the bound parameter by, now.
+func (b Binding) Factor() float64 {
+	if b.Law != "" {
+		return b.lawFactor()
+	}
+	v, ok := Value(b.Variable)
+	if !ok {
+		return 1
+	}
+	return math.Exp2(b.Sensitivity * (v - b.Reference))
+}
+
+// Thresholds maps variables' names to values above which something is
+// suppressed, e.g. {"rain": 0.1}.
+type Thresholds map[string]float64
+
+// Check returns an error if the thresholds name unknown variables.
+func (t Thresholds) Check() error {
+	for name := range t {
+		if err := checkName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exceeded returns an error describing the first variable (by name)
+// that's above its threshold, or nil if none are.
+func (t Thresholds) Exceeded() error {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v, ok := Value(name); ok && v > t[name] {
+			return fmt.Errorf("%s is %v, above %v", name, v, t[name])
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// Start polls the weather provider for as long as the server runs. The
+// configuration must have been checked with Check.
+func Start(c Config) {
+	poll := time.Duration(c.PollMinutes * float64(time.Minute))
+	if poll == 0 {
+		poll = defaultPoll
+	}
+	go func() {
+		failing := false
+		for ; ; time.Sleep(poll) {
+			err := fetch(c)
+			switch {
+			case err != nil && !failing:
+				log.Warningf("can't get the weather: %v", err)
+			case err == nil && failing:
+				log.Infof("getting the weather again")
+			}
+			failing = err != nil
+		}
+	}()
+}
+
+// forecast is the part of the provider's answer that's used.
+type forecast struct {
+	Current	struct {
+		Temperature	*float64	`json:"temperature_2m"`
+		Wind		*float64	`json:"wind_speed_10m"`
+		Precipitation	*float64	`json:"precipitation"`
+	}	`json:"current"`
+}
+
+// fetch asks the provider for the current conditions, and sets the
+// variables it reports.
+func fetch(c Config) error {
+	base := c.URL
+	if base == "" {
+		base = defaultURL
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("latitude", strconv.FormatFloat(c.Latitude, 'f', -1, 64))
+	q.Set("longitude", strconv.FormatFloat(c.Longitude, 'f', -1, 64))
+	q.Set("current", "temperature_2m,wind_speed_10m,precipitation")
+	q.Set("wind_speed_unit", "ms")
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider answered %s", resp.Status)
+	}
+	var f forecast
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return fmt.Errorf("can't decode provider's answer: %w", err)
+	}
+
+	for name, v := range map[string]*float64{
+		Temperature:	f.Current.Temperature,
+		Wind:		f.Current.Wind,
+		Rain:		f.Current.Precipitation,
+	} {
+		if v != nil {
+			set(name, *v, "provider")
+		}
+	}
+	return nil
+}
@@ -2,32 +2,121 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	"os"
 
+	"github.com/blakej11/cricket/internal/admin"
+	"github.com/blakej11/cricket/internal/client"
 	"github.com/blakej11/cricket/internal/config"
+	"github.com/blakej11/cricket/internal/coverage"
+	"github.com/blakej11/cricket/internal/sweep"
 )
 
+var adminAddr = flag.String("admin", "", "address to serve the admin API on, e.g. \":8080\"")
+var pollAddr = flag.String("poll", "", "address to accept polling connections from devices on, e.g. \":8081\"")
 var configFile = flag.String("config", "", "path to config file")
+var venue = flag.String("venue", "", "name of the venue in the config file to use, if it has several")
+var schema = flag.Bool("schema", false, "print a JSON Schema for the config file and exit")
+var plan = flag.Bool("plan", false, "print a coverage plan for the configured clients and exit")
+var planRadius = flag.Float64("plan-radius", 5, "speaker radius in meters, for clients that don't specify one")
+var planResolution = flag.Float64("plan-resolution", 0.5, "grid spacing for the coverage plan, in meters")
+var planFraction = flag.Float64("plan-fraction", 0.25, "fraction of the fleet to suggest clients for")
+var suggestZones = flag.Int("zones", 0, "cluster the configured clients into this many zones, print the config with those zones, and exit")
+var exportFleet = flag.Bool("export-fleet", false, "print the configured fleet as a fleet bundle and exit")
+var showSeed = flag.String("seed", "", "show seed, e.g. from an earlier night's session summary; overrides the config's ShowSeed")
+var sweepFile = flag.String("sweep", "", "path to parameter sweep description; runs the sweep against a virtual fleet and exits")
 
 func main() {
 	flag.Parse()
 
+	if *schema {
+		s, err := config.Schema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(append(s, '\n'))
+		return
+	}
+
 	if *configFile == "" {
 		log.Fatal("must specify configuration via \"-config=/path/to/config.json\"")
 	}
 	jsonBlob, err := os.ReadFile(*configFile)
 	if err != nil {
-		log.Fatalf("could not open config file %q: %w", *configFile, err)
+		log.Fatalf("could not open config file %q: %v", *configFile, err)
 	}
-	cfg, err := config.ParseJSON(jsonBlob)
+	cfg, err := config.ParseJSONForVenue(jsonBlob, *venue)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *plan {
+		report, err := coverage.Analyze(cfg.Clients(), coverage.Config{
+			Radius:		*planRadius,
+			Resolution:	*planResolution,
+			Fraction:	*planFraction,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		report.Write(os.Stdout)
+		return
+	}
+
+	if *suggestZones > 0 {
+		zones, err := coverage.SuggestZones(cfg.Clients(), *suggestZones)
+		if err != nil {
+			log.Fatal(err)
+		}
+		updated, err := config.WithZones(jsonBlob, *venue, zones)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(append(updated, '\n'))
+		return
+	}
+
+	if *exportFleet {
+		b, err := json.MarshalIndent(cfg.Fleet(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(append(b, '\n'))
+		return
+	}
+
+	if *sweepFile != "" {
+		runSweep(cfg)
+		return
+	}
+
+	if *showSeed != "" {
+		cfg.SetShowSeed(*showSeed)
+	}
 	cfg.Run()
+	if *pollAddr != "" {
+		client.ServePolling(*pollAddr, cfg.Namespace())
+	}
+	if *adminAddr != "" {
+		admin.Start(*adminAddr, cfg)
+	}
 
 	ctx := context.Background()
 	<-ctx.Done()
 }
+
+func runSweep(cfg *config.ConfigImpl) {
+	sweepBlob, err := os.ReadFile(*sweepFile)
+	if err != nil {
+		log.Fatalf("could not open sweep file %q: %v", *sweepFile, err)
+	}
+	var sc sweep.Config
+	if err := json.Unmarshal(sweepBlob, &sc); err != nil {
+		log.Fatalf("failed to unmarshal sweep file %q: %v", *sweepFile, err)
+	}
+	if _, err := sweep.Run(cfg, sc, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
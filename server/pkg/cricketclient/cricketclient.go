@@ -0,0 +1,317 @@
+// Package cricketclient is a client for the server's admin API, for
+// companion programs such as kiosk displays or controllers in other art
+// pieces, so they needn't make HTTP calls by hand.
+//
+// It has its own copies of the types the API returns, and depends only
+// on the standard library, so programs outside this module may use it.
+// Within a major version of the module, existing methods keep working
+// with the server; fields may be added to the types they return.
+package cricketclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one server's admin API.
+type Client struct {
+	base	string
+	http	*http.Client
+}
+
+// New returns a client for the admin API at the given base URL, e.g.
+// "http://cricket.local:8080". If hc is nil, http.DefaultClient is used.
+// Subscribe's streams last as long as their context, so hc shouldn't
+// have a Timeout; use contexts to bound other calls instead.
+func New(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{base: strings.TrimSuffix(baseURL, "/"), http: hc}
+}
+
+// Error is returned when the server rejects a request.
+type Error struct {
+	StatusCode	int
+	Message		string	// what the server said
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cricket server: %s (%d)", e.Message, e.StatusCode)
+}
+
+// ---------------------------------------------------------------------
+// Types the API returns.
+
+// ClientInfo describes one device.
+type ClientInfo struct {
+	ID		string
+	Name		string
+	Zone		string
+	Address		string
+	Location	struct{ X, Y float64 }
+	State		string
+	Voltage		float32	// zero if not known yet
+	Queued		int	// requests waiting to be sent
+	Firmware	string
+	Profile		string
+	LastSeen	time.Time
+	Requests	int
+	Failures	int
+	Volume		int
+	Paused		bool
+}
+
+// LeaseSnapshot describes who holds the devices of one lease type.
+type LeaseSnapshot struct {
+	FleetSize	int
+	Unallocated	[]string
+	Asleep		[]string
+	Holders		[]struct {
+		Name		string
+		TargetFraction	float64
+		TargetCount	int
+		Clients		[]string
+	}
+	Waiting		*struct {
+		Name	string
+		Want	int
+		Min	int
+		Have	int
+		Since	time.Time
+	}
+}
+
+// Claim is a set of devices that a companion program holds, so effects
+// leave them alone until it's released.
+type Claim struct {
+	Handle	string
+	Type	string
+	Clients	[]string
+}
+
+// Occupancy is the latest visitor count.
+type Occupancy struct {
+	Visitors	int
+	Level		float64
+	Updated		time.Time	// zero if never reported
+	Stale		bool
+}
+
+// ---------------------------------------------------------------------
+// Inspecting the installation.
+
+// Clients returns the known devices, optionally only those whose ID,
+// name, zone, serial number, or notes contain query.
+func (c *Client) Clients(ctx context.Context, query string) ([]ClientInfo, error) {
+	var result []ClientInfo
+	err := c.get(ctx, "/clients", url.Values{"q": {query}}, &result)
+	return result, err
+}
+
+// Leases returns who holds which devices, by lease type ("sound" or
+// "light").
+func (c *Client) Leases(ctx context.Context) (map[string]LeaseSnapshot, error) {
+	var result map[string]LeaseSnapshot
+	err := c.get(ctx, "/leases", nil, &result)
+	return result, err
+}
+
+// Stopped reports whether the installation is emergency-stopped.
+func (c *Client) Stopped(ctx context.Context) (bool, error) {
+	var result struct{ Stopped bool }
+	err := c.get(ctx, "/estop", nil, &result)
+	return result.Stopped, err
+}
+
+// Intensity returns the installation's intensity, from 0 to 1.
+func (c *Client) Intensity(ctx context.Context) (float64, error) {
+	var result struct{ Level float64 }
+	err := c.get(ctx, "/intensity", nil, &result)
+	return result.Level, err
+}
+
+// Occupancy returns the latest visitor count.
+func (c *Client) Occupancy(ctx context.Context) (Occupancy, error) {
+	var result Occupancy
+	err := c.get(ctx, "/occupancy", nil, &result)
+	return result, err
+}
+
+// ---------------------------------------------------------------------
+// Controlling the installation.
+
+// Startle startles the crickets, e.g. when a motion sensor fires.
+func (c *Client) Startle(ctx context.Context) error {
+	return c.post(ctx, "/startle", nil, nil)
+}
+
+// EmergencyStop silences everything, as the emergency stop button does.
+func (c *Client) EmergencyStop(ctx context.Context) error {
+	return c.post(ctx, "/estop", nil, nil)
+}
+
+// ReleaseEmergencyStop lets the installation carry on after an
+// emergency stop.
+func (c *Client) ReleaseEmergencyStop(ctx context.Context) error {
+	return c.post(ctx, "/estop/release", nil, nil)
+}
+
+// Pause pauses sound in a zone, or everywhere if zone is empty.
+func (c *Client) Pause(ctx context.Context, zone string) error {
+	return c.post(ctx, "/pause", url.Values{"zone": {zone}}, nil)
+}
+
+// Unpause undoes Pause.
+func (c *Client) Unpause(ctx context.Context, zone string) error {
+	return c.post(ctx, "/unpause", url.Values{"zone": {zone}}, nil)
+}
+
+// AdjustVolume turns the volume in a zone (or everywhere, if zone is
+// empty) up or down by delta steps.
+func (c *Client) AdjustVolume(ctx context.Context, zone string, delta int) error {
+	return c.post(ctx, "/volume", url.Values{
+		"zone":		{zone},
+		"delta":	{strconv.Itoa(delta)},
+	}, nil)
+}
+
+// RampVolume moves the volume in a zone (or everywhere, if zone is
+// empty) to level, over the given time.
+func (c *Client) RampVolume(ctx context.Context, zone string, level int, over time.Duration) error {
+	return c.post(ctx, "/volume", url.Values{
+		"zone":		{zone},
+		"level":	{strconv.Itoa(level)},
+		"seconds":	{seconds(over)},
+	}, nil)
+}
+
+// SetIntensity sets the installation's intensity, from 0 to 1.
+func (c *Client) SetIntensity(ctx context.Context, level float64) error {
+	return c.post(ctx, "/intensity", url.Values{"level": {float(level)}}, nil)
+}
+
+// SetWeather reports a weather reading, e.g. "temperature", from a
+// sensor.
+func (c *Client) SetWeather(ctx context.Context, name string, value float64) error {
+	return c.post(ctx, "/weather", url.Values{"name": {name}, "value": {float(value)}}, nil)
+}
+
+// SetOccupancy reports how many visitors are present.
+func (c *Client) SetOccupancy(ctx context.Context, visitors int) error {
+	return c.post(ctx, "/occupancy", url.Values{"count": {strconv.Itoa(visitors)}}, nil)
+}
+
+// CountVisitors reports how many visitors came in and went out since
+// the last report, for counters that see a door rather than a room.
+func (c *Client) CountVisitors(ctx context.Context, in, out int) error {
+	return c.post(ctx, "/occupancy", url.Values{
+		"in":	{strconv.Itoa(in)},
+		"out":	{strconv.Itoa(out)},
+	}, nil)
+}
+
+// Finale runs the configured finale effect.
+func (c *Client) Finale(ctx context.Context) error {
+	return c.post(ctx, "/finale", nil, nil)
+}
+
+// RecallScene puts the installation back the way it was in a captured
+// scene, moving there over the given time.
+func (c *Client) RecallScene(ctx context.Context, name string, over time.Duration) error {
+	return c.post(ctx, "/scenes/" + url.PathEscape(name) + "/recall",
+	    url.Values{"seconds": {seconds(over)}}, nil)
+}
+
+// Claim holds up to count idle devices of a lease type ("sound" or
+// "light"). It fails only if none are idle. The claim must be given
+// back with ReleaseClaim.
+func (c *Client) Claim(ctx context.Context, leaseType string, count int) (Claim, error) {
+	var result Claim
+	err := c.post(ctx, "/claims", url.Values{
+		"type":		{leaseType},
+		"count":	{strconv.Itoa(count)},
+	}, &result)
+	return result, err
+}
+
+// ResizeClaim changes how many devices a claim holds. Shrinking gives
+// back the most recently claimed devices.
+func (c *Client) ResizeClaim(ctx context.Context, handle string, count int) (Claim, error) {
+	var result Claim
+	err := c.post(ctx, "/claims/" + url.PathEscape(handle),
+	    url.Values{"count": {strconv.Itoa(count)}}, &result)
+	return result, err
+}
+
+// ReleaseClaim gives a claim's devices back. If stop is set, whatever
+// they have queued is cleared, rather than played out.
+func (c *Client) ReleaseClaim(ctx context.Context, handle string, stop bool) error {
+	return c.do(ctx, http.MethodDelete, "/claims/" + url.PathEscape(handle),
+	    url.Values{"stop": {strconv.FormatBool(stop)}}, nil)
+}
+
+// ---------------------------------------------------------------------
+
+func (c *Client) get(ctx context.Context, path string, params url.Values, result any) error {
+	return c.do(ctx, http.MethodGet, path, params, result)
+}
+
+func (c *Client) post(ctx context.Context, path string, params url.Values, result any) error {
+	return c.do(ctx, http.MethodPost, path, params, result)
+}
+
+// do makes a request, with params in the query string, and decodes the
+// JSON response into result, unless that's nil.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, result any) error {
+	resp, err := c.send(ctx, method, path, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("cricket server: bad response to %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send makes a request, and returns the response if it succeeded.
+func (c *Client) send(ctx context.Context, method, path string, params url.Values) (*http.Response, error) {
+	u := c.base + path
+	if q := params.Encode(); q != "" {
+		u += "?" + q
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode / 100 != 2 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+	return resp, nil
+}
+
+func seconds(d time.Duration) string {
+	return float(d.Seconds())
+}
+
+func float(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
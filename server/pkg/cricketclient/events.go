@@ -0,0 +1,70 @@
+package cricketclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event is a message that an effect, or the server, announced on the
+// bus: e.g. a thunderclap, or a change in intensity.
+type Event struct {
+	Key	string		// what happened
+	Sender	string		// the name of the effect that announced it
+	Time	time.Time	// when it was announced
+	Value	float64		// optional, e.g. an intensity
+}
+
+// How many events a subscriber can fall behind by before it starts
+// missing them.
+const eventBuffer = 16
+
+// Subscribe streams the events with the given keys, e.g. "intensity",
+// until the context is done or the connection is lost; either way, the
+// channel is then closed. A subscriber that falls more than a few events
+// behind misses events, rather than holding up the stream.
+func (c *Client) Subscribe(ctx context.Context, keys ...string) (<-chan Event, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/events", url.Values{"key": keys})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, eventBuffer)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		// The stream is server-sent events, each with a "data" line
+		// holding the event as JSON, and ending with a blank line.
+		var data strings.Builder
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if d, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(d, " "))
+				continue
+			}
+			if line != "" || data.Len() == 0 {
+				continue
+			}
+			var e Event
+			err := json.Unmarshal([]byte(data.String()), &e)
+			data.Reset()
+			if err != nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case events <- e:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
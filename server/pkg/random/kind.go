@@ -0,0 +1,156 @@
+package random
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// MaxVolume is the loudest volume a device can be set to.
+const MaxVolume = 48
+
+// Kind says what a random value is used for, and so what range it must
+// fall in.
+type Kind int
+const (
+	AnyKind		Kind = iota
+	DurationKind	// seconds, >= 0
+	VolumeKind	// device volume, 0 to MaxVolume
+	CountKind	// a number of things, >= 0
+	FractionKind	// a fraction of something, 0 to 1
+)
+
+// Bounds returns the range of values allowed for this kind. Either end
+// may be infinite.
+func (k Kind) Bounds() (float64, float64) {
+	switch k {
+	case VolumeKind:
+		return 0, MaxVolume
+	case DurationKind, CountKind:
+		return 0, math.Inf(1)
+	case FractionKind:
+		return 0, 1
+	}
+	return math.Inf(-1), math.Inf(1)
+}
+
+// Check returns an error if values drawn from c will obviously be out of
+// range for this kind. It checks the mean, the variance, the extent of
+// a uniform distribution, the mean after each configured change, and
+// each of the choices, if there are any.
+func (k Kind) Check(c Config) error {
+	lo, hi := k.Bounds()
+	check := func(what string, v float64) error {
+		if v < lo || v > hi {
+			return fmt.Errorf("%s %v out of range for a %v (must be in [%v, %v])", what, v, k, lo, hi)
+		}
+		return nil
+	}
+
+	if c.Step < 0 {
+		return fmt.Errorf("step %v is negative", c.Step)
+	}
+	if len(c.Choices) > 0 {
+		for i, v := range c.Choices {
+			if err := check(fmt.Sprintf("choice %d", i), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if c.Variance < 0 {
+		return fmt.Errorf("variance %v is negative", c.Variance)
+	}
+	if err := check("mean", c.Mean); err != nil {
+		return err
+	}
+	if c.Distribution == Uniform {
+		if err := check("minimum", c.Mean - c.Variance / 2.0); err != nil {
+			return err
+		}
+		if err := check("maximum", c.Mean + c.Variance / 2.0); err != nil {
+			return err
+		}
+	}
+	mean := c.Mean
+	for i, d := range c.Changes {
+		mean += d.MeanDeltaRate * d.Duration
+		if err := check(fmt.Sprintf("mean after change %d", i), mean); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k Kind) String() string {
+	switch k {
+	default:
+		return "any"
+	case DurationKind:
+		return "duration"
+	case VolumeKind:
+		return "volume"
+	case CountKind:
+		return "count"
+	case FractionKind:
+		return "fraction"
+	}
+}
+
+func (k Kind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+func (k *Kind) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch strings.ToLower(s) {
+	default:
+		*k = AnyKind
+	case "duration":
+		*k = DurationKind
+	case "volume":
+		*k = VolumeKind
+	case "count":
+		*k = CountKind
+	case "fraction":
+		*k = FractionKind
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// DurationVar is a Variable whose values are durations.
+type DurationVar struct {
+	*Variable
+}
+
+// Get returns a new duration.
+func (v DurationVar) Get() time.Duration {
+	return v.Duration()
+}
+
+// VolumeVar is a Variable whose values are device volumes.
+type VolumeVar struct {
+	*Variable
+}
+
+// Get returns a new volume, clamped to the range the devices accept.
+func (v VolumeVar) Get() int {
+	return min(max(v.Int(), 0), MaxVolume)
+}
+
+// CountVar is a Variable whose values are counts.
+type CountVar struct {
+	*Variable
+}
+
+// Get returns a new count.
+func (v CountVar) Get() int {
+	return v.Int()
+}
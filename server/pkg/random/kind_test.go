@@ -0,0 +1,81 @@
+package random
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestKindCheck(t *testing.T) {
+	tests := []struct {
+		name	string
+		kind	Kind
+		config	Config
+		ok	bool
+	}{
+		{"any negative", AnyKind, Config{Mean: -5}, true},
+		{"duration", DurationKind, Config{Mean: 3, Variance: 1, Distribution: Normal}, true},
+		{"negative duration", DurationKind, Config{Mean: -1}, false},
+		{"negative variance", DurationKind, Config{Mean: 1, Variance: -1}, false},
+		{"negative step", CountKind, Config{Mean: 1, Step: -1}, false},
+		{"uniform below zero", CountKind, Config{Mean: 1, Variance: 4, Distribution: Uniform}, false},
+		{"uniform within range", CountKind, Config{Mean: 2, Variance: 4, Distribution: Uniform}, true},
+		{"loudest volume", VolumeKind, Config{Mean: MaxVolume}, true},
+		{"too loud", VolumeKind, Config{Mean: MaxVolume + 1}, false},
+		{"uniform too loud", VolumeKind, Config{Mean: MaxVolume - 1, Variance: 4, Distribution: Uniform}, false},
+		{"fraction", FractionKind, Config{Mean: 0.5}, true},
+		{"fraction over one", FractionKind, Config{Mean: 1.5}, false},
+		{"change out of range", FractionKind, Config{Mean: 0.5, Changes: []Delta{
+			{MeanDeltaRate: 0.1, Duration: 10},
+		}}, false},
+		{"changes back in range", FractionKind, Config{Mean: 0.5, Changes: []Delta{
+			{MeanDeltaRate: 0.1, Duration: 4},
+			{MeanDeltaRate: -0.1, Duration: 4},
+		}}, true},
+		{"choices", VolumeKind, Config{Choices: []float64{0, 10, MaxVolume}}, true},
+		{"choice out of range", VolumeKind, Config{Choices: []float64{10, MaxVolume + 1}}, false},
+		{"choices ignore mean", FractionKind, Config{Mean: 7, Choices: []float64{0.25}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.kind.Check(tt.config)
+			if (err == nil) != tt.ok {
+				t.Errorf("%v.Check(%+v) = %v, want ok = %v", tt.kind, tt.config, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestKindJSON(t *testing.T) {
+	for _, k := range []Kind{AnyKind, DurationKind, VolumeKind, CountKind, FractionKind} {
+		b, err := json.Marshal(k)
+		if err != nil {
+			t.Fatalf("json.Marshal(%v): %v", k, err)
+		}
+		var got Kind
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %v", b, err)
+		}
+		if got != k {
+			t.Errorf("%v round-tripped through %s as %v", k, b, got)
+		}
+	}
+}
+
+func TestVolumeVarClamps(t *testing.T) {
+	tests := []struct {
+		mean	float64
+		want	int
+	}{
+		{-3, 0},
+		{0, 0},
+		{20, 20},
+		{MaxVolume, MaxVolume},
+		{MaxVolume * 2, MaxVolume},
+	}
+	for _, tt := range tests {
+		v := VolumeVar{New(Config{Mean: tt.mean})}
+		if got := v.Get(); got != tt.want {
+			t.Errorf("VolumeVar with mean %v: Get() = %d, want %d", tt.mean, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package random describes and draws the random values that effects are
+// built from. A Config describes a distribution, whose mean and variance
+// may drift over time, and a Variable draws values from one. The
+// functions Float64, IntN, and so on draw from the show's shared source;
+// see SetSeed.
+//
+// Custom effects outside this module may use this package, and can rely
+// on these, which won't change within a major version of the module:
+//
+//   - Values drawn from a Variable are never negative.
+//   - The JSON form of a Config, including the names of distributions,
+//     stays the same, so configuration files keep working.
+//   - The Kinds and their ranges stay the same.
+//   - A given show seed gives the same sequence of values from the
+//     shared source, if nothing else draws from it in between.
+//
+// A Variable isn't safe for concurrent use; the shared source is.
+package random
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// Config describes how to choose the value of a parameter.
+type Config struct {
+	Mean		float64
+	Variance	float64
+	Distribution	Distribution
+	Changes		[]Delta
+	RepeatChanges	bool
+
+	// If set, values are rounded to the nearest multiple of Step, e.g.
+	// 1 for a whole number of reps.
+	Step		float64
+
+	// If set, each value is one of these, chosen with equal chance, and
+	// the settings above are ignored.
+	Choices		[]float64
+}
+
+type Distribution int
+const (
+	Unknown		Distribution = iota
+	Normal
+	Uniform
+	Exponential
+)
+
+type Delta struct {
+	MeanDeltaRate	float64	// change in mean, per second
+	VarDeltaRate	float64	// change in variance, per second
+	Duration	float64	// duration of these changes, in seconds
+}
+
+// ---------------------------------------------------------------------
+
+// Variable holds the runtime data for how to generate a random value.
+type Variable struct {
+	config		Config
+	mean		float64
+	variance	float64
+
+	// if non-nil, what to multiply values by; see ScaleBy
+	scale		func() float64
+
+	// these are only used if config.Changes is non-nil
+	now		func() time.Time
+	lastUpdateTime	time.Time
+	curChangeIndex	int
+	curDelta	Delta
+}
+
+func New(c Config) *Variable {
+	return NewWithClock(c, time.Now)
+}
+
+// NewWithClock is like New, but the variable's Changes follow the given
+// clock rather than the wall clock, e.g. for a simulation that runs
+// faster than real time.
+func NewWithClock(c Config, now func() time.Time) *Variable {
+	var curDelta Delta
+	if len(c.Changes) > 0 {
+		curDelta = c.Changes[0]
+	}
+	return &Variable{
+		config:		c,
+		mean:		c.Mean,
+		variance:	c.Variance,
+		now:		now,
+		lastUpdateTime:	time.Time{},
+		curChangeIndex:	0,
+		curDelta:	curDelta,
+	}
+}
+
+// Reset resets the random variable to its initial state. Its clock and
+// scale are kept.
+func (v *Variable) Reset() {
+	scale := v.scale
+	*v = *NewWithClock(v.config, v.now)
+	v.scale = scale
+}
+
+// ScaleBy makes the variable multiply each value it draws (and its mean
+// and variance, as durations) by what scale returns at the time, e.g. to
+// follow a control that an operator turns while the variable is in use.
+// Choices aren't scaled.
+func (v *Variable) ScaleBy(scale func() float64) {
+	v.scale = scale
+}
+
+// scaled returns x multiplied by the variable's scale.
+func (v *Variable) scaled(x float64) float64 {
+	if v.scale == nil {
+		return x
+	}
+	return x * v.scale()
+}
+
+// Float64 calculates a new concrete float64 value from the given Variable.
+//
+// - For Uniform distributions, the value will be in the range
+//   [Mean - Uniform / 2, Mean + Uniform / 2), uniformly distributed.
+//
+// - For Normal distributions, the value will be given by a normal
+//   distribution with mean = Mean and stdev = sqrt(Variance). A
+//   negative variance is treated as zero.
+//
+// - For Exponential distributions, the value will be exponentially
+//   distributed with mean = Mean, and Variance is ignored. This is the
+//   time between events that happen at random, Mean apart on average.
+//
+// The value is then multiplied by the variable's scale, if it has one
+// (see ScaleBy). If Step is set, it's rounded to a multiple of Step. If
+// Choices is set, the value is instead one of the choices, chosen with
+// equal chance.
+//
+// In all cases, the value returned will always be non-negative.
+func (v *Variable) Float64() float64 {
+	if n := len(v.config.Choices); n > 0 {
+		return max(v.config.Choices[IntN(n)], 0.0)
+	}
+	if v.lastUpdateTime.IsZero() {
+		v.lastUpdateTime = v.now()
+	}
+	if v.curChangeIndex < len(v.config.Changes) {
+		idx := v.curChangeIndex
+		t := v.now()
+		// How much time has elapsed since the last update?
+		d := t.Sub(v.lastUpdateTime).Seconds()
+
+		for {
+			// Use the current Delta until it runs out.
+			delta := &v.curDelta
+			dt := max(min(d, delta.Duration), 0.0)
+			delta.Duration -= dt
+			d -= dt
+
+			// Perform updates from this Delta.
+			v.mean += dt * delta.MeanDeltaRate
+			v.variance += dt * delta.VarDeltaRate
+
+			if d <= 0 {
+				break
+			}
+
+			// Pick a new Delta.
+			idx += 1
+			if idx == len(v.config.Changes) {
+				if !v.config.RepeatChanges || !v.changesTakeTime() {
+					break
+				}
+				idx = 0
+			}
+			v.curDelta = v.config.Changes[idx]
+		}
+		v.curChangeIndex = idx
+		v.lastUpdateTime = t
+	}
+
+	value := v.mean
+	switch (v.config.Distribution) {
+	default:
+		break
+	case Normal:
+		value += NormFloat64() * math.Sqrt(max(v.variance, 0.0))
+	case Uniform:
+		value += v.variance * Float64() - v.variance / 2.0
+	case Exponential:
+		value *= ExpFloat64()
+	}
+	value = max(v.scaled(value), 0.0)
+	if v.config.Step > 0 {
+		value = math.Round(value / v.config.Step) * v.config.Step
+	}
+	return value
+}
+
+// changesTakeTime returns whether any of the variable's Changes lasts for
+// some time; if none do, repeating them would never use any up.
+func (v *Variable) changesTakeTime() bool {
+	for _, d := range v.config.Changes {
+		if d.Duration > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Variable) Int() int {
+	return int(v.Float64())
+}
+
+func (v *Variable) Duration() time.Duration {
+	return time.Duration(v.Float64() * float64(time.Second))
+}
+
+func (v *Variable) MeanDuration() time.Duration {
+	return time.Duration(v.scaled(v.mean) * float64(time.Second))
+}
+
+func (v *Variable) VarianceDuration() time.Duration {
+	return time.Duration(v.scaled(v.variance) * float64(time.Second))
+}
+
+// ---------------------------------------------------------------------
+
+func (d *Distribution) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch strings.ToLower(s) {
+	default:
+		*d = Unknown
+	case "normal":
+		*d = Normal
+	case "uniform":
+		*d = Uniform
+	case "exponential":
+		*d = Exponential
+	}
+
+	return nil
+}
+
+func (d Distribution) MarshalJSON() ([]byte, error) {
+	var s string
+	switch d {
+	default:
+		s = "unknown"
+	case Normal:
+		s = "normal"
+	case Uniform:
+		s = "uniform"
+	case Exponential:
+		s = "exponential"
+	}
+
+	return json.Marshal(s)
+}
+
@@ -0,0 +1,182 @@
+package random
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFloat64NeverNegative(t *testing.T) {
+	configs := []Config{
+		{Mean: 0.1, Variance: 4, Distribution: Normal},
+		{Mean: 0.1, Variance: 4, Distribution: Uniform},
+		{Mean: 0.1, Distribution: Exponential},
+		{Mean: -1},
+		{Choices: []float64{-1, 2}},
+	}
+	for _, c := range configs {
+		v := New(c)
+		for range 1000 {
+			if x := v.Float64(); x < 0 {
+				t.Fatalf("%+v drew %v", c, x)
+			}
+		}
+	}
+}
+
+func TestFloat64Step(t *testing.T) {
+	v := New(Config{Mean: 10, Variance: 8, Distribution: Uniform, Step: 2})
+	for range 1000 {
+		x := v.Float64()
+		if x != float64(int(x / 2) * 2) {
+			t.Fatalf("drew %v, not a multiple of the step", x)
+		}
+		if x < 6 || x > 14 {
+			t.Fatalf("drew %v, outside [6, 14]", x)
+		}
+	}
+}
+
+func TestFloat64Choices(t *testing.T) {
+	choices := []float64{1, 2, 3}
+	seen := make(map[float64]bool)
+	v := New(Config{Mean: 100, Choices: choices})
+	for range 1000 {
+		seen[v.Float64()] = true
+	}
+	if len(seen) != len(choices) {
+		t.Errorf("drew %v, want each of %v", seen, choices)
+	}
+	for _, c := range choices {
+		if !seen[c] {
+			t.Errorf("never drew %v", c)
+		}
+	}
+}
+
+func TestFloat64FollowsChanges(t *testing.T) {
+	// Up by 1 a second for 5 seconds, then down by 2 a second for 5.
+	changes := []Delta{
+		{MeanDeltaRate: 1, VarDeltaRate: 0.5, Duration: 5},
+		{MeanDeltaRate: -2, Duration: 5},
+	}
+	type step struct {
+		at		float64	// seconds since the first draw
+		mean		float64
+		variance	float64
+	}
+	tests := []struct {
+		name	string
+		repeat	bool
+		changes	[]Delta
+		steps	[]step
+	}{
+		{"within the first change", false, changes, []step{
+			{0, 10, 0}, {1, 11, 0.5}, {3, 13, 1.5},
+		}},
+		{"across a boundary", false, changes, []step{
+			{0, 10, 0}, {4, 14, 2}, {7, 11, 2.5},
+		}},
+		{"past the end", false, changes, []step{
+			{0, 10, 0}, {10, 5, 2.5}, {30, 5, 2.5},
+		}},
+		{"repeating", true, changes, []step{
+			{0, 10, 0}, {10, 5, 2.5}, {12, 7, 3.5}, {16, 8, 5}, {20, 0, 5},
+		}},
+		{"repeating in one jump", true, changes, []step{
+			{0, 10, 0}, {23, 3, 6.5},
+		}},
+		{"clock doesn't move", false, changes, []step{
+			{0, 10, 0}, {0, 10, 0},
+		}},
+		{"only empty changes repeating", true, []Delta{{MeanDeltaRate: 1}}, []step{
+			{0, 10, 0}, {5, 10, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 6, 21, 20, 0, 0, 0, time.UTC)
+			now := start
+			v := NewWithClock(Config{
+				Mean:		10,
+				Changes:	tt.changes,
+				RepeatChanges:	tt.repeat,
+			}, func() time.Time { return now })
+			for _, s := range tt.steps {
+				now = start.Add(time.Duration(s.at * float64(time.Second)))
+				if got := v.Float64(); math.Abs(got - s.mean) > 1e-9 {
+					t.Errorf("at %vs, Float64() = %v, want %v", s.at, got, s.mean)
+				}
+				want := time.Duration(s.variance * float64(time.Second))
+				if got := v.VarianceDuration(); got != want {
+					t.Errorf("at %vs, variance = %v, want %v", s.at, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResetRestartsChanges(t *testing.T) {
+	now := time.Date(2026, 6, 21, 20, 0, 0, 0, time.UTC)
+	v := NewWithClock(Config{
+		Mean:		10,
+		Changes:	[]Delta{{MeanDeltaRate: 1, Duration: 5}},
+	}, func() time.Time { return now })
+	v.Float64()
+	now = now.Add(5 * time.Second)
+	if got := v.Float64(); got != 15 {
+		t.Fatalf("Float64() = %v, want 15", got)
+	}
+	v.Reset()
+	v.Float64()
+	now = now.Add(2 * time.Second)
+	if got := v.Float64(); got != 12 {
+		t.Errorf("after Reset, Float64() = %v, want 12", got)
+	}
+}
+
+func TestScaleBy(t *testing.T) {
+	scale := 2.0
+	v := New(Config{Mean: 3})
+	v.ScaleBy(func() float64 { return scale })
+	if got := v.Float64(); got != 6 {
+		t.Errorf("Float64() = %v, want 6", got)
+	}
+	scale = 0.5
+	if got := v.Float64(); got != 1.5 {
+		t.Errorf("after rescaling, Float64() = %v, want 1.5", got)
+	}
+	v.Reset()
+	if got := v.Float64(); got != 1.5 {
+		t.Errorf("after Reset, Float64() = %v, want 1.5", got)
+	}
+}
+
+func TestDistributionJSON(t *testing.T) {
+	tests := []struct {
+		json	string
+		want	Distribution
+	}{
+		{`"normal"`, Normal},
+		{`"Uniform"`, Uniform},
+		{`"EXPONENTIAL"`, Exponential},
+		{`"poisson"`, Unknown},
+	}
+	for _, tt := range tests {
+		var d Distribution
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %v", tt.json, err)
+		}
+		if d != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %v, want %v", tt.json, d, tt.want)
+		}
+	}
+}
+
+func BenchmarkFloat64(b *testing.B) {
+	v := New(Config{Mean: 5, Variance: 2, Distribution: Normal})
+	for range b.N {
+		v.Float64()
+	}
+}
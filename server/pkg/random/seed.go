@@ -0,0 +1,156 @@
+package random
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// The choices that shape a show (which effect plays next, which clients
+// it gets, which files they play, ...) are made with a shared source of
+// randomness, which is seeded by a "show seed". By default, the seed is
+// the date, so that two nights differ; giving an earlier night's seed
+// makes roughly the same choices again. A date seed changes when the
+// next night's show begins, even if the server is left running. Only
+// roughly the same choices, though: the order
+// in which threads draw from the source depends on timing, and so on the
+// network.
+
+var source struct {
+	mu	sync.Mutex
+	seed	string
+	r	*rand.Rand
+	byDate	bool		// see SeedByDate
+	until	time.Time	// when the date seed next changes
+}
+
+func init() {
+	source.r = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// The hour at which one night's show gives way to the next; a show that
+// runs past midnight keeps the date it started on.
+const showDayStart = 12
+
+// DateSeed returns the show seed for the night that includes t.
+func DateSeed(t time.Time) string {
+	if t.Hour() < showDayStart {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t.Format("2006-01-02")
+}
+
+// nextShowDay returns when the night after the one that includes t
+// begins.
+func nextShowDay(t time.Time) time.Time {
+	n := time.Date(t.Year(), t.Month(), t.Day(), showDayStart, 0, 0, 0, t.Location())
+	if !n.After(t) {
+		n = n.AddDate(0, 0, 1)
+	}
+	return n
+}
+
+// SetSeed seeds the show's randomness.
+func SetSeed(seed string) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.byDate = false
+	setSeed(seed)
+}
+
+// SeedByDate seeds the show's randomness with the date (see DateSeed),
+// and reseeds it each time a new night's show begins.
+func SeedByDate() {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.byDate = true
+	source.until = time.Time{}
+	followDate()
+}
+
+// lockSource locks the source, first reseeding it if it follows the
+// date and a new night has begun.
+func lockSource() {
+	source.mu.Lock()
+	followDate()
+}
+
+// followDate reseeds the source if it follows the date and a new night
+// has begun. The caller must hold source.mu.
+func followDate() {
+	if !source.byDate {
+		return
+	}
+	if now := time.Now(); !now.Before(source.until) {
+		setSeed(DateSeed(now))
+		source.until = nextShowDay(now)
+	}
+}
+
+// setSeed seeds the source. The caller must hold source.mu.
+func setSeed(seed string) {
+	h := fnv.New128a()
+	h.Write([]byte(seed))
+	sum := h.Sum(nil)
+	var hi, lo uint64
+	for i := 0; i < 8; i++ {
+		hi = hi << 8 | uint64(sum[i])
+		lo = lo << 8 | uint64(sum[8 + i])
+	}
+
+	source.seed = seed
+	source.r = rand.New(rand.NewPCG(hi, lo))
+}
+
+// Seed returns the show seed in effect, or "" if it was never set.
+func Seed() string {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.seed
+}
+
+// These are like the functions of the same names in math/rand/v2, but
+// draw from the show's source.
+
+func Float64() float64 {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.Float64()
+}
+
+func NormFloat64() float64 {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.NormFloat64()
+}
+
+func ExpFloat64() float64 {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.ExpFloat64()
+}
+
+func IntN(n int) int {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.IntN(n)
+}
+
+func Int64N(n int64) int64 {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.Int64N(n)
+}
+
+func Perm(n int) []int {
+	lockSource()
+	defer source.mu.Unlock()
+	return source.r.Perm(n)
+}
+
+func Shuffle(n int, swap func(i, j int)) {
+	lockSource()
+	defer source.mu.Unlock()
+	source.r.Shuffle(n, swap)
+}
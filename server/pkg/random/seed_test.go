@@ -0,0 +1,90 @@
+package random
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSetSeedRepeats(t *testing.T) {
+	draw := func() []int {
+		var xs []int
+		for range 20 {
+			xs = append(xs, IntN(1000))
+		}
+		return xs
+	}
+
+	SetSeed("2026-06-21")
+	first := draw()
+	SetSeed("2026-06-22")
+	other := draw()
+	SetSeed("2026-06-21")
+	again := draw()
+
+	if !slices.Equal(first, again) {
+		t.Errorf("the same seed drew %v, then %v", first, again)
+	}
+	if slices.Equal(first, other) {
+		t.Errorf("different seeds both drew %v", first)
+	}
+	if got := Seed(); got != "2026-06-21" {
+		t.Errorf("Seed() = %q, want %q", got, "2026-06-21")
+	}
+}
+
+func TestDateSeed(t *testing.T) {
+	tests := []struct {
+		time	time.Time
+		want	string
+	}{
+		{time.Date(2026, 6, 21, 20, 0, 0, 0, time.Local), "2026-06-21"},
+		{time.Date(2026, 6, 22, 2, 0, 0, 0, time.Local), "2026-06-21"},
+		{time.Date(2026, 6, 22, 12, 0, 0, 0, time.Local), "2026-06-22"},
+		{time.Date(2026, 1, 1, 0, 30, 0, 0, time.Local), "2025-12-31"},
+	}
+	for _, tt := range tests {
+		if got := DateSeed(tt.time); got != tt.want {
+			t.Errorf("DateSeed(%v) = %q, want %q", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestSeedByDate(t *testing.T) {
+	SeedByDate()
+	defer SetSeed("")
+	if got, want := Seed(), DateSeed(time.Now()); got != want {
+		t.Fatalf("Seed() = %q, want %q", got, want)
+	}
+
+	// Pretend the seed was set last night.
+	source.mu.Lock()
+	setSeed("2026-06-21")
+	source.until = time.Now().Add(-time.Minute)
+	source.mu.Unlock()
+	first := IntN(1000)
+	if got, want := Seed(), DateSeed(time.Now()); got != want {
+		t.Errorf("after a new night began, Seed() = %q, want %q", got, want)
+	}
+	SetSeed(DateSeed(time.Now()))
+	if again := IntN(1000); again != first {
+		t.Errorf("reseeding for the new night drew %d, but its seed draws %d", first, again)
+	}
+}
+
+func TestNextShowDay(t *testing.T) {
+	tests := []struct {
+		time	time.Time
+		want	time.Time
+	}{
+		{time.Date(2026, 6, 21, 20, 0, 0, 0, time.Local), time.Date(2026, 6, 22, 12, 0, 0, 0, time.Local)},
+		{time.Date(2026, 6, 22, 2, 0, 0, 0, time.Local), time.Date(2026, 6, 22, 12, 0, 0, 0, time.Local)},
+		{time.Date(2026, 6, 22, 12, 0, 0, 0, time.Local), time.Date(2026, 6, 23, 12, 0, 0, 0, time.Local)},
+		{time.Date(2026, 12, 31, 13, 0, 0, 0, time.Local), time.Date(2027, 1, 1, 12, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		if got := nextShowDay(tt.time); !got.Equal(tt.want) {
+			t.Errorf("nextShowDay(%v) = %v, want %v", tt.time, got, tt.want)
+		}
+	}
+}
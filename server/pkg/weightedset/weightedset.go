@@ -0,0 +1,50 @@
+// Package weightedset makes random choices among items that have
+// different weights, drawing from the show's shared source of randomness
+// (see random.SetSeed).
+//
+// Custom effects outside this module may use this package. Within a
+// major version of the module, Slice keeps the guarantees in its
+// comment.
+package weightedset
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/blakej11/cricket/pkg/random"
+)
+
+// Slice returns the items in a random order, where at each position the
+// chance of a given remaining item coming next is proportional to its
+// weight. Every item appears exactly once. Items with non-positive
+// weights come last, in their original order. There must be a weight
+// for each item. The input slices are not modified.
+func Slice[T any](items []T, weights []float64) []T {
+	// Drawing items one at a time in proportion to their weights is the
+	// same as giving each item an exponentially distributed key with
+	// rate equal to its weight, and sorting by key; see Efraimidis and
+	// Spirakis, "Weighted random sampling with a reservoir" (2006).
+	// That takes O(n log n) time rather than O(n^2).
+	type entry struct {
+		item	T
+		key	float64
+	}
+	positive := make([]entry, 0, len(items))
+	zero := []T{}
+	for i, item := range items {
+		if weights[i] <= 0 {
+			zero = append(zero, item)
+			continue
+		}
+		positive = append(positive, entry{item, random.ExpFloat64() / weights[i]})
+	}
+	slices.SortFunc(positive, func(a, b entry) int {
+		return cmp.Compare(a.key, b.key)
+	})
+
+	result := make([]T, 0, len(items))
+	for _, e := range positive {
+		result = append(result, e.item)
+	}
+	return append(result, zero...)
+}
@@ -0,0 +1,73 @@
+package weightedset
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSliceIsPermutation(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	weights := []float64{1, 5, 0, 2, -1}
+	for range 100 {
+		got := Slice(items, weights)
+		sorted := slices.Clone(got)
+		slices.Sort(sorted)
+		if !slices.Equal(sorted, items) {
+			t.Fatalf("Slice(%v) = %v, not a permutation", items, got)
+		}
+		// Non-positive weights come last, in their original order.
+		if got[3] != "c" || got[4] != "e" {
+			t.Fatalf("Slice(%v) = %v, want c and e last", items, got)
+		}
+	}
+}
+
+func TestSliceDoesntModifyInput(t *testing.T) {
+	items := []int{1, 2, 3, 4}
+	weights := []float64{4, 3, 2, 1}
+	Slice(items, weights)
+	if !slices.Equal(items, []int{1, 2, 3, 4}) || !slices.Equal(weights, []float64{4, 3, 2, 1}) {
+		t.Errorf("Slice modified its input: %v, %v", items, weights)
+	}
+}
+
+func TestSliceEmpty(t *testing.T) {
+	if got := Slice([]int{}, []float64{}); len(got) != 0 {
+		t.Errorf("Slice of nothing = %v", got)
+	}
+}
+
+// The chance of an item coming first is proportional to its weight.
+func TestSliceFirstIsWeighted(t *testing.T) {
+	items := []int{0, 1, 2}
+	weights := []float64{1, 2, 7}
+	const n = 20000
+	var first [3]int
+	for range n {
+		first[Slice(items, weights)[0]]++
+	}
+	for i, w := range weights {
+		want := w / 10
+		got := float64(first[i]) / n
+		if got < want - 0.03 || got > want + 0.03 {
+			t.Errorf("item %d came first %.3f of the time, want about %.3f", i, got, want)
+		}
+	}
+}
+
+func BenchmarkSlice(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		items := make([]int, n)
+		weights := make([]float64, n)
+		for i := range n {
+			items[i] = i
+			weights[i] = float64(i % 7)
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for range b.N {
+				Slice(items, weights)
+			}
+		})
+	}
+}